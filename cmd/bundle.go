@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"runc-go/image"
+)
+
+var bundleCmd = &cobra.Command{
+	Use:   "bundle",
+	Short: "Manage container bundles",
+}
+
+var bundleCreateCmd = &cobra.Command{
+	Use:   "create --image <oci-layout-dir>[:tag] <bundle>",
+	Short: "Create a bundle from a local OCI image layout",
+	Long: `Unpack an image from an OCI image layout directory into <bundle>/rootfs
+and generate <bundle>/config.json from the default spec and the image
+configuration. Without a tag, the layout's only image or the one tagged
+"latest" is used. Nothing is fetched over the network.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runBundleCreate,
+}
+
+var bundleImage string
+
+func init() {
+	rootCmd.AddCommand(bundleCmd)
+	bundleCmd.AddCommand(bundleCreateCmd)
+
+	bundleCreateCmd.Flags().StringVar(&bundleImage, "image", "", "OCI image layout directory, optionally followed by :tag")
+	bundleCreateCmd.MarkFlagRequired("image")
+}
+
+func runBundleCreate(cmd *cobra.Command, args []string) error {
+	if err := image.CreateBundle(bundleImage, args[0]); err != nil {
+		return fmt.Errorf("create bundle: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"runc-go/linux"
+)
+
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Print the host's effective device policy",
+	Long: `Load the host's device policy and print the rules containers are
+created under. A device must match an allow rule and no deny rule. Without
+a policy file the built-in rules apply.`,
+	Args: cobra.NoArgs,
+	RunE: runCheck,
+}
+
+var checkDevicePolicy string
+
+func init() {
+	rootCmd.AddCommand(checkCmd)
+	checkCmd.Flags().StringVar(&checkDevicePolicy, "device-policy", linux.DevicePolicyPath, "device policy file to check")
+}
+
+func runCheck(cmd *cobra.Command, args []string) error {
+	policy, err := linux.LoadDevicePolicy(checkDevicePolicy)
+	if err != nil {
+		return err
+	}
+
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "device policy: %s\n", policy.Source())
+	for _, r := range policy.Allow {
+		fmt.Fprintf(out, "  allow %s\n", r)
+	}
+	fmt.Fprintln(out, "  allow p (FIFOs)")
+	for _, r := range policy.Deny {
+		fmt.Fprintf(out, "  deny  %s\n", r)
+	}
+	return nil
+}
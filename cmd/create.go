@@ -49,6 +49,7 @@ func runCreate(cmd *cobra.Command, args []string) error {
 		ConsoleSocket: createConsoleSocket,
 		NoPivot:       createNoPivot,
 		NoNewKeyring:  createNoNewKeyring,
+		SystemdCgroup: globalSystemdCgroup,
 	}
 
 	if err := c.Create(ctx, opts); err != nil {
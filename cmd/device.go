@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/sys/unix"
+
+	"runc-go/container"
+	"runc-go/spec"
+)
+
+var deviceCmd = &cobra.Command{
+	Use:   "device",
+	Short: "Add or remove device nodes of a live container",
+	Long: `Hand a device node to a created or running container, or take it away
+again, without restarting it. The device cgroup rules follow, and the change
+is recorded in the container's spec.`,
+}
+
+var deviceAddCmd = &cobra.Command{
+	Use:   "add <container-id> --path <path>",
+	Short: "Add a device node to a container",
+	Long: `Create a device node in the container's /dev and allow it in the
+container's device cgroup. Without --type, --major and --minor the host's node
+at the same path is taken as the model. The device must be allowed by the
+host's device policy.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runDeviceAdd,
+}
+
+var deviceRmCmd = &cobra.Command{
+	Use:   "rm <container-id> --path <path>",
+	Short: "Remove a device node from a container",
+	Long:  `Revoke a device's cgroup rules and remove its node from the container.`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  runDeviceRm,
+}
+
+var (
+	devicePath   string
+	deviceType   string
+	deviceMajor  int64
+	deviceMinor  int64
+	deviceAccess string
+)
+
+func init() {
+	rootCmd.AddCommand(deviceCmd)
+	deviceCmd.AddCommand(deviceAddCmd)
+	deviceCmd.AddCommand(deviceRmCmd)
+
+	deviceAddCmd.Flags().StringVar(&devicePath, "path", "", "path of the device in the container, under /dev")
+	deviceAddCmd.Flags().StringVar(&deviceType, "type", "", "device type: c, b or p (default: the host node's)")
+	deviceAddCmd.Flags().Int64Var(&deviceMajor, "major", 0, "major number (default: the host node's)")
+	deviceAddCmd.Flags().Int64Var(&deviceMinor, "minor", 0, "minor number (default: the host node's)")
+	deviceAddCmd.Flags().StringVar(&deviceAccess, "access", "rwm", "cgroup access: a combination of r, w and m")
+	deviceAddCmd.MarkFlagRequired("path")
+
+	deviceRmCmd.Flags().StringVar(&devicePath, "path", "", "path of the device in the container")
+	deviceRmCmd.MarkFlagRequired("path")
+}
+
+func runDeviceAdd(cmd *cobra.Command, args []string) error {
+	dev := spec.LinuxDevice{Path: devicePath, Type: deviceType, Major: deviceMajor, Minor: deviceMinor}
+
+	flags := cmd.Flags()
+	if flags.Changed("major") != flags.Changed("minor") {
+		return fmt.Errorf("--major and --minor go together")
+	}
+	if !flags.Changed("major") && dev.Type != "p" {
+		host, err := hostDevice(devicePath)
+		if err != nil {
+			return err
+		}
+		if dev.Type == "" {
+			dev.Type = host.Type
+		}
+		dev.Major, dev.Minor = host.Major, host.Minor
+	}
+	if dev.Type == "" {
+		dev.Type = "c"
+	}
+
+	return container.AddDevice(GetContext(), args[0], GetStateRoot(), dev, deviceAccess)
+}
+
+func runDeviceRm(cmd *cobra.Command, args []string) error {
+	return container.RemoveDevice(GetContext(), args[0], GetStateRoot(), devicePath)
+}
+
+// hostDevice returns the type and numbers of the host's device node at
+// path.
+func hostDevice(path string) (spec.LinuxDevice, error) {
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		return spec.LinuxDevice{}, fmt.Errorf("no --major and --minor, and no host device: %w", err)
+	}
+	dev := spec.LinuxDevice{Path: path, Major: int64(unix.Major(st.Rdev)), Minor: int64(unix.Minor(st.Rdev))}
+	switch st.Mode & unix.S_IFMT {
+	case unix.S_IFCHR:
+		dev.Type = "c"
+	case unix.S_IFBLK:
+		dev.Type = "b"
+	default:
+		return spec.LinuxDevice{}, fmt.Errorf("host %s is not a device node", path)
+	}
+	return dev, nil
+}
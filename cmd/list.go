@@ -56,12 +56,12 @@ func runList(cmd *cobra.Command, args []string) error {
 
 func outputTable(containers []*container.Container) error {
 	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
-	fmt.Fprintln(w, "ID\tPID\tSTATUS\tBUNDLE\tCREATED")
+	fmt.Fprintln(w, "ID\tPID\tSTATUS\tOOMKILLS\tBUNDLE\tCREATED")
 
 	for _, c := range containers {
 		created := c.State.Created.Format("2006-01-02 15:04:05")
-		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n",
-			c.ID, c.State.Pid, c.State.Status, c.Bundle, created)
+		fmt.Fprintf(w, "%s\t%d\t%s\t%d\t%s\t%s\n",
+			c.ID, c.State.Pid, c.State.Status, c.State.OOMKillCount, c.Bundle, created)
 	}
 
 	return w.Flush()
@@ -69,21 +69,25 @@ func outputTable(containers []*container.Container) error {
 
 func outputJSON(containers []*container.Container) error {
 	type listItem struct {
-		ID      string `json:"id"`
-		Pid     int    `json:"pid"`
-		Status  string `json:"status"`
-		Bundle  string `json:"bundle"`
-		Created string `json:"created"`
+		ID           string `json:"id"`
+		Pid          int    `json:"pid"`
+		Status       string `json:"status"`
+		OOMKilled    bool   `json:"oomKilled"`
+		OOMKillCount uint64 `json:"oomKillCount"`
+		Bundle       string `json:"bundle"`
+		Created      string `json:"created"`
 	}
 
 	items := make([]listItem, len(containers))
 	for i, c := range containers {
 		items[i] = listItem{
-			ID:      c.ID,
-			Pid:     c.State.Pid,
-			Status:  string(c.State.Status),
-			Bundle:  c.Bundle,
-			Created: c.State.Created.Format("2006-01-02T15:04:05Z"),
+			ID:           c.ID,
+			Pid:          c.State.Pid,
+			Status:       string(c.State.Status),
+			OOMKilled:    c.State.OOMKilled,
+			OOMKillCount: c.State.OOMKillCount,
+			Bundle:       c.Bundle,
+			Created:      c.State.Created.Format("2006-01-02T15:04:05Z"),
 		}
 	}
 
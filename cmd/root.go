@@ -10,6 +10,7 @@ import (
 
 	"github.com/spf13/cobra"
 
+	"runc-go/container"
 	"runc-go/logging"
 )
 
@@ -22,10 +23,11 @@ var (
 
 // Global flags
 var (
-	globalRoot      string
-	globalLog       string
-	globalLogFormat string
-	globalDebug     bool
+	globalRoot          string
+	globalLog           string
+	globalLogFormat     string
+	globalDebug         bool
+	globalSystemdCgroup bool
 )
 
 // rootCmd is the base command for runc-go.
@@ -61,18 +63,16 @@ func GetStateRoot() string {
 	if globalRoot != "" {
 		return globalRoot
 	}
-	return "/run/runc-go"
+	return container.DefaultStateRoot()
 }
 
 func init() {
 	// Global flags
-	rootCmd.PersistentFlags().StringVar(&globalRoot, "root", "", "root directory for storage of container state (default: /run/runc-go)")
+	rootCmd.PersistentFlags().StringVar(&globalRoot, "root", "", "root directory for storage of container state (default: /run/runc-go, or $XDG_RUNTIME_DIR/runc-go when rootless)")
 	rootCmd.PersistentFlags().StringVar(&globalLog, "log", "", "set the log file path")
 	rootCmd.PersistentFlags().StringVar(&globalLogFormat, "log-format", "text", "set the format for log output (text or json)")
 	rootCmd.PersistentFlags().BoolVar(&globalDebug, "debug", false, "enable debug logging")
-
-	// Compatibility flags (accepted but may be ignored)
-	rootCmd.PersistentFlags().Bool("systemd-cgroup", false, "enable systemd cgroup support (compatibility flag)")
+	rootCmd.PersistentFlags().BoolVar(&globalSystemdCgroup, "systemd-cgroup", false, "manage cgroups with systemd; cgroupsPath is \"slice:prefix:name\"")
 }
 
 func setupLogging() {
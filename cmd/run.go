@@ -45,6 +45,7 @@ func runRun(cmd *cobra.Command, args []string) error {
 	opts := &container.CreateOptions{
 		PidFile:       runPidFile,
 		ConsoleSocket: runConsoleSocket,
+		SystemdCgroup: globalSystemdCgroup,
 	}
 
 	if err := c.Run(ctx, opts); err != nil {
@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"runc-go/linux"
+	"runc-go/spec"
+)
+
+var seccompCmd = &cobra.Command{
+	Use:   "seccomp",
+	Short: "Inspect and simulate seccomp profiles",
+	Long: `Inspect the BPF program generated from a config.json seccomp section,
+or run it offline against a syscall without installing it.`,
+}
+
+var seccompCompileCmd = &cobra.Command{
+	Use:   "compile <config.json>",
+	Short: "Print the compiled BPF program",
+	Long:  `Compile the seccomp section of a config.json and print a disassembly of the resulting BPF program.`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  runSeccompCompile,
+}
+
+var seccompTestCmd = &cobra.Command{
+	Use:   "test <config.json> <syscall> [args...]",
+	Short: "Simulate a syscall against the compiled filter",
+	Long: `Run the compiled seccomp program through a userspace BPF interpreter
+and print the action the kernel would take. The syscall may be given by
+name or number; arguments accept decimal, octal (0...) or hex (0x...).`,
+	Args: cobra.RangeArgs(2, 8),
+	RunE: runSeccompTest,
+}
+
+var (
+	seccompTestArch   string
+	seccompTestExpect string
+)
+
+func init() {
+	rootCmd.AddCommand(seccompCmd)
+	seccompCmd.AddCommand(seccompCompileCmd)
+	seccompCmd.AddCommand(seccompTestCmd)
+
+	seccompTestCmd.Flags().StringVar(&seccompTestArch, "arch", string(spec.ArchX86_64), "architecture the syscall is made from")
+	seccompTestCmd.Flags().StringVar(&seccompTestExpect, "expect", "", "fail unless the resulting action matches (e.g. SCMP_ACT_ALLOW, SCMP_ACT_ERRNO(1))")
+}
+
+// loadSeccompConfig returns the seccomp section of a config.json.
+func loadSeccompConfig(path string) (*spec.LinuxSeccomp, error) {
+	s, err := spec.LoadSpec(path)
+	if err != nil {
+		return nil, fmt.Errorf("load spec: %w", err)
+	}
+	if s.Linux == nil || s.Linux.Seccomp == nil {
+		return nil, fmt.Errorf("%s has no linux.seccomp section", path)
+	}
+	return s.Linux.Seccomp, nil
+}
+
+func runSeccompCompile(cmd *cobra.Command, args []string) error {
+	config, err := loadSeccompConfig(args[0])
+	if err != nil {
+		return err
+	}
+
+	listing, err := linux.DisassembleSeccomp(config)
+	if err != nil {
+		return err
+	}
+
+	out := cmd.OutOrStdout()
+	for _, arch := range linux.UnsupportedArchitectures(config) {
+		fmt.Fprintf(out, "# warning: architecture %s has no syscall table; its calls are killed\n", arch)
+	}
+	for _, name := range linux.UnrecognizedSyscalls(config) {
+		fmt.Fprintf(out, "# warning: syscall %q is not in the syscall table and is omitted\n", name)
+	}
+	fmt.Fprint(out, listing)
+	return nil
+}
+
+func runSeccompTest(cmd *cobra.Command, args []string) error {
+	config, err := loadSeccompConfig(args[0])
+	if err != nil {
+		return err
+	}
+
+	arch := spec.Arch(seccompTestArch)
+	if !strings.HasPrefix(seccompTestArch, "SCMP_ARCH_") {
+		arch = spec.Arch("SCMP_ARCH_" + strings.ToUpper(seccompTestArch))
+	}
+	// Syscall names and numbers mean something only with the
+	// architecture's own table
+	supported := linux.SyscallArches()
+	if !slices.Contains(supported, arch) {
+		return fmt.Errorf("unsupported architecture: %s (no syscall table; supported: %v)", seccompTestArch, supported)
+	}
+	audit, _ := linux.AuditArch(arch)
+
+	nr, ok := linux.SyscallNumberArch(arch, args[1])
+	if !ok {
+		n, err := strconv.ParseInt(args[1], 0, 32)
+		if err != nil {
+			return fmt.Errorf("unknown syscall: %s", args[1])
+		}
+		nr = int(n)
+	}
+
+	data := linux.SeccompData{Nr: int32(nr), Arch: audit}
+	for i, arg := range args[2:] {
+		v, err := strconv.ParseUint(arg, 0, 64)
+		if err != nil {
+			return fmt.Errorf("invalid argument %d %q: %w", i, arg, err)
+		}
+		data.Args[i] = v
+	}
+
+	ret, err := linux.SimulateSeccomp(config, data)
+	if err != nil {
+		return fmt.Errorf("simulate: %w", err)
+	}
+
+	action := linux.SeccompActionName(ret)
+	fmt.Fprintln(cmd.OutOrStdout(), action)
+
+	if seccompTestExpect != "" && !seccompActionMatches(seccompTestExpect, action) {
+		return fmt.Errorf("expected %s, got %s", seccompTestExpect, action)
+	}
+	return nil
+}
+
+// seccompActionMatches reports whether an expected action matches the
+// simulated one. An expectation without "(data)" matches any data value,
+// and SCMP_ACT_KILL is accepted as the alias of SCMP_ACT_KILL_THREAD.
+func seccompActionMatches(expected, action string) bool {
+	if expected == string(spec.ActKill) {
+		expected = string(spec.ActKillThread)
+	}
+	if expected == action {
+		return true
+	}
+	return !strings.Contains(expected, "(") && strings.SplitN(action, "(", 2)[0] == expected
+}
@@ -2,10 +2,13 @@ package cmd
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
+	"runtime"
 
 	"github.com/spf13/cobra"
 
+	"runc-go/linux"
 	"runc-go/spec"
 )
 
@@ -18,8 +21,10 @@ var specCmd = &cobra.Command{
 }
 
 var (
-	specBundle   string
-	specRootless bool
+	specBundle         string
+	specRootless       bool
+	specSeccomp        string
+	specSeccompProfile string
 )
 
 func init() {
@@ -27,6 +32,8 @@ func init() {
 
 	specCmd.Flags().StringVarP(&specBundle, "bundle", "b", ".", "bundle directory")
 	specCmd.Flags().BoolVar(&specRootless, "rootless", false, "generate a rootless spec")
+	specCmd.Flags().StringVar(&specSeccomp, "seccomp", "default", "seccomp profile: default, unconfined or a profile file (OCI or Docker format)")
+	specCmd.Flags().StringVar(&specSeccompProfile, "seccomp-profile", "", "convert a Docker/Podman seccomp profile into the spec")
 }
 
 func runSpec(cmd *cobra.Command, args []string) error {
@@ -49,7 +56,64 @@ func runSpec(cmd *cobra.Command, args []string) error {
 		}
 	}
 
+	profile := specSeccomp
+	if specSeccompProfile != "" {
+		if cmd.Flags().Changed("seccomp") {
+			return fmt.Errorf("--seccomp and --seccomp-profile are mutually exclusive")
+		}
+		profile = specSeccompProfile
+	}
+
+	switch profile {
+	case "default":
+		// DefaultSpec leaves seccomp out where the built-in profile has
+		// no rules for the architecture; say so rather than write an
+		// unconfined spec
+		if _, err := spec.DefaultSeccomp(runtime.GOARCH, nil); err != nil {
+			return fmt.Errorf("%w; pass --seccomp unconfined or a profile file", err)
+		}
+	case "unconfined":
+		s.Linux.Seccomp = nil
+	default:
+		// An OCI seccomp section is a subset of the Docker format, so
+		// both load through the same converter
+		seccomp, err := convertSeccompProfile(profile, s)
+		if err != nil {
+			return err
+		}
+		s.Linux.Seccomp = seccomp
+	}
+
 	encoder := json.NewEncoder(os.Stdout)
 	encoder.SetIndent("", "  ")
 	return encoder.Encode(s)
 }
+
+// convertSeccompProfile loads a Docker-format seccomp profile and resolves
+// its conditional rules against the spec's bounding capabilities and the
+// running kernel.
+func convertSeccompProfile(path string, s *spec.Spec) (*spec.LinuxSeccomp, error) {
+	profile, err := spec.LoadDockerSeccompProfile(path)
+	if err != nil {
+		return nil, fmt.Errorf("load seccomp profile: %w", err)
+	}
+
+	release, err := linux.KernelRelease()
+	if err != nil {
+		return nil, err
+	}
+
+	env := spec.DockerSeccompEnv{
+		Arch:          runtime.GOARCH,
+		KernelRelease: release,
+	}
+	if s.Process != nil && s.Process.Capabilities != nil {
+		env.Capabilities = s.Process.Capabilities.Bounding
+	}
+
+	seccomp, err := profile.ToOCI(env)
+	if err != nil {
+		return nil, fmt.Errorf("convert seccomp profile %s: %w", path, err)
+	}
+	return seccomp, nil
+}
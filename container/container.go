@@ -4,6 +4,7 @@ package container
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -13,6 +14,7 @@ import (
 	"time"
 
 	cerrors "runc-go/errors"
+	"runc-go/linux"
 	"runc-go/logging"
 	"runc-go/spec"
 )
@@ -53,6 +55,16 @@ const (
 	StateFileName = "state.json"
 )
 
+// DefaultStateRoot returns the state directory used when none is given:
+// $XDG_RUNTIME_DIR/runc-go for rootless callers that have a runtime
+// directory, DefaultStateDir otherwise.
+func DefaultStateRoot() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" && linux.IsRootless() {
+		return filepath.Join(dir, "runc-go")
+	}
+	return DefaultStateDir
+}
+
 // Container represents an OCI container.
 type Container struct {
 	// mu protects concurrent access to container state.
@@ -95,7 +107,7 @@ func Load(ctx context.Context, id string, stateRoot string) (*Container, error)
 	}
 
 	if stateRoot == "" {
-		stateRoot = DefaultStateDir
+		stateRoot = DefaultStateRoot()
 	}
 
 	stateDir := filepath.Join(stateRoot, id)
@@ -115,6 +127,14 @@ func Load(ctx context.Context, id string, stateRoot string) (*Container, error)
 		StateDir:    stateDir,
 		State:       state,
 		InitProcess: state.Pid,
+		CgroupPath:  state.CgroupPath,
+	}
+
+	// A spec changed since create, by device add or rm, is kept in the
+	// state
+	if state.Config != nil {
+		c.Spec = state.Config
+		return c, nil
 	}
 
 	// Load spec if available (non-fatal if missing)
@@ -144,7 +164,7 @@ func New(ctx context.Context, id, bundle, stateRoot string) (*Container, error)
 	}
 
 	if stateRoot == "" {
-		stateRoot = DefaultStateDir
+		stateRoot = DefaultStateRoot()
 	}
 
 	// Validate bundle
@@ -315,7 +335,7 @@ func (c *Container) CreateExecFifo() error {
 // List returns all containers in the state directory.
 func List(ctx context.Context, stateRoot string) ([]*Container, error) {
 	if stateRoot == "" {
-		stateRoot = DefaultStateDir
+		stateRoot = DefaultStateRoot()
 	}
 
 	entries, err := os.ReadDir(stateRoot)
@@ -346,6 +366,7 @@ func List(ctx context.Context, stateRoot string) ([]*Container, error) {
 
 		// Refresh status
 		c.RefreshStatus()
+		c.RefreshCgroupStats()
 		containers = append(containers, c)
 	}
 
@@ -376,7 +397,10 @@ func (c *Container) Signal(sig syscall.Signal) error {
 	return nil
 }
 
-// SignalAll sends a signal to all processes in the container.
+// SignalAll sends a signal to every process in the container's cgroup,
+// including processes that left the init process group (setsid, daemons).
+// A rootless container without a cgroup only has its init's process group
+// to signal.
 // This method is thread-safe.
 func (c *Container) SignalAll(sig syscall.Signal) error {
 	c.mu.RLock()
@@ -384,12 +408,99 @@ func (c *Container) SignalAll(sig syscall.Signal) error {
 	id := c.ID
 	c.mu.RUnlock()
 
-	// Send to process group
-	if pid <= 0 {
-		return cerrors.WrapWithContainer(nil, cerrors.ErrInvalidState, "signal all", id)
+	cgroup, err := c.cgroup()
+	if errors.Is(err, errNoCgroup) {
+		if pid <= 0 {
+			return cerrors.WrapWithContainer(nil, cerrors.ErrInvalidState, "signal all", id)
+		}
+		if err := syscall.Kill(-pid, sig); err != nil {
+			return cerrors.WrapWithContainer(err, cerrors.ErrInternal, "signal all", id)
+		}
+		return nil
+	}
+	if err != nil {
+		return cerrors.WrapWithContainer(err, cerrors.ErrInternal, "signal all", id)
 	}
-	if err := syscall.Kill(-pid, sig); err != nil {
+	if err := cgroup.Kill(sig); err != nil {
 		return cerrors.WrapWithContainer(err, cerrors.ErrInternal, "signal all", id)
 	}
 	return nil
 }
+
+// errNoCgroup is returned for rootless containers created without a cgroup.
+var errNoCgroup = errors.New("container has no cgroup")
+
+// cgroup opens the container's cgroup with the driver it was created with.
+// A removed cgroup is not recreated.
+// This method is thread-safe.
+func (c *Container) cgroup() (linux.CgroupManager, error) {
+	c.mu.RLock()
+	cgroupPath := c.CgroupPath
+	if cgroupPath == "" {
+		cgroupPath = linux.GetCgroupPath(c.ID, "")
+	}
+	systemd := c.State != nil && c.State.SystemdCgroup
+	noCgroup := c.CgroupPath == "" && c.State != nil && c.State.Rootless
+	c.mu.RUnlock()
+
+	if noCgroup {
+		return nil, errNoCgroup
+	}
+	if systemd {
+		return openCgroup(cgroupPath, systemd)
+	}
+	return linux.OpenCgroupManager(cgroupPath)
+}
+
+// addExecProcess moves an exec'd process into the container's cgroup. The
+// root of a delegated v2 cgroup may hold no processes once the container
+// enabled controllers in it; the process then joins the init's cgroup.
+// A container without a cgroup leaves it where it is.
+func (c *Container) addExecProcess(pid int) error {
+	cgroup, err := c.cgroup()
+	if errors.Is(err, errNoCgroup) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = cgroup.AddProcess(pid)
+	if errors.Is(err, syscall.EBUSY) {
+		c.mu.RLock()
+		initPid := c.InitProcess
+		c.mu.RUnlock()
+		return linux.JoinCgroupOf(pid, initPid)
+	}
+	return err
+}
+
+// RefreshCgroupStats reads the cgroup's memory events and pressure into
+// the in-memory state; callers that see the container stop save it, so
+// OOM kills stay visible once the cgroup is gone. Values the hierarchy
+// cannot provide keep their last reading. It reports whether the state
+// changed.
+// This method is thread-safe.
+func (c *Container) RefreshCgroupStats() bool {
+	cgroup, err := c.cgroup()
+	if err != nil {
+		return false
+	}
+	events, eventsErr := cgroup.GetMemoryEvents()
+	pressure, pressureErr := cgroup.GetMemoryPressure()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	changed := false
+	if eventsErr == nil && (c.State.MemoryEvents == nil || *c.State.MemoryEvents != *events) {
+		c.State.MemoryEvents = events
+		c.State.OOMKillCount = events.OOMKill
+		c.State.OOMKilled = events.OOMKill > 0
+		changed = true
+	}
+	if pressureErr == nil && (c.State.MemoryPressure == nil || *c.State.MemoryPressure != *pressure) {
+		c.State.MemoryPressure = pressure
+		changed = true
+	}
+	return changed
+}
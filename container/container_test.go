@@ -2,11 +2,19 @@ package container
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strconv"
 	"sync"
+	"syscall"
 	"testing"
+	"time"
 
+	cerrors "runc-go/errors"
+	"runc-go/linux"
 	"runc-go/spec"
 )
 
@@ -415,6 +423,123 @@ func TestRefreshStatus(t *testing.T) {
 	}
 }
 
+func TestRefreshCgroupStats_MissingCgroup(t *testing.T) {
+	events := &spec.MemoryEvents{OOM: 1, OOMKill: 1}
+	c := &Container{
+		ID:         "refresh-stats-missing",
+		CgroupPath: "/runc-go-test-missing/refresh-stats",
+		State: &spec.ContainerState{
+			State: spec.State{
+				Status:       spec.StatusStopped,
+				OOMKilled:    true,
+				OOMKillCount: 1,
+			},
+			MemoryEvents: events,
+		},
+	}
+
+	// A removed cgroup keeps the last reading and is not recreated
+	if c.RefreshCgroupStats() {
+		t.Error("RefreshCgroupStats reported a change without a cgroup")
+	}
+	if !c.State.OOMKilled || c.State.OOMKillCount != 1 || c.State.MemoryEvents != events {
+		t.Errorf("state changed: %+v", c.State)
+	}
+	if _, err := os.Stat(filepath.Join("/sys/fs/cgroup", c.CgroupPath)); !os.IsNotExist(err) {
+		t.Errorf("cgroup was created: %v", err)
+	}
+}
+
+func TestCgroup_RootlessWithoutCgroup(t *testing.T) {
+	c := &Container{
+		ID: "rootless-no-cgroup",
+		State: &spec.ContainerState{
+			Rootless: true,
+		},
+	}
+	if _, err := c.cgroup(); !errors.Is(err, errNoCgroup) {
+		t.Errorf("cgroup() = %v, want errNoCgroup", err)
+	}
+}
+
+func TestDefaultStateRoot(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", "/run/user/1000")
+	want := DefaultStateDir
+	if linux.IsRootless() {
+		want = "/run/user/1000/runc-go"
+	}
+	if got := DefaultStateRoot(); got != want {
+		t.Errorf("DefaultStateRoot() = %s, want %s", got, want)
+	}
+}
+
+func TestOpenExecFifo_Descriptor(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ExecFifoName)
+	if err := syscall.Mkfifo(path, 0600); err != nil {
+		t.Fatalf("mkfifo: %v", err)
+	}
+	pathFile, err := openExecFifoPath(path, nil, false)
+	if err != nil {
+		t.Fatalf("openExecFifoPath failed: %v", err)
+	}
+	defer pathFile.Close()
+
+	// openExecFifo closes the descriptor it is given
+	fd, err := syscall.Dup(int(pathFile.Fd()))
+	if err != nil {
+		t.Fatalf("dup: %v", err)
+	}
+	t.Setenv("_RUNC_GO_INIT_FIFO_FD", strconv.Itoa(fd))
+	fifo, err := openExecFifo("/nonexistent")
+	if err != nil {
+		t.Fatalf("openExecFifo failed: %v", err)
+	}
+	defer fifo.Close()
+
+	// The init holds both ends, so start's open does not block either
+	w, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("open write end: %v", err)
+	}
+	w.Write([]byte{0})
+	w.Close()
+	buf := make([]byte, 1)
+	if n, err := fifo.Read(buf); n != 1 || err != nil {
+		t.Errorf("read fifo = %d, %v; want the start byte", n, err)
+	}
+}
+
+func TestInitReporter(t *testing.T) {
+	report := func(fn func(*initReporter)) error {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		fn(&initReporter{pipe: w})
+		return waitForInitSetup(r, "test")
+	}
+
+	if err := report(func(s *initReporter) { s.ready(); s.fail(errors.New("late")) }); err != nil {
+		t.Errorf("ready: waitForInitSetup() = %v, want nil", err)
+	}
+
+	mountErr := cerrors.WrapWithDetail(os.ErrNotExist, cerrors.ErrRootfs, "mount", "/data")
+	err := report(func(s *initReporter) { s.fail(fmt.Errorf("setup rootfs: %w", mountErr)) })
+	var cerr *cerrors.ContainerError
+	if !errors.As(err, &cerr) {
+		t.Fatalf("fail: waitForInitSetup() = %v, want a ContainerError", err)
+	}
+	if cerr.Kind != cerrors.ErrRootfs || cerr.Op != "mount" || cerr.Detail != "/data" || cerr.Container != "test" {
+		t.Errorf("error = %+v, want the init's rootfs error", cerr)
+	}
+
+	err = report(func(s *initReporter) { s.pipe.Close() })
+	if !cerrors.IsKind(err, cerrors.ErrInternal) {
+		t.Errorf("exit: waitForInitSetup() = %v, want an internal error", err)
+	}
+}
+
 func TestStateJSON(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "runc-go-test-*")
 	if err != nil {
@@ -874,3 +999,75 @@ func TestContainer_ConcurrentMixedOperations(t *testing.T) {
 		t.Error("GetState returned nil after mixed concurrent operations")
 	}
 }
+
+// TestSignalAll_ExecProcess verifies kill --all reaches a process exec'd
+// into the container, which is not in the init's process group.
+func TestSignalAll_ExecProcess(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("creating cgroups needs root")
+	}
+	cgroupPath := fmt.Sprintf("runc-go-test/signal-all-%d", os.Getpid())
+	cgroup, err := linux.NewCgroupManager(cgroupPath)
+	if err != nil {
+		t.Skipf("cgroups unavailable: %v", err)
+	}
+	defer cgroup.Destroy()
+
+	start := func(setsid bool) *exec.Cmd {
+		cmd := exec.Command("sleep", "60")
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: setsid}
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { cmd.Process.Kill() })
+		return cmd
+	}
+	init := start(true)
+	if err := cgroup.AddProcess(init.Process.Pid); err != nil {
+		t.Fatalf("AddProcess: %v", err)
+	}
+	c := &Container{
+		ID:          "signal-all-exec",
+		CgroupPath:  cgroupPath,
+		InitProcess: init.Process.Pid,
+		State:       &spec.ContainerState{},
+	}
+	execd := start(true)
+	if err := c.addExecProcess(execd.Process.Pid); err != nil {
+		t.Fatalf("addExecProcess: %v", err)
+	}
+
+	if err := c.SignalAll(syscall.SIGKILL); err != nil {
+		t.Fatalf("SignalAll: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- execd.Wait() }()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("SignalAll did not reach the exec'd process")
+	}
+	init.Wait()
+}
+
+// TestSignalAll_RootlessWithoutCgroup verifies a container without a
+// cgroup is signalled through its init's process group.
+func TestSignalAll_RootlessWithoutCgroup(t *testing.T) {
+	cmd := exec.Command("sleep", "60")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+	c := &Container{
+		ID:          "signal-all-rootless",
+		InitProcess: cmd.Process.Pid,
+		State:       &spec.ContainerState{Rootless: true},
+	}
+	if err := c.SignalAll(syscall.SIGKILL); err != nil {
+		t.Fatalf("SignalAll: %v", err)
+	}
+	if err := cmd.Wait(); err == nil {
+		t.Error("process exited normally, want killed")
+	}
+}
@@ -2,14 +2,22 @@
 package container
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
 	"syscall"
 
+	"golang.org/x/sys/unix"
+
 	cerrors "runc-go/errors"
 	"runc-go/linux"
 	"runc-go/spec"
@@ -29,6 +37,10 @@ type CreateOptions struct {
 
 	// NoNewKeyring disables creating a new session keyring.
 	NoNewKeyring bool
+
+	// SystemdCgroup places the container in a systemd scope unit instead
+	// of creating the cgroup directly.
+	SystemdCgroup bool
 }
 
 // Create creates a container but doesn't start the user process.
@@ -45,13 +57,47 @@ func (c *Container) Create(ctx context.Context, opts *CreateOptions) error {
 		opts = &CreateOptions{}
 	}
 
+	// The init assembles an image or overlay rootfs; catch bad
+	// annotations here
+	if _, err := linux.ParseImageRootfs(c.Spec, c.Bundle, c.StateDir); err != nil {
+		return cerrors.WrapWithDetail(err, cerrors.ErrInvalidConfig, "create", "image rootfs")
+	}
+	if _, err := linux.ParseOverlayRootfs(c.Spec, c.Bundle); err != nil {
+		return cerrors.WrapWithDetail(err, cerrors.ErrInvalidConfig, "create", "overlay rootfs")
+	}
+	if err := linux.ValidateProcMounts(c.Spec); err != nil {
+		return cerrors.WrapWithDetail(err, cerrors.ErrInvalidConfig, "create", "proc mount")
+	}
+	if err := linux.ValidateSysctls(c.Spec); err != nil {
+		return cerrors.WrapWithDetail(err, cerrors.ErrInvalidConfig, "create", "sysctl")
+	}
+	if err := linux.ValidateProcessLabels(c.Spec); err != nil {
+		return cerrors.WrapWithDetail(err, cerrors.ErrInvalidConfig, "create", "process label")
+	}
+	etcFiles, err := linux.ParseEtcFiles(c.Spec)
+	if err != nil {
+		return cerrors.WrapWithDetail(err, cerrors.ErrInvalidConfig, "create", "etc files")
+	}
+	devicePolicy, err := linux.LoadDevicePolicy(linux.DevicePolicyPath)
+	if err != nil {
+		return cerrors.WrapWithDetail(err, cerrors.ErrDevice, "create", "device policy")
+	}
+	if err := linux.ValidateDevices(c.Spec, devicePolicy); err != nil {
+		return cerrors.WrapWithDetail(err, cerrors.ErrInvalidConfig, "create", "devices")
+	}
+	// The init checks the nodes it makes against this same policy
+	encodedPolicy, err := devicePolicy.Encode()
+	if err != nil {
+		return cerrors.WrapWithDetail(err, cerrors.ErrDevice, "create", "device policy")
+	}
+
 	// Create exec FIFO for synchronization
 	if err := c.CreateExecFifo(); err != nil {
 		return cerrors.Wrap(err, cerrors.ErrResource, "create exec fifo")
 	}
 
 	// Cleanup function to call on error after FIFO is created
-	var cgroup *linux.Cgroup
+	var cgroup linux.CgroupManager
 	cleanup := func() {
 		// Remove FIFO
 		os.Remove(c.ExecFifoPath())
@@ -61,31 +107,60 @@ func (c *Container) Create(ctx context.Context, opts *CreateOptions) error {
 		}
 	}
 
+	// The generated /etc files live in the state directory, which delete
+	// removes
+	if etcFiles != nil {
+		if err := etcFiles.Generate(c.StateDir); err != nil {
+			cleanup()
+			return cerrors.Wrap(err, cerrors.ErrResource, "generate etc files")
+		}
+	}
+
+	// Without real root the cgroup goes below the user's systemd manager;
+	// when that is not possible the container runs without one
+	rootless := linux.IsRootless()
+	c.State.Rootless = rootless
+
 	// Setup cgroup
 	cgroupPath := linux.GetCgroupPath(c.ID, "")
+	if opts.SystemdCgroup {
+		cgroupPath = linux.DefaultSystemdCgroupPath(c.ID)
+		if rootless {
+			cgroupPath = linux.DefaultUserSystemdCgroupPath(c.ID)
+		}
+	} else if rootless {
+		if cgroupPath, err = linux.RootlessCgroupPath(c.ID); err != nil {
+			fmt.Fprintf(os.Stderr, "[create] warning: running without a cgroup: %v\n", err)
+		}
+	}
 	if c.Spec.Linux != nil && c.Spec.Linux.CgroupsPath != "" {
 		cgroupPath = c.Spec.Linux.CgroupsPath
 	}
-	c.CgroupPath = cgroupPath
-
-	// Enable parent controllers
-	linux.EnsureParentControllers(cgroupPath)
 
-	// Create cgroup
-	var err error
-	cgroup, err = linux.NewCgroup(cgroupPath)
-	if err != nil {
-		cleanup()
-		return fmt.Errorf("create cgroup: %w", err)
-	}
+	if cgroupPath != "" {
+		// Enable parent controllers (systemd does this for its own slices)
+		if !opts.SystemdCgroup {
+			linux.EnsureParentControllers(cgroupPath)
+		}
 
-	// Apply resource limits
-	if c.Spec.Linux != nil && c.Spec.Linux.Resources != nil {
-		if err := cgroup.ApplyResources(c.Spec.Linux.Resources); err != nil {
-			cleanup()
-			return fmt.Errorf("apply resources: %w", err)
+		// Create cgroup (v2, or v1 on legacy and hybrid hosts)
+		// Devices are denied but for the defaults, the spec's rules and
+		// its device nodes, and the device policy's denials come last
+		resources := linux.DeviceResources(c.Spec.Linux, devicePolicy)
+		cgroup, err = createCgroup(cgroupPath, opts.SystemdCgroup, resources)
+		if err != nil {
+			// A rootless user may lack the delegation or controllers
+			if !rootless || opts.SystemdCgroup {
+				cleanup()
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "[create] warning: running without a cgroup: %v\n", err)
+			cgroupPath = ""
 		}
 	}
+	c.CgroupPath = cgroupPath
+	c.State.CgroupPath = cgroupPath
+	c.State.SystemdCgroup = opts.SystemdCgroup
 
 	// Get path to our own executable
 	self, err := os.Executable()
@@ -105,13 +180,99 @@ func (c *Container) Create(ctx context.Context, opts *CreateOptions) error {
 	}
 	cmd.SysProcAttr = sysProcAttr
 
+	// Mappings beyond the caller's own IDs are written by newuidmap and
+	// newgidmap once the init exists. The init then re-executes itself to
+	// gain the capabilities of container root.
+	mapIDs := linux.NeedIDMapHelpers(c.Spec.Linux)
+	if mapIDs {
+		sysProcAttr.UidMappings = nil
+		sysProcAttr.GidMappings = nil
+		sysProcAttr.Credential = nil
+	}
+
+	// The init opens the FIFO through this descriptor, so it needs no
+	// access to the state directory, which container root may not have
+	fifo, err := openExecFifoPath(c.ExecFifoPath(), c.Spec.Linux, rootless)
+	if err != nil {
+		cleanup()
+		return err
+	}
+	defer fifo.Close()
+
+	// The init blocks on this pipe until it has been added to the cgroup,
+	// so its cgroup namespace and limits apply from the start
+	syncRead, syncWrite, err := os.Pipe()
+	if err != nil {
+		cleanup()
+		return fmt.Errorf("create sync pipe: %w", err)
+	}
+	defer syncRead.Close()
+	defer syncWrite.Close()
+
+	// The init reports on this pipe whether it could set up the container
+	statusRead, statusWrite, err := os.Pipe()
+	if err != nil {
+		cleanup()
+		return fmt.Errorf("create status pipe: %w", err)
+	}
+	defer statusRead.Close()
+	defer statusWrite.Close()
+	cmd.ExtraFiles = []*os.File{syncRead, fifo, statusWrite}
+
+	// ID-mapped mounts and an image rootfs need host privileges, and the
+	// generated /etc files sit in the state directory, so they are
+	// prepared here and passed on as descriptors after the fixed ones
+	mountTrees, err := linux.OpenIDMappedMounts(c.Spec, c.Bundle)
+	if err != nil {
+		cleanup()
+		return err
+	}
+	rootfsTree, err := linux.OpenImageRootfs(c.Spec, c.Bundle)
+	if err != nil {
+		for _, tree := range mountTrees {
+			tree.Close()
+		}
+		cleanup()
+		return cerrors.WrapWithDetail(err, cerrors.ErrRootfs, "create", c.Spec.Root.Path)
+	}
+	if rootfsTree != nil {
+		mountTrees[linux.RootfsMountTree] = rootfsTree
+	}
+	etcTrees, err := linux.OpenEtcFileMounts(c.Spec, c.StateDir)
+	if err != nil {
+		for _, tree := range mountTrees {
+			tree.Close()
+		}
+		cleanup()
+		return cerrors.Wrap(err, cerrors.ErrResource, "etc files")
+	}
+	for i, tree := range etcTrees {
+		mountTrees[i] = tree
+	}
+	var mountFds []string
+	for i, tree := range mountTrees {
+		defer tree.Close()
+		mountFds = append(mountFds, fmt.Sprintf("%d:%d", i, initSyncFd+len(cmd.ExtraFiles)))
+		cmd.ExtraFiles = append(cmd.ExtraFiles, tree)
+	}
+
 	// Setup environment for init
 	cmd.Env = append(os.Environ(),
 		fmt.Sprintf("_RUNC_GO_INIT_BUNDLE=%s", c.Bundle),
 		fmt.Sprintf("_RUNC_GO_INIT_FIFO=%s", c.ExecFifoPath()),
 		fmt.Sprintf("_RUNC_GO_INIT_ID=%s", c.ID),
 		fmt.Sprintf("_RUNC_GO_STATE_DIR=%s", c.StateDir),
+		fmt.Sprintf("_RUNC_GO_INIT_SYNC=%d", initSyncFd),
+		fmt.Sprintf("_RUNC_GO_INIT_FIFO_FD=%d", initFifoFd),
+		fmt.Sprintf("_RUNC_GO_INIT_STATUS=%d", initStatusFd),
+		"_RUNC_GO_INIT_DEVICE_POLICY="+encodedPolicy,
 	)
+	if mapIDs {
+		cmd.Env = append(cmd.Env, "_RUNC_GO_INIT_REEXEC=1")
+	}
+	if len(mountFds) > 0 {
+		cmd.Env = append(cmd.Env, "_RUNC_GO_INIT_MOUNT_FDS="+strings.Join(mountFds, ","))
+	}
 
 	// Setup stdin/stdout/stderr
 	var console *utils.Console
@@ -173,14 +334,50 @@ func (c *Container) Create(ctx context.Context, opts *CreateOptions) error {
 		}
 	}
 
+	// Only the init may hold the write end, so its exit is seen as EOF
+	statusWrite.Close()
+
 	c.InitProcess = cmd.Process.Pid
 	c.State.Pid = c.InitProcess
 
 	// Add process to cgroup
-	if err := cgroup.AddProcess(c.InitProcess); err != nil {
+	if cgroup != nil {
+		if err := cgroup.AddProcess(c.InitProcess); err != nil {
+			cmd.Process.Kill()
+			cleanup()
+			return fmt.Errorf("add to cgroup: %w", err)
+		}
+	}
+
+	// The helpers need the init's pid, so they run while it waits
+	if mapIDs {
+		if err := linux.MapIDs(c.InitProcess, c.Spec.Linux.UIDMappings, c.Spec.Linux.GIDMappings); err != nil {
+			cmd.Process.Kill()
+			cleanup()
+			return fmt.Errorf("map user namespace IDs: %w", err)
+		}
+	}
+
+	// Hand the cgroup to container root for nested managers
+	if cgroup != nil && linux.CgroupDelegated(c.Spec.Annotations) {
+		if err := delegateCgroup(cgroup, c.Spec.Linux); err != nil {
+			cmd.Process.Kill()
+			cleanup()
+			return fmt.Errorf("delegate cgroup: %w", err)
+		}
+	}
+	if _, err := syncWrite.Write([]byte{0}); err != nil {
+		cmd.Process.Kill()
+		cleanup()
+		return fmt.Errorf("signal init: %w", err)
+	}
+
+	// A failed mount or masked path aborts the creation
+	if err := waitForInitSetup(statusRead, c.ID); err != nil {
 		cmd.Process.Kill()
+		cmd.Wait()
 		cleanup()
-		return fmt.Errorf("add to cgroup: %w", err)
+		return err
 	}
 
 	// Write PID file if requested
@@ -206,14 +403,252 @@ func (c *Container) Create(ctx context.Context, opts *CreateOptions) error {
 	return nil
 }
 
+// openCgroup returns the cgroup manager for a container cgroup path. With
+// systemd the scope unit is only created once the first process is added.
+func openCgroup(cgroupPath string, systemd bool) (linux.CgroupManager, error) {
+	if systemd {
+		cgroup, err := linux.NewSystemdCgroup(cgroupPath)
+		if err != nil {
+			return nil, err
+		}
+		return cgroup, nil
+	}
+	return linux.NewCgroupManager(cgroupPath)
+}
+
+// createCgroup creates the container's cgroup and applies its resource
+// limits. The cgroup is removed again when the limits cannot be applied.
+func createCgroup(cgroupPath string, systemd bool, resources *spec.LinuxResources) (linux.CgroupManager, error) {
+	cgroup, err := openCgroup(cgroupPath, systemd)
+	if err != nil {
+		return nil, fmt.Errorf("create cgroup: %w", err)
+	}
+	if resources != nil {
+		if err := cgroup.ApplyResources(resources); err != nil {
+			cgroup.Destroy()
+			return nil, fmt.Errorf("apply resources: %w", err)
+		}
+	}
+	return cgroup, nil
+}
+
+// delegateCgroup gives the cgroup to the host IDs of container root.
+func delegateCgroup(cgroup linux.CgroupManager, l *spec.Linux) error {
+	uid, gid, err := linux.RootHostIDs(l)
+	if err != nil {
+		return err
+	}
+	return cgroup.Delegate(uid, gid)
+}
+
+const (
+	// initSyncFd is the init's end of the sync pipe, the first ExtraFiles
+	// entry.
+	initSyncFd = 3
+
+	// initFifoFd is an O_PATH descriptor of the exec FIFO.
+	initFifoFd = 4
+
+	// initStatusFd is the init's end of the status pipe, on which it
+	// reports the outcome of its setup.
+	initStatusFd = 5
+)
+
+// initSetupError is the message an init sends on the status pipe when it
+// fails to set up the container.
+type initSetupError struct {
+	Kind    cerrors.ErrorKind `json:"kind"`
+	Op      string            `json:"op"`
+	Detail  string            `json:"detail,omitempty"`
+	Message string            `json:"message,omitempty"`
+}
+
+// initReporter sends the outcome of the init's setup to the parent. A
+// zero byte means the container is set up; anything else is an
+// initSetupError.
+type initReporter struct {
+	pipe *os.File
+}
+
+// newInitReporter returns a reporter on the status pipe passed by the
+// parent. Without one, reports are dropped.
+func newInitReporter() *initReporter {
+	n, err := strconv.Atoi(os.Getenv("_RUNC_GO_INIT_STATUS"))
+	if err != nil {
+		return &initReporter{}
+	}
+	// The inherited descriptor survives a re-exec of the init
+	return &initReporter{pipe: os.NewFile(uintptr(n), "status")}
+}
+
+// ready reports a successful setup and closes the pipe.
+func (r *initReporter) ready() {
+	if r.pipe == nil {
+		return
+	}
+	r.pipe.Write([]byte{0})
+	r.pipe.Close()
+	r.pipe = nil
+}
+
+// fail reports err unless the setup was already reported.
+func (r *initReporter) fail(err error) {
+	if r.pipe == nil {
+		return
+	}
+	msg := initSetupError{Kind: cerrors.ErrInternal, Op: "init", Message: err.Error()}
+	var cerr *cerrors.ContainerError
+	if errors.As(err, &cerr) {
+		msg = initSetupError{Kind: cerr.Kind, Op: cerr.Op, Detail: cerr.Detail}
+		if cerr.Err != nil {
+			msg.Message = cerr.Err.Error()
+		}
+	}
+	json.NewEncoder(r.pipe).Encode(msg)
+	r.pipe.Close()
+	r.pipe = nil
+}
+
+// waitForInitSetup waits for the init's report on the status pipe and
+// returns the error it sent. EOF without a report means the init died.
+func waitForInitSetup(status *os.File, id string) error {
+	r := bufio.NewReader(status)
+	b, err := r.Peek(1)
+	if err != nil {
+		return cerrors.WrapWithDetail(err, cerrors.ErrInternal, "init", "exited during setup")
+	}
+	if b[0] == 0 {
+		return nil
+	}
+	var msg initSetupError
+	if err := json.NewDecoder(r).Decode(&msg); err != nil {
+		return cerrors.Wrap(err, cerrors.ErrInternal, "read init status")
+	}
+	cerr := &cerrors.ContainerError{
+		Op:        msg.Op,
+		Container: id,
+		Kind:      msg.Kind,
+		Detail:    msg.Detail,
+	}
+	if msg.Message != "" {
+		cerr.Err = errors.New(msg.Message)
+	}
+	return cerr
+}
+
+// openExecFifoPath opens the exec FIFO with O_PATH for the init and gives
+// it to container root, which reopens it through /proc/self/fd.
+// Unprivileged callers cannot chown to subordinate IDs; their FIFO is
+// already owned by the caller.
+func openExecFifoPath(path string, l *spec.Linux, rootless bool) (*os.File, error) {
+	uid, gid, err := linux.RootHostIDs(l)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chown(path, uid, gid); err != nil && !rootless {
+		return nil, fmt.Errorf("chown exec fifo: %w", err)
+	}
+	fd, err := syscall.Open(path, unix.O_PATH|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("open exec fifo: %w", err)
+	}
+	return os.NewFile(uintptr(fd), path), nil
+}
+
+// openExecFifo opens the exec FIFO for reading in the init. The path is
+// used when the parent passed no descriptor. It is opened read-write, which
+// does not wait for start to open the other end, so the init can finish
+// its setup and report it first; the read then blocks until start writes.
+func openExecFifo(path string) (*os.File, error) {
+	if fd := os.Getenv("_RUNC_GO_INIT_FIFO_FD"); fd != "" {
+		n, err := strconv.Atoi(fd)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fifo fd %q", fd)
+		}
+		// The inherited descriptor is not close-on-exec
+		pathFile := os.NewFile(uintptr(n), "exec.fifo")
+		defer pathFile.Close()
+		path = "/proc/self/fd/" + fd
+	}
+	return os.OpenFile(path, os.O_RDWR, 0)
+}
+
+// inheritedMountTrees returns the ID-mapped mount trees passed by the
+// parent, keyed by mount index.
+func inheritedMountTrees() (map[int]*os.File, error) {
+	trees := make(map[int]*os.File)
+	list := os.Getenv("_RUNC_GO_INIT_MOUNT_FDS")
+	if list == "" {
+		return trees, nil
+	}
+	for _, entry := range strings.Split(list, ",") {
+		index, fd, ok := strings.Cut(entry, ":")
+		i, iErr := strconv.Atoi(index)
+		n, fdErr := strconv.Atoi(fd)
+		if !ok || iErr != nil || fdErr != nil {
+			return nil, fmt.Errorf("invalid mount fd %q", entry)
+		}
+		trees[i] = os.NewFile(uintptr(n), "mount")
+	}
+	return trees, nil
+}
+
+// reexecInit executes the init again after its ID mappings were written
+// from outside. The first exec ran unmapped and dropped all capabilities;
+// now the process is container root and regains them.
+func reexecInit() error {
+	var env []string
+	for _, kv := range os.Environ() {
+		key, _, _ := strings.Cut(kv, "=")
+		if key != "_RUNC_GO_INIT_SYNC" && key != "_RUNC_GO_INIT_REEXEC" {
+			env = append(env, kv)
+		}
+	}
+	if err := syscall.Exec("/proc/self/exe", os.Args, env); err != nil {
+		return fmt.Errorf("re-exec init: %w", err)
+	}
+	return nil
+}
+
+// waitForCgroup blocks until the parent has moved the process into the
+// container's cgroup, on the sync pipe named by the env variable key. EOF
+// means the parent gave up.
+func waitForCgroup(key string) error {
+	fd := os.Getenv(key)
+	if fd == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(fd)
+	if err != nil {
+		return fmt.Errorf("invalid sync fd %q", fd)
+	}
+	pipe := os.NewFile(uintptr(n), "sync")
+	defer pipe.Close()
+
+	buf := make([]byte, 1)
+	if _, err := pipe.Read(buf); err != nil {
+		return fmt.Errorf("wait for cgroup: %w", err)
+	}
+	return nil
+}
+
 // InitContainer is called inside the container namespace to complete setup.
-// This is executed by the re-exec'd process.
+// This is executed by the re-exec'd process. Errors before the container
+// is set up are also reported to the parent, which fails the create.
 func InitContainer() error {
+	status := newInitReporter()
+	err := initContainer(status)
+	status.fail(err)
+	return err
+}
+
+// initContainer sets up the container and execs its process.
+func initContainer(status *initReporter) error {
 	// Get init parameters from environment
 	bundle := os.Getenv("_RUNC_GO_INIT_BUNDLE")
 	fifoPath := os.Getenv("_RUNC_GO_INIT_FIFO")
 	// containerID := os.Getenv("_RUNC_GO_INIT_ID")
-	// stateDir := os.Getenv("_RUNC_GO_STATE_DIR")
+	stateDir := os.Getenv("_RUNC_GO_STATE_DIR")
 
 	if bundle == "" || fifoPath == "" {
 		return fmt.Errorf("missing init environment")
@@ -226,11 +661,40 @@ func InitContainer() error {
 		return fmt.Errorf("load spec: %w", err)
 	}
 
+	if err := waitForCgroup("_RUNC_GO_INIT_SYNC"); err != nil {
+		return err
+	}
+	if os.Getenv("_RUNC_GO_INIT_REEXEC") != "" {
+		return reexecInit()
+	}
+	// The policy is the host's business, not the container process's
+	devicePolicy, err := linux.DecodeDevicePolicy(os.Getenv("_RUNC_GO_INIT_DEVICE_POLICY"))
+	if err != nil {
+		return err
+	}
+	os.Unsetenv("_RUNC_GO_INIT_DEVICE_POLICY")
+
 	// Join namespaces if paths specified
 	if s.Linux != nil {
 		if err := linux.SetNamespaces(s.Linux.Namespaces); err != nil {
 			return fmt.Errorf("set namespaces: %w", err)
 		}
+		// A delegated cgroup keeps the init in a leaf, but the namespace
+		// is rooted at the container's cgroup joined above
+		var leaf string
+		if linux.CgroupDelegated(s.Annotations) {
+			if leaf, err = linux.InitCgroupLeaf(); err != nil {
+				return fmt.Errorf("find init cgroup leaf: %w", err)
+			}
+		}
+		if err := linux.UnshareCgroupNamespace(s.Linux.Namespaces); err != nil {
+			return err
+		}
+		if leaf != "" {
+			if err := linux.EnterCgroup(leaf); err != nil {
+				return err
+			}
+		}
 	}
 
 	// Set hostname
@@ -248,29 +712,36 @@ func InitContainer() error {
 	}
 
 	// IMPORTANT: Open FIFO BEFORE pivot_root, as it won't be accessible after
-	fifo, err := os.OpenFile(fifoPath, os.O_RDONLY, 0)
+	fifo, err := openExecFifo(fifoPath)
 	if err != nil {
 		return fmt.Errorf("open fifo: %w", err)
 	}
 
 	// Setup rootfs (pivot_root, mounts, etc.)
-	if err := linux.SetupRootfs(s, bundle); err != nil {
+	mountTrees, err := inheritedMountTrees()
+	if err != nil {
+		fifo.Close()
+		return err
+	}
+	if err := linux.SetupRootfs(s, bundle, stateDir, mountTrees, devicePolicy); err != nil {
 		fifo.Close()
 		return fmt.Errorf("setup rootfs: %w", err)
 	}
 
-	// Setup devices
-	if s.Linux != nil && len(s.Linux.Devices) > 0 {
-		if err := linux.CreateDevices(s.Linux.Devices); err != nil {
-			fmt.Printf("[init] warning: create devices: %v\n", err)
+	// Both go through the container's /proc, which must be procfs. The
+	// label is per thread: the user process is forked from this one.
+	if s.Process != nil {
+		if err := linux.SetOOMScoreAdj(s.Process.OOMScoreAdj); err != nil {
+			fifo.Close()
+			return fmt.Errorf("set oom score adj: %w", err)
+		}
+		runtime.LockOSThread()
+		if err := linux.SetProcessLabel(s.Process); err != nil {
+			fifo.Close()
+			return fmt.Errorf("set process label: %w", err)
 		}
 	}
 
-	// Setup default devices
-	linux.SetupDefaultDevices()
-	linux.SetupDevSymlinks()
-	linux.SetupDevPts()
-
 	// Change to working directory
 	if s.Process != nil && s.Process.Cwd != "" {
 		if err := os.Chdir(s.Process.Cwd); err != nil {
@@ -279,6 +750,8 @@ func InitContainer() error {
 		}
 	}
 
+	status.ready()
+
 	// Now wait on FIFO - this blocks until Start() is called
 	// Read from FIFO (blocks until writer connects)
 	buf := make([]byte, 1)
@@ -289,18 +762,6 @@ func InitContainer() error {
 		return fmt.Errorf("read fifo: %w", err)
 	}
 
-	// Create /dev/console if stdin is a PTY (character device)
-	// Go's Setctty flag handles setsid() and TIOCSCTTY automatically
-	var stat syscall.Stat_t
-	if err := syscall.Fstat(0, &stat); err == nil {
-		if stat.Mode&syscall.S_IFCHR != 0 {
-			os.Remove("/dev/console")
-			if err := syscall.Mknod("/dev/console", syscall.S_IFCHR|0600, int(stat.Rdev)); err != nil {
-				fmt.Printf("[init] warning: failed to create /dev/console: %v\n", err)
-			}
-		}
-	}
-
 	// Apply capabilities
 	if s.Process != nil && s.Process.Capabilities != nil {
 		if err := linux.ApplyCapabilities(s.Process.Capabilities); err != nil {
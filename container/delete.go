@@ -37,28 +37,60 @@ func Delete(ctx context.Context, id, stateRoot string, opts *DeleteOptions) erro
 	c.RefreshStatus()
 
 	// Check if running
-	if c.IsRunning() {
-		if !opts.Force {
-			return fmt.Errorf("container is running, use --force to kill it")
-		}
+	running := c.IsRunning()
+	if running && !opts.Force {
+		return fmt.Errorf("container is running, use --force to kill it")
+	}
 
-		// Force kill
-		if err := c.Signal(syscall.SIGKILL); err != nil {
-			return fmt.Errorf("kill container: %w", err)
+	cgroup, cgroupErr := c.cgroup()
+	if opts.Force {
+		// Kill through the cgroup so processes that escaped the init
+		// process group die too
+		if cgroupErr == nil {
+			if err := cgroup.Kill(syscall.SIGKILL); err != nil {
+				return fmt.Errorf("kill container: %w", err)
+			}
+		} else if running {
+			if err := c.Signal(syscall.SIGKILL); err != nil {
+				return fmt.Errorf("kill container: %w", err)
+			}
 		}
 
 		// Wait for process to exit
-		waitForExit(ctx, c.InitProcess, 5*time.Second)
+		if running {
+			waitForExit(ctx, c.InitProcess, 5*time.Second)
+		}
 	}
 
-	// Clean up cgroup
-	cgroupPath := linux.GetCgroupPath(c.ID, "")
-	if c.CgroupPath != "" {
-		cgroupPath = c.CgroupPath
+	// Clean up cgroup. Leftover processes keep the state so delete can be
+	// retried.
+	if cgroupErr == nil {
+		timeout := time.Duration(0)
+		if opts.Force {
+			timeout = 5 * time.Second
+		}
+		if err := waitForEmptyCgroup(ctx, cgroup, timeout); err != nil {
+			if !opts.Force {
+				return fmt.Errorf("%w, use --force to kill them", err)
+			}
+			return err
+		}
+		// Keep the last counters in the state in case removal fails
+		if c.RefreshCgroupStats() {
+			c.SaveState()
+		}
+		if err := cgroup.Destroy(); err != nil {
+			return fmt.Errorf("remove cgroup: %w", err)
+		}
+	}
+
+	// An overlay rootfs may leave mounts or a used work directory behind,
+	// and an image rootfs its loop device
+	if err := linux.TeardownOverlayRootfs(c.Spec, c.Bundle); err != nil {
+		return fmt.Errorf("tear down rootfs: %w", err)
 	}
-	cgroup, err := linux.NewCgroup(cgroupPath)
-	if err == nil {
-		cgroup.Destroy()
+	if err := linux.TeardownImageRootfs(c.Spec, c.Bundle); err != nil {
+		return fmt.Errorf("tear down rootfs: %w", err)
 	}
 
 	// Remove exec FIFO if it exists
@@ -89,10 +121,33 @@ func waitForExit(ctx context.Context, pid int, timeout time.Duration) {
 	}
 }
 
+// waitForEmptyCgroup polls until the cgroup and its descendants hold no
+// processes. It checks at least once, even with a zero timeout.
+func waitForEmptyCgroup(ctx context.Context, cgroup linux.CgroupManager, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		pids, err := cgroup.Procs()
+		if err != nil {
+			return fmt.Errorf("list cgroup processes: %w", err)
+		}
+		if len(pids) == 0 {
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("cgroup %s still has processes %v", cgroup.Path(), pids)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
 // Cleanup removes all state for containers that are no longer running.
 func Cleanup(ctx context.Context, stateRoot string) error {
 	if stateRoot == "" {
-		stateRoot = DefaultStateDir
+		stateRoot = DefaultStateRoot()
 	}
 
 	entries, err := os.ReadDir(stateRoot)
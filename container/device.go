@@ -0,0 +1,203 @@
+// Package container implements device hot-plug.
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	cerrors "runc-go/errors"
+	"runc-go/linux"
+	"runc-go/spec"
+)
+
+// AddDevice gives the live container id the device dev, allows it in the
+// container's device cgroup with access, and records it in the
+// container's spec.
+func AddDevice(ctx context.Context, id, stateRoot string, dev spec.LinuxDevice, access string) error {
+	c, err := loadForDevices(ctx, id, stateRoot, "device add")
+	if err != nil {
+		return err
+	}
+	if access == "" || strings.Trim(access, "rwm") != "" {
+		return cerrors.WrapWithDetail(fmt.Errorf("invalid access %q, want a combination of r, w and m", access), cerrors.ErrInvalidConfig, "device add", dev.Path)
+	}
+	dev.Path = filepath.Clean(dev.Path)
+	if _, ok := findDevice(c.Spec, dev.Path); ok {
+		return cerrors.WrapWithDetail(os.ErrExist, cerrors.ErrAlreadyExists, "device add", dev.Path)
+	}
+
+	policy, err := linux.LoadDevicePolicy(linux.DevicePolicyPath)
+	if err != nil {
+		return cerrors.WrapWithDetail(err, cerrors.ErrDevice, "device add", "device policy")
+	}
+	if err := linux.ValidateDevices(&spec.Spec{Linux: &spec.Linux{Devices: []spec.LinuxDevice{dev}}}, policy); err != nil {
+		return cerrors.WrapWithDetail(err, cerrors.ErrInvalidConfig, "device add", dev.Path)
+	}
+
+	next := withDevices(c.Spec)
+	next.Linux.Devices = append(next.Linux.Devices, dev)
+	if dev.Type != "p" {
+		if next.Linux.Resources == nil {
+			next.Linux.Resources = &spec.LinuxResources{}
+		}
+		devType := dev.Type
+		if devType == "u" {
+			devType = "c"
+		}
+		major, minor := dev.Major, dev.Minor
+		next.Linux.Resources.Devices = append(next.Linux.Resources.Devices, spec.LinuxDeviceCgroup{
+			Allow:  true,
+			Type:   devType,
+			Major:  &major,
+			Minor:  &minor,
+			Access: access,
+		})
+	}
+
+	userns := linux.HasNamespace(c.Spec.Linux.Namespaces, spec.UserNamespace)
+	if err := linux.AddDevice(c.InitProcess, dev, userns); err != nil {
+		return cerrors.WrapWithDetail(err, cerrors.ErrDevice, "device add", dev.Path)
+	}
+	if err := c.updateDeviceRules(c.Spec, next, policy); err != nil {
+		linux.RemoveDevice(c.InitProcess, dev.Path)
+		return cerrors.WrapWithDetail(err, cerrors.ErrCgroup, "device add", dev.Path)
+	}
+	return c.saveSpec(next)
+}
+
+// RemoveDevice takes the device at path, one of its spec's, away from the
+// live container id, and revokes the device cgroup rules allowing it.
+func RemoveDevice(ctx context.Context, id, stateRoot, path string) error {
+	c, err := loadForDevices(ctx, id, stateRoot, "device rm")
+	if err != nil {
+		return err
+	}
+	path = filepath.Clean(path)
+	i, ok := findDevice(c.Spec, path)
+	if !ok {
+		return cerrors.WrapWithDetail(os.ErrNotExist, cerrors.ErrNotFound, "device rm", path)
+	}
+	dev := c.Spec.Linux.Devices[i]
+
+	policy, err := linux.LoadDevicePolicy(linux.DevicePolicyPath)
+	if err != nil {
+		return cerrors.WrapWithDetail(err, cerrors.ErrDevice, "device rm", "device policy")
+	}
+
+	next := withDevices(c.Spec)
+	next.Linux.Devices = append(next.Linux.Devices[:i:i], next.Linux.Devices[i+1:]...)
+	if r := next.Linux.Resources; r != nil && dev.Type != "p" {
+		devType := dev.Type
+		if devType == "u" {
+			devType = "c"
+		}
+		var rules []spec.LinuxDeviceCgroup
+		for _, rule := range r.Devices {
+			if rule.Allow && rule.Type == devType && rule.Major != nil && *rule.Major == dev.Major &&
+				rule.Minor != nil && *rule.Minor == dev.Minor {
+				continue
+			}
+			rules = append(rules, rule)
+		}
+		r.Devices = rules
+	}
+
+	// Access goes first, so the node is of no use once the rules are
+	// revoked even if removing it fails
+	if err := c.updateDeviceRules(c.Spec, next, policy); err != nil {
+		return cerrors.WrapWithDetail(err, cerrors.ErrCgroup, "device rm", path)
+	}
+	if err := linux.RemoveDevice(c.InitProcess, path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return cerrors.WrapWithDetail(err, cerrors.ErrDevice, "device rm", path)
+	}
+	return c.saveSpec(next)
+}
+
+// loadForDevices loads container id for a device change, which needs a
+// live init and, for mknod and the mount namespace, real root.
+func loadForDevices(ctx context.Context, id, stateRoot, op string) (*Container, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	c, err := Load(ctx, id, stateRoot)
+	if err != nil {
+		return nil, fmt.Errorf("load container: %w", err)
+	}
+	c.RefreshStatus()
+	if c.State.Status != spec.StatusCreated && c.State.Status != spec.StatusRunning {
+		return nil, cerrors.WrapWithContainer(nil, cerrors.ErrInvalidState, op, id)
+	}
+	if c.Spec == nil || c.Spec.Linux == nil {
+		return nil, cerrors.New(cerrors.ErrInvalidConfig, op, "spec has no linux section")
+	}
+	if os.Geteuid() != 0 {
+		return nil, cerrors.New(cerrors.ErrPermission, op, "changing a container's devices needs root")
+	}
+	return c, nil
+}
+
+// findDevice returns the index of the device at path in the spec's
+// devices.
+func findDevice(s *spec.Spec, path string) (int, bool) {
+	for i, dev := range s.Linux.Devices {
+		if filepath.Clean(dev.Path) == path {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// withDevices returns a copy of s whose devices and device rules can be
+// changed without touching those of s.
+func withDevices(s *spec.Spec) *spec.Spec {
+	next := *s
+	l := *s.Linux
+	l.Devices = append([]spec.LinuxDevice(nil), s.Linux.Devices...)
+	if s.Linux.Resources != nil {
+		r := *s.Linux.Resources
+		r.Devices = append([]spec.LinuxDeviceCgroup(nil), r.Devices...)
+		l.Resources = &r
+	}
+	next.Linux = &l
+	return &next
+}
+
+// updateDeviceRules moves the container's device cgroup from the rules
+// generated for one spec to those of the next.
+func (c *Container) updateDeviceRules(from, to *spec.Spec, policy *linux.DevicePolicy) error {
+	var fromRules, toRules []spec.LinuxDeviceCgroup
+	if r := linux.DeviceResources(from.Linux, policy); r != nil {
+		fromRules = r.Devices
+	}
+	if r := linux.DeviceResources(to.Linux, policy); r != nil {
+		toRules = r.Devices
+	}
+	cgroup, err := c.cgroup()
+	if errors.Is(err, errNoCgroup) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return linux.UpdateDeviceRules(cgroup, fromRules, toRules)
+}
+
+// saveSpec records s as the container's spec in its state, which Load
+// prefers over the bundle's config.json from then on.
+func (c *Container) saveSpec(s *spec.Spec) error {
+	c.mu.Lock()
+	c.Spec = s
+	c.State.Config = s
+	c.mu.Unlock()
+	if err := c.SaveState(); err != nil {
+		return cerrors.Wrap(err, cerrors.ErrInternal, "save state")
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package container
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	cerrors "runc-go/errors"
+	"runc-go/spec"
+)
+
+func TestWithDevices(t *testing.T) {
+	s := spec.DefaultSpec()
+	s.Linux.Devices = []spec.LinuxDevice{{Path: "/dev/fuse", Type: "c", Major: 10, Minor: 229}}
+	if s.Linux.Resources == nil {
+		s.Linux.Resources = &spec.LinuxResources{}
+	}
+	s.Linux.Resources.Devices = []spec.LinuxDeviceCgroup{{Allow: false, Access: "rwm"}}
+
+	next := withDevices(s)
+	next.Linux.Devices[0].Path = "/dev/changed"
+	next.Linux.Devices = append(next.Linux.Devices, spec.LinuxDevice{Path: "/dev/net/tun"})
+	next.Linux.Resources.Devices[0].Allow = true
+
+	if s.Linux.Devices[0].Path != "/dev/fuse" || len(s.Linux.Devices) != 1 {
+		t.Errorf("withDevices shares devices with the original: %+v", s.Linux.Devices)
+	}
+	if s.Linux.Resources.Devices[0].Allow {
+		t.Error("withDevices shares device rules with the original")
+	}
+}
+
+func TestSaveSpec(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "runc-go-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	bundleDir := filepath.Join(tmpDir, "bundle")
+	if err := os.MkdirAll(filepath.Join(bundleDir, "rootfs"), 0755); err != nil {
+		t.Fatalf("failed to create dirs: %v", err)
+	}
+	if err := spec.DefaultSpec().Save(filepath.Join(bundleDir, "config.json")); err != nil {
+		t.Fatalf("failed to write config.json: %v", err)
+	}
+
+	stateRoot := filepath.Join(tmpDir, "state")
+	ctx := context.Background()
+
+	c, err := New(ctx, "device-test", bundleDir, stateRoot)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	c.State.Status = spec.StatusStopped
+
+	next := withDevices(c.Spec)
+	next.Linux.Devices = append(next.Linux.Devices, spec.LinuxDevice{Path: "/dev/fuse", Type: "c", Major: 10, Minor: 229})
+	if err := c.saveSpec(next); err != nil {
+		t.Fatalf("saveSpec failed: %v", err)
+	}
+
+	// The spec in the state wins over the bundle's config.json
+	loaded, err := Load(ctx, "device-test", stateRoot)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if _, ok := findDevice(loaded.Spec, "/dev/fuse"); !ok {
+		t.Errorf("loaded spec lacks the saved device: %+v", loaded.Spec.Linux.Devices)
+	}
+
+	// Devices of a stopped container cannot change
+	err = RemoveDevice(ctx, "device-test", stateRoot, "/dev/fuse")
+	if !cerrors.IsKind(err, cerrors.ErrInvalidState) {
+		t.Errorf("RemoveDevice on a stopped container: got %v, want ErrInvalidState", err)
+	}
+}
@@ -17,6 +17,7 @@ import (
 	"golang.org/x/term"
 
 	cerrors "runc-go/errors"
+	"runc-go/linux"
 	"runc-go/spec"
 )
 
@@ -132,20 +133,47 @@ func Exec(ctx context.Context, containerID, stateRoot string, args []string, opt
 		fmt.Sprintf("_RUNC_GO_EXEC_ARGS=%s", encodedArgs),
 	)
 
+	// Join the cgroup namespace only if the container has its own
+	if c.Spec != nil && c.Spec.Linux != nil && linux.HasNamespace(c.Spec.Linux.Namespaces, spec.CgroupNamespace) {
+		cmd.Env = append(cmd.Env, "_RUNC_GO_EXEC_CGROUPNS=1")
+	}
+
 	// Add additional env vars
 	for _, e := range opts.Env {
 		cmd.Env = append(cmd.Env, "_RUNC_GO_EXEC_ENV_"+e)
 	}
 
+	// exec-init blocks on this pipe until it is in the container's cgroup,
+	// so neither it nor anything it starts runs outside the limits, or
+	// escapes kill --all
+	syncRead, syncWrite, err := os.Pipe()
+	if err != nil {
+		return cerrors.Wrap(err, cerrors.ErrResource, "create sync pipe")
+	}
+	defer syncRead.Close()
+	defer syncWrite.Close()
+	cmd.ExtraFiles = []*os.File{syncRead}
+	cmd.Env = append(cmd.Env, fmt.Sprintf("_RUNC_GO_EXEC_SYNC=%d", execSyncFd))
+	joinCgroup := func(pid int) error {
+		syncRead.Close()
+		if err := c.addExecProcess(pid); err != nil {
+			return cerrors.Wrap(err, cerrors.ErrCgroup, "add exec process to cgroup")
+		}
+		if _, err := syncWrite.Write([]byte{0}); err != nil {
+			return cerrors.Wrap(err, cerrors.ErrInternal, "release exec process")
+		}
+		return nil
+	}
+
 	// Handle TTY with console socket (containerd style)
 	if opts.Tty && opts.ConsoleSocket != "" {
-		return execWithConsoleSocket(cmd, opts)
+		return execWithConsoleSocket(cmd, opts, joinCgroup)
 	}
 
 	// Handle TTY without console socket (direct terminal)
 	if opts.Tty {
 		cmd.Env = append(cmd.Env, "_RUNC_GO_EXEC_TTY=1")
-		return execWithPTY(cmd, opts)
+		return execWithPTY(cmd, opts, joinCgroup)
 	}
 
 	// Non-TTY mode: just pass through stdin/stdout/stderr
@@ -157,6 +185,11 @@ func Exec(ctx context.Context, containerID, stateRoot string, args []string, opt
 	if err := cmd.Start(); err != nil {
 		return cerrors.Wrap(err, cerrors.ErrInternal, "start exec process")
 	}
+	if err := joinCgroup(cmd.Process.Pid); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
 
 	// Write PID file if requested
 	if opts.PidFile != "" {
@@ -184,7 +217,8 @@ func Exec(ctx context.Context, containerID, stateRoot string, args []string, opt
 }
 
 // execWithPTY runs the command with a pseudo-terminal for interactive use.
-func execWithPTY(cmd *exec.Cmd, opts *ExecOptions) error {
+// joinCgroup moves the started process into the container's cgroup.
+func execWithPTY(cmd *exec.Cmd, opts *ExecOptions, joinCgroup func(pid int) error) error {
 	// Open PTY master
 	ptmx, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
 	if err != nil {
@@ -254,6 +288,11 @@ func execWithPTY(cmd *exec.Cmd, opts *ExecOptions) error {
 	if err := cmd.Start(); err != nil {
 		return fmt.Errorf("start exec process: %w", err)
 	}
+	if err := joinCgroup(cmd.Process.Pid); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
 
 	// Close slave in parent (child has it)
 	slave.Close()
@@ -304,8 +343,9 @@ func execWithPTY(cmd *exec.Cmd, opts *ExecOptions) error {
 }
 
 // execWithConsoleSocket runs with PTY and sends master FD to console socket.
-// This is used by containerd to handle the PTY I/O.
-func execWithConsoleSocket(cmd *exec.Cmd, opts *ExecOptions) error {
+// This is used by containerd to handle the PTY I/O. joinCgroup moves the
+// started process into the container's cgroup.
+func execWithConsoleSocket(cmd *exec.Cmd, opts *ExecOptions, joinCgroup func(pid int) error) error {
 	// Open PTY master
 	ptmx, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
 	if err != nil {
@@ -346,6 +386,11 @@ func execWithConsoleSocket(cmd *exec.Cmd, opts *ExecOptions) error {
 	if err := cmd.Start(); err != nil {
 		return fmt.Errorf("start exec process: %w", err)
 	}
+	if err := joinCgroup(cmd.Process.Pid); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
 
 	// Close slave in parent (child has it)
 	slave.Close()
@@ -428,6 +473,10 @@ func setTerminalSize(f *os.File, width, height int) {
 	syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TIOCSWINSZ, uintptr(unsafe.Pointer(&ws)))
 }
 
+// execSyncFd is exec-init's end of the sync pipe, its only ExtraFiles
+// entry.
+const execSyncFd = 3
+
 // ExecInit is called to actually join the container and exec.
 // This uses nsenter to properly join all namespaces (including mount).
 func ExecInit() error {
@@ -445,6 +494,11 @@ func ExecInit() error {
 		return cerrors.ErrNoProcessArgs
 	}
 
+	// Namespaces are only entered from within the container's cgroup
+	if err := waitForCgroup("_RUNC_GO_EXEC_SYNC"); err != nil {
+		return cerrors.Wrap(err, cerrors.ErrCgroup, "exec-init")
+	}
+
 	// Collect additional environment variables
 	var extraEnv []string
 	for _, e := range os.Environ() {
@@ -463,6 +517,9 @@ func ExecInit() error {
 		"-n", // network namespace
 		"-p", // PID namespace
 	}
+	if os.Getenv("_RUNC_GO_EXEC_CGROUPNS") != "" {
+		nsenterArgs = append(nsenterArgs, "-C") // cgroup namespace
+	}
 
 	// Add separator and the command to execute
 	nsenterArgs = append(nsenterArgs, "--")
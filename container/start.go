@@ -99,8 +99,10 @@ func (c *Container) Wait(ctx context.Context) (int, error) {
 			return -1, cerrors.Wrap(result.err, cerrors.ErrInternal, "wait4")
 		}
 
-		// Update state
+		// Update state. Record the memory counters while the cgroup
+		// still exists; a systemd scope goes away with its last process.
 		c.State.Status = spec.StatusStopped
+		c.RefreshCgroupStats()
 		if saveErr := c.SaveState(); saveErr != nil {
 			// Log error but still return exit code - state save is non-critical for Wait()
 			fmt.Printf("[wait] warning: failed to save state: %v\n", saveErr)
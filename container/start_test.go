@@ -2,12 +2,15 @@ package container
 
 import (
 	"context"
+	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"syscall"
 	"testing"
 	"time"
 
+	"runc-go/linux"
 	"runc-go/spec"
 )
 
@@ -255,6 +258,57 @@ func TestWait_ContextCancellation(t *testing.T) {
 	}
 }
 
+// TestWait_RecordsCgroupStats verifies Wait saves the memory counters
+// when the init exits, before the cgroup can go away.
+func TestWait_RecordsCgroupStats(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("creating cgroups needs root")
+	}
+	cgroupPath := fmt.Sprintf("runc-go-test/wait-stats-%d", os.Getpid())
+	cgroup, err := linux.NewCgroupManager(cgroupPath)
+	if err != nil {
+		t.Skipf("cgroups unavailable: %v", err)
+	}
+	defer cgroup.Destroy()
+	if _, err := cgroup.GetMemoryEvents(); err != nil {
+		t.Skipf("memory events unavailable: %v", err)
+	}
+
+	cmd := exec.Command("sleep", "0.2")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cgroup.AddProcess(cmd.Process.Pid); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		t.Fatalf("AddProcess: %v", err)
+	}
+	stateDir := t.TempDir()
+	c := &Container{
+		ID:          "wait-stats",
+		StateDir:    stateDir,
+		CgroupPath:  cgroupPath,
+		InitProcess: cmd.Process.Pid,
+		State: &spec.ContainerState{
+			State: spec.State{Status: spec.StatusRunning},
+		},
+	}
+	if _, err := c.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+
+	saved, err := spec.LoadState(filepath.Join(stateDir, StateFileName))
+	if err != nil {
+		t.Fatalf("load state: %v", err)
+	}
+	if saved.Status != spec.StatusStopped {
+		t.Errorf("status = %q, want stopped", saved.Status)
+	}
+	if saved.MemoryEvents == nil {
+		t.Error("memory events were not saved when the container stopped")
+	}
+}
+
 // ============================================================================
 // RUN TESTS
 // ============================================================================
@@ -17,6 +17,7 @@ func State(ctx context.Context, id, stateRoot string) error {
 
 	// Refresh status based on actual process state
 	c.RefreshStatus()
+	c.RefreshCgroupStats()
 
 	// Get OCI state
 	state := c.GetState()
@@ -35,6 +36,7 @@ func StateJSON(ctx context.Context, id, stateRoot string) (string, error) {
 	}
 
 	c.RefreshStatus()
+	c.RefreshCgroupStats()
 	data, err := c.StateJSON()
 	if err != nil {
 		return "", err
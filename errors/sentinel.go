@@ -156,7 +156,7 @@ var (
 		Detail: "failed to create device",
 	}
 
-	// ErrDeviceNotAllowed indicates a device is not in the whitelist.
+	// ErrDeviceNotAllowed indicates a device the device policy does not allow.
 	ErrDeviceNotAllowed = &ContainerError{
 		Kind:   ErrDevice,
 		Detail: "device not allowed",
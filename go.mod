@@ -5,12 +5,12 @@ go 1.24.0
 toolchain go1.24.11
 
 require (
+	github.com/spf13/cobra v1.8.0
 	golang.org/x/sys v0.40.0
 	golang.org/x/term v0.39.0
 )
 
 require (
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
-	github.com/spf13/cobra v1.8.0 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
 )
@@ -0,0 +1,106 @@
+package image
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// layerMediaTypePrefixes are the media types of unpackable layers; the
+// compression is detected from the content.
+var layerMediaTypePrefixes = []string{
+	"application/vnd.oci.image.layer.",
+	"application/vnd.docker.image.rootfs.",
+}
+
+// CreateBundle creates a bundle at bundlePath from the image ref,
+// "<oci-layout-dir>[:tag]": the image's layers unpacked into rootfs and
+// a config.json running its configuration. Everything is read from the
+// local layout. The bundle directory must be empty or missing, and is
+// removed again if unpacking fails.
+func CreateBundle(ref, bundlePath string) (err error) {
+	dir, tag := ParseRef(ref)
+	layout, err := OpenLayout(dir)
+	if err != nil {
+		return err
+	}
+	manifest, err := layout.Resolve(tag)
+	if err != nil {
+		return err
+	}
+	config, err := layout.Config(manifest)
+	if err != nil {
+		return err
+	}
+	if config.OS != "" && config.OS != "linux" {
+		return fmt.Errorf("image is for %s, not linux", config.OS)
+	}
+	for _, layer := range manifest.Layers {
+		if !isLayerMediaType(layer.MediaType) {
+			return fmt.Errorf("layer %s: unsupported media type %q", layer.Digest, layer.MediaType)
+		}
+	}
+
+	entries, err := os.ReadDir(bundlePath)
+	switch {
+	case os.IsNotExist(err):
+		if err := os.MkdirAll(bundlePath, 0755); err != nil {
+			return err
+		}
+		defer func() {
+			if err != nil {
+				os.RemoveAll(bundlePath)
+			}
+		}()
+	case err != nil:
+		return err
+	case len(entries) > 0:
+		return fmt.Errorf("bundle %s is not empty", bundlePath)
+	}
+
+	rootfs := filepath.Join(bundlePath, "rootfs")
+	if err := os.Mkdir(rootfs, 0755); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(rootfs)
+		}
+	}()
+
+	for _, layer := range manifest.Layers {
+		if err := unpackBlob(layout, layer, rootfs); err != nil {
+			return fmt.Errorf("layer %s: %w", layer.Digest, err)
+		}
+	}
+
+	s, err := GenerateSpec(config, rootfs)
+	if err != nil {
+		return err
+	}
+	return s.Save(filepath.Join(bundlePath, "config.json"))
+}
+
+// unpackBlob applies the layer blob desc points at to rootfs.
+func unpackBlob(layout *Layout, desc Descriptor, rootfs string) error {
+	blob, err := layout.Open(desc)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+	return UnpackLayer(rootfs, blob)
+}
+
+// isLayerMediaType reports whether mediaType is a filesystem layer.
+func isLayerMediaType(mediaType string) bool {
+	if strings.HasSuffix(mediaType, "+encrypted") {
+		return false
+	}
+	for _, prefix := range layerMediaTypePrefixes {
+		if strings.HasPrefix(mediaType, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package image
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"runc-go/spec"
+)
+
+const (
+	testPasswd = "root:x:0:0:root:/root:/bin/sh\napp:x:1000:1000::/home/app:/bin/sh\n"
+	testGroup  = "root:x:0:\napp:x:1000:\nwheel:x:10:root,app\naudio:x:29:app\n"
+)
+
+// writeImage creates a layout with one image built from layers.
+func writeImage(t *testing.T, config Config, layers ...[]byte) string {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "layout")
+	writeLayout(t, dir)
+	m := Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeManifest,
+		Config:        writeJSONBlob(t, dir, "application/vnd.oci.image.config.v1+json", config),
+	}
+	for _, layer := range layers {
+		m.Layers = append(m.Layers, writeBlob(t, dir, "application/vnd.oci.image.layer.v1.tar+gzip", layer))
+	}
+	writeLayout(t, dir, tagged(writeJSONBlob(t, dir, MediaTypeManifest, m), "v1"))
+	return dir
+}
+
+func TestCreateBundle(t *testing.T) {
+	config := Config{OS: "linux", Config: ContainerConfig{
+		User:         "app",
+		Env:          []string{"PATH=/app/bin:/usr/bin", "LANG=C.UTF-8"},
+		Entrypoint:   []string{"/app/bin/server"},
+		Cmd:          []string{"--port", "8080"},
+		WorkingDir:   "/app",
+		ExposedPorts: map[string]struct{}{"8080/tcp": {}, "53/udp": {}},
+		StopSignal:   "SIGQUIT",
+	}}
+	layout := writeImage(t, config,
+		buildLayer(t, true,
+			layerEntry{name: "etc/passwd", content: testPasswd},
+			layerEntry{name: "etc/group", content: testGroup},
+		),
+		buildLayer(t, true, layerEntry{name: "app/bin/server", content: "#!/bin/sh\n", mode: 0755}),
+	)
+	bundle := filepath.Join(t.TempDir(), "bundle")
+
+	if err := CreateBundle(layout+":v1", bundle); err != nil {
+		t.Fatalf("CreateBundle error: %v", err)
+	}
+	assertContent(t, filepath.Join(bundle, "rootfs"), "app/bin/server", "#!/bin/sh\n")
+
+	s, err := spec.LoadSpec(filepath.Join(bundle, "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/app/bin/server", "--port", "8080"}; !reflect.DeepEqual(s.Process.Args, want) {
+		t.Errorf("args = %v, want %v", s.Process.Args, want)
+	}
+	if want := []string{"PATH=/app/bin:/usr/bin", "TERM=xterm", "LANG=C.UTF-8"}; !reflect.DeepEqual(s.Process.Env, want) {
+		t.Errorf("env = %v, want %v", s.Process.Env, want)
+	}
+	if s.Process.Cwd != "/app" {
+		t.Errorf("cwd = %q, want /app", s.Process.Cwd)
+	}
+	user := s.Process.User
+	if user.UID != 1000 || user.GID != 1000 || !reflect.DeepEqual(user.AdditionalGids, []uint32{10, 29}) {
+		t.Errorf("user = %+v, want 1000:1000 with groups 10 and 29", user)
+	}
+	if got := s.Annotations[ExposedPortsAnnotation]; got != "53/udp,8080/tcp" {
+		t.Errorf("exposed ports annotation = %q", got)
+	}
+	if got := s.Annotations[StopSignalAnnotation]; got != "SIGQUIT" {
+		t.Errorf("stop signal annotation = %q", got)
+	}
+
+	// A bundle is never created over an existing one
+	if err := CreateBundle(layout, bundle); err == nil {
+		t.Error("CreateBundle wrote into a non-empty bundle")
+	}
+}
+
+func TestCreateBundle_RemovedOnFailure(t *testing.T) {
+	layout := writeImage(t, Config{OS: "linux"},
+		buildLayer(t, true, layerEntry{name: "ok", content: "x"}),
+		buildLayer(t, true, layerEntry{name: "../evil", content: "x"}),
+	)
+	bundle := filepath.Join(t.TempDir(), "bundle")
+	if err := CreateBundle(layout, bundle); err == nil {
+		t.Fatal("CreateBundle unpacked an escaping layer")
+	}
+	if _, err := os.Stat(bundle); !os.IsNotExist(err) {
+		t.Errorf("failed bundle was left behind: %v", err)
+	}
+	assertMissing(t, filepath.Dir(bundle), "evil")
+}
+
+func TestResolveUser(t *testing.T) {
+	rootfs := unpack(t, buildLayer(t, false,
+		layerEntry{name: "etc/", typeflag: tar.TypeDir},
+		layerEntry{name: "etc/passwd", content: testPasswd},
+		layerEntry{name: "etc/group", content: testGroup},
+	))
+	tests := []struct {
+		user    string
+		want    spec.User
+		wantErr bool
+	}{
+		{user: "root", want: spec.User{AdditionalGids: []uint32{10}}},
+		{user: "1000", want: spec.User{UID: 1000, GID: 1000, AdditionalGids: []uint32{10, 29}}},
+		{user: "app:wheel", want: spec.User{UID: 1000, GID: 10, AdditionalGids: []uint32{29}}},
+		{user: "app:5", want: spec.User{UID: 1000, GID: 5, AdditionalGids: []uint32{10, 29}}},
+		{user: "4242", want: spec.User{UID: 4242}},
+		{user: "4242:4242", want: spec.User{UID: 4242, GID: 4242}},
+		{user: "nobody", wantErr: true},
+		{user: "app:nogroup", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := resolveUser(rootfs, tt.user)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("resolveUser(%q) error = %v, wantErr %v", tt.user, err, tt.wantErr)
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("resolveUser(%q) = %+v, want %+v", tt.user, got, tt.want)
+		}
+	}
+}
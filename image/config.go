@@ -0,0 +1,217 @@
+package image
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"runc-go/spec"
+)
+
+const (
+	// ExposedPortsAnnotation lists the image's exposed ports, comma
+	// separated, as in the OCI image spec's conversion rules.
+	ExposedPortsAnnotation = "org.opencontainers.image.exposedPorts"
+
+	// StopSignalAnnotation is the signal the image expects to stop on.
+	StopSignalAnnotation = "org.opencontainers.image.stopSignal"
+)
+
+// Config is the image configuration blob.
+type Config struct {
+	Architecture string          `json:"architecture"`
+	OS           string          `json:"os"`
+	Config       ContainerConfig `json:"config"`
+}
+
+// ContainerConfig holds the defaults for containers run from an image.
+type ContainerConfig struct {
+	User         string              `json:"User,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	WorkingDir   string              `json:"WorkingDir,omitempty"`
+	StopSignal   string              `json:"StopSignal,omitempty"`
+}
+
+// Config reads the image configuration of m.
+func (l *Layout) Config(m *Manifest) (*Config, error) {
+	var c Config
+	if err := l.readJSON(m.Config, &c); err != nil {
+		return nil, fmt.Errorf("read image config: %w", err)
+	}
+	return &c, nil
+}
+
+// GenerateSpec returns spec.DefaultSpec() with the image configuration
+// applied. The user is looked up in the unpacked rootfs.
+func GenerateSpec(c *Config, rootfs string) (*spec.Spec, error) {
+	s := spec.DefaultSpec()
+	// DefaultSpec leaves seccomp out on architectures without a default
+	// profile, and a bundle must not come out unconfined
+	if _, err := spec.DefaultSeccomp(runtime.GOARCH, nil); err != nil {
+		return nil, err
+	}
+	ic := c.Config
+
+	if args := append(append([]string{}, ic.Entrypoint...), ic.Cmd...); len(args) > 0 {
+		s.Process.Args = args
+	}
+	s.Process.Env = mergeEnv(s.Process.Env, ic.Env)
+	if ic.WorkingDir != "" {
+		s.Process.Cwd = ic.WorkingDir
+	}
+	if ic.User != "" {
+		user, err := resolveUser(rootfs, ic.User)
+		if err != nil {
+			return nil, fmt.Errorf("image user %q: %w", ic.User, err)
+		}
+		s.Process.User = user
+	}
+
+	annotations := make(map[string]string)
+	if len(ic.ExposedPorts) > 0 {
+		ports := make([]string, 0, len(ic.ExposedPorts))
+		for port := range ic.ExposedPorts {
+			ports = append(ports, port)
+		}
+		sort.Strings(ports)
+		annotations[ExposedPortsAnnotation] = strings.Join(ports, ",")
+	}
+	if ic.StopSignal != "" {
+		annotations[StopSignalAnnotation] = ic.StopSignal
+	}
+	if len(annotations) > 0 {
+		s.Annotations = annotations
+	}
+	return s, nil
+}
+
+// mergeEnv overrides the variables of base with those of image, keeping
+// the order of first appearance.
+func mergeEnv(base, image []string) []string {
+	env := append([]string{}, base...)
+	index := make(map[string]int)
+	for i, kv := range env {
+		key, _, _ := strings.Cut(kv, "=")
+		index[key] = i
+	}
+	for _, kv := range image {
+		key, _, _ := strings.Cut(kv, "=")
+		if i, ok := index[key]; ok {
+			env[i] = kv
+			continue
+		}
+		index[key] = len(env)
+		env = append(env, kv)
+	}
+	return env
+}
+
+// resolveUser turns an image user, "user[:group]" by name or ID, into
+// IDs using the rootfs' /etc/passwd and /etc/group. A named user also
+// gets the groups that list it as a member.
+func resolveUser(rootfs, imageUser string) (spec.User, error) {
+	name, group, hasGroup := strings.Cut(imageUser, ":")
+	var user spec.User
+
+	passwd, err := readDatabase(rootfs, "/etc/passwd")
+	if err != nil {
+		return user, err
+	}
+	uid, numeric := parseID(name)
+	found := false
+	for _, entry := range passwd {
+		if len(entry) < 4 || (entry[0] != name && (!numeric || entry[2] != name)) {
+			continue
+		}
+		entryUID, ok := parseID(entry[2])
+		entryGID, gidOK := parseID(entry[3])
+		if !ok || !gidOK {
+			continue
+		}
+		name, uid, user.GID, found = entry[0], entryUID, entryGID, true
+		break
+	}
+	if !found && !numeric {
+		return user, fmt.Errorf("no user %q in /etc/passwd", name)
+	}
+	user.UID = uid
+
+	groups, err := readDatabase(rootfs, "/etc/group")
+	if err != nil {
+		return user, err
+	}
+	if hasGroup {
+		gid, known := parseID(group)
+		for _, entry := range groups {
+			if len(entry) >= 3 && entry[0] == group {
+				gid, known = parseID(entry[2])
+				break
+			}
+		}
+		if !known {
+			return user, fmt.Errorf("no group %q in /etc/group", group)
+		}
+		user.GID = gid
+	}
+
+	if !found {
+		return user, nil
+	}
+	for _, entry := range groups {
+		if len(entry) < 4 {
+			continue
+		}
+		gid, ok := parseID(entry[2])
+		if !ok || gid == user.GID {
+			continue
+		}
+		for _, member := range strings.Split(entry[3], ",") {
+			if member == name {
+				user.AdditionalGids = append(user.AdditionalGids, gid)
+				break
+			}
+		}
+	}
+	return user, nil
+}
+
+// parseID parses a numeric user or group ID.
+func parseID(s string) (uint32, bool) {
+	id, err := strconv.ParseUint(s, 10, 32)
+	return uint32(id), err == nil
+}
+
+// readDatabase reads a colon-separated database such as /etc/passwd from
+// the rootfs. A missing file is empty.
+func readDatabase(rootfs, name string) ([][]string, error) {
+	path, err := resolveInRootfs(rootfs, name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries [][]string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, strings.Split(line, ":"))
+	}
+	return entries, scanner.Err()
+}
@@ -0,0 +1,248 @@
+// Package image turns local OCI image layouts into runtime bundles.
+package image
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Media types of the image layout documents.
+const (
+	MediaTypeIndex          = "application/vnd.oci.image.index.v1+json"
+	MediaTypeManifest       = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeDockerList     = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+
+	// RefNameAnnotation tags a manifest in an index.
+	RefNameAnnotation = "org.opencontainers.image.ref.name"
+
+	// maxDocumentSize bounds the JSON documents read into memory.
+	maxDocumentSize = 4 << 20
+)
+
+// Descriptor points at a blob in the layout.
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	URLs        []string          `json:"urls,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *Platform         `json:"platform,omitempty"`
+}
+
+// Platform is the platform a manifest in an index is built for.
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// Index lists the manifests of a layout or a multi-platform image.
+type Index struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+// Manifest is an image manifest: a config and its layers, bottom-most first.
+type Manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Config        Descriptor   `json:"config"`
+	Layers        []Descriptor `json:"layers"`
+}
+
+// Layout is an OCI image layout directory.
+type Layout struct {
+	Path string
+}
+
+// ParseRef splits "<oci-layout-dir>[:tag]" into the layout directory and
+// the tag. A directory whose name contains a colon is taken whole when it
+// exists.
+func ParseRef(ref string) (dir, tag string) {
+	i := strings.LastIndex(ref, ":")
+	if i < 0 || strings.Contains(ref[i+1:], "/") {
+		return ref, ""
+	}
+	if _, err := os.Stat(filepath.Join(ref, "index.json")); err == nil {
+		return ref, ""
+	}
+	return ref[:i], ref[i+1:]
+}
+
+// OpenLayout checks that dir is an OCI image layout.
+func OpenLayout(dir string) (*Layout, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "oci-layout"))
+	if err != nil {
+		return nil, fmt.Errorf("not an OCI image layout: %w", err)
+	}
+	var marker struct {
+		Version string `json:"imageLayoutVersion"`
+	}
+	if err := json.Unmarshal(data, &marker); err != nil {
+		return nil, fmt.Errorf("parse oci-layout: %w", err)
+	}
+	if !strings.HasPrefix(marker.Version, "1.") {
+		return nil, fmt.Errorf("unsupported image layout version %q", marker.Version)
+	}
+	return &Layout{Path: dir}, nil
+}
+
+// Resolve finds the manifest for tag, descending into multi-platform
+// indexes to the one for this host. An empty tag picks the layout's only
+// image, or the one tagged "latest".
+func (l *Layout) Resolve(tag string) (*Manifest, error) {
+	var index Index
+	data, err := os.ReadFile(filepath.Join(l.Path, "index.json"))
+	if err != nil {
+		return nil, fmt.Errorf("read index: %w", err)
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("parse index.json: %w", err)
+	}
+
+	desc, err := selectTag(index.Manifests, tag)
+	if err != nil {
+		return nil, err
+	}
+	// Nested indexes are bounded so a cycle of blobs cannot loop forever
+	for depth := 0; depth < 8; depth++ {
+		switch desc.MediaType {
+		case MediaTypeManifest, MediaTypeDockerManifest:
+			var m Manifest
+			if err := l.readJSON(desc, &m); err != nil {
+				return nil, err
+			}
+			return &m, nil
+		case MediaTypeIndex, MediaTypeDockerList:
+			var nested Index
+			if err := l.readJSON(desc, &nested); err != nil {
+				return nil, err
+			}
+			if desc, err = selectPlatform(nested.Manifests); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unsupported manifest media type %q", desc.MediaType)
+		}
+	}
+	return nil, fmt.Errorf("too many nested indexes")
+}
+
+// selectTag returns the manifest tagged tag.
+func selectTag(manifests []Descriptor, tag string) (Descriptor, error) {
+	if tag == "" {
+		if len(manifests) == 1 {
+			return manifests[0], nil
+		}
+		tag = "latest"
+	}
+	for _, desc := range manifests {
+		if desc.Annotations[RefNameAnnotation] == tag {
+			return desc, nil
+		}
+	}
+	if len(manifests) == 0 {
+		return Descriptor{}, fmt.Errorf("image layout has no manifests")
+	}
+	return Descriptor{}, fmt.Errorf("no image tagged %q", tag)
+}
+
+// selectPlatform returns the manifest built for this host.
+func selectPlatform(manifests []Descriptor) (Descriptor, error) {
+	for _, desc := range manifests {
+		p := desc.Platform
+		if p == nil || (p.OS == runtime.GOOS && p.Architecture == runtime.GOARCH) {
+			return desc, nil
+		}
+	}
+	return Descriptor{}, fmt.Errorf("no manifest for %s/%s", runtime.GOOS, runtime.GOARCH)
+}
+
+// readJSON decodes the JSON blob desc points at into v.
+func (l *Layout) readJSON(desc Descriptor, v interface{}) error {
+	if desc.Size > maxDocumentSize {
+		return fmt.Errorf("blob %s is too large: %d bytes", desc.Digest, desc.Size)
+	}
+	blob, err := l.Open(desc)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+	data, err := io.ReadAll(blob)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("parse %s: %w", desc.Digest, err)
+	}
+	return nil
+}
+
+// Open returns a reader of the blob desc points at. The digest and size
+// are checked as the blob is read; the final Read returns an error on a
+// mismatch.
+func (l *Layout) Open(desc Descriptor) (io.ReadCloser, error) {
+	algorithm, encoded, ok := strings.Cut(desc.Digest, ":")
+	var h hash.Hash
+	switch algorithm {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return nil, fmt.Errorf("unsupported digest %q", desc.Digest)
+	}
+	if !ok || len(encoded) != hex.EncodedLen(h.Size()) || strings.Trim(encoded, "0123456789abcdef") != "" {
+		return nil, fmt.Errorf("invalid digest %q", desc.Digest)
+	}
+
+	f, err := os.Open(filepath.Join(l.Path, "blobs", algorithm, encoded))
+	if err != nil {
+		if os.IsNotExist(err) && len(desc.URLs) > 0 {
+			return nil, fmt.Errorf("blob %s is not in the layout and cannot be fetched offline", desc.Digest)
+		}
+		return nil, fmt.Errorf("open blob: %w", err)
+	}
+	return &verifiedReader{f: f, h: h, desc: desc}, nil
+}
+
+// verifiedReader checks a blob against its descriptor while it is read.
+type verifiedReader struct {
+	f    *os.File
+	h    hash.Hash
+	desc Descriptor
+	n    int64
+}
+
+func (r *verifiedReader) Read(p []byte) (int, error) {
+	n, err := r.f.Read(p)
+	r.h.Write(p[:n])
+	r.n += int64(n)
+	if r.n > r.desc.Size {
+		return n, fmt.Errorf("blob %s is larger than %d bytes", r.desc.Digest, r.desc.Size)
+	}
+	if err == io.EOF {
+		if r.n != r.desc.Size {
+			return n, fmt.Errorf("blob %s has %d bytes, want %d", r.desc.Digest, r.n, r.desc.Size)
+		}
+		if _, encoded, _ := strings.Cut(r.desc.Digest, ":"); hex.EncodeToString(r.h.Sum(nil)) != encoded {
+			return n, fmt.Errorf("blob %s does not match its digest", r.desc.Digest)
+		}
+	}
+	return n, err
+}
+
+func (r *verifiedReader) Close() error {
+	return r.f.Close()
+}
@@ -0,0 +1,243 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// writeBlob stores data in the layout and returns its descriptor.
+func writeBlob(t *testing.T, dir, mediaType string, data []byte) Descriptor {
+	t.Helper()
+	sum := sha256.Sum256(data)
+	encoded := hex.EncodeToString(sum[:])
+	blobs := filepath.Join(dir, "blobs", "sha256")
+	if err := os.MkdirAll(blobs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(blobs, encoded), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return Descriptor{MediaType: mediaType, Digest: "sha256:" + encoded, Size: int64(len(data))}
+}
+
+// writeJSONBlob stores v as a JSON blob.
+func writeJSONBlob(t *testing.T, dir, mediaType string, v interface{}) Descriptor {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writeBlob(t, dir, mediaType, data)
+}
+
+// writeLayout creates an image layout whose index lists manifests.
+func writeLayout(t *testing.T, dir string, manifests ...Descriptor) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "oci-layout"), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(Index{SchemaVersion: 2, Manifests: manifests})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "index.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// tagged returns desc with a ref name.
+func tagged(desc Descriptor, tag string) Descriptor {
+	desc.Annotations = map[string]string{RefNameAnnotation: tag}
+	return desc
+}
+
+// layerEntry is a file in a test layer.
+type layerEntry struct {
+	name     string
+	typeflag byte
+	content  string
+	linkname string
+	mode     int64
+}
+
+// buildLayer returns a tarball of entries, gzipped if compress is set.
+func buildLayer(t *testing.T, compress bool, entries ...layerEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	var zw *gzip.Writer
+	if compress {
+		zw = gzip.NewWriter(&buf)
+		w = zw
+	}
+	tw := tar.NewWriter(w)
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:     e.name,
+			Typeflag: e.typeflag,
+			Linkname: e.linkname,
+			Mode:     e.mode,
+			Size:     int64(len(e.content)),
+			Uid:      os.Getuid(),
+			Gid:      os.Getgid(),
+		}
+		if hdr.Typeflag == 0 {
+			hdr.Typeflag = tar.TypeReg
+		}
+		if hdr.Mode == 0 {
+			hdr.Mode = 0644
+			if hdr.Typeflag == tar.TypeDir {
+				hdr.Mode = 0755
+			}
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			hdr.Size = 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(tw, e.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestParseRef(t *testing.T) {
+	dir := t.TempDir()
+	colon := filepath.Join(dir, "img:v1")
+	writeLayout(t, colon)
+
+	tests := []struct {
+		ref, dir, tag string
+	}{
+		{"/images/alpine", "/images/alpine", ""},
+		{"/images/alpine:3.19", "/images/alpine", "3.19"},
+		{"./host:5000/alpine", "./host:5000/alpine", ""},
+		{colon, colon, ""},
+		{colon + ":latest", colon, "latest"},
+	}
+	for _, tt := range tests {
+		dir, tag := ParseRef(tt.ref)
+		if dir != tt.dir || tag != tt.tag {
+			t.Errorf("ParseRef(%q) = %q, %q, want %q, %q", tt.ref, dir, tag, tt.dir, tt.tag)
+		}
+	}
+}
+
+func TestLayout_Resolve(t *testing.T) {
+	dir := t.TempDir()
+	config := writeJSONBlob(t, dir, "application/vnd.oci.image.config.v1+json", Config{OS: "linux"})
+	manifest := func(layer string) Descriptor {
+		return writeJSONBlob(t, dir, MediaTypeManifest, Manifest{
+			SchemaVersion: 2,
+			Config:        config,
+			Layers:        []Descriptor{{MediaType: "application/vnd.oci.image.layer.v1.tar", Digest: layer}},
+		})
+	}
+	this := manifest("sha256:this")
+	other := manifest("sha256:other")
+	this.Platform = &Platform{OS: runtime.GOOS, Architecture: runtime.GOARCH}
+	other.Platform = &Platform{OS: "windows", Architecture: "arm"}
+	multi := writeJSONBlob(t, dir, MediaTypeIndex, Index{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeIndex,
+		Manifests:     []Descriptor{other, this},
+	})
+	writeLayout(t, dir, tagged(manifest("sha256:v1"), "v1"), tagged(multi, "latest"))
+
+	layout, err := OpenLayout(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		tag, layer string
+	}{
+		{"v1", "sha256:v1"},
+		{"latest", "sha256:this"},
+		{"", "sha256:this"},
+	}
+	for _, tt := range tests {
+		m, err := layout.Resolve(tt.tag)
+		if err != nil {
+			t.Fatalf("Resolve(%q) error: %v", tt.tag, err)
+		}
+		if got := m.Layers[0].Digest; got != tt.layer {
+			t.Errorf("Resolve(%q) picked %s, want %s", tt.tag, got, tt.layer)
+		}
+	}
+	if _, err := layout.Resolve("v2"); err == nil {
+		t.Error("Resolve(v2) succeeded for a missing tag")
+	}
+}
+
+func TestLayout_OpenVerifies(t *testing.T) {
+	dir := t.TempDir()
+	writeLayout(t, dir)
+	layout, err := OpenLayout(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc := writeBlob(t, dir, "", []byte("layer data"))
+
+	read := func(desc Descriptor) error {
+		blob, err := layout.Open(desc)
+		if err != nil {
+			return err
+		}
+		defer blob.Close()
+		_, err = io.ReadAll(blob)
+		return err
+	}
+	if err := read(desc); err != nil {
+		t.Fatalf("read intact blob: %v", err)
+	}
+
+	// Tamper with the blob behind the digest
+	_, encoded, _ := strings.Cut(desc.Digest, ":")
+	if err := os.WriteFile(filepath.Join(dir, "blobs", "sha256", encoded), []byte("LAYER DATA"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := read(desc); err == nil {
+		t.Error("read a blob that does not match its digest")
+	}
+
+	short := desc
+	short.Size--
+	if err := read(short); err == nil {
+		t.Error("read a blob larger than its descriptor")
+	}
+
+	for _, digest := range []string{"md5:abc", "sha256:../../../etc/passwd", "sha256:" + strings.Repeat("A", 64)} {
+		if _, err := layout.Open(Descriptor{Digest: digest}); err == nil {
+			t.Errorf("Open(%q) succeeded", digest)
+		}
+	}
+}
+
+func TestOpenLayout_NotALayout(t *testing.T) {
+	if _, err := OpenLayout(t.TempDir()); err == nil {
+		t.Error("OpenLayout accepted a directory without oci-layout")
+	}
+}
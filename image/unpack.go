@@ -0,0 +1,350 @@
+package image
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// whiteoutPrefix marks a file of a lower layer as deleted.
+	whiteoutPrefix = ".wh."
+	// whiteoutOpaque hides every lower-layer entry of its directory.
+	whiteoutOpaque = ".wh..wh..opq"
+
+	// xattrPrefix introduces extended attributes in PAX records.
+	xattrPrefix = "SCHILY.xattr."
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// unpacker applies one layer to a rootfs.
+type unpacker struct {
+	root string
+	// seen holds the paths this layer created, which an opaque whiteout
+	// in the same layer must keep.
+	seen map[string]bool
+	// dirs are restored to their timestamps once their entries are in.
+	dirs []*tar.Header
+	// privileged is set when file owners and device nodes can be created.
+	privileged bool
+}
+
+// UnpackLayer applies the layer tarball in r, compressed with gzip or
+// not at all, on top of rootfs. Whiteout entries delete files of the
+// layers below. Entries that would land outside rootfs are rejected.
+func UnpackLayer(rootfs string, r io.Reader) error {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(4)
+	var layer io.Reader = br
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		layer = zr
+	case bytes.HasPrefix(magic, zstdMagic):
+		return fmt.Errorf("zstd compressed layers are not supported")
+	}
+
+	u := &unpacker{
+		root:       rootfs,
+		seen:       make(map[string]bool),
+		privileged: os.Geteuid() == 0,
+	}
+	tr := tar.NewReader(layer)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read layer: %w", err)
+		}
+		if err := u.apply(hdr, tr); err != nil {
+			return fmt.Errorf("%s: %w", hdr.Name, err)
+		}
+	}
+
+	// Entries added below a directory changed its mtime; restore them
+	// deepest first
+	for i := len(u.dirs) - 1; i >= 0; i-- {
+		hdr := u.dirs[i]
+		target, err := u.target(hdr.Name)
+		if err != nil {
+			return err
+		}
+		if err := setTimes(target, hdr); err != nil {
+			return fmt.Errorf("%s: %w", hdr.Name, err)
+		}
+	}
+
+	// Drain the stream so a blob reader can check the digest
+	_, err := io.Copy(io.Discard, br)
+	return err
+}
+
+// entryPath returns the layer entry name relative to the rootfs. A name
+// that climbs above the rootfs with ".." is an error.
+func entryPath(name string) (string, error) {
+	rel := filepath.Clean(strings.TrimLeft(name, "/"))
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("layer entry %q escapes the rootfs", name)
+	}
+	return rel, nil
+}
+
+// resolveInRootfs resolves rel below root, following symlinks as if root
+// were "/": absolute targets and ".." never leave it. Components that do
+// not exist yet are taken as they are.
+func resolveInRootfs(root, rel string) (string, error) {
+	current := ""
+	remaining := strings.Split(rel, "/")
+	links := 0
+	for len(remaining) > 0 {
+		name := remaining[0]
+		remaining = remaining[1:]
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			if current = path.Dir(current); current == "." {
+				current = ""
+			}
+			continue
+		}
+
+		next := path.Join(current, name)
+		fi, err := os.Lstat(filepath.Join(root, next))
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			if err != nil && !os.IsNotExist(err) && !errors.Is(err, unix.ENOTDIR) {
+				return "", err
+			}
+			current = next
+			continue
+		}
+
+		if links++; links > 40 {
+			return "", &os.PathError{Op: "resolve", Path: rel, Err: unix.ELOOP}
+		}
+		target, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(target) {
+			current = ""
+		}
+		remaining = append(strings.Split(target, "/"), remaining...)
+	}
+	return filepath.Join(root, current), nil
+}
+
+// target returns the host path of a layer entry. The parent directories
+// are resolved inside the rootfs; the entry itself is never followed.
+func (u *unpacker) target(name string) (string, error) {
+	rel, err := entryPath(name)
+	if err != nil {
+		return "", err
+	}
+	if rel == "." {
+		return u.root, nil
+	}
+	parent, err := resolveInRootfs(u.root, path.Dir(rel))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(parent, path.Base(rel)), nil
+}
+
+// apply writes one layer entry.
+func (u *unpacker) apply(hdr *tar.Header, r io.Reader) error {
+	if hdr.Typeflag == tar.TypeXGlobalHeader {
+		return nil
+	}
+	target, err := u.target(hdr.Name)
+	if err != nil {
+		return err
+	}
+	if target == u.root {
+		// The rootfs itself keeps the bundle's ownership and mode
+		return nil
+	}
+	parent, base := filepath.Split(target)
+
+	switch {
+	case base == whiteoutOpaque:
+		return u.removeLower(parent)
+	case strings.HasPrefix(base, whiteoutPrefix):
+		name := strings.TrimPrefix(base, whiteoutPrefix)
+		if name == "" || name == "." || name == ".." {
+			return fmt.Errorf("invalid whiteout")
+		}
+		return os.RemoveAll(filepath.Join(parent, name))
+	}
+
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return err
+	}
+	// A new entry replaces whatever the layers below have there, except
+	// that directories merge
+	if fi, err := os.Lstat(target); err == nil && !(fi.IsDir() && hdr.Typeflag == tar.TypeDir) {
+		if err := os.RemoveAll(target); err != nil {
+			return err
+		}
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.Mkdir(target, 0700); err != nil && !os.IsExist(err) {
+			return err
+		}
+		u.dirs = append(u.dirs, hdr)
+	case tar.TypeReg, tar.TypeRegA:
+		f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL|unix.O_NOFOLLOW, 0600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, r)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		if err := os.Symlink(hdr.Linkname, target); err != nil {
+			return err
+		}
+	case tar.TypeLink:
+		source, err := u.target(hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		if err := os.Link(source, target); err != nil {
+			return err
+		}
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		mode := uint32(hdr.Mode & 07777)
+		switch hdr.Typeflag {
+		case tar.TypeChar:
+			mode |= unix.S_IFCHR
+		case tar.TypeBlock:
+			mode |= unix.S_IFBLK
+		default:
+			mode |= unix.S_IFIFO
+		}
+		dev := unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor))
+		if err := unix.Mknod(target, mode, int(dev)); err != nil {
+			// Unprivileged users cannot create devices; the runtime
+			// populates /dev for the container anyway
+			if err == unix.EPERM && hdr.Typeflag != tar.TypeFifo {
+				return nil
+			}
+			return &os.PathError{Op: "mknod", Path: target, Err: err}
+		}
+	default:
+		return fmt.Errorf("unsupported entry type %q", hdr.Typeflag)
+	}
+
+	for p := target; p != u.root && !u.seen[p]; p = filepath.Dir(p) {
+		u.seen[p] = true
+	}
+	if err := u.setMetadata(target, hdr); err != nil {
+		return err
+	}
+	if hdr.Typeflag != tar.TypeDir {
+		return setTimes(target, hdr)
+	}
+	return nil
+}
+
+// removeLower empties dir of everything that this layer did not create.
+func (u *unpacker) removeLower(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		p := filepath.Join(dir, entry.Name())
+		if !u.seen[p] {
+			if err := os.RemoveAll(p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// setMetadata applies the owner, extended attributes and mode of an entry.
+// Owners are only set with privileges: an unprivileged user owns the whole
+// rootfs and maps it into the container with a user namespace.
+func (u *unpacker) setMetadata(target string, hdr *tar.Header) error {
+	if hdr.Typeflag == tar.TypeLink {
+		// A hard link shares its source's inode
+		return nil
+	}
+	if u.privileged {
+		if err := os.Lchown(target, hdr.Uid, hdr.Gid); err != nil {
+			return err
+		}
+	}
+	for key, value := range hdr.PAXRecords {
+		name, ok := strings.CutPrefix(key, xattrPrefix)
+		if !ok {
+			continue
+		}
+		if err := unix.Lsetxattr(target, name, []byte(value), 0); err != nil {
+			// trusted.* and security.* need privileges, and not every
+			// filesystem has xattrs
+			if err == unix.EPERM || err == unix.ENOTSUP {
+				continue
+			}
+			return &os.PathError{Op: "setxattr " + name, Path: target, Err: err}
+		}
+	}
+	if hdr.Typeflag == tar.TypeSymlink {
+		return nil
+	}
+	// Chmod after chown, which clears the setuid and setgid bits
+	return os.Chmod(target, hdr.FileInfo().Mode())
+}
+
+// setTimes sets the access and modification times of an entry without
+// following symlinks.
+func setTimes(target string, hdr *tar.Header) error {
+	atime := hdr.AccessTime
+	if atime.IsZero() {
+		atime = hdr.ModTime
+	}
+	times := []unix.Timespec{timespec(atime), timespec(hdr.ModTime)}
+	if err := unix.UtimesNanoAt(unix.AT_FDCWD, target, times, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return &os.PathError{Op: "utimensat", Path: target, Err: err}
+	}
+	return nil
+}
+
+func timespec(t time.Time) unix.Timespec {
+	if t.IsZero() {
+		return unix.Timespec{Nsec: unix.UTIME_OMIT}
+	}
+	return unix.NsecToTimespec(t.UnixNano())
+}
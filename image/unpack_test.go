@@ -0,0 +1,164 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// unpack applies layers to a new rootfs.
+func unpack(t *testing.T, layers ...[]byte) string {
+	t.Helper()
+	rootfs := filepath.Join(t.TempDir(), "rootfs")
+	if err := os.Mkdir(rootfs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for i, layer := range layers {
+		if err := UnpackLayer(rootfs, bytes.NewReader(layer)); err != nil {
+			t.Fatalf("layer %d: %v", i, err)
+		}
+	}
+	return rootfs
+}
+
+// assertContent fails unless rel in rootfs holds content.
+func assertContent(t *testing.T, rootfs, rel, content string) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(rootfs, rel))
+	if err != nil {
+		t.Errorf("read %s: %v", rel, err)
+	} else if string(data) != content {
+		t.Errorf("%s = %q, want %q", rel, data, content)
+	}
+}
+
+// assertMissing fails when rel exists in rootfs.
+func assertMissing(t *testing.T, rootfs, rel string) {
+	t.Helper()
+	if _, err := os.Lstat(filepath.Join(rootfs, rel)); !os.IsNotExist(err) {
+		t.Errorf("%s exists: %v", rel, err)
+	}
+}
+
+func TestUnpackLayer_Whiteouts(t *testing.T) {
+	base := buildLayer(t, true,
+		layerEntry{name: "etc/", typeflag: tar.TypeDir},
+		layerEntry{name: "etc/hostname", content: "base"},
+		layerEntry{name: "etc/removed", content: "base"},
+		layerEntry{name: "var/cache/", typeflag: tar.TypeDir},
+		layerEntry{name: "var/cache/old", content: "base"},
+		layerEntry{name: "var/cache/sub/old", content: "base"},
+		layerEntry{name: "bin/sh", content: "shell", mode: 0755},
+		layerEntry{name: "bin/link", typeflag: tar.TypeLink, linkname: "bin/sh"},
+		layerEntry{name: "usr/lib", typeflag: tar.TypeSymlink, linkname: "../lib"},
+	)
+	top := buildLayer(t, false,
+		layerEntry{name: "etc/.wh.removed"},
+		layerEntry{name: "etc/hostname", content: "top"},
+		// The new entry comes before the opaque marker and survives it
+		layerEntry{name: "var/cache/new", content: "top"},
+		layerEntry{name: "var/cache/.wh..wh..opq"},
+		layerEntry{name: "bin/sh/", typeflag: tar.TypeDir},
+	)
+	rootfs := unpack(t, base, top)
+
+	assertContent(t, rootfs, "etc/hostname", "top")
+	assertMissing(t, rootfs, "etc/removed")
+	assertContent(t, rootfs, "var/cache/new", "top")
+	assertMissing(t, rootfs, "var/cache/old")
+	assertMissing(t, rootfs, "var/cache/sub")
+	assertContent(t, rootfs, "bin/link", "shell")
+	if fi, err := os.Stat(filepath.Join(rootfs, "bin/sh")); err != nil || !fi.IsDir() {
+		t.Errorf("bin/sh was not replaced by a directory: %v", err)
+	}
+	if target, err := os.Readlink(filepath.Join(rootfs, "usr/lib")); err != nil || target != "../lib" {
+		t.Errorf("usr/lib -> %q, %v", target, err)
+	}
+	if fi, err := os.Stat(filepath.Join(rootfs, "bin/link")); err != nil || fi.Mode().Perm() != 0755 {
+		t.Errorf("bin/link mode = %v, %v", fi.Mode(), err)
+	}
+}
+
+func TestUnpackLayer_Escapes(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry layerEntry
+	}{
+		{"dotdot", layerEntry{name: "../escaped", content: "x"}},
+		{"nested dotdot", layerEntry{name: "a/../../escaped", content: "x"}},
+		{"absolute dotdot", layerEntry{name: "/../escaped", content: "x"}},
+		{"hardlink", layerEntry{name: "link", typeflag: tar.TypeLink, linkname: "../../etc/passwd"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rootfs := filepath.Join(t.TempDir(), "rootfs")
+			if err := os.Mkdir(rootfs, 0755); err != nil {
+				t.Fatal(err)
+			}
+			layer := buildLayer(t, false, tt.entry)
+			if err := UnpackLayer(rootfs, bytes.NewReader(layer)); err == nil {
+				t.Fatal("UnpackLayer accepted an entry outside the rootfs")
+			}
+			assertMissing(t, filepath.Dir(rootfs), "escaped")
+		})
+	}
+}
+
+func TestUnpackLayer_SymlinkParents(t *testing.T) {
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "victim"), []byte("host"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Writes through symlinks, absolute or climbing, stay in the rootfs
+	rootfs := unpack(t,
+		buildLayer(t, false,
+			layerEntry{name: "abs", typeflag: tar.TypeSymlink, linkname: outside},
+			layerEntry{name: "rel", typeflag: tar.TypeSymlink, linkname: "../../../../../../.." + outside},
+		),
+		buildLayer(t, false,
+			layerEntry{name: "abs/written", content: "container"},
+			layerEntry{name: "rel/victim", content: "container"},
+			layerEntry{name: "abs/.wh.victim"},
+		),
+	)
+
+	assertContent(t, rootfs, filepath.Join(outside, "written"), "container")
+	assertMissing(t, rootfs, filepath.Join(outside, "victim"))
+	assertContent(t, outside, "victim", "host")
+	assertMissing(t, outside, "written")
+}
+
+func TestResolveInRootfs(t *testing.T) {
+	rootfs := t.TempDir()
+	for name, target := range map[string]string{
+		"lib":  "usr/lib",
+		"up":   "../../..",
+		"loop": "loop",
+	} {
+		if err := os.Symlink(target, filepath.Join(rootfs, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		rel, want string
+	}{
+		{"lib/x", "usr/lib/x"},
+		{"up/etc", "etc"},
+		{"../../etc", "etc"},
+		{"missing/../etc", "etc"},
+	}
+	for _, tt := range tests {
+		got, err := resolveInRootfs(rootfs, tt.rel)
+		if err != nil {
+			t.Fatalf("resolveInRootfs(%q) error: %v", tt.rel, err)
+		}
+		if want := filepath.Join(rootfs, tt.want); got != want {
+			t.Errorf("resolveInRootfs(%q) = %s, want %s", tt.rel, got, want)
+		}
+	}
+	if _, err := resolveInRootfs(rootfs, "loop/x"); err == nil {
+		t.Error("resolveInRootfs followed a symlink loop")
+	}
+}
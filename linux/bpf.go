@@ -0,0 +1,413 @@
+// Package linux provides a classic BPF interpreter and disassembler.
+//
+// These are used to inspect and simulate seccomp programs offline,
+// without installing them into the kernel.
+package linux
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"runc-go/spec"
+)
+
+// bpfMaxInsns is the kernel's BPF_MAXINSNS limit for classic BPF programs.
+const bpfMaxInsns = 4096
+
+// SeccompData mirrors struct seccomp_data, the input a seccomp filter sees.
+type SeccompData struct {
+	// Nr is the system call number.
+	Nr int32
+
+	// Arch is the AUDIT_ARCH_* value of the calling convention.
+	Arch uint32
+
+	// InstructionPointer is the address of the syscall instruction.
+	InstructionPointer uint64
+
+	// Args are the six raw syscall arguments.
+	Args [6]uint64
+}
+
+// bytes serializes the seccomp data in native (little-endian) layout.
+func (d *SeccompData) bytes() []byte {
+	buf := make([]byte, seccompDataSize)
+	binary.LittleEndian.PutUint32(buf[offsetNR:], uint32(d.Nr))
+	binary.LittleEndian.PutUint32(buf[offsetArch:], d.Arch)
+	binary.LittleEndian.PutUint64(buf[offsetIP:], d.InstructionPointer)
+	for i, arg := range d.Args {
+		binary.LittleEndian.PutUint64(buf[offsetArgs+8*i:], arg)
+	}
+	return buf
+}
+
+// runBPF executes a classic BPF program against the given packet and
+// returns the value of the RET instruction it terminates on.
+// Only the subset of cBPF that seccomp accepts is supported.
+func runBPF(prog []sockFilter, pkt []byte) (uint32, error) {
+	if len(prog) == 0 {
+		return 0, fmt.Errorf("empty program")
+	}
+	if len(prog) > bpfMaxInsns {
+		return 0, fmt.Errorf("program too long: %d instructions (max %d)", len(prog), bpfMaxInsns)
+	}
+
+	var a, x uint32
+	var mem [bpfMemWords]uint32
+
+	load := func(off uint32, size uint32) (uint32, error) {
+		if off%size != 0 || uint64(off)+uint64(size) > uint64(len(pkt)) {
+			return 0, fmt.Errorf("invalid load at offset %d", off)
+		}
+		switch size {
+		case 4:
+			return binary.LittleEndian.Uint32(pkt[off:]), nil
+		case 2:
+			return uint32(binary.LittleEndian.Uint16(pkt[off:])), nil
+		default:
+			return uint32(pkt[off]), nil
+		}
+	}
+
+	for pc := 0; pc < len(prog); pc++ {
+		ins := prog[pc]
+		switch ins.Code & 0x07 {
+		case BPF_LD:
+			switch ins.Code & 0xe0 {
+			case BPF_ABS:
+				v, err := load(ins.K, bpfLoadSize(ins.Code))
+				if err != nil {
+					return 0, fmt.Errorf("line %d: %w", pc, err)
+				}
+				a = v
+			case BPF_IND:
+				v, err := load(x+ins.K, bpfLoadSize(ins.Code))
+				if err != nil {
+					return 0, fmt.Errorf("line %d: %w", pc, err)
+				}
+				a = v
+			case BPF_IMM:
+				a = ins.K
+			case BPF_MEM:
+				if ins.K >= bpfMemWords {
+					return 0, fmt.Errorf("line %d: invalid scratch slot %d", pc, ins.K)
+				}
+				a = mem[ins.K]
+			case BPF_LEN:
+				a = uint32(len(pkt))
+			default:
+				return 0, fmt.Errorf("line %d: unsupported load mode 0x%02x", pc, ins.Code)
+			}
+		case BPF_LDX:
+			switch ins.Code & 0xe0 {
+			case BPF_IMM:
+				x = ins.K
+			case BPF_MEM:
+				if ins.K >= bpfMemWords {
+					return 0, fmt.Errorf("line %d: invalid scratch slot %d", pc, ins.K)
+				}
+				x = mem[ins.K]
+			case BPF_LEN:
+				x = uint32(len(pkt))
+			default:
+				return 0, fmt.Errorf("line %d: unsupported ldx mode 0x%02x", pc, ins.Code)
+			}
+		case BPF_ST, BPF_STX:
+			if ins.K >= bpfMemWords {
+				return 0, fmt.Errorf("line %d: invalid scratch slot %d", pc, ins.K)
+			}
+			if ins.Code&0x07 == BPF_ST {
+				mem[ins.K] = a
+			} else {
+				mem[ins.K] = x
+			}
+		case BPF_ALU:
+			operand := ins.K
+			if ins.Code&BPF_X != 0 {
+				operand = x
+			}
+			switch ins.Code & 0xf0 {
+			case BPF_ADD:
+				a += operand
+			case BPF_SUB:
+				a -= operand
+			case BPF_MUL:
+				a *= operand
+			case BPF_DIV:
+				if operand == 0 {
+					return 0, nil
+				}
+				a /= operand
+			case BPF_MOD:
+				if operand == 0 {
+					return 0, nil
+				}
+				a %= operand
+			case BPF_OR:
+				a |= operand
+			case BPF_AND:
+				a &= operand
+			case BPF_XOR:
+				a ^= operand
+			case BPF_LSH:
+				a <<= operand
+			case BPF_RSH:
+				a >>= operand
+			case BPF_NEG:
+				a = -a
+			default:
+				return 0, fmt.Errorf("line %d: unsupported alu op 0x%02x", pc, ins.Code)
+			}
+		case BPF_JMP:
+			if ins.Code&0xf0 == BPF_JA {
+				pc += int(ins.K)
+				break
+			}
+			operand := ins.K
+			if ins.Code&BPF_X != 0 {
+				operand = x
+			}
+			var taken bool
+			switch ins.Code & 0xf0 {
+			case BPF_JEQ:
+				taken = a == operand
+			case BPF_JGT:
+				taken = a > operand
+			case BPF_JGE:
+				taken = a >= operand
+			case BPF_JSET:
+				taken = a&operand != 0
+			default:
+				return 0, fmt.Errorf("line %d: unsupported jump op 0x%02x", pc, ins.Code)
+			}
+			if taken {
+				pc += int(ins.Jt)
+			} else {
+				pc += int(ins.Jf)
+			}
+		case BPF_RET:
+			switch ins.Code & 0x18 {
+			case BPF_K:
+				return ins.K, nil
+			case BPF_A:
+				return a, nil
+			default:
+				return 0, fmt.Errorf("line %d: unsupported ret source 0x%02x", pc, ins.Code)
+			}
+		case BPF_MISC:
+			switch ins.Code & 0xf8 {
+			case BPF_TAX:
+				x = a
+			case BPF_TXA:
+				a = x
+			default:
+				return 0, fmt.Errorf("line %d: unsupported misc op 0x%02x", pc, ins.Code)
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("program fell off the end without returning")
+}
+
+// bpfLoadSize returns the load width in bytes encoded in an instruction.
+func bpfLoadSize(code uint16) uint32 {
+	switch code & 0x18 {
+	case BPF_H:
+		return 2
+	case BPF_B:
+		return 1
+	default:
+		return 4
+	}
+}
+
+// disassembleBPF renders a classic BPF program in the same column layout
+// as libseccomp's scmp_bpf_disasm, annotated for seccomp_data offsets,
+// syscall numbers and return actions.
+func disassembleBPF(prog []sockFilter) string {
+	var b strings.Builder
+	b.WriteString(" line  OP   JT   JF   K\n")
+	b.WriteString("=================================\n")
+
+	// Track what the accumulator holds so syscall numbers can be named,
+	// and the architecture whose section follows an arch check. An
+	// instruction after a ret or ja is only reached by jumps, so it takes
+	// the accumulator state from the jumps to it.
+	loadedNR := false
+	table := syscallMap
+	jumpedNR := make(map[int]bool)
+	reached := true
+	for pc, ins := range prog {
+		if nr, ok := jumpedNR[pc]; ok && !reached {
+			loadedNR = nr
+		}
+		reached = true
+		fmt.Fprintf(&b, " %04d: 0x%02x 0x%02x 0x%02x 0x%08x   ", pc, ins.Code, ins.Jt, ins.Jf, ins.K)
+
+		operand := fmt.Sprintf("%d", ins.K)
+		if ins.Code&BPF_X != 0 {
+			operand = "X"
+		}
+
+		switch ins.Code & 0x07 {
+		case BPF_LD, BPF_LDX:
+			op := "ld"
+			reg := "A"
+			if ins.Code&0x07 == BPF_LDX {
+				op = "ldx"
+				reg = "X"
+			}
+			switch ins.Code & 0xe0 {
+			case BPF_ABS:
+				fmt.Fprintf(&b, "%-4s $data[%d]%s", op, ins.K, seccompFieldName(ins.K))
+				if reg == "A" {
+					loadedNR = ins.K == offsetNR
+				}
+			case BPF_IND:
+				fmt.Fprintf(&b, "%-4s $data[X+%d]", op, ins.K)
+				loadedNR = false
+			case BPF_IMM:
+				fmt.Fprintf(&b, "%-4s %d", op, ins.K)
+				if reg == "A" {
+					loadedNR = false
+				}
+			case BPF_MEM:
+				fmt.Fprintf(&b, "%-4s $temp[%d]", op, ins.K)
+				if reg == "A" {
+					loadedNR = false
+				}
+			case BPF_LEN:
+				fmt.Fprintf(&b, "%-4s $len", op)
+				if reg == "A" {
+					loadedNR = false
+				}
+			default:
+				fmt.Fprintf(&b, "%-4s ???", op)
+			}
+		case BPF_ST:
+			fmt.Fprintf(&b, "%-4s $temp[%d]", "st", ins.K)
+		case BPF_STX:
+			fmt.Fprintf(&b, "%-4s $temp[%d]", "stx", ins.K)
+		case BPF_ALU:
+			ops := map[uint16]string{
+				BPF_ADD: "add", BPF_SUB: "sub", BPF_MUL: "mul", BPF_DIV: "div",
+				BPF_OR: "or", BPF_AND: "and", BPF_LSH: "lsh", BPF_RSH: "rsh",
+				BPF_NEG: "neg", BPF_MOD: "mod", BPF_XOR: "xor",
+			}
+			op, ok := ops[ins.Code&0xf0]
+			if !ok {
+				op = "alu?"
+			}
+			if ins.Code&0xf0 == BPF_NEG {
+				b.WriteString(op)
+			} else {
+				fmt.Fprintf(&b, "%-4s %s", op, operand)
+			}
+			loadedNR = false
+		case BPF_JMP:
+			if ins.Code&0xf0 == BPF_JA {
+				fmt.Fprintf(&b, "%-4s %04d", "jmp", pc+1+int(ins.K))
+				jumpedNR[pc+1+int(ins.K)] = loadedNR
+				reached = false
+				break
+			}
+			ops := map[uint16]string{BPF_JEQ: "jeq", BPF_JGT: "jgt", BPF_JGE: "jge", BPF_JSET: "jset"}
+			op, ok := ops[ins.Code&0xf0]
+			if !ok {
+				op = "jmp?"
+			}
+			if ins.Code&BPF_X == 0 && ins.Code&0xf0 == BPF_JSET {
+				operand = fmt.Sprintf("0x%x", ins.K)
+			}
+			fmt.Fprintf(&b, "%-4s %s", op, operand)
+			if ins.Code&BPF_X == 0 {
+				if loadedNR {
+					if name, ok := syscallNameIn(table, int(ins.K)); ok {
+						fmt.Fprintf(&b, " (%s)", name)
+					}
+				} else if arch, ok := auditArchName(ins.K); ok {
+					fmt.Fprintf(&b, " (%s)", arch)
+					if t, ok := syscallTables[spec.Arch(arch)]; ok {
+						table = t
+					}
+				}
+			}
+			fmt.Fprintf(&b, "  true:%04d false:%04d", pc+1+int(ins.Jt), pc+1+int(ins.Jf))
+			jumpedNR[pc+1+int(ins.Jt)] = loadedNR
+			jumpedNR[pc+1+int(ins.Jf)] = loadedNR
+		case BPF_RET:
+			reached = false
+			if ins.Code&0x18 == BPF_A {
+				fmt.Fprintf(&b, "%-4s A", "ret")
+			} else {
+				fmt.Fprintf(&b, "%-4s %s", "ret", SeccompActionName(ins.K))
+			}
+		case BPF_MISC:
+			if ins.Code&0xf8 == BPF_TXA {
+				b.WriteString("txa")
+				loadedNR = false
+			} else {
+				b.WriteString("tax")
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// seccompFieldName names the seccomp_data field at the given offset.
+func seccompFieldName(off uint32) string {
+	switch {
+	case off == offsetNR:
+		return " (nr)"
+	case off == offsetArch:
+		return " (arch)"
+	case off == offsetIP:
+		return " (ip lo)"
+	case off == offsetIP+4:
+		return " (ip hi)"
+	case off >= offsetArgs && off < seccompDataSize:
+		half := "lo"
+		if (off-offsetArgs)%8 == 4 {
+			half = "hi"
+		}
+		return fmt.Sprintf(" (arg%d %s)", (off-offsetArgs)/8, half)
+	}
+	return ""
+}
+
+// auditArchName returns the OCI architecture name for an audit arch value.
+func auditArchName(audit uint32) (string, bool) {
+	for arch, v := range archToAudit {
+		if v == audit {
+			return string(arch), true
+		}
+	}
+	return "", false
+}
+
+// SeccompActionName renders a seccomp filter return value the way OCI
+// configs spell it, e.g. "SCMP_ACT_ERRNO(1)".
+func SeccompActionName(ret uint32) string {
+	data := ret & SECCOMP_RET_DATA
+	switch ret & SECCOMP_RET_ACTION_FULL {
+	case SECCOMP_RET_KILL_PROCESS:
+		return string(spec.ActKillProcess)
+	case SECCOMP_RET_KILL_THREAD:
+		return string(spec.ActKillThread)
+	case SECCOMP_RET_TRAP:
+		return string(spec.ActTrap)
+	case SECCOMP_RET_ERRNO:
+		return fmt.Sprintf("%s(%d)", spec.ActErrno, data)
+	case SECCOMP_RET_USER_NOTIF:
+		return string(spec.ActNotify)
+	case SECCOMP_RET_TRACE:
+		return fmt.Sprintf("%s(%d)", spec.ActTrace, data)
+	case SECCOMP_RET_LOG:
+		return string(spec.ActLog)
+	case SECCOMP_RET_ALLOW:
+		return string(spec.ActAllow)
+	}
+	return fmt.Sprintf("UNKNOWN(0x%08x)", ret)
+}
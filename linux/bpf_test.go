@@ -0,0 +1,590 @@
+package linux
+
+import (
+	"strings"
+	"testing"
+
+	"runc-go/spec"
+)
+
+// ============================================================================
+// BPF INTERPRETER TESTS
+// ============================================================================
+
+// TestRunBPF_Ret tests that the interpreter returns the RET constant.
+func TestRunBPF_Ret(t *testing.T) {
+	prog := []sockFilter{bpfStmt(BPF_RET|BPF_K, SECCOMP_RET_ALLOW)}
+
+	data := SeccompData{}
+	ret, err := runBPF(prog, data.bytes())
+	if err != nil {
+		t.Fatalf("runBPF failed: %v", err)
+	}
+	if ret != SECCOMP_RET_ALLOW {
+		t.Errorf("ret = 0x%x, want 0x%x", ret, SECCOMP_RET_ALLOW)
+	}
+}
+
+// TestRunBPF_ALUAndScratch tests ALU ops, scratch memory and register moves.
+func TestRunBPF_ALUAndScratch(t *testing.T) {
+	prog := []sockFilter{
+		bpfStmt(BPF_LD|BPF_IMM, 6),
+		bpfStmt(BPF_ALU|BPF_MUL|BPF_K, 7),
+		bpfStmt(BPF_ST, 3),
+		bpfStmt(BPF_LDX|BPF_MEM, 3),
+		bpfStmt(BPF_MISC|BPF_TXA, 0),
+		bpfStmt(BPF_ALU|BPF_SUB|BPF_K, 2),
+		bpfStmt(BPF_RET|BPF_A, 0),
+	}
+
+	data := SeccompData{}
+	ret, err := runBPF(prog, data.bytes())
+	if err != nil {
+		t.Fatalf("runBPF failed: %v", err)
+	}
+	if ret != 40 {
+		t.Errorf("ret = %d, want 40", ret)
+	}
+}
+
+// TestRunBPF_Jumps tests conditional and unconditional jumps.
+func TestRunBPF_Jumps(t *testing.T) {
+	prog := []sockFilter{
+		bpfStmt(BPF_LD|BPF_W|BPF_ABS, offsetArgs),
+		bpfJump(BPF_JMP|BPF_JSET|BPF_K, 0x4, 0, 1),
+		bpfStmt(BPF_JMP|BPF_JA, 1),
+		bpfStmt(BPF_RET|BPF_K, SECCOMP_RET_ALLOW),
+		bpfStmt(BPF_RET|BPF_K, SECCOMP_RET_TRAP),
+	}
+
+	tests := []struct {
+		arg  uint64
+		want uint32
+	}{
+		{0x4, SECCOMP_RET_TRAP},
+		{0x5, SECCOMP_RET_TRAP},
+		{0x3, SECCOMP_RET_ALLOW},
+	}
+
+	for _, tt := range tests {
+		data := SeccompData{Args: [6]uint64{tt.arg}}
+		ret, err := runBPF(prog, data.bytes())
+		if err != nil {
+			t.Fatalf("runBPF failed: %v", err)
+		}
+		if ret != tt.want {
+			t.Errorf("arg 0x%x: ret = 0x%x, want 0x%x", tt.arg, ret, tt.want)
+		}
+	}
+}
+
+// TestRunBPF_Errors tests that malformed programs are rejected.
+func TestRunBPF_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		prog []sockFilter
+	}{
+		{"empty", nil},
+		{"no return", []sockFilter{bpfStmt(BPF_LD|BPF_IMM, 1)}},
+		{"out of bounds load", []sockFilter{
+			bpfStmt(BPF_LD|BPF_W|BPF_ABS, seccompDataSize),
+			bpfStmt(BPF_RET|BPF_K, SECCOMP_RET_ALLOW),
+		}},
+		{"misaligned load", []sockFilter{
+			bpfStmt(BPF_LD|BPF_W|BPF_ABS, 2),
+			bpfStmt(BPF_RET|BPF_K, SECCOMP_RET_ALLOW),
+		}},
+		{"bad scratch slot", []sockFilter{
+			bpfStmt(BPF_ST, bpfMemWords),
+			bpfStmt(BPF_RET|BPF_K, SECCOMP_RET_ALLOW),
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := SeccompData{}
+			if _, err := runBPF(tt.prog, data.bytes()); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+// ============================================================================
+// SECCOMP SIMULATION TESTS
+// ============================================================================
+
+// TestSimulateSeccomp tests end-to-end evaluation of a compiled OCI profile.
+func TestSimulateSeccomp(t *testing.T) {
+	errnoVal := uint(1)
+	config := &spec.LinuxSeccomp{
+		DefaultAction: spec.ActAllow,
+		Architectures: []spec.Arch{spec.ArchX86_64},
+		Syscalls: []spec.LinuxSyscall{
+			{Names: []string{"mount", "kexec_load"}, Action: spec.ActErrno, ErrnoRet: &errnoVal},
+			{Names: []string{"ptrace"}, Action: spec.ActKillProcess},
+		},
+	}
+
+	tests := []struct {
+		name string
+		data SeccompData
+		want string
+	}{
+		{"allowed syscall", SeccompData{Nr: 0, Arch: AUDIT_ARCH_X86_64}, "SCMP_ACT_ALLOW"},
+		{"errno syscall", SeccompData{Nr: 165, Arch: AUDIT_ARCH_X86_64}, "SCMP_ACT_ERRNO(1)"},
+		{"second name in rule", SeccompData{Nr: 246, Arch: AUDIT_ARCH_X86_64}, "SCMP_ACT_ERRNO(1)"},
+		{"killed syscall", SeccompData{Nr: 101, Arch: AUDIT_ARCH_X86_64}, "SCMP_ACT_KILL_PROCESS"},
+		{"foreign arch", SeccompData{Nr: 0, Arch: AUDIT_ARCH_AARCH64}, "SCMP_ACT_KILL_PROCESS"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ret, err := SimulateSeccomp(config, tt.data)
+			if err != nil {
+				t.Fatalf("SimulateSeccomp failed: %v", err)
+			}
+			if got := SeccompActionName(ret); got != tt.want {
+				t.Errorf("action = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSeccompActionName tests rendering of raw return values.
+func TestSeccompActionName(t *testing.T) {
+	tests := []struct {
+		ret  uint32
+		want string
+	}{
+		{SECCOMP_RET_ALLOW, "SCMP_ACT_ALLOW"},
+		{SECCOMP_RET_LOG, "SCMP_ACT_LOG"},
+		{SECCOMP_RET_KILL_PROCESS, "SCMP_ACT_KILL_PROCESS"},
+		{SECCOMP_RET_KILL_THREAD, "SCMP_ACT_KILL_THREAD"},
+		{SECCOMP_RET_TRAP, "SCMP_ACT_TRAP"},
+		{SECCOMP_RET_ERRNO | 38, "SCMP_ACT_ERRNO(38)"},
+		{SECCOMP_RET_TRACE | 7, "SCMP_ACT_TRACE(7)"},
+		{SECCOMP_RET_USER_NOTIF, "SCMP_ACT_NOTIFY"},
+		{0x12340000, "UNKNOWN(0x12340000)"},
+	}
+
+	for _, tt := range tests {
+		if got := SeccompActionName(tt.ret); got != tt.want {
+			t.Errorf("SeccompActionName(0x%x) = %s, want %s", tt.ret, got, tt.want)
+		}
+	}
+}
+
+// ============================================================================
+// DISASSEMBLER TESTS
+// ============================================================================
+
+// TestDisassembleSeccomp tests that the listing names fields, syscalls and actions.
+func TestDisassembleSeccomp(t *testing.T) {
+	config := &spec.LinuxSeccomp{
+		DefaultAction: spec.ActErrno,
+		Syscalls: []spec.LinuxSyscall{
+			{Names: []string{"execve"}, Action: spec.ActAllow},
+		},
+	}
+
+	listing, err := DisassembleSeccomp(config)
+	if err != nil {
+		t.Fatalf("DisassembleSeccomp failed: %v", err)
+	}
+
+	for _, want := range []string{
+		"$data[4] (arch)",
+		"(SCMP_ARCH_X86_64)",
+		"$data[0] (nr)",
+		"jeq  59 (execve)",
+		"ret  SCMP_ACT_ALLOW",
+		"ret  SCMP_ACT_ERRNO(0)",
+	} {
+		if !strings.Contains(listing, want) {
+			t.Errorf("listing missing %q:\n%s", want, listing)
+		}
+	}
+
+	filter, _ := buildSeccompFilter(config)
+	lines := strings.Count(listing, "\n")
+	if lines != len(filter)+2 {
+		t.Errorf("listing has %d lines, want %d", lines, len(filter)+2)
+	}
+}
+
+// TestDisassembleSeccomp_ArchSections tests that each section's syscalls
+// are named from its architecture's table.
+func TestDisassembleSeccomp_ArchSections(t *testing.T) {
+	config := &spec.LinuxSeccomp{
+		DefaultAction: spec.ActErrno,
+		Architectures: []spec.Arch{spec.ArchX86_64, spec.ArchX86},
+		Syscalls: []spec.LinuxSyscall{
+			{Names: []string{"execve"}, Action: spec.ActAllow},
+		},
+	}
+
+	listing, err := DisassembleSeccomp(config)
+	if err != nil {
+		t.Fatalf("DisassembleSeccomp failed: %v", err)
+	}
+	for _, want := range []string{"(SCMP_ARCH_X86)", "jeq  59 (execve)", "jeq  11 (execve)"} {
+		if !strings.Contains(listing, want) {
+			t.Errorf("listing missing %q:\n%s", want, listing)
+		}
+	}
+}
+
+// TestUnrecognizedSyscalls tests reporting of names missing from the table.
+func TestUnrecognizedSyscalls(t *testing.T) {
+	config := &spec.LinuxSeccomp{
+		Syscalls: []spec.LinuxSyscall{
+			{Names: []string{"read", "not_a_syscall"}},
+		},
+	}
+
+	got := UnrecognizedSyscalls(config)
+	if len(got) != 1 || got[0] != "not_a_syscall" {
+		t.Errorf("UnrecognizedSyscalls = %v, want [not_a_syscall]", got)
+	}
+	if UnrecognizedSyscalls(nil) != nil {
+		t.Error("nil config should report nothing")
+	}
+}
+
+// ============================================================================
+// ARGUMENT CONDITION TESTS
+// ============================================================================
+
+// simulateArgs runs a single-rule profile for write(2) against arg0.
+func simulateArgs(t *testing.T, args []spec.LinuxSeccompArg, arg0 uint64) string {
+	t.Helper()
+	config := &spec.LinuxSeccomp{
+		DefaultAction: spec.ActAllow,
+		Syscalls: []spec.LinuxSyscall{
+			{Names: []string{"write"}, Action: spec.ActKillProcess, Args: args},
+		},
+	}
+	ret, err := SimulateSeccomp(config, SeccompData{Nr: 1, Arch: AUDIT_ARCH_X86_64, Args: [6]uint64{arg0}})
+	if err != nil {
+		t.Fatalf("SimulateSeccomp failed: %v", err)
+	}
+	return SeccompActionName(ret)
+}
+
+// TestSimulateSeccomp_ArgOperators tests every comparison operator, including
+// values that differ only in the high 32 bits.
+func TestSimulateSeccomp_ArgOperators(t *testing.T) {
+	const big = 0x100000005
+	tests := []struct {
+		op    spec.LinuxSeccompOperator
+		value uint64
+		arg   uint64
+		match bool
+	}{
+		{spec.OpEqualTo, big, big, true},
+		{spec.OpEqualTo, big, 5, false},
+		{spec.OpNotEqual, big, 5, true},
+		{spec.OpNotEqual, big, big, false},
+		{spec.OpGreaterThan, big, big + 1, true},
+		{spec.OpGreaterThan, big, big, false},
+		{spec.OpGreaterThan, big, 0xffffffff, false},
+		{spec.OpGreaterThan, 5, big, true},
+		{spec.OpGreaterEqual, big, big, true},
+		{spec.OpGreaterEqual, big, big - 1, false},
+		{spec.OpLessThan, big, big - 1, true},
+		{spec.OpLessThan, big, big, false},
+		{spec.OpLessThan, 5, big, false},
+		{spec.OpLessThan, big, 0xffffffff, true},
+		{spec.OpLessEqual, big, big, true},
+		{spec.OpLessEqual, big, big + 1, false},
+	}
+
+	for _, tt := range tests {
+		args := []spec.LinuxSeccompArg{{Index: 0, Value: tt.value, Op: tt.op}}
+		want := "SCMP_ACT_ALLOW"
+		if tt.match {
+			want = "SCMP_ACT_KILL_PROCESS"
+		}
+		if got := simulateArgs(t, args, tt.arg); got != want {
+			t.Errorf("%s 0x%x with arg 0x%x: action = %s, want %s", tt.op, tt.value, tt.arg, got, want)
+		}
+	}
+}
+
+// TestSimulateSeccomp_MaskedEqual tests SCMP_CMP_MASKED_EQ on a flag bit.
+func TestSimulateSeccomp_MaskedEqual(t *testing.T) {
+	args := []spec.LinuxSeccompArg{{Index: 0, Value: 0x10000000, ValueTwo: 0x10000000, Op: spec.OpMaskedEqual}}
+
+	if got := simulateArgs(t, args, 0x10020011); got != "SCMP_ACT_KILL_PROCESS" {
+		t.Errorf("flag set: action = %s, want SCMP_ACT_KILL_PROCESS", got)
+	}
+	if got := simulateArgs(t, args, 0x00020011); got != "SCMP_ACT_ALLOW" {
+		t.Errorf("flag clear: action = %s, want SCMP_ACT_ALLOW", got)
+	}
+}
+
+// TestSimulateSeccomp_ArgCombination tests that conditions on distinct
+// arguments are ANDed while repeated indexes are ORed.
+func TestSimulateSeccomp_ArgCombination(t *testing.T) {
+	and := &spec.LinuxSeccomp{
+		DefaultAction: spec.ActAllow,
+		Syscalls: []spec.LinuxSyscall{{
+			Names:  []string{"write"},
+			Action: spec.ActKillProcess,
+			Args: []spec.LinuxSeccompArg{
+				{Index: 0, Value: 1, Op: spec.OpEqualTo},
+				{Index: 2, Value: 10, Op: spec.OpGreaterThan},
+			},
+		}},
+	}
+	or := &spec.LinuxSeccomp{
+		DefaultAction: spec.ActAllow,
+		Syscalls: []spec.LinuxSyscall{{
+			Names:  []string{"write"},
+			Action: spec.ActKillProcess,
+			Args: []spec.LinuxSeccompArg{
+				{Index: 0, Value: 1, Op: spec.OpEqualTo},
+				{Index: 0, Value: 2, Op: spec.OpEqualTo},
+			},
+		}},
+	}
+
+	tests := []struct {
+		name   string
+		config *spec.LinuxSeccomp
+		args   [6]uint64
+		want   string
+	}{
+		{"and both hold", and, [6]uint64{1, 0, 11}, "SCMP_ACT_KILL_PROCESS"},
+		{"and one holds", and, [6]uint64{1, 0, 10}, "SCMP_ACT_ALLOW"},
+		{"or first", or, [6]uint64{1}, "SCMP_ACT_KILL_PROCESS"},
+		{"or second", or, [6]uint64{2}, "SCMP_ACT_KILL_PROCESS"},
+		{"or neither", or, [6]uint64{3}, "SCMP_ACT_ALLOW"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := SeccompData{Nr: 1, Arch: AUDIT_ARCH_X86_64, Args: tt.args}
+			ret, err := SimulateSeccomp(tt.config, data)
+			if err != nil {
+				t.Fatalf("SimulateSeccomp failed: %v", err)
+			}
+			if got := SeccompActionName(ret); got != tt.want {
+				t.Errorf("action = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSimulateSeccomp_RulesAfterArgs tests that rules following an argument
+// check still see the syscall number.
+func TestSimulateSeccomp_RulesAfterArgs(t *testing.T) {
+	config := &spec.LinuxSeccomp{
+		DefaultAction: spec.ActAllow,
+		Syscalls: []spec.LinuxSyscall{
+			{Names: []string{"write"}, Action: spec.ActKillProcess,
+				Args: []spec.LinuxSeccompArg{{Index: 0, Value: 99, Op: spec.OpEqualTo}}},
+			{Names: []string{"write"}, Action: spec.ActLog},
+		},
+	}
+
+	ret, err := SimulateSeccomp(config, SeccompData{Nr: 1, Arch: AUDIT_ARCH_X86_64, Args: [6]uint64{1}})
+	if err != nil {
+		t.Fatalf("SimulateSeccomp failed: %v", err)
+	}
+	if got := SeccompActionName(ret); got != "SCMP_ACT_LOG" {
+		t.Errorf("action = %s, want SCMP_ACT_LOG", got)
+	}
+}
+
+// TestBuildSeccompFilter_InvalidArgs tests that malformed conditions are rejected.
+func TestBuildSeccompFilter_InvalidArgs(t *testing.T) {
+	for _, arg := range []spec.LinuxSeccompArg{
+		{Index: 6, Value: 1, Op: spec.OpEqualTo},
+		{Index: 0, Value: 1, Op: "SCMP_CMP_INVALID"},
+	} {
+		config := &spec.LinuxSeccomp{
+			DefaultAction: spec.ActAllow,
+			Syscalls: []spec.LinuxSyscall{
+				{Names: []string{"write"}, Action: spec.ActErrno, Args: []spec.LinuxSeccompArg{arg}},
+			},
+		}
+		if _, err := buildSeccompFilter(config); err == nil {
+			t.Errorf("expected error for %+v", arg)
+		}
+	}
+}
+
+// TestSimulateSeccomp_X32 tests that x32 syscalls are killed: there is no
+// x32 syscall table, so not even listing x32 lets them through.
+func TestSimulateSeccomp_X32(t *testing.T) {
+	data := SeccompData{Nr: X32_SYSCALL_BIT | 1, Arch: AUDIT_ARCH_X86_64}
+
+	config := &spec.LinuxSeccomp{DefaultAction: spec.ActAllow}
+	ret, err := SimulateSeccomp(config, data)
+	if err != nil {
+		t.Fatalf("SimulateSeccomp failed: %v", err)
+	}
+	if ret != SECCOMP_RET_KILL_PROCESS {
+		t.Errorf("x32 syscall: action = %s, want SCMP_ACT_KILL_PROCESS", SeccompActionName(ret))
+	}
+
+	config.Architectures = []spec.Arch{spec.ArchX86_64, spec.ArchX32}
+	ret, err = SimulateSeccomp(config, data)
+	if err != nil {
+		t.Fatalf("SimulateSeccomp failed: %v", err)
+	}
+	if ret != SECCOMP_RET_KILL_PROCESS {
+		t.Errorf("x32 listed: action = %s, want SCMP_ACT_KILL_PROCESS", SeccompActionName(ret))
+	}
+}
+
+// ============================================================================
+// DEFAULT PROFILE TESTS
+// ============================================================================
+
+// TestDefaultSeccomp_Architectures tests that the built-in profile resolves
+// to names known on each architecture it covers, so SetupSeccomp accepts it.
+func TestDefaultSeccomp_Architectures(t *testing.T) {
+	for goarch, missing := range map[string]int{"amd64": 0, "arm64": 0, "386": 1 /* kexec_file_load */} {
+		config, err := spec.DefaultSeccomp(goarch, nil)
+		if err != nil {
+			t.Errorf("DefaultSeccomp(%s) failed: %v", goarch, err)
+			continue
+		}
+		if names := UnrecognizedSyscalls(config); len(names) != missing {
+			t.Errorf("%s: unrecognized syscalls %v, want %d", goarch, names, missing)
+		}
+		if _, err := buildSeccompFilter(config); err != nil {
+			t.Errorf("%s: buildSeccompFilter failed: %v", goarch, err)
+		}
+	}
+}
+
+// TestDefaultSeccomp_Simulated tests the built-in profile end to end.
+func TestDefaultSeccomp_Simulated(t *testing.T) {
+	unprivileged, err := spec.DefaultSeccomp("amd64", []string{"CAP_CHOWN", "CAP_KILL"})
+	if err != nil {
+		t.Fatalf("DefaultSeccomp failed: %v", err)
+	}
+	privileged, err := spec.DefaultSeccomp("amd64", []string{"CAP_SYS_ADMIN"})
+	if err != nil {
+		t.Fatalf("DefaultSeccomp failed: %v", err)
+	}
+
+	if names := UnrecognizedSyscalls(unprivileged); len(names) != 0 {
+		t.Errorf("default profile has unrecognized syscalls: %v", names)
+	}
+
+	const cloneNewUser = 0x10000000
+	tests := []struct {
+		name    string
+		config  *spec.LinuxSeccomp
+		syscall string
+		arg0    uint64
+		want    string
+	}{
+		{"read", unprivileged, "read", 0, "SCMP_ACT_ALLOW"},
+		{"keyctl", unprivileged, "keyctl", 0, "SCMP_ACT_ERRNO(1)"},
+		{"kexec", unprivileged, "kexec_load", 0, "SCMP_ACT_ERRNO(1)"},
+		{"init_module", unprivileged, "init_module", 0, "SCMP_ACT_ERRNO(1)"},
+		{"bpf", unprivileged, "bpf", 0, "SCMP_ACT_ERRNO(1)"},
+		{"mount", unprivileged, "mount", 0, "SCMP_ACT_ERRNO(1)"},
+		{"plain clone", unprivileged, "clone", 0x11, "SCMP_ACT_ALLOW"},
+		{"clone userns", unprivileged, "clone", cloneNewUser | 0x11, "SCMP_ACT_ERRNO(1)"},
+		{"unshare userns", unprivileged, "unshare", cloneNewUser, "SCMP_ACT_ERRNO(1)"},
+		{"clone3", unprivileged, "clone3", 0, "SCMP_ACT_ERRNO(38)"},
+		{"bpf with CAP_SYS_ADMIN", privileged, "bpf", 0, "SCMP_ACT_ALLOW"},
+		{"unshare userns with CAP_SYS_ADMIN", privileged, "unshare", cloneNewUser, "SCMP_ACT_ALLOW"},
+		{"keyctl with CAP_SYS_ADMIN", privileged, "keyctl", 0, "SCMP_ACT_ERRNO(1)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nr, _ := SyscallNumber(tt.syscall)
+			data := SeccompData{Nr: int32(nr), Arch: AUDIT_ARCH_X86_64, Args: [6]uint64{tt.arg0}}
+			ret, err := SimulateSeccomp(tt.config, data)
+			if err != nil {
+				t.Fatalf("SimulateSeccomp failed: %v", err)
+			}
+			if got := SeccompActionName(ret); got != tt.want {
+				t.Errorf("action = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRunBPF_I386Numbers tests that i386 calls are matched against the i386
+// syscall table: i386 mount is 21, which is access on x86_64.
+func TestRunBPF_I386Numbers(t *testing.T) {
+	errno := uint(1)
+	config := &spec.LinuxSeccomp{
+		DefaultAction:   spec.ActErrno,
+		DefaultErrnoRet: &errno,
+		Architectures:   []spec.Arch{spec.ArchX86_64, spec.ArchX86, spec.ArchX32},
+		Syscalls: []spec.LinuxSyscall{
+			{Names: []string{"access", "read"}, Action: spec.ActAllow},
+		},
+	}
+	filter, err := buildSeccompFilter(config)
+	if err != nil {
+		t.Fatalf("buildSeccompFilter failed: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		data SeccompData
+		want uint32
+	}{
+		{"i386 mount", SeccompData{Nr: int32(syscallMapX86["mount"]), Arch: AUDIT_ARCH_I386}, SECCOMP_RET_ERRNO | 1},
+		{"i386 access", SeccompData{Nr: int32(syscallMapX86["access"]), Arch: AUDIT_ARCH_I386}, SECCOMP_RET_ALLOW},
+		{"x86_64 access", SeccompData{Nr: int32(syscallMap["access"]), Arch: AUDIT_ARCH_X86_64}, SECCOMP_RET_ALLOW},
+		{"x86_64 mount", SeccompData{Nr: int32(syscallMap["mount"]), Arch: AUDIT_ARCH_X86_64}, SECCOMP_RET_ERRNO | 1},
+		{"aarch64", SeccompData{Nr: 63, Arch: AUDIT_ARCH_AARCH64}, SECCOMP_RET_KILL_PROCESS},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ret, err := runBPF(filter, tt.data.bytes())
+			if err != nil {
+				t.Fatalf("runBPF failed: %v", err)
+			}
+			if ret != tt.want {
+				t.Errorf("action = %s, want %s", SeccompActionName(ret), SeccompActionName(tt.want))
+			}
+		})
+	}
+
+	// A deny list must catch i386 mount too
+	config = &spec.LinuxSeccomp{
+		DefaultAction: spec.ActAllow,
+		Architectures: []spec.Arch{spec.ArchX86_64, spec.ArchX86},
+		Syscalls:      []spec.LinuxSyscall{{Names: []string{"mount"}, Action: spec.ActKillProcess}},
+	}
+	if filter, err = buildSeccompFilter(config); err != nil {
+		t.Fatalf("buildSeccompFilter failed: %v", err)
+	}
+	data := SeccompData{Nr: 21, Arch: AUDIT_ARCH_I386}
+	ret, err := runBPF(filter, data.bytes())
+	if err != nil {
+		t.Fatalf("runBPF failed: %v", err)
+	}
+	if ret != SECCOMP_RET_KILL_PROCESS {
+		t.Errorf("i386 mount under a deny list: action = %s, want SCMP_ACT_KILL_PROCESS", SeccompActionName(ret))
+	}
+}
+
+// TestBuildSeccompFilter_NoSupportedArch tests that a config listing only
+// architectures without a syscall table is rejected.
+func TestBuildSeccompFilter_NoSupportedArch(t *testing.T) {
+	config := &spec.LinuxSeccomp{
+		DefaultAction: spec.ActAllow,
+		Architectures: []spec.Arch{spec.ArchARM, spec.ArchX32},
+	}
+	if _, err := buildSeccompFilter(config); err == nil {
+		t.Error("expected error for architectures without syscall tables")
+	}
+	if got := UnsupportedArchitectures(config); len(got) != 2 {
+		t.Errorf("UnsupportedArchitectures = %v, want [%s %s]", got, spec.ArchARM, spec.ArchX32)
+	}
+}
@@ -1,13 +1,21 @@
-// Package linux provides cgroup v2 resource management.
+// Package linux provides cgroup resource management.
 package linux
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"runc-go/spec"
 )
@@ -16,8 +24,231 @@ import (
 // Valid keys are like: cpu.max, memory.max, pids.max, io.bfq.weight
 var validCgroupKey = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9]*(\.[a-zA-Z][a-zA-Z0-9]*)*$`)
 
+// validHugepageSize matches page sizes as the kernel names them in hugetlb
+// files (e.g. 64KB, 2MB, 1GB).
+var validHugepageSize = regexp.MustCompile(`^[1-9][0-9]*[KMG]B$`)
+
 const cgroupRoot = "/sys/fs/cgroup"
 
+// CgroupManager is the interface shared by the cgroup v1 and v2 backends.
+type CgroupManager interface {
+	// Path returns the container's cgroup directory. With cgroup v1 this
+	// is the directory in the memory hierarchy (or the first one mounted).
+	Path() string
+
+	// AddProcess moves a process into the cgroup.
+	AddProcess(pid int) error
+
+	// ApplyResources applies OCI resource limits to the cgroup.
+	ApplyResources(resources *spec.LinuxResources) error
+
+	// Freeze freezes all processes in the cgroup.
+	Freeze() error
+
+	// Thaw unfreezes all processes in the cgroup.
+	Thaw() error
+
+	// Kill sends a signal to every process in the cgroup, including
+	// those in nested cgroups.
+	Kill(sig syscall.Signal) error
+
+	// Procs returns the PIDs of every process in the cgroup, including
+	// those in nested cgroups.
+	Procs() ([]int, error)
+
+	// Delegate hands the cgroup to uid/gid so that a manager inside the
+	// container can create and populate child cgroups.
+	Delegate(uid, gid int) error
+
+	// Destroy removes the cgroup and any nested cgroups. They must be empty.
+	Destroy() error
+
+	// GetMemoryCurrent returns current memory usage.
+	GetMemoryCurrent() (int64, error)
+
+	// GetPidsCurrent returns current number of processes.
+	GetPidsCurrent() (int64, error)
+
+	// GetMemoryEvents returns the memory event counters, including OOM
+	// kills. They persist after the processes exit.
+	GetMemoryEvents() (*spec.MemoryEvents, error)
+
+	// GetMemoryPressure returns the PSI memory pressure. It requires a
+	// cgroup v2 hierarchy.
+	GetMemoryPressure() (*spec.PSIStats, error)
+}
+
+// CgroupMode is the layout of the host's cgroup filesystems.
+type CgroupMode int
+
+const (
+	// CgroupModeUnified is a pure cgroup v2 host.
+	CgroupModeUnified CgroupMode = iota
+	// CgroupModeHybrid has v1 controllers plus a controller-less v2 mount.
+	CgroupModeHybrid
+	// CgroupModeLegacy has only v1 hierarchies.
+	CgroupModeLegacy
+)
+
+// String returns the mode name.
+func (m CgroupMode) String() string {
+	switch m {
+	case CgroupModeUnified:
+		return "unified"
+	case CgroupModeHybrid:
+		return "hybrid"
+	case CgroupModeLegacy:
+		return "legacy"
+	default:
+		return "unknown"
+	}
+}
+
+// CgroupHierarchy describes the cgroup filesystems mounted on the host.
+type CgroupHierarchy struct {
+	// Mode is the overall layout.
+	Mode CgroupMode
+
+	// Unified is the cgroup2 mount point, if any.
+	Unified string
+
+	// Controllers maps each v1 controller to its mount point. Controllers
+	// that are co-mounted (e.g. cpu,cpuacct) share a mount point.
+	Controllers map[string]string
+}
+
+var (
+	// cgroupHierarchyOnce ensures mountinfo is only parsed once.
+	cgroupHierarchyOnce sync.Once
+	// cgroupHierarchy holds the detected hierarchy.
+	cgroupHierarchy *CgroupHierarchy
+	// cgroupHierarchyErr holds the detection error, if any.
+	cgroupHierarchyErr error
+)
+
+// DetectCgroupHierarchy reports how cgroups are mounted on this host,
+// based on /proc/self/mountinfo.
+func DetectCgroupHierarchy() (*CgroupHierarchy, error) {
+	cgroupHierarchyOnce.Do(func() {
+		f, err := os.Open("/proc/self/mountinfo")
+		if err != nil {
+			cgroupHierarchyErr = fmt.Errorf("open mountinfo: %w", err)
+			return
+		}
+		defer f.Close()
+		cgroupHierarchy, cgroupHierarchyErr = parseCgroupHierarchy(f)
+	})
+	return cgroupHierarchy, cgroupHierarchyErr
+}
+
+// v1Controllers lists the cgroup v1 controllers recognized in mount options.
+var v1Controllers = map[string]bool{
+	"blkio": true, "cpu": true, "cpuacct": true, "cpuset": true,
+	"devices": true, "freezer": true, "hugetlb": true, "memory": true,
+	"misc": true, "net_cls": true, "net_prio": true, "perf_event": true,
+	"pids": true, "rdma": true,
+}
+
+// parseCgroupHierarchy finds the cgroup mounts in mountinfo-formatted data.
+func parseCgroupHierarchy(r io.Reader) (*CgroupHierarchy, error) {
+	h := &CgroupHierarchy{Controllers: make(map[string]string)}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// Format: ID PARENT MAJ:MIN ROOT MOUNTPOINT OPTS [OPTIONAL...] - FSTYPE SOURCE SUPEROPTS
+		fields := strings.Fields(scanner.Text())
+		sep := -1
+		for i, f := range fields {
+			if f == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep < 5 || len(fields) < sep+4 {
+			continue
+		}
+		mountPoint := fields[4]
+		fsType := fields[sep+1]
+		superOpts := fields[sep+3]
+
+		switch fsType {
+		case "cgroup2":
+			// Prefer the canonical location if several are mounted
+			if h.Unified == "" || mountPoint == cgroupRoot {
+				h.Unified = mountPoint
+			}
+		case "cgroup":
+			for _, opt := range strings.Split(superOpts, ",") {
+				if v1Controllers[opt] {
+					if _, seen := h.Controllers[opt]; !seen {
+						h.Controllers[opt] = mountPoint
+					}
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read mountinfo: %w", err)
+	}
+
+	switch {
+	case len(h.Controllers) == 0 && h.Unified != "":
+		h.Mode = CgroupModeUnified
+	case len(h.Controllers) > 0 && h.Unified != "":
+		h.Mode = CgroupModeHybrid
+	case len(h.Controllers) > 0:
+		h.Mode = CgroupModeLegacy
+	default:
+		return nil, fmt.Errorf("no cgroup filesystem mounted")
+	}
+	return h, nil
+}
+
+// NewCgroupManager creates or opens the cgroup at cgroupPath using the
+// backend that matches the host's hierarchy.
+func NewCgroupManager(cgroupPath string) (CgroupManager, error) {
+	h, err := DetectCgroupHierarchy()
+	if err != nil {
+		return nil, err
+	}
+	return newCgroupManager(h, cgroupPath)
+}
+
+// OpenCgroupManager opens an existing cgroup without creating it. The
+// error wraps fs.ErrNotExist when the cgroup is gone.
+func OpenCgroupManager(cgroupPath string) (CgroupManager, error) {
+	h, err := DetectCgroupHierarchy()
+	if err != nil {
+		return nil, err
+	}
+	var cg CgroupManager
+	if h.Mode == CgroupModeUnified {
+		cg = &Cgroup{path: filepath.Join(h.Unified, filepath.Clean("/"+cgroupPath))}
+	} else {
+		cg = cgroupV1At(h, cgroupPath)
+	}
+	if _, err := os.Stat(cg.Path()); err != nil {
+		return nil, fmt.Errorf("open cgroup: %w", err)
+	}
+	return cg, nil
+}
+
+// newCgroupManager creates a cgroup for an explicit hierarchy.
+func newCgroupManager(h *CgroupHierarchy, cgroupPath string) (CgroupManager, error) {
+	if h.Mode == CgroupModeUnified {
+		cg, err := newCgroupAt(h.Unified, cgroupPath)
+		if err != nil {
+			return nil, err
+		}
+		return cg, nil
+	}
+	cg, err := newCgroupV1(h, cgroupPath)
+	if err != nil {
+		return nil, err
+	}
+	return cg, nil
+}
+
 // Cgroup represents a cgroup v2 control group.
 type Cgroup struct {
 	path string
@@ -26,12 +257,17 @@ type Cgroup struct {
 // NewCgroup creates or opens a cgroup at the given path.
 // Path should be relative to /sys/fs/cgroup (e.g., "runc-go/container-id").
 func NewCgroup(cgroupPath string) (*Cgroup, error) {
+	return newCgroupAt(cgroupRoot, cgroupPath)
+}
+
+// newCgroupAt creates or opens a cgroup v2 below the given mount point.
+func newCgroupAt(root, cgroupPath string) (*Cgroup, error) {
 	// Handle absolute paths or OCI-style paths
 	var fullPath string
 	if strings.HasPrefix(cgroupPath, "/") {
-		fullPath = filepath.Join(cgroupRoot, cgroupPath)
+		fullPath = filepath.Join(root, cgroupPath)
 	} else {
-		fullPath = filepath.Join(cgroupRoot, cgroupPath)
+		fullPath = filepath.Join(root, cgroupPath)
 	}
 
 	if err := os.MkdirAll(fullPath, 0755); err != nil {
@@ -70,6 +306,22 @@ func (c *Cgroup) ApplyResources(resources *spec.LinuxResources) error {
 		return err
 	}
 
+	if err := c.applyBlockIO(resources.BlockIO); err != nil {
+		return err
+	}
+
+	if err := c.applyHugepages(resources.HugepageLimits); err != nil {
+		return err
+	}
+
+	if err := c.applyRdma(resources.Rdma); err != nil {
+		return err
+	}
+
+	if err := c.applyDevices(resources.Devices); err != nil {
+		return err
+	}
+
 	// Apply unified cgroup v2 settings directly
 	for key, value := range resources.Unified {
 		// SECURITY: Validate cgroup key to prevent path traversal
@@ -86,25 +338,56 @@ func (c *Cgroup) ApplyResources(resources *spec.LinuxResources) error {
 	return nil
 }
 
+// writeFile writes a value to a file in the cgroup directory.
+func (c *Cgroup) writeFile(file, value string) error {
+	if err := os.WriteFile(filepath.Join(c.path, file), []byte(value), 0644); err != nil {
+		return fmt.Errorf("set %s: %w", file, err)
+	}
+	return nil
+}
+
 // applyMemory applies memory limits.
 func (c *Cgroup) applyMemory(memory *spec.LinuxMemory) error {
 	if memory == nil {
 		return nil
 	}
 
-	// memory.max - hard limit
+	// Reject v1-only settings rather than silently dropping them
+	if memory.Swappiness != nil {
+		return fmt.Errorf("memory.swappiness has no cgroup v2 equivalent")
+	}
+	if memory.Kernel != nil {
+		return fmt.Errorf("memory.kernel has no cgroup v2 equivalent")
+	}
+	if memory.KernelTCP != nil {
+		return fmt.Errorf("memory.kernelTCP has no cgroup v2 equivalent")
+	}
+	// An enabled OOM killer is all v2 has
+	if memory.DisableOOMKiller != nil && *memory.DisableOOMKiller {
+		return fmt.Errorf("memory.disableOOMKiller has no cgroup v2 equivalent")
+	}
+	if memory.UseHierarchy != nil && !*memory.UseHierarchy {
+		return fmt.Errorf("memory.useHierarchy=false is not supported: cgroup v2 is always hierarchical")
+	}
+
 	if memory.Limit != nil && *memory.Limit > 0 {
-		path := filepath.Join(c.path, "memory.max")
-		if err := os.WriteFile(path, []byte(strconv.FormatInt(*memory.Limit, 10)), 0644); err != nil {
-			return fmt.Errorf("set memory.max: %w", err)
+		// Refuse to shrink the limit below what is already in use
+		if memory.CheckBeforeUpdate != nil && *memory.CheckBeforeUpdate {
+			if usage, err := c.GetMemoryCurrent(); err == nil && *memory.Limit < usage {
+				return fmt.Errorf("memory limit %d is below current usage %d", *memory.Limit, usage)
+			}
+		}
+
+		// memory.max - hard limit
+		if err := c.writeFile("memory.max", strconv.FormatInt(*memory.Limit, 10)); err != nil {
+			return err
 		}
 	}
 
 	// memory.low - soft limit / reservation
 	if memory.Reservation != nil && *memory.Reservation > 0 {
-		path := filepath.Join(c.path, "memory.low")
-		if err := os.WriteFile(path, []byte(strconv.FormatInt(*memory.Reservation, 10)), 0644); err != nil {
-			return fmt.Errorf("set memory.low: %w", err)
+		if err := c.writeFile("memory.low", strconv.FormatInt(*memory.Reservation, 10)); err != nil {
+			return err
 		}
 	}
 
@@ -128,12 +411,35 @@ func (c *Cgroup) applyMemory(memory *spec.LinuxMemory) error {
 	return nil
 }
 
+// cpuSharesToWeight converts cgroup v1 cpu.shares to a v2 cpu.weight:
+// weight = 1 + (shares - 2) * 9999 / 262142, which maps shares (2-262144)
+// to weight (1-10000).
+func cpuSharesToWeight(shares uint64) uint64 {
+	var weight uint64 = 1
+	if shares > 2 {
+		weight = 1 + (shares-2)*9999/262142
+	}
+	if weight > 10000 {
+		weight = 10000
+	}
+	return weight
+}
+
 // applyCPU applies CPU limits.
 func (c *Cgroup) applyCPU(cpu *spec.LinuxCPU) error {
 	if cpu == nil {
 		return nil
 	}
 
+	// The realtime scheduler has no v2 interface
+	if (cpu.RealtimeRuntime != nil && *cpu.RealtimeRuntime != 0) ||
+		(cpu.RealtimePeriod != nil && *cpu.RealtimePeriod != 0) {
+		return fmt.Errorf("cpu.realtimeRuntime and cpu.realtimePeriod have no cgroup v2 equivalent")
+	}
+	if cpu.Idle != nil && *cpu.Idle != 0 && *cpu.Idle != 1 {
+		return fmt.Errorf("invalid cpu.idle %d: must be 0 or 1", *cpu.Idle)
+	}
+
 	// cpu.max - quota and period
 	if cpu.Quota != nil || cpu.Period != nil {
 		quota := "max"
@@ -151,25 +457,29 @@ func (c *Cgroup) applyCPU(cpu *spec.LinuxCPU) error {
 		}
 	}
 
+	// cpu.max.burst - must follow cpu.max, as it may not exceed the quota
+	if cpu.Burst != nil {
+		if err := c.writeFile("cpu.max.burst", strconv.FormatUint(*cpu.Burst, 10)); err != nil {
+			return err
+		}
+	}
+
 	// cpu.weight (replaces cpu.shares)
 	if cpu.Shares != nil && *cpu.Shares > 0 {
-		// Convert shares to weight using the correct formula:
-		// weight = 1 + (shares - 2) * 9999 / 262142
-		// This maps shares (2-262144) to weight (1-10000)
-		shares := *cpu.Shares
-		var weight uint64 = 1
-		if shares > 2 {
-			weight = 1 + (shares-2)*9999/262142
-		}
-		if weight > 10000 {
-			weight = 10000
-		}
+		weight := cpuSharesToWeight(*cpu.Shares)
 		path := filepath.Join(c.path, "cpu.weight")
 		if err := os.WriteFile(path, []byte(strconv.FormatUint(weight, 10)), 0644); err != nil {
 			return fmt.Errorf("set cpu.weight: %w", err)
 		}
 	}
 
+	// cpu.idle - after cpu.weight, which the kernel refuses on idle groups
+	if cpu.Idle != nil {
+		if err := c.writeFile("cpu.idle", strconv.FormatInt(*cpu.Idle, 10)); err != nil {
+			return err
+		}
+	}
+
 	// cpuset.cpus
 	if cpu.Cpus != "" {
 		path := filepath.Join(c.path, "cpuset.cpus")
@@ -205,10 +515,377 @@ func (c *Cgroup) applyPids(pids *spec.LinuxPids) error {
 	return nil
 }
 
-// Destroy removes the cgroup.
+// applyBlockIO applies block I/O weights and throttles through the io
+// controller. Weights go to io.bfq.weight when the BFQ scheduler provides
+// it, since BFQ uses the blkio weight range directly.
+func (c *Cgroup) applyBlockIO(blkio *spec.LinuxBlockIO) error {
+	if blockIOEmpty(blkio) {
+		return nil
+	}
+	if err := validateBlockIO(blkio); err != nil {
+		return err
+	}
+	if blkio.LeafWeight != nil {
+		return fmt.Errorf("blockIO.leafWeight has no cgroup v2 equivalent")
+	}
+	for _, wd := range blkio.WeightDevice {
+		if wd.LeafWeight != nil {
+			return fmt.Errorf("blockIO.weightDevice[%d:%d].leafWeight has no cgroup v2 equivalent", wd.Major, wd.Minor)
+		}
+	}
+	if err := c.requireController("io"); err != nil {
+		return err
+	}
+
+	weightFile := "io.weight"
+	weight := func(w uint16) string {
+		return strconv.FormatUint(blkioWeightToIOWeight(w), 10)
+	}
+	if _, err := os.Stat(filepath.Join(c.path, "io.bfq.weight")); err == nil {
+		weightFile = "io.bfq.weight"
+		weight = func(w uint16) string {
+			return strconv.FormatUint(uint64(w), 10)
+		}
+	}
+
+	if blkio.Weight != nil {
+		if err := c.writeFile(weightFile, weight(*blkio.Weight)); err != nil {
+			return err
+		}
+	}
+	for _, wd := range blkio.WeightDevice {
+		if wd.Weight == nil {
+			continue
+		}
+		if err := c.writeFile(weightFile, fmt.Sprintf("%d:%d %s", wd.Major, wd.Minor, weight(*wd.Weight))); err != nil {
+			return err
+		}
+	}
+
+	// io.max takes one device per write
+	for _, line := range ioMaxLines(blkio) {
+		if err := c.writeFile("io.max", line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// applyDevices replaces the cgroup's device filter with one compiled
+// from the rules.
+func (c *Cgroup) applyDevices(devices []spec.LinuxDeviceCgroup) error {
+	if len(devices) == 0 {
+		return nil
+	}
+	return setDeviceFilter(c.path, devices)
+}
+
+// applyHugepages applies hugetlb limits per page size.
+func (c *Cgroup) applyHugepages(limits []spec.LinuxHugepageLimit) error {
+	if len(limits) == 0 {
+		return nil
+	}
+	if err := c.requireController("hugetlb"); err != nil {
+		return err
+	}
+
+	for _, l := range limits {
+		if !validHugepageSize.MatchString(l.Pagesize) {
+			return fmt.Errorf("invalid hugepage size %q", l.Pagesize)
+		}
+		value := strconv.FormatUint(l.Limit, 10)
+		if err := c.writeFile("hugetlb."+l.Pagesize+".max", value); err != nil {
+			return err
+		}
+
+		// Reservations are accounted separately since Linux 5.7
+		rsvd := "hugetlb." + l.Pagesize + ".rsvd.max"
+		if _, err := os.Stat(filepath.Join(c.path, rsvd)); err == nil {
+			if err := c.writeFile(rsvd, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// applyRdma applies RDMA limits per device.
+func (c *Cgroup) applyRdma(rdma map[string]spec.LinuxRdma) error {
+	if len(rdma) == 0 {
+		return nil
+	}
+	lines, err := rdmaLines(rdma)
+	if err != nil {
+		return err
+	}
+	if err := c.requireController("rdma"); err != nil {
+		return err
+	}
+
+	// rdma.max takes one device per write
+	for _, line := range lines {
+		if err := c.writeFile("rdma.max", line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rdmaLines formats rdma.max lines sorted by device. Unset limits are "max".
+func rdmaLines(rdma map[string]spec.LinuxRdma) ([]string, error) {
+	devices := make([]string, 0, len(rdma))
+	for device := range rdma {
+		if device == "" || strings.ContainsAny(device, " \t\n/") {
+			return nil, fmt.Errorf("invalid rdma device %q", device)
+		}
+		devices = append(devices, device)
+	}
+	sort.Strings(devices)
+
+	limit := func(v *uint32) string {
+		if v == nil {
+			return "max"
+		}
+		return strconv.FormatUint(uint64(*v), 10)
+	}
+	lines := make([]string, len(devices))
+	for i, device := range devices {
+		r := rdma[device]
+		lines[i] = fmt.Sprintf("%s hca_handle=%s hca_object=%s", device, limit(r.HcaHandles), limit(r.HcaObjects))
+	}
+	return lines, nil
+}
+
+// requireController checks that a controller is enabled for the cgroup,
+// i.e. that the parent delegates it and it is listed in cgroup.controllers.
+func (c *Cgroup) requireController(name string) error {
+	data, err := os.ReadFile(filepath.Join(c.path, "cgroup.controllers"))
+	if err != nil {
+		return fmt.Errorf("read cgroup.controllers: %w", err)
+	}
+	for _, controller := range strings.Fields(string(data)) {
+		if controller == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s controller is not enabled in the parent of %s", name, c.path)
+}
+
+// blockIOEmpty reports whether blkio sets nothing.
+func blockIOEmpty(blkio *spec.LinuxBlockIO) bool {
+	return blkio == nil || (blkio.Weight == nil && blkio.LeafWeight == nil &&
+		len(blkio.WeightDevice) == 0 &&
+		len(blkio.ThrottleReadBpsDevice) == 0 && len(blkio.ThrottleWriteBpsDevice) == 0 &&
+		len(blkio.ThrottleReadIOPSDevice) == 0 && len(blkio.ThrottleWriteIOPSDevice) == 0)
+}
+
+// validateBlockIO checks weights against the blkio range of 10-1000.
+func validateBlockIO(blkio *spec.LinuxBlockIO) error {
+	check := func(name string, w *uint16) error {
+		if w != nil && (*w < 10 || *w > 1000) {
+			return fmt.Errorf("%s %d out of range (10-1000)", name, *w)
+		}
+		return nil
+	}
+	if err := check("blockIO.weight", blkio.Weight); err != nil {
+		return err
+	}
+	if err := check("blockIO.leafWeight", blkio.LeafWeight); err != nil {
+		return err
+	}
+	for _, wd := range blkio.WeightDevice {
+		device := fmt.Sprintf("blockIO.weightDevice[%d:%d]", wd.Major, wd.Minor)
+		if err := check(device+".weight", wd.Weight); err != nil {
+			return err
+		}
+		if err := check(device+".leafWeight", wd.LeafWeight); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// blkioWeightToIOWeight converts a blkio weight to an io.weight:
+// weight = 1 + (blkio - 10) * 9999 / 990, which maps 10-1000 to 1-10000.
+func blkioWeightToIOWeight(weight uint16) uint64 {
+	return 1 + (uint64(weight)-10)*9999/990
+}
+
+// ioMaxLines merges the throttle lists into one io.max line per device,
+// in the order devices first appear. A zero rate removes the limit.
+func ioMaxLines(blkio *spec.LinuxBlockIO) []string {
+	var devices []string
+	limits := make(map[string][]string)
+	add := func(key string, throttles []spec.LinuxThrottleDevice) {
+		for _, t := range throttles {
+			device := fmt.Sprintf("%d:%d", t.Major, t.Minor)
+			if _, seen := limits[device]; !seen {
+				devices = append(devices, device)
+			}
+			rate := "max"
+			if t.Rate > 0 {
+				rate = strconv.FormatUint(t.Rate, 10)
+			}
+			limits[device] = append(limits[device], key+"="+rate)
+		}
+	}
+	add("rbps", blkio.ThrottleReadBpsDevice)
+	add("wbps", blkio.ThrottleWriteBpsDevice)
+	add("riops", blkio.ThrottleReadIOPSDevice)
+	add("wiops", blkio.ThrottleWriteIOPSDevice)
+
+	lines := make([]string, len(devices))
+	for i, device := range devices {
+		lines[i] = device + " " + strings.Join(limits[device], " ")
+	}
+	return lines
+}
+
+// Kill sends sig to every process in the cgroup and its descendants.
+// SIGKILL uses cgroup.kill where the kernel has it (5.14+). Otherwise the
+// cgroup is frozen while its processes are signalled so that none can
+// fork a child the walk would miss.
+func (c *Cgroup) Kill(sig syscall.Signal) error {
+	if sig == syscall.SIGKILL {
+		err := c.writeFile("cgroup.kill", "1")
+		if err == nil || !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	// A frozen cgroup is left frozen; its processes see the signal once
+	// thawed, and SIGKILL is delivered to frozen tasks on v2
+	froze := false
+	if data, err := os.ReadFile(filepath.Join(c.path, "cgroup.freeze")); err == nil && strings.TrimSpace(string(data)) == "0" {
+		if err := c.Freeze(); err == nil {
+			froze = true
+			c.waitFrozen()
+		}
+	}
+
+	pids, err := c.Procs()
+	if err == nil {
+		err = signalProcs(pids, sig)
+	}
+	if froze {
+		if thawErr := c.Thaw(); thawErr != nil && err == nil {
+			err = thawErr
+		}
+	}
+	return err
+}
+
+// waitFrozen waits briefly for cgroup.events to report the cgroup frozen.
+func (c *Cgroup) waitFrozen() bool {
+	for i := 0; i < 100; i++ {
+		data, err := os.ReadFile(filepath.Join(c.path, "cgroup.events"))
+		if err != nil {
+			return false
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if line == "frozen 1" {
+				return true
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+// Procs returns the PIDs in the cgroup and its descendants.
+func (c *Cgroup) Procs() ([]int, error) {
+	return cgroupProcs([]string{c.path})
+}
+
+// Destroy removes the cgroup and its descendants, deepest first. A cgroup
+// that still holds processes fails with EBUSY.
 func (c *Cgroup) Destroy() error {
-	// Cgroup must be empty to remove
-	return os.Remove(c.path)
+	return removeCgroupTree(c.path)
+}
+
+// cgroupProcs reads cgroup.procs in every directory below each of dirs and
+// returns the sorted, de-duplicated PIDs. Directories removed during the
+// walk are skipped.
+func cgroupProcs(dirs []string) ([]int, error) {
+	seen := make(map[int]bool)
+	var pids []int
+	for _, dir := range dirs {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			if !d.IsDir() {
+				return nil
+			}
+			data, err := os.ReadFile(filepath.Join(path, "cgroup.procs"))
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return fmt.Errorf("read cgroup.procs: %w", err)
+			}
+			for _, field := range strings.Fields(string(data)) {
+				pid, err := strconv.Atoi(field)
+				if err != nil {
+					return fmt.Errorf("parse %s/cgroup.procs: %w", path, err)
+				}
+				if !seen[pid] {
+					seen[pid] = true
+					pids = append(pids, pid)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Ints(pids)
+	return pids, nil
+}
+
+// signalProcs sends sig to each PID. Processes that already exited are
+// not an error.
+func signalProcs(pids []int, sig syscall.Signal) error {
+	for _, pid := range pids {
+		if err := syscall.Kill(pid, sig); err != nil && err != syscall.ESRCH {
+			return fmt.Errorf("signal %d: %w", pid, err)
+		}
+	}
+	return nil
+}
+
+// removeCgroupTree removes dir and every cgroup below it, children before
+// parents. A missing dir is not an error.
+func removeCgroupTree(dir string) error {
+	var dirs []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// WalkDir visits parents first
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Remove(dirs[i]); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
 }
 
 // GetMemoryCurrent returns current memory usage.
@@ -229,6 +906,110 @@ func (c *Cgroup) GetPidsCurrent() (int64, error) {
 	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
 }
 
+// GetMemoryEvents returns the counters in memory.events.
+func (c *Cgroup) GetMemoryEvents() (*spec.MemoryEvents, error) {
+	f, err := os.Open(filepath.Join(c.path, "memory.events"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseMemoryEvents(f)
+}
+
+// GetMemoryPressure returns the PSI averages in memory.pressure.
+func (c *Cgroup) GetMemoryPressure() (*spec.PSIStats, error) {
+	return readPSI(filepath.Join(c.path, "memory.pressure"))
+}
+
+// parseMemoryEvents parses "key value" lines from memory.events. Unknown
+// keys (e.g. oom_group_kill) are ignored.
+func parseMemoryEvents(r io.Reader) (*spec.MemoryEvents, error) {
+	events := &spec.MemoryEvents{}
+	counters := map[string]*uint64{
+		"low":      &events.Low,
+		"high":     &events.High,
+		"max":      &events.Max,
+		"oom":      &events.OOM,
+		"oom_kill": &events.OOMKill,
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		counter, ok := counters[fields[0]]
+		if !ok {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse memory event %s: %w", fields[0], err)
+		}
+		*counter = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// readPSI reads a PSI file such as memory.pressure.
+func readPSI(path string) (*spec.PSIStats, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parsePSI(f)
+}
+
+// parsePSI parses PSI lines of the form
+// "some avg10=0.00 avg60=0.00 avg300=0.00 total=0".
+func parsePSI(r io.Reader) (*spec.PSIStats, error) {
+	stats := &spec.PSIStats{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		var data *spec.PSIData
+		switch fields[0] {
+		case "some":
+			data = &stats.Some
+		case "full":
+			data = &stats.Full
+		default:
+			continue
+		}
+		for _, field := range fields[1:] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid PSI field %q", field)
+			}
+			var err error
+			switch key {
+			case "avg10":
+				data.Avg10, err = strconv.ParseFloat(value, 64)
+			case "avg60":
+				data.Avg60, err = strconv.ParseFloat(value, 64)
+			case "avg300":
+				data.Avg300, err = strconv.ParseFloat(value, 64)
+			case "total":
+				data.Total, err = strconv.ParseUint(value, 10, 64)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("parse PSI %s: %w", key, err)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
 // Freeze freezes all processes in the cgroup.
 func (c *Cgroup) Freeze() error {
 	path := filepath.Join(c.path, "cgroup.freeze")
@@ -241,18 +1022,29 @@ func (c *Cgroup) Thaw() error {
 	return os.WriteFile(path, []byte("0"), 0644)
 }
 
+// delegatedControllers are enabled for container cgroups by
+// EnsureParentControllers.
+var delegatedControllers = []string{"cpu", "cpuset", "memory", "pids", "io", "hugetlb", "rdma", "misc"}
+
 // EnsureParentControllers enables controllers on parent cgroups.
 func EnsureParentControllers(cgroupPath string) error {
+	// Controllers are always available in v1 hierarchies
+	if h, err := DetectCgroupHierarchy(); err == nil && h.Mode != CgroupModeUnified {
+		return nil
+	}
+
 	// Walk up from cgroupPath and enable controllers at each level
 	parts := strings.Split(strings.Trim(cgroupPath, "/"), "/")
 	current := cgroupRoot
 
-	controllers := "+cpu +memory +pids +cpuset"
-
 	for _, part := range parts[:len(parts)] {
 		controlFile := filepath.Join(current, "cgroup.subtree_control")
-		if err := os.WriteFile(controlFile, []byte(controllers), 0644); err != nil {
-			// Best effort - some controllers might not be available
+		// One write per controller: a single unavailable controller
+		// fails the whole write
+		for _, controller := range delegatedControllers {
+			if err := os.WriteFile(controlFile, []byte("+"+controller), 0644); err != nil {
+				// Best effort - some controllers might not be available
+			}
 		}
 		current = filepath.Join(current, part)
 	}
@@ -0,0 +1,142 @@
+// Package linux provides cgroup delegation to managers inside the container.
+package linux
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// CgroupDelegateAnnotation opts a container into cgroup delegation when
+// set to "true".
+const CgroupDelegateAnnotation = "org.opencontainers.runc-go.cgroup.delegate"
+
+// cgroupInitLeaf is the child cgroup holding the init of a delegated v2
+// cgroup. cgroup v2 only lets a cgroup enable controllers for its children
+// while it has no processes of its own.
+const cgroupInitLeaf = "init"
+
+// delegatedFiles are the files a cgroup's owner needs to create and
+// populate children. v1 hierarchies have tasks instead of cgroup.threads.
+var delegatedFiles = []string{"cgroup.procs", "cgroup.subtree_control", "cgroup.threads", "tasks"}
+
+// CgroupDelegated reports whether the annotations request delegation.
+func CgroupDelegated(annotations map[string]string) bool {
+	return annotations[CgroupDelegateAnnotation] == "true"
+}
+
+// chownCgroup gives a cgroup directory and its delegation files to
+// uid/gid. Files the hierarchy does not have are skipped.
+func chownCgroup(dir string, uid, gid int) error {
+	if err := os.Chown(dir, uid, gid); err != nil {
+		return fmt.Errorf("chown %s: %w", dir, err)
+	}
+	for _, file := range delegatedFiles {
+		if err := os.Chown(filepath.Join(dir, file), uid, gid); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("chown %s: %w", file, err)
+		}
+	}
+	return nil
+}
+
+// Delegate gives the cgroup to uid/gid and creates the init leaf.
+func (c *Cgroup) Delegate(uid, gid int) error {
+	if err := chownCgroup(c.path, uid, gid); err != nil {
+		return err
+	}
+	leaf := filepath.Join(c.path, cgroupInitLeaf)
+	if err := os.Mkdir(leaf, 0755); err != nil && !os.IsExist(err) {
+		return fmt.Errorf("create init leaf: %w", err)
+	}
+	return chownCgroup(leaf, uid, gid)
+}
+
+// Delegate gives the cgroup to uid/gid in every hierarchy. v1 has no rule
+// against processes in inner cgroups, so the init stays where it is.
+func (c *CgroupV1) Delegate(uid, gid int) error {
+	for _, dir := range c.dirs() {
+		if err := chownCgroup(dir, uid, gid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delegate gives the scope's cgroup to uid/gid. The scope itself is
+// always started with Delegate=yes.
+func (s *SystemdCgroup) Delegate(uid, gid int) error {
+	return s.fs.Delegate(uid, gid)
+}
+
+// InitCgroupLeaf returns the host path of the init leaf of the delegated
+// v2 cgroup the calling process is in, or "" if there is none. It must be
+// called before unsharing the cgroup namespace, which hides the host path.
+func InitCgroupLeaf() (string, error) {
+	h, err := DetectCgroupHierarchy()
+	if err != nil || h.Unified == "" {
+		return "", err
+	}
+	f, err := os.Open("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	own, err := parseProcCgroup(f)
+	f.Close()
+	if err != nil {
+		return "", err
+	}
+	path, ok := own[""]
+	if !ok {
+		return "", nil
+	}
+
+	leaf := filepath.Join(h.Unified, path, cgroupInitLeaf)
+	if _, err := os.Stat(leaf); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", nil
+		}
+		return "", err
+	}
+	return leaf, nil
+}
+
+// EnterCgroup moves the calling process into the cgroup directory dir.
+func EnterCgroup(dir string) error {
+	procs := filepath.Join(dir, "cgroup.procs")
+	if err := os.WriteFile(procs, []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
+		return fmt.Errorf("enter cgroup %s: %w", dir, err)
+	}
+	return nil
+}
+
+// JoinCgroupOf moves pid into the v2 cgroup that target is in.
+func JoinCgroupOf(pid, target int) error {
+	h, err := DetectCgroupHierarchy()
+	if err != nil {
+		return err
+	}
+	if h.Unified == "" {
+		return fmt.Errorf("join cgroup of %d: no cgroup v2 hierarchy", target)
+	}
+	f, err := os.Open(fmt.Sprintf("/proc/%d/cgroup", target))
+	if err != nil {
+		return err
+	}
+	paths, err := parseProcCgroup(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	path, ok := paths[""]
+	if !ok {
+		return fmt.Errorf("join cgroup of %d: not in a cgroup v2 hierarchy", target)
+	}
+	procs := filepath.Join(h.Unified, path, "cgroup.procs")
+	if err := os.WriteFile(procs, []byte(strconv.Itoa(pid)), 0644); err != nil {
+		return fmt.Errorf("join cgroup of %d: %w", target, err)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package linux
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// owner returns the uid and gid owning path.
+func owner(t *testing.T, path string) (int, int) {
+	t.Helper()
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat %s: %v", path, err)
+	}
+	st := fi.Sys().(*syscall.Stat_t)
+	return int(st.Uid), int(st.Gid)
+}
+
+func TestCgroup_Delegate(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("skipping delegation test: requires root")
+	}
+	cg := fakeV2Cgroup(t, "cpu memory pids")
+	for _, file := range []string{"cgroup.procs", "cgroup.subtree_control", "memory.max"} {
+		if err := os.WriteFile(filepath.Join(cg.Path(), file), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := cg.Delegate(100000, 100001); err != nil {
+		t.Fatalf("Delegate failed: %v", err)
+	}
+	for _, path := range []string{"", "cgroup.procs", "cgroup.subtree_control", cgroupInitLeaf} {
+		if uid, gid := owner(t, filepath.Join(cg.Path(), path)); uid != 100000 || gid != 100001 {
+			t.Errorf("%q owned by %d:%d, want 100000:100001", path, uid, gid)
+		}
+	}
+	// Limits stay with the runtime
+	if uid, _ := owner(t, filepath.Join(cg.Path(), "memory.max")); uid != 0 {
+		t.Errorf("memory.max owned by %d, want 0", uid)
+	}
+
+	// Delegating again is harmless
+	if err := cg.Delegate(100000, 100001); err != nil {
+		t.Errorf("second Delegate failed: %v", err)
+	}
+}
+
+func TestCgroupV1_Delegate(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("skipping delegation test: requires root")
+	}
+	h := fakeV1Hierarchy(t, "memory", "pids")
+	cg, err := newCgroupV1(h, "runc-go/c1")
+	if err != nil {
+		t.Fatalf("newCgroupV1 failed: %v", err)
+	}
+	if err := cg.Delegate(1000, 1000); err != nil {
+		t.Fatalf("Delegate failed: %v", err)
+	}
+	for _, dir := range cg.dirs() {
+		if uid, _ := owner(t, dir); uid != 1000 {
+			t.Errorf("%s owned by %d, want 1000", dir, uid)
+		}
+		// v1 has no internal-process rule, so no leaf
+		if _, err := os.Stat(filepath.Join(dir, cgroupInitLeaf)); !os.IsNotExist(err) {
+			t.Errorf("unexpected init leaf in %s", dir)
+		}
+	}
+}
+
+func TestCgroupDelegated(t *testing.T) {
+	if CgroupDelegated(nil) {
+		t.Error("nil annotations should not delegate")
+	}
+	if CgroupDelegated(map[string]string{CgroupDelegateAnnotation: "yes"}) {
+		t.Error("only \"true\" should delegate")
+	}
+	if !CgroupDelegated(map[string]string{CgroupDelegateAnnotation: "true"}) {
+		t.Error("\"true\" should delegate")
+	}
+}
@@ -0,0 +1,178 @@
+// Package linux provides the container's view of the cgroup filesystem.
+package linux
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"runc-go/spec"
+)
+
+// cgroupMountOptions controls how cgroup mounts from the spec are made.
+type cgroupMountOptions struct {
+	// namespaced is set when the init has a cgroup namespace. A fresh
+	// cgroup mount is then rooted at the container's cgroup; without one
+	// the container's directories are bind-mounted from the host.
+	namespaced bool
+
+	// writable is set when the container has its own user namespace or a
+	// delegated cgroup. Otherwise the mount is forced read-only, since
+	// container root could rewrite its own limits. A writable mount can
+	// still ask for "ro".
+	writable bool
+}
+
+// newCgroupMountOptions derives the cgroup mount behaviour from the
+// container's namespaces and annotations.
+func newCgroupMountOptions(s *spec.Spec) cgroupMountOptions {
+	var namespaces []spec.LinuxNamespace
+	if s.Linux != nil {
+		namespaces = s.Linux.Namespaces
+	}
+	return cgroupMountOptions{
+		namespaced: HasNamespace(namespaces, spec.CgroupNamespace),
+		writable:   HasNamespace(namespaces, spec.UserNamespace) || CgroupDelegated(s.Annotations),
+	}
+}
+
+// mountCgroup mounts the container's cgroup subtree at dest. On a unified
+// host this is a single cgroup2 mount; on legacy and hybrid hosts it is a
+// tmpfs with one directory per v1 hierarchy (plus "unified" in hybrid mode)
+// and symlinks for co-mounted controllers, as on the host.
+func mountCgroup(dest string, flags uintptr, opts cgroupMountOptions) error {
+	h, err := DetectCgroupHierarchy()
+	if err != nil {
+		return err
+	}
+
+	// Without a namespace the bind sources are found from the init's own
+	// cgroup, which the parent has already moved it into
+	var own map[string]string
+	if !opts.namespaced {
+		f, err := os.Open("/proc/self/cgroup")
+		if err != nil {
+			return err
+		}
+		own, err = parseProcCgroup(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if !opts.writable {
+		flags |= MS_RDONLY
+	}
+
+	if h.Mode == CgroupModeUnified {
+		return mountCgroupDir(h.Unified, own, "", dest, "cgroup2", flags, opts)
+	}
+
+	if err := syscall.Mount("tmpfs", dest, "tmpfs", flags&^MS_RDONLY, "mode=755"); err != nil {
+		return fmt.Errorf("mount tmpfs: %w", err)
+	}
+
+	// Group co-mounted controllers (e.g. cpu,cpuacct) by mount point
+	hierarchies := make(map[string][]string)
+	for controller, mountPoint := range h.Controllers {
+		hierarchies[mountPoint] = append(hierarchies[mountPoint], controller)
+	}
+	mountPoints := make([]string, 0, len(hierarchies))
+	for mountPoint := range hierarchies {
+		mountPoints = append(mountPoints, mountPoint)
+	}
+	sort.Strings(mountPoints)
+
+	for _, mountPoint := range mountPoints {
+		controllers := hierarchies[mountPoint]
+		sort.Strings(controllers)
+		name := filepath.Base(mountPoint)
+		sub := filepath.Join(dest, name)
+		if err := os.Mkdir(sub, 0755); err != nil {
+			return fmt.Errorf("mkdir %s: %w", sub, err)
+		}
+		if err := mountCgroupDir(mountPoint, own, strings.Join(controllers, ","), sub, "cgroup", flags, opts); err != nil {
+			return err
+		}
+		for _, controller := range controllers {
+			if controller != name {
+				if err := os.Symlink(name, filepath.Join(dest, controller)); err != nil && !os.IsExist(err) {
+					return fmt.Errorf("symlink %s: %w", controller, err)
+				}
+			}
+		}
+	}
+
+	if h.Unified != "" {
+		sub := filepath.Join(dest, filepath.Base(h.Unified))
+		if err := os.Mkdir(sub, 0755); err != nil {
+			return fmt.Errorf("mkdir %s: %w", sub, err)
+		}
+		if err := mountCgroupDir(h.Unified, own, "", sub, "cgroup2", flags, opts); err != nil {
+			return err
+		}
+	}
+
+	if flags&MS_RDONLY != 0 {
+		if err := syscall.Mount("", dest, "", flags|MS_REMOUNT, "mode=755"); err != nil {
+			return fmt.Errorf("remount tmpfs read-only: %w", err)
+		}
+	}
+	return nil
+}
+
+// mountCgroupDir mounts one hierarchy at dest. controllers names the v1
+// hierarchy and is empty for cgroup2.
+func mountCgroupDir(hostMount string, own map[string]string, controllers, dest, fsType string, flags uintptr, opts cgroupMountOptions) error {
+	if opts.namespaced {
+		if err := syscall.Mount(fsType, dest, fsType, flags, controllers); err != nil {
+			return fmt.Errorf("mount %s at %s: %w", fsType, dest, err)
+		}
+		return nil
+	}
+
+	key, _, _ := strings.Cut(controllers, ",")
+	path, ok := own[key]
+	if !ok {
+		return fmt.Errorf("process is not in a %s cgroup", fsType)
+	}
+	source := filepath.Join(hostMount, path)
+	if err := syscall.Mount(source, dest, "", MS_BIND, ""); err != nil {
+		return fmt.Errorf("bind mount %s: %w", source, err)
+	}
+	if err := syscall.Mount("", dest, "", flags|MS_BIND|MS_REMOUNT, ""); err != nil {
+		return fmt.Errorf("remount %s: %w", dest, err)
+	}
+	return nil
+}
+
+// parseProcCgroup parses /proc/<pid>/cgroup into a map from controller to
+// cgroup path. The cgroup2 entry has the key "".
+func parseProcCgroup(r io.Reader) (map[string]string, error) {
+	paths := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// Format: hierarchy-ID:controller-list:path
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[1] == "" {
+			paths[""] = parts[2]
+			continue
+		}
+		for _, controller := range strings.Split(parts[1], ",") {
+			paths[controller] = parts[2]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read cgroup membership: %w", err)
+	}
+	return paths, nil
+}
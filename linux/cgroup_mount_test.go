@@ -0,0 +1,53 @@
+package linux
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"runc-go/spec"
+)
+
+func TestParseProcCgroup(t *testing.T) {
+	data := `9:name=systemd:/user.slice
+4:cpu,cpuacct:/runc-go/c1
+3:memory:/runc-go/c1
+0::/runc-go/c1
+`
+	got, err := parseProcCgroup(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("parseProcCgroup failed: %v", err)
+	}
+	want := map[string]string{
+		"name=systemd": "/user.slice",
+		"cpu":          "/runc-go/c1",
+		"cpuacct":      "/runc-go/c1",
+		"memory":       "/runc-go/c1",
+		"":             "/runc-go/c1",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseProcCgroup = %v, want %v", got, want)
+	}
+}
+
+func TestNewCgroupMountOptions(t *testing.T) {
+	userns := []spec.LinuxNamespace{{Type: spec.UserNamespace}, {Type: spec.CgroupNamespace}}
+	hostns := []spec.LinuxNamespace{{Type: spec.PIDNamespace}}
+	delegated := map[string]string{CgroupDelegateAnnotation: "true"}
+
+	tests := []struct {
+		name string
+		spec *spec.Spec
+		want cgroupMountOptions
+	}{
+		{"no linux section", &spec.Spec{}, cgroupMountOptions{}},
+		{"host user namespace is read-only", &spec.Spec{Linux: &spec.Linux{Namespaces: hostns}}, cgroupMountOptions{}},
+		{"own user namespace is writable", &spec.Spec{Linux: &spec.Linux{Namespaces: userns}}, cgroupMountOptions{namespaced: true, writable: true}},
+		{"delegated cgroup is writable", &spec.Spec{Annotations: delegated, Linux: &spec.Linux{Namespaces: hostns}}, cgroupMountOptions{writable: true}},
+	}
+	for _, tt := range tests {
+		if got := newCgroupMountOptions(tt.spec); got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,648 @@
+// Package linux provides the systemd cgroup driver.
+package linux
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"runc-go/spec"
+)
+
+// systemd D-Bus names
+const (
+	systemdBusName      = "org.freedesktop.systemd1"
+	systemdObjectPath   = "/org/freedesktop/systemd1"
+	systemdManagerIface = "org.freedesktop.systemd1.Manager"
+
+	// systemdJobTimeout bounds how long we wait for a start/stop job.
+	systemdJobTimeout = 30 * time.Second
+
+	// systemdInfinity is how systemd spells "no limit" in uint64 properties.
+	systemdInfinity = math.MaxUint64
+)
+
+// systemdSocket is a socket to a systemd manager; bus is set for a D-Bus
+// daemon socket rather than systemd's private one.
+type systemdSocket struct {
+	path string
+	bus  bool
+}
+
+// systemdSockets are tried in order. The private socket talks to systemd
+// directly and is available to root even when no bus daemon runs.
+var systemdSockets = []systemdSocket{
+	{"/run/systemd/private", false},
+	{"/run/dbus/system_bus_socket", true},
+}
+
+// validUnitName matches the characters systemd allows in unit names.
+var validUnitName = regexp.MustCompile(`^[a-zA-Z0-9:_.\\-]+$`)
+
+// systemdProperty is a unit property as passed to systemd.
+type systemdProperty struct {
+	Name  string
+	Value dbusVariant
+}
+
+// systemdManager is the subset of the systemd manager API used by the driver.
+type systemdManager interface {
+	// StartTransientUnit creates and starts a unit and waits for the job.
+	StartTransientUnit(name string, props []systemdProperty) error
+
+	// StopUnit stops a unit and waits for the job.
+	StopUnit(name string) error
+
+	// SetUnitProperties changes properties of a running unit.
+	SetUnitProperties(name string, props []systemdProperty) error
+
+	// Close releases the connection.
+	Close() error
+}
+
+// dbusSystemd implements systemdManager over D-Bus.
+type dbusSystemd struct {
+	conn *dbusConn
+}
+
+// connectSystemd connects to the systemd manager.
+func connectSystemd() (systemdManager, error) {
+	return connectSystemdSockets(systemdSockets)
+}
+
+// connectUserSystemd connects to the calling user's systemd manager
+// through the sockets in $XDG_RUNTIME_DIR.
+func connectUserSystemd() (systemdManager, error) {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		return nil, fmt.Errorf("connect to user systemd: XDG_RUNTIME_DIR is not set")
+	}
+	return connectSystemdSockets([]systemdSocket{
+		{filepath.Join(dir, "systemd/private"), false},
+		{filepath.Join(dir, "bus"), true},
+	})
+}
+
+// connectSystemdSockets connects through the first socket that works.
+func connectSystemdSockets(sockets []systemdSocket) (systemdManager, error) {
+	var lastErr error
+	for _, sock := range sockets {
+		conn, err := dialDBus(sock.path)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		m, err := newDBusSystemd(conn, sock.bus)
+		if err != nil {
+			conn.Close()
+			lastErr = err
+			continue
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("connect to systemd: %w", lastErr)
+}
+
+// newDBusSystemd sets up an authenticated connection for job tracking.
+func newDBusSystemd(conn *dbusConn, bus bool) (*dbusSystemd, error) {
+	if bus {
+		// A bus connection must register and ask for the signals it wants
+		if _, err := conn.call("org.freedesktop.DBus", "/org/freedesktop/DBus",
+			"org.freedesktop.DBus", "Hello", ""); err != nil {
+			return nil, err
+		}
+		rule := "type='signal',sender='" + systemdBusName + "',interface='" +
+			systemdManagerIface + "',member='JobRemoved'"
+		if _, err := conn.call("org.freedesktop.DBus", "/org/freedesktop/DBus",
+			"org.freedesktop.DBus", "AddMatch", "s", rule); err != nil {
+			return nil, err
+		}
+	}
+
+	// systemd only emits JobRemoved to subscribed clients
+	if _, err := conn.call(systemdBusName, systemdObjectPath, systemdManagerIface, "Subscribe", ""); err != nil {
+		return nil, err
+	}
+	return &dbusSystemd{conn: conn}, nil
+}
+
+// encodeProperties converts properties to the a(sv) wire form.
+func encodeProperties(props []systemdProperty) []interface{} {
+	out := make([]interface{}, len(props))
+	for i, p := range props {
+		out[i] = []interface{}{p.Name, p.Value}
+	}
+	return out
+}
+
+// StartTransientUnit creates and starts a transient unit.
+func (m *dbusSystemd) StartTransientUnit(name string, props []systemdProperty) error {
+	reply, err := m.conn.call(systemdBusName, systemdObjectPath, systemdManagerIface,
+		"StartTransientUnit", "ssa(sv)a(sa(sv))",
+		name, "replace", encodeProperties(props), []interface{}{})
+	if err != nil {
+		return err
+	}
+	return m.waitJob(name, reply)
+}
+
+// StopUnit stops a unit.
+func (m *dbusSystemd) StopUnit(name string) error {
+	reply, err := m.conn.call(systemdBusName, systemdObjectPath, systemdManagerIface,
+		"StopUnit", "ss", name, "replace")
+	if err != nil {
+		return err
+	}
+	return m.waitJob(name, reply)
+}
+
+// SetUnitProperties changes properties of a running unit at runtime only.
+func (m *dbusSystemd) SetUnitProperties(name string, props []systemdProperty) error {
+	_, err := m.conn.call(systemdBusName, systemdObjectPath, systemdManagerIface,
+		"SetUnitProperties", "sba(sv)", name, true, encodeProperties(props))
+	return err
+}
+
+// Close closes the connection.
+func (m *dbusSystemd) Close() error {
+	return m.conn.Close()
+}
+
+// waitJob waits for the JobRemoved signal of the job in a method reply.
+func (m *dbusSystemd) waitJob(unit string, reply []interface{}) error {
+	if len(reply) != 1 {
+		return fmt.Errorf("unexpected reply for %s", unit)
+	}
+	job, _ := reply[0].(string)
+
+	// JobRemoved(u id, o job, s unit, s result)
+	signal, err := m.conn.waitSignal(func(msg *dbusMessage) bool {
+		if msg.Member != "JobRemoved" || len(msg.Body) != 4 {
+			return false
+		}
+		path, _ := msg.Body[1].(string)
+		return path == job
+	}, systemdJobTimeout)
+	if err != nil {
+		return fmt.Errorf("wait for job %s: %w", job, err)
+	}
+
+	if result, _ := signal.Body[3].(string); result != "done" {
+		return fmt.Errorf("systemd job for %s failed: %s", unit, result)
+	}
+	return nil
+}
+
+// ============================================================================
+// Cgroup driver
+// ============================================================================
+
+// SystemdCgroup is a container cgroup owned by systemd as a transient scope
+// unit. The scope is delegated to us, so resource files are also written
+// directly for anything systemd has no property for.
+type SystemdCgroup struct {
+	// unit is the scope unit name (e.g. "runc-go-abc.scope").
+	unit string
+
+	// slice is the parent slice unit.
+	slice string
+
+	// unified reports whether the host uses cgroup v2 property names.
+	unified bool
+
+	// fs accesses the scope's cgroup directory directly.
+	fs CgroupManager
+
+	// connect opens a connection to the systemd manager.
+	connect func() (systemdManager, error)
+
+	// resources are applied when the scope is started.
+	resources *spec.LinuxResources
+
+	// started reports whether the scope is known to be running: this
+	// instance started it, or found its cgroup on reopening.
+	started bool
+}
+
+// DefaultSystemdCgroupPath returns the default systemd cgroupsPath for a container.
+func DefaultSystemdCgroupPath(containerID string) string {
+	return "system.slice:runc-go:" + containerID
+}
+
+// DefaultUserSystemdCgroupPath returns the default cgroupsPath for a
+// rootless container in the user's systemd manager.
+func DefaultUserSystemdCgroupPath(containerID string) string {
+	return "user.slice:runc-go:" + containerID
+}
+
+// ParseSystemdCgroupPath splits a "slice:prefix:name" cgroupsPath. An empty
+// slice means system.slice.
+func ParseSystemdCgroupPath(cgroupsPath string) (slice, unit string, err error) {
+	parts := strings.Split(cgroupsPath, ":")
+	if len(parts) != 3 {
+		return "", "", fmt.Errorf("expected cgroupsPath of the form slice:prefix:name, got %q", cgroupsPath)
+	}
+	slice, prefix, name := parts[0], parts[1], parts[2]
+
+	if slice == "" {
+		slice = "system.slice"
+	}
+	if !strings.HasSuffix(slice, ".slice") || !validUnitName.MatchString(slice) {
+		return "", "", fmt.Errorf("invalid slice name %q", slice)
+	}
+
+	if name == "" {
+		return "", "", fmt.Errorf("missing unit name in %q", cgroupsPath)
+	}
+	if strings.HasSuffix(name, ".slice") {
+		return "", "", fmt.Errorf("slice units are not supported as container cgroups: %q", name)
+	}
+	unit = name
+	if prefix != "" {
+		unit = prefix + "-" + name
+	}
+	unit += ".scope"
+	if !validUnitName.MatchString(unit) {
+		return "", "", fmt.Errorf("invalid unit name %q", unit)
+	}
+	return slice, unit, nil
+}
+
+// ExpandSlice returns the cgroup path of a slice, where each dash in the
+// name denotes a parent: "a-b.slice" is "/a.slice/a-b.slice".
+func ExpandSlice(slice string) (string, error) {
+	if !strings.HasSuffix(slice, ".slice") || strings.Contains(slice, "/") {
+		return "", fmt.Errorf("invalid slice name %q", slice)
+	}
+	if slice == "-.slice" {
+		return "/", nil
+	}
+
+	name := strings.TrimSuffix(slice, ".slice")
+	if name == "" || strings.HasPrefix(name, "-") || strings.HasSuffix(name, "-") || strings.Contains(name, "--") {
+		return "", fmt.Errorf("invalid slice name %q", slice)
+	}
+
+	var path, prefix string
+	for _, component := range strings.Split(name, "-") {
+		path += "/" + prefix + component + ".slice"
+		prefix += component + "-"
+	}
+	return path, nil
+}
+
+// NewSystemdCgroup prepares a systemd-managed cgroup for a "slice:prefix:name"
+// cgroupsPath. The scope itself is created by the first AddProcess. Rootless
+// callers use their own systemd manager, whose slices live below
+// user@UID.service.
+func NewSystemdCgroup(cgroupsPath string) (*SystemdCgroup, error) {
+	h, err := DetectCgroupHierarchy()
+	if err != nil {
+		return nil, err
+	}
+	if IsRootless() {
+		root, err := UserManagerCgroup()
+		if err != nil {
+			return nil, err
+		}
+		return newSystemdCgroupAt(h, root, cgroupsPath, connectUserSystemd)
+	}
+	return newSystemdCgroup(h, cgroupsPath, connectSystemd)
+}
+
+// newSystemdCgroup builds the driver for an explicit hierarchy and connector.
+func newSystemdCgroup(h *CgroupHierarchy, cgroupsPath string, connect func() (systemdManager, error)) (*SystemdCgroup, error) {
+	return newSystemdCgroupAt(h, "/", cgroupsPath, connect)
+}
+
+// newSystemdCgroupAt builds the driver for a manager whose root slice is
+// the cgroup root below the hierarchy's mount point.
+func newSystemdCgroupAt(h *CgroupHierarchy, root, cgroupsPath string, connect func() (systemdManager, error)) (*SystemdCgroup, error) {
+	slice, unit, err := ParseSystemdCgroupPath(cgroupsPath)
+	if err != nil {
+		return nil, err
+	}
+	slicePath, err := ExpandSlice(slice)
+	if err != nil {
+		return nil, err
+	}
+	rel := filepath.Join(root, slicePath, unit)
+
+	s := &SystemdCgroup{
+		unit:    unit,
+		slice:   slice,
+		unified: h.Mode == CgroupModeUnified,
+		connect: connect,
+	}
+
+	// systemd creates the directories; only compute where they will be
+	if s.unified {
+		s.fs = &Cgroup{path: filepath.Join(h.Unified, rel)}
+	} else {
+		v1 := &CgroupV1{paths: make(map[string]string)}
+		for controller, mountPoint := range h.Controllers {
+			v1.paths[controller] = filepath.Join(mountPoint, rel)
+		}
+		if h.Mode == CgroupModeHybrid && h.Unified != "" {
+			v1.paths[""] = filepath.Join(h.Unified, rel)
+		}
+		s.fs = v1
+	}
+	return s, nil
+}
+
+// Unit returns the scope unit name.
+func (s *SystemdCgroup) Unit() string {
+	return s.unit
+}
+
+// Path returns the filesystem path of the scope's cgroup.
+func (s *SystemdCgroup) Path() string {
+	return s.fs.Path()
+}
+
+// running reports whether the scope is up. A driver reopened for update,
+// kill or delete did not start the scope itself, so the scope's cgroup,
+// which systemd creates with the unit and removes with it, is taken as
+// the sign.
+func (s *SystemdCgroup) running() bool {
+	if !s.started {
+		if _, err := os.Stat(s.fs.Path()); err == nil {
+			s.started = true
+		}
+	}
+	return s.started
+}
+
+// AddProcess starts the scope with pid as its first member, or moves pid
+// into an already started scope.
+func (s *SystemdCgroup) AddProcess(pid int) error {
+	if s.running() {
+		return s.fs.AddProcess(pid)
+	}
+
+	props := []systemdProperty{
+		{"Description", dbusVariant{"s", "runc-go container " + s.unit}},
+		{"Slice", dbusVariant{"s", s.slice}},
+		{"Delegate", dbusVariant{"b", true}},
+		{"PIDs", dbusVariant{"au", []uint32{uint32(pid)}}},
+		// Keep the scope out of shutdown ordering; the runtime stops it
+		{"DefaultDependencies", dbusVariant{"b", false}},
+		{"MemoryAccounting", dbusVariant{"b", true}},
+		{"CPUAccounting", dbusVariant{"b", true}},
+		{"TasksAccounting", dbusVariant{"b", true}},
+	}
+	resourceProps, err := systemdResourceProperties(s.resources, s.unified)
+	if err != nil {
+		return err
+	}
+	props = append(props, resourceProps...)
+
+	mgr, err := s.connect()
+	if err != nil {
+		return err
+	}
+	defer mgr.Close()
+
+	if err := mgr.StartTransientUnit(s.unit, props); err != nil {
+		return fmt.Errorf("start unit %s: %w", s.unit, err)
+	}
+	s.started = true
+
+	// Write what systemd has no property for (e.g. unified keys, swappiness)
+	return s.fs.ApplyResources(s.resources)
+}
+
+// ApplyResources records resources for the scope. Once the scope is running
+// they are pushed to systemd and written to the delegated cgroup.
+func (s *SystemdCgroup) ApplyResources(resources *spec.LinuxResources) error {
+	props, err := systemdResourceProperties(resources, s.unified)
+	if err != nil {
+		return err
+	}
+	s.resources = resources
+	if !s.running() {
+		return nil
+	}
+
+	if len(props) > 0 {
+		mgr, err := s.connect()
+		if err != nil {
+			return err
+		}
+		defer mgr.Close()
+		if err := mgr.SetUnitProperties(s.unit, props); err != nil {
+			return fmt.Errorf("set properties on %s: %w", s.unit, err)
+		}
+	}
+	return s.fs.ApplyResources(resources)
+}
+
+// Freeze freezes all processes in the cgroup.
+func (s *SystemdCgroup) Freeze() error {
+	return s.fs.Freeze()
+}
+
+// Thaw unfreezes all processes in the cgroup.
+func (s *SystemdCgroup) Thaw() error {
+	return s.fs.Thaw()
+}
+
+// Kill signals every process in the scope's cgroup.
+func (s *SystemdCgroup) Kill(sig syscall.Signal) error {
+	return s.fs.Kill(sig)
+}
+
+// Procs returns the PIDs in the scope's cgroup.
+func (s *SystemdCgroup) Procs() ([]int, error) {
+	return s.fs.Procs()
+}
+
+// Destroy stops the scope unit. A unit that no longer exists (systemd
+// garbage-collects empty scopes) is not an error.
+func (s *SystemdCgroup) Destroy() error {
+	mgr, err := s.connect()
+	if err != nil {
+		return err
+	}
+	defer mgr.Close()
+
+	if err := mgr.StopUnit(s.unit); err != nil {
+		var dbusErr *dbusError
+		if !errors.As(err, &dbusErr) || dbusErr.Name != "org.freedesktop.systemd1.NoSuchUnit" {
+			return fmt.Errorf("stop unit %s: %w", s.unit, err)
+		}
+	}
+	s.started = false
+
+	if err := s.fs.Destroy(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// GetMemoryEvents returns the scope's memory event counters.
+func (s *SystemdCgroup) GetMemoryEvents() (*spec.MemoryEvents, error) {
+	return s.fs.GetMemoryEvents()
+}
+
+// GetMemoryPressure returns the scope's PSI memory pressure.
+func (s *SystemdCgroup) GetMemoryPressure() (*spec.PSIStats, error) {
+	return s.fs.GetMemoryPressure()
+}
+
+// GetMemoryCurrent returns current memory usage.
+func (s *SystemdCgroup) GetMemoryCurrent() (int64, error) {
+	return s.fs.GetMemoryCurrent()
+}
+
+// GetPidsCurrent returns current number of processes.
+func (s *SystemdCgroup) GetPidsCurrent() (int64, error) {
+	return s.fs.GetPidsCurrent()
+}
+
+// systemdResourceProperties maps OCI resources to systemd unit properties.
+// Resources without a systemd equivalent are left to the cgroup files.
+func systemdResourceProperties(r *spec.LinuxResources, unified bool) ([]systemdProperty, error) {
+	if r == nil {
+		return nil, nil
+	}
+	var props []systemdProperty
+	add := func(name, sig string, value interface{}) {
+		props = append(props, systemdProperty{name, dbusVariant{sig, value}})
+	}
+	limit := func(v int64) uint64 {
+		if v < 0 {
+			return systemdInfinity
+		}
+		return uint64(v)
+	}
+
+	if mem := r.Memory; mem != nil {
+		if unified && mem.DisableOOMKiller != nil && *mem.DisableOOMKiller {
+			return nil, fmt.Errorf("memory.disableOOMKiller has no cgroup v2 equivalent")
+		}
+		if mem.Limit != nil && *mem.Limit != 0 {
+			if unified {
+				add("MemoryMax", "t", limit(*mem.Limit))
+			} else {
+				add("MemoryLimit", "t", limit(*mem.Limit))
+			}
+		}
+		if unified && mem.Reservation != nil && *mem.Reservation > 0 {
+			add("MemoryLow", "t", uint64(*mem.Reservation))
+		}
+		if unified && mem.Swap != nil {
+			// OCI swap is memory+swap; systemd wants swap alone
+			swap := uint64(systemdInfinity)
+			if *mem.Swap >= 0 {
+				v := *mem.Swap
+				if mem.Limit != nil && *mem.Limit > 0 {
+					v -= *mem.Limit
+				}
+				if v < 0 {
+					v = 0
+				}
+				swap = uint64(v)
+			}
+			add("MemorySwapMax", "t", swap)
+		}
+	}
+
+	if cpu := r.CPU; cpu != nil {
+		if cpu.Shares != nil && *cpu.Shares > 0 {
+			if unified {
+				add("CPUWeight", "t", cpuSharesToWeight(*cpu.Shares))
+			} else {
+				add("CPUShares", "t", *cpu.Shares)
+			}
+		}
+
+		period := uint64(100000)
+		if cpu.Period != nil && *cpu.Period > 0 {
+			period = *cpu.Period
+			add("CPUQuotaPeriodUSec", "t", period)
+		}
+		if cpu.Quota != nil {
+			quota := uint64(systemdInfinity)
+			if *cpu.Quota > 0 {
+				// systemd takes a per-second quota in 10ms steps; round up
+				// so the container never gets less than it asked for
+				quota = uint64(*cpu.Quota) * 1000000 / period
+				if quota%10000 != 0 {
+					quota = (quota/10000 + 1) * 10000
+				}
+			}
+			add("CPUQuotaPerSecUSec", "t", quota)
+		}
+
+		if unified && cpu.Cpus != "" {
+			mask, err := rangeListToMask(cpu.Cpus)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cpus %q: %w", cpu.Cpus, err)
+			}
+			add("AllowedCPUs", "ay", mask)
+		}
+		if unified && cpu.Mems != "" {
+			mask, err := rangeListToMask(cpu.Mems)
+			if err != nil {
+				return nil, fmt.Errorf("invalid mems %q: %w", cpu.Mems, err)
+			}
+			add("AllowedMemoryNodes", "ay", mask)
+		}
+	}
+
+	if r.BlockIO != nil && r.BlockIO.Weight != nil {
+		if err := validateBlockIO(r.BlockIO); err != nil {
+			return nil, err
+		}
+		if unified {
+			add("IOWeight", "t", blkioWeightToIOWeight(*r.BlockIO.Weight))
+		} else {
+			add("BlockIOWeight", "t", uint64(*r.BlockIO.Weight))
+		}
+	}
+
+	if r.Pids != nil && r.Pids.Limit != 0 {
+		add("TasksMax", "t", limit(r.Pids.Limit))
+	}
+
+	return props, nil
+}
+
+// rangeListToMask converts a list such as "0-3,8" to a little-endian bitmask.
+func rangeListToMask(list string) ([]byte, error) {
+	var mask []byte
+	for _, part := range strings.Split(list, ",") {
+		part = strings.TrimSpace(part)
+		lo, hi, isRange := strings.Cut(part, "-")
+		start, err := strconv.ParseUint(lo, 10, 16)
+		if err != nil {
+			return nil, err
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.ParseUint(hi, 10, 16); err != nil {
+				return nil, err
+			}
+		}
+		if end < start {
+			return nil, fmt.Errorf("invalid range %q", part)
+		}
+		for bit := start; bit <= end; bit++ {
+			for uint64(len(mask)) <= bit/8 {
+				mask = append(mask, 0)
+			}
+			mask[bit/8] |= 1 << (bit % 8)
+		}
+	}
+	return mask, nil
+}
@@ -0,0 +1,557 @@
+package linux
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"runc-go/spec"
+)
+
+func TestDBusMessage_RoundTrip(t *testing.T) {
+	msg := &dbusMessage{
+		Type:        dbusTypeMethodCall,
+		Serial:      7,
+		Path:        systemdObjectPath,
+		Interface:   systemdManagerIface,
+		Member:      "StartTransientUnit",
+		Destination: systemdBusName,
+		Signature:   "ssa(sv)a(sa(sv))ayaut",
+		Body: []interface{}{
+			"c1.scope",
+			"replace",
+			[]interface{}{
+				[]interface{}{"Delegate", dbusVariant{"b", true}},
+				[]interface{}{"MemoryMax", dbusVariant{"t", uint64(1 << 30)}},
+				[]interface{}{"PIDs", dbusVariant{"au", []uint32{42}}},
+			},
+			[]interface{}{},
+			[]byte{0x0f, 0x01},
+			[]uint32{1, 2, 3},
+			uint64(math.MaxUint64),
+		},
+	}
+
+	data, err := msg.marshal()
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	got, err := readDBusMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("readDBusMessage failed: %v", err)
+	}
+	if got.Type != msg.Type || got.Serial != msg.Serial || got.Path != msg.Path ||
+		got.Interface != msg.Interface || got.Member != msg.Member ||
+		got.Destination != msg.Destination || got.Signature != msg.Signature {
+		t.Errorf("header mismatch: %+v", got)
+	}
+
+	want := []interface{}{
+		"c1.scope",
+		"replace",
+		[]interface{}{
+			[]interface{}{"Delegate", dbusVariant{"b", true}},
+			[]interface{}{"MemoryMax", dbusVariant{"t", uint64(1 << 30)}},
+			[]interface{}{"PIDs", dbusVariant{"au", []interface{}{uint32(42)}}},
+		},
+		[]interface{}{},
+		[]byte{0x0f, 0x01},
+		[]interface{}{uint32(1), uint32(2), uint32(3)},
+		uint64(math.MaxUint64),
+	}
+	if !reflect.DeepEqual(got.Body, want) {
+		t.Errorf("Body = %#v\nwant %#v", got.Body, want)
+	}
+}
+
+func TestDBusEncode_Errors(t *testing.T) {
+	tests := []struct {
+		sig   string
+		value interface{}
+	}{
+		{"s", 1},
+		{"u", "x"},
+		{"(su)", []interface{}{"only one"}},
+		{"a(sv)", "not a slice"},
+		{"v", "not a variant"},
+	}
+	for _, tt := range tests {
+		e := &dbusEncoder{}
+		if err := e.encode(tt.sig, tt.value); err == nil {
+			t.Errorf("encode(%q, %#v) should fail", tt.sig, tt.value)
+		}
+	}
+
+	if _, err := dbusSplitSignature("a(su"); err == nil {
+		t.Error("unterminated struct should fail")
+	}
+}
+
+func TestParseSystemdCgroupPath(t *testing.T) {
+	tests := []struct {
+		path  string
+		slice string
+		unit  string
+		err   bool
+	}{
+		{"system.slice:runc-go:abc", "system.slice", "runc-go-abc.scope", false},
+		{":runc-go:abc", "system.slice", "runc-go-abc.scope", false},
+		{"machine.slice::abc", "machine.slice", "abc.scope", false},
+		{"user-1000.slice:docker:x_y.z", "user-1000.slice", "docker-x_y.z.scope", false},
+		{"system.slice:runc-go", "", "", true},
+		{"/sys/fs/cgroup/x", "", "", true},
+		{"system:runc-go:abc", "", "", true},
+		{"system.slice:runc-go:", "", "", true},
+		{"system.slice:runc-go:sub.slice", "", "", true},
+		{"system.slice:runc-go:a/b", "", "", true},
+		{"system.slice:runc go:abc", "", "", true},
+	}
+	for _, tt := range tests {
+		slice, unit, err := ParseSystemdCgroupPath(tt.path)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseSystemdCgroupPath(%q) should fail", tt.path)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseSystemdCgroupPath(%q) failed: %v", tt.path, err)
+			continue
+		}
+		if slice != tt.slice || unit != tt.unit {
+			t.Errorf("ParseSystemdCgroupPath(%q) = %q, %q; want %q, %q", tt.path, slice, unit, tt.slice, tt.unit)
+		}
+	}
+
+	if got := DefaultSystemdCgroupPath("c1"); got != "system.slice:runc-go:c1" {
+		t.Errorf("DefaultSystemdCgroupPath = %q", got)
+	}
+}
+
+func TestExpandSlice(t *testing.T) {
+	tests := []struct {
+		slice string
+		want  string
+		err   bool
+	}{
+		{"-.slice", "/", false},
+		{"system.slice", "/system.slice", false},
+		{"user-1000.slice", "/user.slice/user-1000.slice", false},
+		{"a-b-c.slice", "/a.slice/a-b.slice/a-b-c.slice", false},
+		{"system", "", true},
+		{"a--b.slice", "", true},
+		{"-a.slice", "", true},
+		{"a-.slice", "", true},
+		{"a/b.slice", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ExpandSlice(tt.slice)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ExpandSlice(%q) should fail", tt.slice)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ExpandSlice(%q) = %q, %v; want %q", tt.slice, got, err, tt.want)
+		}
+	}
+}
+
+// propertyMap flattens properties for lookups.
+func propertyMap(props []systemdProperty) map[string]dbusVariant {
+	m := make(map[string]dbusVariant)
+	for _, p := range props {
+		m[p.Name] = p.Value
+	}
+	return m
+}
+
+func TestSystemdResourceProperties(t *testing.T) {
+	limit := int64(512 << 20)
+	swap := int64(1 << 30)
+	reservation := int64(256 << 20)
+	shares := uint64(1024)
+	quota := int64(50000)
+	period := uint64(100000)
+
+	r := &spec.LinuxResources{
+		Memory: &spec.LinuxMemory{Limit: &limit, Swap: &swap, Reservation: &reservation},
+		CPU:    &spec.LinuxCPU{Shares: &shares, Quota: &quota, Period: &period, Cpus: "0-2,8", Mems: "0"},
+		Pids:   &spec.LinuxPids{Limit: -1},
+	}
+
+	props, err := systemdResourceProperties(r, true)
+	if err != nil {
+		t.Fatalf("systemdResourceProperties failed: %v", err)
+	}
+	want := map[string]dbusVariant{
+		"MemoryMax":          {"t", uint64(limit)},
+		"MemoryLow":          {"t", uint64(reservation)},
+		"MemorySwapMax":      {"t", uint64(swap - limit)},
+		"CPUWeight":          {"t", uint64(39)},
+		"CPUQuotaPeriodUSec": {"t", period},
+		"CPUQuotaPerSecUSec": {"t", uint64(500000)},
+		"AllowedCPUs":        {"ay", []byte{0x07, 0x01}},
+		"AllowedMemoryNodes": {"ay", []byte{0x01}},
+		"TasksMax":           {"t", uint64(math.MaxUint64)},
+	}
+	if got := propertyMap(props); !reflect.DeepEqual(got, want) {
+		t.Errorf("unified properties = %v\nwant %v", got, want)
+	}
+
+	props, err = systemdResourceProperties(r, false)
+	if err != nil {
+		t.Fatalf("systemdResourceProperties(v1) failed: %v", err)
+	}
+	got := propertyMap(props)
+	if got["MemoryLimit"] != (dbusVariant{"t", uint64(limit)}) {
+		t.Errorf("MemoryLimit = %v", got["MemoryLimit"])
+	}
+	if got["CPUShares"] != (dbusVariant{"t", shares}) {
+		t.Errorf("CPUShares = %v", got["CPUShares"])
+	}
+	for _, name := range []string{"MemoryMax", "MemorySwapMax", "CPUWeight", "AllowedCPUs"} {
+		if _, ok := got[name]; ok {
+			t.Errorf("v1 properties should not include %s", name)
+		}
+	}
+
+	// Quotas are rounded up to systemd's 10ms granularity
+	odd := int64(12345)
+	props, _ = systemdResourceProperties(&spec.LinuxResources{CPU: &spec.LinuxCPU{Quota: &odd}}, true)
+	if v := propertyMap(props)["CPUQuotaPerSecUSec"]; v != (dbusVariant{"t", uint64(130000)}) {
+		t.Errorf("rounded quota = %v, want 130000", v)
+	}
+
+	// The OOM killer cannot be disabled on v2
+	yes, no := true, false
+	_, err = systemdResourceProperties(&spec.LinuxResources{Memory: &spec.LinuxMemory{Limit: &limit, DisableOOMKiller: &yes}}, true)
+	if err == nil || !strings.Contains(err.Error(), "memory.disableOOMKiller has no cgroup v2 equivalent") {
+		t.Errorf("disableOOMKiller on v2: got %v, want no cgroup v2 equivalent", err)
+	}
+	props, err = systemdResourceProperties(&spec.LinuxResources{Memory: &spec.LinuxMemory{Limit: &limit, DisableOOMKiller: &no}}, true)
+	if err != nil || propertyMap(props)["MemoryMax"] != (dbusVariant{"t", uint64(limit)}) {
+		t.Errorf("disableOOMKiller=false: props %v, error %v, want MemoryMax kept", props, err)
+	}
+	if _, err := systemdResourceProperties(&spec.LinuxResources{Memory: &spec.LinuxMemory{DisableOOMKiller: &yes}}, false); err != nil {
+		t.Errorf("disableOOMKiller on v1: %v", err)
+	}
+
+	bad := &spec.LinuxResources{CPU: &spec.LinuxCPU{Cpus: "3-1"}}
+	if _, err := systemdResourceProperties(bad, true); err == nil {
+		t.Error("invalid cpu list should fail")
+	}
+}
+
+// fakeSystemd is an in-process stand-in for the systemd manager. It speaks
+// the D-Bus wire protocol and creates scope directories in a fake cgroupfs.
+type fakeSystemd struct {
+	root string
+
+	mu       sync.Mutex
+	units    map[string][]systemdProperty
+	calls    []string
+	setProps []systemdProperty
+	result   string
+}
+
+// connect returns a connector wired to a new server goroutine.
+func (f *fakeSystemd) connect() (systemdManager, error) {
+	client, server := net.Pipe()
+	go f.serve(server)
+	conn, err := newDBusConn(client)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return newDBusSystemd(conn, false)
+}
+
+// serve handles one connection.
+func (f *fakeSystemd) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	auth, err := r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(auth, "\x00AUTH EXTERNAL ") {
+		return
+	}
+	fmt.Fprintf(conn, "OK 0123456789abcdef0123456789abcdef\r\n")
+	if begin, err := r.ReadString('\n'); err != nil || begin != "BEGIN\r\n" {
+		return
+	}
+
+	var serial uint32
+	send := func(m *dbusMessage) {
+		serial++
+		m.Serial = serial
+		data, err := m.marshal()
+		if err != nil {
+			panic(err)
+		}
+		conn.Write(data)
+	}
+	reply := func(call *dbusMessage, sig string, body ...interface{}) {
+		send(&dbusMessage{Type: dbusTypeMethodReturn, ReplySerial: call.Serial, Signature: sig, Body: body})
+	}
+	job := func(call *dbusMessage, unit, result string) {
+		path := fmt.Sprintf("/org/freedesktop/systemd1/job/%d", call.Serial)
+		reply(call, "o", path)
+		send(&dbusMessage{
+			Type: dbusTypeSignal, Path: systemdObjectPath, Interface: systemdManagerIface,
+			Member: "JobRemoved", Signature: "uoss",
+			Body: []interface{}{call.Serial, path, unit, result},
+		})
+	}
+
+	for {
+		call, err := readDBusMessage(r)
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		f.calls = append(f.calls, call.Member)
+		f.mu.Unlock()
+
+		switch call.Member {
+		case "Subscribe":
+			reply(call, "")
+		case "StartTransientUnit":
+			unit := call.Body[0].(string)
+			props := decodeFakeProperties(call.Body[2])
+			f.mu.Lock()
+			f.units[unit] = props
+			result := f.result
+			f.mu.Unlock()
+			slice := propertyMap(props)["Slice"].Value.(string)
+			slicePath, _ := ExpandSlice(slice)
+			os.MkdirAll(filepath.Join(f.root, slicePath, unit), 0755)
+			job(call, unit, result)
+		case "StopUnit":
+			unit := call.Body[0].(string)
+			f.mu.Lock()
+			props, ok := f.units[unit]
+			delete(f.units, unit)
+			f.mu.Unlock()
+			if !ok {
+				send(&dbusMessage{
+					Type: dbusTypeError, ReplySerial: call.Serial,
+					ErrorName: "org.freedesktop.systemd1.NoSuchUnit", Signature: "s",
+					Body: []interface{}{"Unit " + unit + " not loaded."},
+				})
+				continue
+			}
+			slicePath, _ := ExpandSlice(propertyMap(props)["Slice"].Value.(string))
+			os.RemoveAll(filepath.Join(f.root, slicePath, unit))
+			job(call, unit, "done")
+		case "SetUnitProperties":
+			f.mu.Lock()
+			f.setProps = decodeFakeProperties(call.Body[2])
+			f.mu.Unlock()
+			reply(call, "")
+		default:
+			send(&dbusMessage{
+				Type: dbusTypeError, ReplySerial: call.Serial,
+				ErrorName: "org.freedesktop.DBus.Error.UnknownMethod",
+			})
+		}
+	}
+}
+
+// decodeFakeProperties converts a decoded a(sv) back to properties.
+func decodeFakeProperties(v interface{}) []systemdProperty {
+	var props []systemdProperty
+	for _, item := range v.([]interface{}) {
+		field := item.([]interface{})
+		props = append(props, systemdProperty{field[0].(string), field[1].(dbusVariant)})
+	}
+	return props
+}
+
+func newFakeSystemd(t *testing.T) *fakeSystemd {
+	return &fakeSystemd{root: t.TempDir(), units: make(map[string][]systemdProperty), result: "done"}
+}
+
+func TestSystemdCgroup_Lifecycle(t *testing.T) {
+	f := newFakeSystemd(t)
+	h := &CgroupHierarchy{Mode: CgroupModeUnified, Unified: f.root}
+	s, err := newSystemdCgroup(h, "user-1000.slice:runc-go:c1", f.connect)
+	if err != nil {
+		t.Fatalf("newSystemdCgroup failed: %v", err)
+	}
+
+	scope := filepath.Join(f.root, "user.slice/user-1000.slice/runc-go-c1.scope")
+	if s.Path() != scope {
+		t.Errorf("Path = %s, want %s", s.Path(), scope)
+	}
+	if s.Unit() != "runc-go-c1.scope" {
+		t.Errorf("Unit = %s", s.Unit())
+	}
+
+	limit := int64(64 << 20)
+	resources := &spec.LinuxResources{
+		Memory:  &spec.LinuxMemory{Limit: &limit},
+		Unified: map[string]string{"memory.high": "50000000"},
+	}
+	if err := s.ApplyResources(resources); err != nil {
+		t.Fatalf("ApplyResources before start failed: %v", err)
+	}
+	if len(f.calls) != 0 {
+		t.Errorf("ApplyResources before start should not contact systemd, got %v", f.calls)
+	}
+
+	if err := s.AddProcess(4242); err != nil {
+		t.Fatalf("AddProcess failed: %v", err)
+	}
+	props := propertyMap(f.units["runc-go-c1.scope"])
+	if props["PIDs"].Sig != "au" || !reflect.DeepEqual(props["PIDs"].Value, []interface{}{uint32(4242)}) {
+		t.Errorf("PIDs = %v", props["PIDs"])
+	}
+	if props["Delegate"] != (dbusVariant{"b", true}) {
+		t.Errorf("Delegate = %v", props["Delegate"])
+	}
+	if props["Slice"] != (dbusVariant{"s", "user-1000.slice"}) {
+		t.Errorf("Slice = %v", props["Slice"])
+	}
+	if props["MemoryMax"] != (dbusVariant{"t", uint64(limit)}) {
+		t.Errorf("MemoryMax = %v", props["MemoryMax"])
+	}
+	// Keys without a systemd property go to the delegated cgroup directly
+	if got := readFakeFile(t, filepath.Join(scope, "memory.high")); got != "50000000" {
+		t.Errorf("memory.high = %q", got)
+	}
+
+	// A second process joins through the filesystem
+	if err := s.AddProcess(4343); err != nil {
+		t.Fatalf("second AddProcess failed: %v", err)
+	}
+	if got := readFakeFile(t, filepath.Join(scope, "cgroup.procs")); got != "4343" {
+		t.Errorf("cgroup.procs = %q", got)
+	}
+
+	pids := int64(100)
+	if err := s.ApplyResources(&spec.LinuxResources{Pids: &spec.LinuxPids{Limit: pids}}); err != nil {
+		t.Fatalf("ApplyResources after start failed: %v", err)
+	}
+	if got := propertyMap(f.setProps)["TasksMax"]; got != (dbusVariant{"t", uint64(pids)}) {
+		t.Errorf("TasksMax = %v", got)
+	}
+
+	os.Remove(filepath.Join(scope, "memory.high"))
+	os.Remove(filepath.Join(scope, "cgroup.procs"))
+	os.Remove(filepath.Join(scope, "pids.max"))
+	if err := s.Destroy(); err != nil {
+		t.Fatalf("Destroy failed: %v", err)
+	}
+	if _, ok := f.units["runc-go-c1.scope"]; ok {
+		t.Error("unit still exists after Destroy")
+	}
+	if _, err := os.Stat(scope); !os.IsNotExist(err) {
+		t.Errorf("scope dir still exists: %v", err)
+	}
+
+	// Stopping a unit systemd already collected is not an error
+	if err := s.Destroy(); err != nil {
+		t.Errorf("second Destroy failed: %v", err)
+	}
+}
+
+// TestSystemdCgroup_Reopen tests that a driver reopened for a running
+// scope, as update, kill and delete do, changes the scope rather than
+// only recording the resources.
+func TestSystemdCgroup_Reopen(t *testing.T) {
+	f := newFakeSystemd(t)
+	h := &CgroupHierarchy{Mode: CgroupModeUnified, Unified: f.root}
+	s, err := newSystemdCgroup(h, "system.slice:runc-go:c4", f.connect)
+	if err != nil {
+		t.Fatalf("newSystemdCgroup failed: %v", err)
+	}
+	if err := s.AddProcess(4242); err != nil {
+		t.Fatalf("AddProcess failed: %v", err)
+	}
+
+	reopened, err := newSystemdCgroup(h, "system.slice:runc-go:c4", f.connect)
+	if err != nil {
+		t.Fatalf("newSystemdCgroup failed: %v", err)
+	}
+	pids := int64(100)
+	if err := reopened.ApplyResources(&spec.LinuxResources{Pids: &spec.LinuxPids{Limit: pids}}); err != nil {
+		t.Fatalf("ApplyResources on reopened scope failed: %v", err)
+	}
+	if got := propertyMap(f.setProps)["TasksMax"]; got != (dbusVariant{"t", uint64(pids)}) {
+		t.Errorf("TasksMax = %v, want it set on the running scope", got)
+	}
+	scope := filepath.Join(f.root, "system.slice/runc-go-c4.scope")
+	if got := readFakeFile(t, filepath.Join(scope, "pids.max")); got != "100" {
+		t.Errorf("pids.max = %q, want 100", got)
+	}
+
+	// A process joins the running scope instead of starting it again
+	if err := reopened.AddProcess(4343); err != nil {
+		t.Fatalf("AddProcess on reopened scope failed: %v", err)
+	}
+	starts := 0
+	for _, call := range f.calls {
+		if call == "StartTransientUnit" {
+			starts++
+		}
+	}
+	if starts != 1 {
+		t.Errorf("StartTransientUnit called %d times, want 1", starts)
+	}
+}
+
+func TestSystemdCgroup_JobFailed(t *testing.T) {
+	f := newFakeSystemd(t)
+	f.result = "failed"
+	h := &CgroupHierarchy{Mode: CgroupModeUnified, Unified: f.root}
+	s, err := newSystemdCgroup(h, "system.slice:runc-go:c2", f.connect)
+	if err != nil {
+		t.Fatalf("newSystemdCgroup failed: %v", err)
+	}
+	err = s.AddProcess(1)
+	if err == nil || !strings.Contains(err.Error(), "failed") {
+		t.Errorf("AddProcess error = %v, want job failure", err)
+	}
+}
+
+func TestSystemdCgroup_Legacy(t *testing.T) {
+	h := fakeV1Hierarchy(t, "memory", "cpu,cpuacct")
+	s, err := newSystemdCgroup(h, "system.slice:runc-go:c3", nil)
+	if err != nil {
+		t.Fatalf("newSystemdCgroup failed: %v", err)
+	}
+	v1, ok := s.fs.(*CgroupV1)
+	if !ok {
+		t.Fatalf("fs = %T, want *CgroupV1", s.fs)
+	}
+	want := filepath.Join(h.Controllers["cpu"], "system.slice/runc-go-c3.scope")
+	if got, _ := v1.ControllerPath("cpu"); got != want {
+		t.Errorf("cpu path = %s, want %s", got, want)
+	}
+	if _, err := os.Stat(want); !os.IsNotExist(err) {
+		t.Error("driver should leave directory creation to systemd")
+	}
+}
+
+func TestSystemdCgroup_UserManager(t *testing.T) {
+	h := &CgroupHierarchy{Mode: CgroupModeUnified, Unified: "/sys/fs/cgroup"}
+	s, err := newSystemdCgroupAt(h, "/user.slice/user-1000.slice/user@1000.service",
+		DefaultUserSystemdCgroupPath("c4"), nil)
+	if err != nil {
+		t.Fatalf("newSystemdCgroupAt failed: %v", err)
+	}
+	want := "/sys/fs/cgroup/user.slice/user-1000.slice/user@1000.service/user.slice/runc-go-c4.scope"
+	if s.Path() != want {
+		t.Errorf("Path = %s, want %s", s.Path(), want)
+	}
+}
@@ -2,10 +2,15 @@ package linux
 
 import (
 	"os"
+	"os/exec"
 	"path/filepath"
+	"reflect"
 	"strings"
+	"syscall"
 	"testing"
 
+	"golang.org/x/sys/unix"
+
 	"runc-go/spec"
 )
 
@@ -447,3 +452,437 @@ func TestCPUWeightFormula(t *testing.T) {
 	}
 }
 
+
+// fakeV2Cgroup returns a cgroup in a temporary directory with the given
+// controllers enabled.
+func fakeV2Cgroup(t *testing.T, controllers string) *Cgroup {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "cgroup.controllers"), []byte(controllers+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return &Cgroup{path: dir}
+}
+
+func TestIOMaxLines(t *testing.T) {
+	blkio := &spec.LinuxBlockIO{
+		ThrottleReadBpsDevice:   []spec.LinuxThrottleDevice{{Major: 8, Minor: 0, Rate: 1048576}},
+		ThrottleWriteBpsDevice:  []spec.LinuxThrottleDevice{{Major: 8, Minor: 16, Rate: 2097152}, {Major: 8, Minor: 0, Rate: 0}},
+		ThrottleReadIOPSDevice:  []spec.LinuxThrottleDevice{{Major: 8, Minor: 0, Rate: 100}},
+		ThrottleWriteIOPSDevice: []spec.LinuxThrottleDevice{{Major: 8, Minor: 16, Rate: 50}},
+	}
+	want := []string{
+		"8:0 rbps=1048576 wbps=max riops=100",
+		"8:16 wbps=2097152 wiops=50",
+	}
+	got := ioMaxLines(blkio)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("ioMaxLines = %q, want %q", got, want)
+	}
+}
+
+func TestApplyBlockIO(t *testing.T) {
+	weight := uint16(500)
+	devWeight := uint16(1000)
+	blkio := &spec.LinuxBlockIO{
+		Weight:                 &weight,
+		WeightDevice:           []spec.LinuxWeightDevice{{Major: 8, Minor: 0, Weight: &devWeight}},
+		ThrottleReadBpsDevice:  []spec.LinuxThrottleDevice{{Major: 8, Minor: 0, Rate: 4096}},
+		ThrottleReadIOPSDevice: []spec.LinuxThrottleDevice{{Major: 8, Minor: 0, Rate: 10}},
+	}
+
+	cg := fakeV2Cgroup(t, "cpu io memory pids")
+	if err := cg.ApplyResources(&spec.LinuxResources{BlockIO: blkio}); err != nil {
+		t.Fatalf("ApplyResources failed: %v", err)
+	}
+	// The last write to io.weight is the per-device one
+	if got := readFakeFile(t, filepath.Join(cg.path, "io.weight")); got != "8:0 10000" {
+		t.Errorf("io.weight = %q", got)
+	}
+	if got := readFakeFile(t, filepath.Join(cg.path, "io.max")); got != "8:0 rbps=4096 riops=10" {
+		t.Errorf("io.max = %q", got)
+	}
+
+	// With BFQ, weights keep the blkio range
+	cg = fakeV2Cgroup(t, "io")
+	if err := os.WriteFile(filepath.Join(cg.path, "io.bfq.weight"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cg.ApplyResources(&spec.LinuxResources{BlockIO: &spec.LinuxBlockIO{Weight: &weight}}); err != nil {
+		t.Fatalf("ApplyResources (bfq) failed: %v", err)
+	}
+	if got := readFakeFile(t, filepath.Join(cg.path, "io.bfq.weight")); got != "500" {
+		t.Errorf("io.bfq.weight = %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(cg.path, "io.weight")); !os.IsNotExist(err) {
+		t.Error("io.weight should not be written when BFQ is available")
+	}
+
+	if got := blkioWeightToIOWeight(10); got != 1 {
+		t.Errorf("blkioWeightToIOWeight(10) = %d", got)
+	}
+	if got := blkioWeightToIOWeight(1000); got != 10000 {
+		t.Errorf("blkioWeightToIOWeight(1000) = %d", got)
+	}
+}
+
+func TestApplyBlockIO_Errors(t *testing.T) {
+	weight := uint16(500)
+	low := uint16(5)
+
+	tests := []struct {
+		name        string
+		controllers string
+		blkio       *spec.LinuxBlockIO
+		want        string
+	}{
+		{"io not enabled", "cpu memory", &spec.LinuxBlockIO{Weight: &weight}, "io controller is not enabled"},
+		{"weight range", "io", &spec.LinuxBlockIO{Weight: &low}, "out of range"},
+		{"leaf weight", "io", &spec.LinuxBlockIO{LeafWeight: &weight}, "no cgroup v2 equivalent"},
+		{"device leaf weight", "io", &spec.LinuxBlockIO{
+			WeightDevice: []spec.LinuxWeightDevice{{Major: 8, Minor: 0, LeafWeight: &weight}},
+		}, "no cgroup v2 equivalent"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cg := fakeV2Cgroup(t, tt.controllers)
+			err := cg.ApplyResources(&spec.LinuxResources{BlockIO: tt.blkio})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+
+	// An empty BlockIO needs no io controller
+	cg := fakeV2Cgroup(t, "")
+	if err := cg.ApplyResources(&spec.LinuxResources{BlockIO: &spec.LinuxBlockIO{}}); err != nil {
+		t.Errorf("empty BlockIO failed: %v", err)
+	}
+}
+
+func TestApplyMemory_V2Knobs(t *testing.T) {
+	limit := int64(64 << 20)
+	yes := true
+
+	cg := fakeV2Cgroup(t, "memory")
+	// The OOM killer cannot be disabled, and nothing is written
+	err := cg.ApplyResources(&spec.LinuxResources{
+		Memory: &spec.LinuxMemory{Limit: &limit, DisableOOMKiller: &yes},
+	})
+	if err == nil || !strings.Contains(err.Error(), "memory.disableOOMKiller has no cgroup v2 equivalent") {
+		t.Fatalf("ApplyResources with disableOOMKiller: got %v, want no cgroup v2 equivalent", err)
+	}
+	if _, err := os.Stat(filepath.Join(cg.path, "memory.max")); err == nil {
+		t.Error("memory.max written for a rejected disableOOMKiller")
+	}
+	no := false
+	err = cg.ApplyResources(&spec.LinuxResources{
+		Memory: &spec.LinuxMemory{Limit: &limit, DisableOOMKiller: &no},
+	})
+	if err != nil {
+		t.Fatalf("ApplyResources failed: %v", err)
+	}
+	if got := readFakeFile(t, filepath.Join(cg.path, "memory.max")); got != "67108864" {
+		t.Errorf("memory.max = %q, want the limit", got)
+	}
+
+	// memory.oom.group has no OCI field and is set through unified
+	err = cg.ApplyResources(&spec.LinuxResources{Unified: map[string]string{"memory.oom.group": "1"}})
+	if err != nil {
+		t.Fatalf("ApplyResources with unified memory.oom.group failed: %v", err)
+	}
+	if got := readFakeFile(t, filepath.Join(cg.path, "memory.oom.group")); got != "1" {
+		t.Errorf("memory.oom.group = %q, want 1", got)
+	}
+
+	// CheckBeforeUpdate refuses a limit below current usage
+	if err := os.WriteFile(filepath.Join(cg.path, "memory.current"), []byte("100000000\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = cg.ApplyResources(&spec.LinuxResources{
+		Memory: &spec.LinuxMemory{Limit: &limit, CheckBeforeUpdate: &yes},
+	})
+	if err == nil || !strings.Contains(err.Error(), "below current usage") {
+		t.Errorf("CheckBeforeUpdate error = %v", err)
+	}
+	bigger := int64(200000000)
+	if err := cg.ApplyResources(&spec.LinuxResources{
+		Memory: &spec.LinuxMemory{Limit: &bigger, CheckBeforeUpdate: &yes},
+	}); err != nil {
+		t.Errorf("CheckBeforeUpdate with room failed: %v", err)
+	}
+
+	swappiness := uint64(60)
+	kernel := int64(1 << 20)
+	rejected := []struct {
+		name   string
+		memory *spec.LinuxMemory
+	}{
+		{"swappiness", &spec.LinuxMemory{Swappiness: &swappiness}},
+		{"kernel", &spec.LinuxMemory{Kernel: &kernel}},
+		{"kernelTCP", &spec.LinuxMemory{KernelTCP: &kernel}},
+		{"useHierarchy", &spec.LinuxMemory{UseHierarchy: &no}},
+	}
+	for _, tt := range rejected {
+		err := cg.ApplyResources(&spec.LinuxResources{Memory: tt.memory})
+		if err == nil || !strings.Contains(err.Error(), tt.name) {
+			t.Errorf("%s: error = %v, want rejection naming the field", tt.name, err)
+		}
+	}
+}
+
+func TestApplyCPU_V2Knobs(t *testing.T) {
+	quota := int64(50000)
+	burst := uint64(20000)
+	shares := uint64(1024)
+	idle := int64(1)
+
+	cg := fakeV2Cgroup(t, "cpu")
+	err := cg.ApplyResources(&spec.LinuxResources{
+		CPU: &spec.LinuxCPU{Quota: &quota, Burst: &burst, Shares: &shares, Idle: &idle},
+	})
+	if err != nil {
+		t.Fatalf("ApplyResources failed: %v", err)
+	}
+	if got := readFakeFile(t, filepath.Join(cg.path, "cpu.max.burst")); got != "20000" {
+		t.Errorf("cpu.max.burst = %q", got)
+	}
+	if got := readFakeFile(t, filepath.Join(cg.path, "cpu.idle")); got != "1" {
+		t.Errorf("cpu.idle = %q", got)
+	}
+
+	runtime := int64(950000)
+	period := uint64(1000000)
+	badIdle := int64(2)
+	rejected := []*spec.LinuxCPU{
+		{RealtimeRuntime: &runtime},
+		{RealtimePeriod: &period},
+		{Idle: &badIdle},
+	}
+	for _, cpu := range rejected {
+		if err := cg.ApplyResources(&spec.LinuxResources{CPU: cpu}); err == nil {
+			t.Errorf("ApplyResources(%+v) should fail", cpu)
+		}
+	}
+}
+
+func TestApplyHugepagesAndRdma(t *testing.T) {
+	cg := fakeV2Cgroup(t, "hugetlb rdma")
+	if err := os.WriteFile(filepath.Join(cg.path, "hugetlb.2MB.rsvd.max"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	handles := uint32(4)
+	err := cg.ApplyResources(&spec.LinuxResources{
+		HugepageLimits: []spec.LinuxHugepageLimit{{Pagesize: "2MB", Limit: 1 << 30}},
+		Rdma:           map[string]spec.LinuxRdma{"mlx5_0": {HcaHandles: &handles}},
+	})
+	if err != nil {
+		t.Fatalf("ApplyResources failed: %v", err)
+	}
+	for _, file := range []string{"hugetlb.2MB.max", "hugetlb.2MB.rsvd.max"} {
+		if got := readFakeFile(t, filepath.Join(cg.path, file)); got != "1073741824" {
+			t.Errorf("%s = %q", file, got)
+		}
+	}
+	if got := readFakeFile(t, filepath.Join(cg.path, "rdma.max")); got != "mlx5_0 hca_handle=4 hca_object=max" {
+		t.Errorf("rdma.max = %q", got)
+	}
+
+	lines, err := rdmaLines(map[string]spec.LinuxRdma{"b": {}, "a": {HcaObjects: &handles}})
+	if err != nil || strings.Join(lines, "\n") != "a hca_handle=max hca_object=4\nb hca_handle=max hca_object=max" {
+		t.Errorf("rdmaLines = %q, %v", lines, err)
+	}
+
+	tests := []struct {
+		name        string
+		controllers string
+		resources   *spec.LinuxResources
+		want        string
+	}{
+		{"hugetlb not enabled", "rdma", &spec.LinuxResources{
+			HugepageLimits: []spec.LinuxHugepageLimit{{Pagesize: "2MB", Limit: 1}},
+		}, "hugetlb controller is not enabled"},
+		{"page size traversal", "hugetlb", &spec.LinuxResources{
+			HugepageLimits: []spec.LinuxHugepageLimit{{Pagesize: "../2MB", Limit: 1}},
+		}, "invalid hugepage size"},
+		{"rdma not enabled", "hugetlb", &spec.LinuxResources{
+			Rdma: map[string]spec.LinuxRdma{"mlx5_0": {}},
+		}, "rdma controller is not enabled"},
+		{"rdma device", "rdma", &spec.LinuxResources{
+			Rdma: map[string]spec.LinuxRdma{"mlx5_0 hca_handle=1": {}},
+		}, "invalid rdma device"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := fakeV2Cgroup(t, tt.controllers).ApplyResources(tt.resources)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCgroupProcs_Nested(t *testing.T) {
+	cg := fakeV2Cgroup(t, "")
+	files := map[string]string{
+		"cgroup.procs":            "12\n7\n",
+		"child/cgroup.procs":      "30\n",
+		"child/deep/cgroup.procs": "7\n5\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(cg.Path(), name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pids, err := cg.Procs()
+	if err != nil {
+		t.Fatalf("Procs failed: %v", err)
+	}
+	if want := []int{5, 7, 12, 30}; !reflect.DeepEqual(pids, want) {
+		t.Errorf("Procs = %v, want %v", pids, want)
+	}
+
+	// A missing cgroup holds no processes
+	gone := &Cgroup{path: filepath.Join(cg.Path(), "missing")}
+	if pids, err := gone.Procs(); err != nil || len(pids) != 0 {
+		t.Errorf("Procs of missing cgroup = %v, %v", pids, err)
+	}
+}
+
+// TestCgroup_KillKernel starts processes in a scratch cgroup and a nested
+// child, kills them through both the freezer walk and cgroup.kill, and
+// removes the tree.
+func TestCgroup_KillKernel(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("skipping cgroup kill test: requires root")
+	}
+	h, err := DetectCgroupHierarchy()
+	if err != nil || h.Unified == "" {
+		t.Skip("skipping cgroup kill test: no cgroup v2 mount")
+	}
+	dir, err := os.MkdirTemp(h.Unified, "runc-go-killtest-")
+	if err != nil {
+		t.Skipf("skipping cgroup kill test: %v", err)
+	}
+	cg := &Cgroup{path: dir}
+	defer cg.Destroy()
+
+	child := filepath.Join(dir, "child")
+	if err := os.Mkdir(child, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// start runs sleep inside a cgroup directory.
+	start := func(path string) *exec.Cmd {
+		fd, err := unix.Open(path, unix.O_RDONLY|unix.O_DIRECTORY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer unix.Close(fd)
+		cmd := exec.Command("sleep", "100")
+		cmd.SysProcAttr = &syscall.SysProcAttr{UseCgroupFD: true, CgroupFD: fd, Setsid: true}
+		if err := cmd.Start(); err != nil {
+			t.Skipf("skipping cgroup kill test: cannot run in cgroup: %v", err)
+		}
+		return cmd
+	}
+
+	for _, sig := range []syscall.Signal{syscall.SIGTERM, syscall.SIGKILL} {
+		cmds := []*exec.Cmd{start(dir), start(child)}
+		if pids, err := cg.Procs(); err != nil || len(pids) != 2 {
+			t.Fatalf("Procs = %v, %v, want 2 processes", pids, err)
+		}
+		if err := cg.Kill(sig); err != nil {
+			t.Fatalf("Kill(%v) failed: %v", sig, err)
+		}
+		for _, cmd := range cmds {
+			err := cmd.Wait()
+			if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); !ok || status.Signal() != sig {
+				t.Errorf("Kill(%v): sleep exited with %v", sig, err)
+			}
+		}
+		if pids, _ := cg.Procs(); len(pids) != 0 {
+			t.Errorf("Kill(%v) left %v", sig, pids)
+		}
+	}
+
+	if data, err := os.ReadFile(filepath.Join(dir, "cgroup.freeze")); err == nil && strings.TrimSpace(string(data)) != "0" {
+		t.Errorf("cgroup left frozen: %q", data)
+	}
+	if err := cg.Destroy(); err != nil {
+		t.Fatalf("Destroy failed: %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("cgroup still exists after Destroy: %v", err)
+	}
+}
+
+func TestParseMemoryEvents(t *testing.T) {
+	input := "low 1\nhigh 2\nmax 3\noom 4\noom_kill 5\noom_group_kill 6\n"
+	events, err := parseMemoryEvents(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parseMemoryEvents failed: %v", err)
+	}
+	want := spec.MemoryEvents{Low: 1, High: 2, Max: 3, OOM: 4, OOMKill: 5}
+	if *events != want {
+		t.Errorf("events = %+v, want %+v", *events, want)
+	}
+
+	if _, err := parseMemoryEvents(strings.NewReader("oom_kill x\n")); err == nil {
+		t.Error("expected error for invalid counter")
+	}
+}
+
+func TestParsePSI(t *testing.T) {
+	input := "some avg10=1.50 avg60=0.25 avg300=0.00 total=1234\n" +
+		"full avg10=0.50 avg60=0.00 avg300=0.00 total=56\n"
+	stats, err := parsePSI(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parsePSI failed: %v", err)
+	}
+	want := spec.PSIStats{
+		Some: spec.PSIData{Avg10: 1.5, Avg60: 0.25, Total: 1234},
+		Full: spec.PSIData{Avg10: 0.5, Total: 56},
+	}
+	if *stats != want {
+		t.Errorf("stats = %+v, want %+v", *stats, want)
+	}
+
+	for _, bad := range []string{"some avg10\n", "full total=-1\n"} {
+		if _, err := parsePSI(strings.NewReader(bad)); err == nil {
+			t.Errorf("parsePSI(%q): expected error", bad)
+		}
+	}
+}
+
+func TestCgroup_MemoryStats(t *testing.T) {
+	dir := t.TempDir()
+	cg := &Cgroup{path: dir}
+
+	if _, err := cg.GetMemoryEvents(); !os.IsNotExist(err) {
+		t.Errorf("GetMemoryEvents without memory.events: %v, want not exist", err)
+	}
+
+	os.WriteFile(filepath.Join(dir, "memory.events"), []byte("low 0\nhigh 0\nmax 7\noom 2\noom_kill 1\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "memory.pressure"), []byte("some avg10=3.00 avg60=2.00 avg300=1.00 total=99\nfull avg10=0.00 avg60=0.00 avg300=0.00 total=0\n"), 0644)
+
+	events, err := cg.GetMemoryEvents()
+	if err != nil {
+		t.Fatalf("GetMemoryEvents failed: %v", err)
+	}
+	if events.Max != 7 || events.OOM != 2 || events.OOMKill != 1 {
+		t.Errorf("events = %+v", *events)
+	}
+	pressure, err := cg.GetMemoryPressure()
+	if err != nil {
+		t.Fatalf("GetMemoryPressure failed: %v", err)
+	}
+	if pressure.Some.Avg10 != 3 || pressure.Some.Total != 99 {
+		t.Errorf("pressure = %+v", *pressure)
+	}
+}
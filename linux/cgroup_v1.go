@@ -0,0 +1,637 @@
+// Package linux provides cgroup v1 resource management.
+package linux
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"runc-go/spec"
+)
+
+// CgroupV1 represents a container's cgroup across the v1 hierarchies.
+// Each controller has its own directory, although co-mounted controllers
+// (e.g. cpu,cpuacct) share one.
+type CgroupV1 struct {
+	// paths maps each controller to the container's directory in its
+	// hierarchy. In hybrid mode the "" key holds the directory in the
+	// controller-less v2 hierarchy, which systemd uses to track processes.
+	paths map[string]string
+}
+
+// newCgroupV1 creates or opens the container's directories in every v1 hierarchy.
+func newCgroupV1(h *CgroupHierarchy, cgroupPath string) (*CgroupV1, error) {
+	c := cgroupV1At(h, cgroupPath)
+	for _, dir := range c.dirs() {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("create cgroup directory: %w", err)
+		}
+	}
+
+	// A v1 cpuset cgroup starts with no CPUs or memory nodes, and no task
+	// can join it until both are populated
+	if dir, ok := c.paths["cpuset"]; ok {
+		if err := inheritCpuset(h.Controllers["cpuset"], dir); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// cgroupV1At computes the per-controller directories without creating them.
+func cgroupV1At(h *CgroupHierarchy, cgroupPath string) *CgroupV1 {
+	// Anchor the path so ".." cannot climb out of a hierarchy
+	rel := filepath.Clean("/" + cgroupPath)
+
+	c := &CgroupV1{paths: make(map[string]string)}
+	for controller, mountPoint := range h.Controllers {
+		c.paths[controller] = filepath.Join(mountPoint, rel)
+	}
+	if h.Mode == CgroupModeHybrid && h.Unified != "" {
+		c.paths[""] = filepath.Join(h.Unified, rel)
+	}
+	return c
+}
+
+// dirs returns the distinct cgroup directories, sorted for stable ordering.
+func (c *CgroupV1) dirs() []string {
+	seen := make(map[string]bool)
+	var dirs []string
+	for _, dir := range c.paths {
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// Path returns the directory in the memory hierarchy, or the first
+// directory if memory is not mounted.
+func (c *CgroupV1) Path() string {
+	if dir, ok := c.paths["memory"]; ok {
+		return dir
+	}
+	if dirs := c.dirs(); len(dirs) > 0 {
+		return dirs[0]
+	}
+	return ""
+}
+
+// ControllerPath returns the directory for a single controller.
+func (c *CgroupV1) ControllerPath(controller string) (string, bool) {
+	dir, ok := c.paths[controller]
+	return dir, ok
+}
+
+// AddProcess adds a process to the cgroup in every hierarchy.
+func (c *CgroupV1) AddProcess(pid int) error {
+	for _, dir := range c.dirs() {
+		procsPath := filepath.Join(dir, "cgroup.procs")
+		if err := os.WriteFile(procsPath, []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("add process to %s: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+// ApplyResources applies OCI resource limits using v1 file names.
+func (c *CgroupV1) ApplyResources(resources *spec.LinuxResources) error {
+	if resources == nil {
+		return nil
+	}
+
+	if len(resources.Unified) > 0 {
+		return fmt.Errorf("unified resources require cgroup v2")
+	}
+
+	if err := c.applyMemory(resources.Memory); err != nil {
+		return err
+	}
+
+	if err := c.applyCPU(resources.CPU); err != nil {
+		return err
+	}
+
+	if err := c.applyPids(resources.Pids); err != nil {
+		return err
+	}
+
+	if err := c.applyBlockIO(resources.BlockIO); err != nil {
+		return err
+	}
+
+	if err := c.applyHugepages(resources.HugepageLimits); err != nil {
+		return err
+	}
+
+	if err := c.applyRdma(resources.Rdma); err != nil {
+		return err
+	}
+
+	return c.applyDevices(resources.Devices)
+}
+
+// controllerPath returns the directory for a controller the resources need.
+func (c *CgroupV1) controllerPath(controller string) (string, error) {
+	dir, ok := c.paths[controller]
+	if !ok {
+		return "", fmt.Errorf("cgroup v1 %s controller is not mounted", controller)
+	}
+	return dir, nil
+}
+
+// writeV1 writes a value to a controller file.
+func writeV1(dir, file, value string) error {
+	if err := os.WriteFile(filepath.Join(dir, file), []byte(value), 0644); err != nil {
+		return fmt.Errorf("set %s: %w", file, err)
+	}
+	return nil
+}
+
+// applyMemory applies memory limits.
+func (c *CgroupV1) applyMemory(memory *spec.LinuxMemory) error {
+	if memory == nil {
+		return nil
+	}
+	if memory.Limit == nil && memory.Reservation == nil && memory.Swap == nil &&
+		memory.Swappiness == nil && memory.DisableOOMKiller == nil {
+		return nil
+	}
+	dir, err := c.controllerPath("memory")
+	if err != nil {
+		return err
+	}
+
+	// Refuse to shrink the limit below what is already in use
+	if memory.CheckBeforeUpdate != nil && *memory.CheckBeforeUpdate && memory.Limit != nil && *memory.Limit > 0 {
+		usage, err := readCgroupInt(filepath.Join(dir, "memory.usage_in_bytes"))
+		if err == nil && *memory.Limit < usage {
+			return fmt.Errorf("memory limit %d is below current usage %d", *memory.Limit, usage)
+		}
+	}
+
+	// memory.limit_in_bytes - hard limit (-1 is unlimited)
+	setLimit := func() error {
+		if memory.Limit == nil || *memory.Limit == 0 {
+			return nil
+		}
+		return writeV1(dir, "memory.limit_in_bytes", strconv.FormatInt(*memory.Limit, 10))
+	}
+
+	// memory.memsw.limit_in_bytes - memory+swap, same meaning as OCI swap
+	setSwap := func() error {
+		if memory.Swap == nil || *memory.Swap == 0 {
+			return nil
+		}
+		if err := writeV1(dir, "memory.memsw.limit_in_bytes", strconv.FormatInt(*memory.Swap, 10)); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				// Swap accounting might not be enabled
+				fmt.Printf("[cgroup] warning: %v\n", err)
+				return nil
+			}
+			return err
+		}
+		return nil
+	}
+
+	// The kernel rejects limit > memsw, so raise memsw first when growing
+	swapFirst := false
+	if memory.Swap != nil && *memory.Swap != 0 {
+		current, err := readCgroupInt(filepath.Join(dir, "memory.memsw.limit_in_bytes"))
+		swapFirst = err == nil && (*memory.Swap == -1 || current < *memory.Swap)
+	}
+	if swapFirst {
+		if err := setSwap(); err != nil {
+			return err
+		}
+		if err := setLimit(); err != nil {
+			return err
+		}
+	} else {
+		if err := setLimit(); err != nil {
+			return err
+		}
+		if err := setSwap(); err != nil {
+			return err
+		}
+	}
+
+	// memory.soft_limit_in_bytes - soft limit / reservation
+	if memory.Reservation != nil && *memory.Reservation != 0 {
+		if err := writeV1(dir, "memory.soft_limit_in_bytes", strconv.FormatInt(*memory.Reservation, 10)); err != nil {
+			return err
+		}
+	}
+
+	if memory.Swappiness != nil {
+		if *memory.Swappiness > 100 {
+			return fmt.Errorf("invalid swappiness %d: must be 0-100", *memory.Swappiness)
+		}
+		if err := writeV1(dir, "memory.swappiness", strconv.FormatUint(*memory.Swappiness, 10)); err != nil {
+			return err
+		}
+	}
+
+	if memory.DisableOOMKiller != nil && *memory.DisableOOMKiller {
+		if err := writeV1(dir, "memory.oom_control", "1"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// applyCPU applies CPU limits.
+func (c *CgroupV1) applyCPU(cpu *spec.LinuxCPU) error {
+	if cpu == nil {
+		return nil
+	}
+	if cpu.Idle != nil && *cpu.Idle != 0 {
+		return fmt.Errorf("cpu.idle requires cgroup v2")
+	}
+
+	if cpu.Shares != nil || cpu.Quota != nil || cpu.Period != nil || cpu.Burst != nil ||
+		cpu.RealtimeRuntime != nil || cpu.RealtimePeriod != nil {
+		dir, err := c.controllerPath("cpu")
+		if err != nil {
+			return err
+		}
+
+		// cpu.shares is native in v1, no conversion needed
+		if cpu.Shares != nil && *cpu.Shares > 0 {
+			if err := writeV1(dir, "cpu.shares", strconv.FormatUint(*cpu.Shares, 10)); err != nil {
+				return err
+			}
+		}
+
+		// The period must be set before a quota that depends on it
+		if cpu.Period != nil && *cpu.Period > 0 {
+			if err := writeV1(dir, "cpu.cfs_period_us", strconv.FormatUint(*cpu.Period, 10)); err != nil {
+				return err
+			}
+		}
+		if cpu.Quota != nil || cpu.Period != nil {
+			quota := int64(-1)
+			if cpu.Quota != nil && *cpu.Quota > 0 {
+				quota = *cpu.Quota
+			}
+			if err := writeV1(dir, "cpu.cfs_quota_us", strconv.FormatInt(quota, 10)); err != nil {
+				return err
+			}
+		}
+		if cpu.Burst != nil {
+			if err := writeV1(dir, "cpu.cfs_burst_us", strconv.FormatUint(*cpu.Burst, 10)); err != nil {
+				return err
+			}
+		}
+
+		// The realtime period bounds the runtime, so it goes first
+		if cpu.RealtimePeriod != nil && *cpu.RealtimePeriod != 0 {
+			if err := writeV1(dir, "cpu.rt_period_us", strconv.FormatUint(*cpu.RealtimePeriod, 10)); err != nil {
+				return err
+			}
+		}
+		if cpu.RealtimeRuntime != nil && *cpu.RealtimeRuntime != 0 {
+			if err := writeV1(dir, "cpu.rt_runtime_us", strconv.FormatInt(*cpu.RealtimeRuntime, 10)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if cpu.Cpus != "" || cpu.Mems != "" {
+		dir, err := c.controllerPath("cpuset")
+		if err != nil {
+			return err
+		}
+		if cpu.Cpus != "" {
+			if err := writeV1(dir, "cpuset.cpus", cpu.Cpus); err != nil {
+				return err
+			}
+		}
+		if cpu.Mems != "" {
+			if err := writeV1(dir, "cpuset.mems", cpu.Mems); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// applyPids applies process count limits.
+func (c *CgroupV1) applyPids(pids *spec.LinuxPids) error {
+	if pids == nil || pids.Limit == 0 {
+		return nil
+	}
+	dir, err := c.controllerPath("pids")
+	if err != nil {
+		return err
+	}
+
+	value := "max"
+	if pids.Limit > 0 {
+		value = strconv.FormatInt(pids.Limit, 10)
+	}
+	return writeV1(dir, "pids.max", value)
+}
+
+// applyBlockIO writes blkio weights and per-device throttles, one device
+// per write.
+func (c *CgroupV1) applyBlockIO(blkio *spec.LinuxBlockIO) error {
+	if blockIOEmpty(blkio) {
+		return nil
+	}
+	if err := validateBlockIO(blkio); err != nil {
+		return err
+	}
+	dir, err := c.controllerPath("blkio")
+	if err != nil {
+		return err
+	}
+
+	if blkio.Weight != nil {
+		if err := writeV1(dir, "blkio.weight", strconv.Itoa(int(*blkio.Weight))); err != nil {
+			return err
+		}
+	}
+	if blkio.LeafWeight != nil {
+		if err := writeV1(dir, "blkio.leaf_weight", strconv.Itoa(int(*blkio.LeafWeight))); err != nil {
+			return err
+		}
+	}
+	for _, wd := range blkio.WeightDevice {
+		if wd.Weight != nil {
+			if err := writeV1(dir, "blkio.weight_device", fmt.Sprintf("%d:%d %d", wd.Major, wd.Minor, *wd.Weight)); err != nil {
+				return err
+			}
+		}
+		if wd.LeafWeight != nil {
+			if err := writeV1(dir, "blkio.leaf_weight_device", fmt.Sprintf("%d:%d %d", wd.Major, wd.Minor, *wd.LeafWeight)); err != nil {
+				return err
+			}
+		}
+	}
+
+	throttles := []struct {
+		file    string
+		devices []spec.LinuxThrottleDevice
+	}{
+		{"blkio.throttle.read_bps_device", blkio.ThrottleReadBpsDevice},
+		{"blkio.throttle.write_bps_device", blkio.ThrottleWriteBpsDevice},
+		{"blkio.throttle.read_iops_device", blkio.ThrottleReadIOPSDevice},
+		{"blkio.throttle.write_iops_device", blkio.ThrottleWriteIOPSDevice},
+	}
+	for _, throttle := range throttles {
+		for _, d := range throttle.devices {
+			// A zero rate removes the limit
+			if err := writeV1(dir, throttle.file, fmt.Sprintf("%d:%d %d", d.Major, d.Minor, d.Rate)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// applyHugepages writes hugetlb limits per page size.
+func (c *CgroupV1) applyHugepages(limits []spec.LinuxHugepageLimit) error {
+	if len(limits) == 0 {
+		return nil
+	}
+	dir, err := c.controllerPath("hugetlb")
+	if err != nil {
+		return err
+	}
+	for _, l := range limits {
+		if !validHugepageSize.MatchString(l.Pagesize) {
+			return fmt.Errorf("invalid hugepage size %q", l.Pagesize)
+		}
+		if err := writeV1(dir, "hugetlb."+l.Pagesize+".limit_in_bytes", strconv.FormatUint(l.Limit, 10)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyRdma writes RDMA limits, one device per write.
+func (c *CgroupV1) applyRdma(rdma map[string]spec.LinuxRdma) error {
+	if len(rdma) == 0 {
+		return nil
+	}
+	lines, err := rdmaLines(rdma)
+	if err != nil {
+		return err
+	}
+	dir, err := c.controllerPath("rdma")
+	if err != nil {
+		return err
+	}
+	for _, line := range lines {
+		if err := writeV1(dir, "rdma.max", line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyDevices writes device rules to devices.allow and devices.deny in order.
+func (c *CgroupV1) applyDevices(devices []spec.LinuxDeviceCgroup) error {
+	if len(devices) == 0 {
+		return nil
+	}
+	dir, err := c.controllerPath("devices")
+	if err != nil {
+		return err
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(MakeDevicesCgroupRules(devices)), "\n") {
+		// Each line is "allow|deny TYPE MAJOR:MINOR ACCESS"
+		verb, rule, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		if err := writeV1(dir, "devices."+verb, rule); err != nil {
+			return fmt.Errorf("device rule %q: %w", line, err)
+		}
+	}
+	return nil
+}
+
+// Kill sends sig to every process in the cgroup and its descendants. v1
+// has no cgroup.kill, so the freezer (when mounted) holds the processes
+// still while they are signalled.
+func (c *CgroupV1) Kill(sig syscall.Signal) error {
+	freezer, hasFreezer := c.paths["freezer"]
+	froze := false
+	if hasFreezer {
+		if data, err := os.ReadFile(filepath.Join(freezer, "freezer.state")); err == nil && strings.TrimSpace(string(data)) == "THAWED" {
+			if err := c.Freeze(); err == nil {
+				froze = true
+				waitV1Frozen(freezer)
+			}
+		}
+	}
+
+	pids, err := c.Procs()
+	if err == nil {
+		err = signalProcs(pids, sig)
+	}
+
+	// Frozen v1 tasks act on SIGKILL only once thawed
+	if froze || (hasFreezer && sig == syscall.SIGKILL) {
+		if thawErr := c.Thaw(); thawErr != nil && err == nil {
+			err = thawErr
+		}
+	}
+	return err
+}
+
+// waitV1Frozen waits briefly for freezer.state to leave FREEZING.
+func waitV1Frozen(dir string) bool {
+	for i := 0; i < 100; i++ {
+		data, err := os.ReadFile(filepath.Join(dir, "freezer.state"))
+		if err != nil {
+			return false
+		}
+		if strings.TrimSpace(string(data)) == "FROZEN" {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+// Procs returns the union of the PIDs in every hierarchy.
+func (c *CgroupV1) Procs() ([]int, error) {
+	return cgroupProcs(c.dirs())
+}
+
+// Destroy removes the cgroup and its descendants from every hierarchy.
+func (c *CgroupV1) Destroy() error {
+	var firstErr error
+	for _, dir := range c.dirs() {
+		if err := removeCgroupTree(dir); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// GetMemoryCurrent returns current memory usage.
+func (c *CgroupV1) GetMemoryCurrent() (int64, error) {
+	dir, err := c.controllerPath("memory")
+	if err != nil {
+		return 0, err
+	}
+	return readCgroupInt(filepath.Join(dir, "memory.usage_in_bytes"))
+}
+
+// GetPidsCurrent returns current number of processes.
+func (c *CgroupV1) GetPidsCurrent() (int64, error) {
+	dir, err := c.controllerPath("pids")
+	if err != nil {
+		return 0, err
+	}
+	return readCgroupInt(filepath.Join(dir, "pids.current"))
+}
+
+// GetMemoryEvents builds the counters v1 has: allocation failures at the
+// limit from memory.failcnt, and OOM state from memory.oom_control.
+func (c *CgroupV1) GetMemoryEvents() (*spec.MemoryEvents, error) {
+	dir, err := c.controllerPath("memory")
+	if err != nil {
+		return nil, err
+	}
+	failcnt, err := readCgroupInt(filepath.Join(dir, "memory.failcnt"))
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "memory.oom_control"))
+	if err != nil {
+		return nil, err
+	}
+	control, err := parseMemoryEvents(strings.NewReader(string(data)))
+	if err != nil {
+		return nil, err
+	}
+	// oom_control reports oom_kill (4.13+) but has no OOM event count
+	return &spec.MemoryEvents{Max: uint64(failcnt), OOMKill: control.OOMKill}, nil
+}
+
+// GetMemoryPressure reads memory.pressure from the hybrid v2 directory;
+// v1 hierarchies have no PSI files.
+func (c *CgroupV1) GetMemoryPressure() (*spec.PSIStats, error) {
+	dir, ok := c.paths[""]
+	if !ok {
+		return nil, fmt.Errorf("memory pressure: %w", errors.ErrUnsupported)
+	}
+	return readPSI(filepath.Join(dir, "memory.pressure"))
+}
+
+// Freeze freezes all processes in the cgroup.
+func (c *CgroupV1) Freeze() error {
+	dir, err := c.controllerPath("freezer")
+	if err != nil {
+		return err
+	}
+	return writeV1(dir, "freezer.state", "FROZEN")
+}
+
+// Thaw unfreezes all processes in the cgroup.
+func (c *CgroupV1) Thaw() error {
+	dir, err := c.controllerPath("freezer")
+	if err != nil {
+		return err
+	}
+	return writeV1(dir, "freezer.state", "THAWED")
+}
+
+// inheritCpuset copies cpuset.cpus and cpuset.mems from the nearest
+// populated ancestor into every empty directory from the mount point down to dir.
+func inheritCpuset(mountPoint, dir string) error {
+	rel, err := filepath.Rel(mountPoint, dir)
+	if err != nil || rel == "." {
+		return nil
+	}
+
+	current := mountPoint
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		parent := current
+		current = filepath.Join(current, part)
+		for _, file := range []string{"cpuset.cpus", "cpuset.mems"} {
+			value, err := os.ReadFile(filepath.Join(current, file))
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("read %s: %w", file, err)
+			}
+			if strings.TrimSpace(string(value)) != "" {
+				continue
+			}
+			inherited, err := os.ReadFile(filepath.Join(parent, file))
+			if err != nil {
+				return fmt.Errorf("read parent %s: %w", file, err)
+			}
+			if err := writeV1(current, file, strings.TrimSpace(string(inherited))); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readCgroupInt reads a single integer from a cgroup file.
+func readCgroupInt(path string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
@@ -0,0 +1,443 @@
+package linux
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"runc-go/spec"
+)
+
+const mountinfoUnified = `25 30 0:23 / /sys rw,nosuid,nodev,noexec,relatime shared:7 - sysfs sysfs rw
+32 25 0:28 / /sys/fs/cgroup rw,nosuid,nodev,noexec,relatime shared:9 - cgroup2 cgroup2 rw,nsdelegate,memory_recursiveprot
+`
+
+const mountinfoHybrid = `32 25 0:28 / /sys/fs/cgroup ro,nosuid,nodev,noexec shared:9 - tmpfs tmpfs ro,mode=755
+33 32 0:29 / /sys/fs/cgroup/unified rw,nosuid,nodev,noexec,relatime shared:10 - cgroup2 cgroup2 rw,nsdelegate
+34 32 0:30 / /sys/fs/cgroup/systemd rw,nosuid,nodev,noexec,relatime shared:11 - cgroup cgroup rw,xattr,name=systemd
+37 32 0:33 / /sys/fs/cgroup/cpu,cpuacct rw,nosuid,nodev,noexec,relatime shared:14 - cgroup cgroup rw,cpu,cpuacct
+38 32 0:34 / /sys/fs/cgroup/memory rw,nosuid,nodev,noexec,relatime shared:15 - cgroup cgroup rw,memory
+`
+
+const mountinfoLegacy = `32 25 0:28 / /sys/fs/cgroup ro,nosuid,nodev,noexec shared:9 - tmpfs tmpfs ro,mode=755
+37 32 0:33 / /sys/fs/cgroup/cpu,cpuacct rw,nosuid,nodev,noexec,relatime shared:14 - cgroup cgroup rw,cpu,cpuacct
+38 32 0:34 / /sys/fs/cgroup/memory rw,nosuid,nodev,noexec,relatime shared:15 - cgroup cgroup rw,memory
+`
+
+func TestParseCgroupHierarchy(t *testing.T) {
+	tests := []struct {
+		name        string
+		mountinfo   string
+		mode        CgroupMode
+		unified     string
+		controllers map[string]string
+	}{
+		{"unified", mountinfoUnified, CgroupModeUnified, "/sys/fs/cgroup", nil},
+		{"hybrid", mountinfoHybrid, CgroupModeHybrid, "/sys/fs/cgroup/unified", map[string]string{
+			"cpu":     "/sys/fs/cgroup/cpu,cpuacct",
+			"cpuacct": "/sys/fs/cgroup/cpu,cpuacct",
+			"memory":  "/sys/fs/cgroup/memory",
+		}},
+		{"legacy", mountinfoLegacy, CgroupModeLegacy, "", map[string]string{
+			"cpu":     "/sys/fs/cgroup/cpu,cpuacct",
+			"cpuacct": "/sys/fs/cgroup/cpu,cpuacct",
+			"memory":  "/sys/fs/cgroup/memory",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := parseCgroupHierarchy(strings.NewReader(tt.mountinfo))
+			if err != nil {
+				t.Fatalf("parseCgroupHierarchy failed: %v", err)
+			}
+			if h.Mode != tt.mode {
+				t.Errorf("Mode = %s, want %s", h.Mode, tt.mode)
+			}
+			if h.Unified != tt.unified {
+				t.Errorf("Unified = %q, want %q", h.Unified, tt.unified)
+			}
+			if len(h.Controllers) != len(tt.controllers) {
+				t.Errorf("Controllers = %v, want %v", h.Controllers, tt.controllers)
+			}
+			for c, mnt := range tt.controllers {
+				if h.Controllers[c] != mnt {
+					t.Errorf("Controllers[%s] = %q, want %q", c, h.Controllers[c], mnt)
+				}
+			}
+		})
+	}
+
+	if _, err := parseCgroupHierarchy(strings.NewReader("")); err == nil {
+		t.Error("expected error with no cgroup mounts")
+	}
+}
+
+// fakeV1Hierarchy builds a fake legacy cgroupfs tree under a temp dir.
+func fakeV1Hierarchy(t *testing.T, controllers ...string) *CgroupHierarchy {
+	t.Helper()
+	root := t.TempDir()
+	h := &CgroupHierarchy{Mode: CgroupModeLegacy, Controllers: make(map[string]string)}
+	for _, c := range controllers {
+		mnt := filepath.Join(root, c)
+		if err := os.MkdirAll(mnt, 0755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		for _, name := range strings.Split(c, ",") {
+			h.Controllers[name] = mnt
+		}
+	}
+	return h
+}
+
+// readFakeFile reads a cgroup file written by the backend.
+func readFakeFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	return string(data)
+}
+
+func TestNewCgroupManager_Dispatch(t *testing.T) {
+	root := t.TempDir()
+	v2, err := newCgroupManager(&CgroupHierarchy{Mode: CgroupModeUnified, Unified: root}, "runc-go/c1")
+	if err != nil {
+		t.Fatalf("newCgroupManager(unified) failed: %v", err)
+	}
+	if _, ok := v2.(*Cgroup); !ok {
+		t.Errorf("unified hierarchy gave %T, want *Cgroup", v2)
+	}
+	if v2.Path() != filepath.Join(root, "runc-go/c1") {
+		t.Errorf("Path = %s", v2.Path())
+	}
+
+	v1, err := newCgroupManager(fakeV1Hierarchy(t, "memory"), "runc-go/c1")
+	if err != nil {
+		t.Fatalf("newCgroupManager(legacy) failed: %v", err)
+	}
+	if _, ok := v1.(*CgroupV1); !ok {
+		t.Errorf("legacy hierarchy gave %T, want *CgroupV1", v1)
+	}
+}
+
+func TestCgroupV1_Paths(t *testing.T) {
+	h := fakeV1Hierarchy(t, "cpu,cpuacct", "memory", "pids")
+	h.Mode = CgroupModeHybrid
+	h.Unified = filepath.Join(t.TempDir(), "unified")
+
+	cg, err := newCgroupV1(h, "/runc-go/../../escape/c1")
+	if err != nil {
+		t.Fatalf("newCgroupV1 failed: %v", err)
+	}
+
+	// Co-mounted controllers share a directory; the v2 mount is joined too
+	if len(cg.dirs()) != 4 {
+		t.Errorf("dirs = %v, want 4 distinct directories", cg.dirs())
+	}
+	cpu, _ := cg.ControllerPath("cpu")
+	cpuacct, _ := cg.ControllerPath("cpuacct")
+	if cpu != cpuacct {
+		t.Errorf("cpu %s and cpuacct %s should share a directory", cpu, cpuacct)
+	}
+	if cg.Path() != filepath.Join(h.Controllers["memory"], "escape/c1") {
+		t.Errorf("Path = %s, want the memory directory", cg.Path())
+	}
+	for _, dir := range cg.dirs() {
+		if _, err := os.Stat(dir); err != nil {
+			t.Errorf("directory %s not created: %v", dir, err)
+		}
+	}
+
+	if err := cg.AddProcess(42); err != nil {
+		t.Fatalf("AddProcess failed: %v", err)
+	}
+	for _, dir := range cg.dirs() {
+		if got := readFakeFile(t, filepath.Join(dir, "cgroup.procs")); got != "42" {
+			t.Errorf("%s/cgroup.procs = %q, want 42", dir, got)
+		}
+	}
+
+	if err := cg.Destroy(); err != nil {
+		// The fake cgroup.procs files keep the directories non-empty
+		t.Logf("Destroy: %v", err)
+	}
+}
+
+func TestCgroupV1_ApplyResources(t *testing.T) {
+	h := fakeV1Hierarchy(t, "cpu,cpuacct", "cpuset", "memory", "pids", "devices", "freezer")
+	if err := os.WriteFile(filepath.Join(h.Controllers["cpuset"], "cpuset.cpus"), []byte("0-3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(h.Controllers["cpuset"], "cpuset.mems"), []byte("0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cg, err := newCgroupV1(h, "runc-go/c1")
+	if err != nil {
+		t.Fatalf("newCgroupV1 failed: %v", err)
+	}
+
+	// The intermediate and leaf cpuset directories inherit from the root
+	for _, rel := range []string{"runc-go", "runc-go/c1"} {
+		dir := filepath.Join(h.Controllers["cpuset"], rel)
+		if got := readFakeFile(t, filepath.Join(dir, "cpuset.cpus")); got != "0-3" {
+			t.Errorf("%s cpuset.cpus = %q, want 0-3", rel, got)
+		}
+		if got := readFakeFile(t, filepath.Join(dir, "cpuset.mems")); got != "0" {
+			t.Errorf("%s cpuset.mems = %q, want 0", rel, got)
+		}
+	}
+
+	limit := int64(256 << 20)
+	reservation := int64(128 << 20)
+	shares := uint64(512)
+	quota := int64(50000)
+	period := uint64(100000)
+	swappiness := uint64(10)
+	major, minor := int64(1), int64(3)
+	resources := &spec.LinuxResources{
+		Memory: &spec.LinuxMemory{Limit: &limit, Reservation: &reservation, Swappiness: &swappiness},
+		CPU:    &spec.LinuxCPU{Shares: &shares, Quota: &quota, Period: &period, Cpus: "1"},
+		Pids:   &spec.LinuxPids{Limit: 64},
+		Devices: []spec.LinuxDeviceCgroup{
+			{Allow: false, Access: "rwm"},
+			{Allow: true, Type: "c", Major: &major, Minor: &minor, Access: "rwm"},
+		},
+	}
+	if err := cg.ApplyResources(resources); err != nil {
+		t.Fatalf("ApplyResources failed: %v", err)
+	}
+
+	cpuDir, _ := cg.ControllerPath("cpu")
+	memDir, _ := cg.ControllerPath("memory")
+	cpusetDir, _ := cg.ControllerPath("cpuset")
+	pidsDir, _ := cg.ControllerPath("pids")
+	devDir, _ := cg.ControllerPath("devices")
+	want := map[string]string{
+		filepath.Join(memDir, "memory.limit_in_bytes"):      "268435456",
+		filepath.Join(memDir, "memory.soft_limit_in_bytes"): "134217728",
+		filepath.Join(memDir, "memory.swappiness"):          "10",
+		filepath.Join(cpuDir, "cpu.shares"):                 "512",
+		filepath.Join(cpuDir, "cpu.cfs_period_us"):          "100000",
+		filepath.Join(cpuDir, "cpu.cfs_quota_us"):           "50000",
+		filepath.Join(cpusetDir, "cpuset.cpus"):             "1",
+		filepath.Join(pidsDir, "pids.max"):                  "64",
+		filepath.Join(devDir, "devices.deny"):               "a *:* rwm",
+		filepath.Join(devDir, "devices.allow"):              "c 1:3 rwm",
+	}
+	for path, value := range want {
+		if got := readFakeFile(t, path); got != value {
+			t.Errorf("%s = %q, want %q", path, got, value)
+		}
+	}
+
+	if err := cg.Freeze(); err != nil {
+		t.Fatalf("Freeze failed: %v", err)
+	}
+	freezerDir, _ := cg.ControllerPath("freezer")
+	if got := readFakeFile(t, filepath.Join(freezerDir, "freezer.state")); got != "FROZEN" {
+		t.Errorf("freezer.state = %q, want FROZEN", got)
+	}
+	if err := cg.Thaw(); err != nil {
+		t.Fatalf("Thaw failed: %v", err)
+	}
+	if got := readFakeFile(t, filepath.Join(freezerDir, "freezer.state")); got != "THAWED" {
+		t.Errorf("freezer.state = %q, want THAWED", got)
+	}
+}
+
+func TestCgroupV1_SwapOrdering(t *testing.T) {
+	h := fakeV1Hierarchy(t, "memory")
+	cg, err := newCgroupV1(h, "c1")
+	if err != nil {
+		t.Fatalf("newCgroupV1 failed: %v", err)
+	}
+	memDir, _ := cg.ControllerPath("memory")
+	if err := os.WriteFile(filepath.Join(memDir, "memory.memsw.limit_in_bytes"), []byte("1000\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	limit, swap := int64(2000), int64(4000)
+	if err := cg.applyMemory(&spec.LinuxMemory{Limit: &limit, Swap: &swap}); err != nil {
+		t.Fatalf("applyMemory failed: %v", err)
+	}
+	if got := readFakeFile(t, filepath.Join(memDir, "memory.memsw.limit_in_bytes")); got != "4000" {
+		t.Errorf("memsw = %q, want 4000", got)
+	}
+	if got := readFakeFile(t, filepath.Join(memDir, "memory.limit_in_bytes")); got != "2000" {
+		t.Errorf("limit = %q, want 2000", got)
+	}
+}
+
+func TestCgroupV1_Errors(t *testing.T) {
+	cg, err := newCgroupV1(fakeV1Hierarchy(t, "memory"), "c1")
+	if err != nil {
+		t.Fatalf("newCgroupV1 failed: %v", err)
+	}
+
+	pids := &spec.LinuxResources{Pids: &spec.LinuxPids{Limit: 10}}
+	if err := cg.ApplyResources(pids); err == nil || !strings.Contains(err.Error(), "pids controller") {
+		t.Errorf("expected missing pids controller error, got %v", err)
+	}
+
+	unified := &spec.LinuxResources{Unified: map[string]string{"memory.high": "1G"}}
+	if err := cg.ApplyResources(unified); err == nil {
+		t.Error("expected error for unified resources on cgroup v1")
+	}
+
+	if err := cg.Freeze(); err == nil {
+		t.Error("expected error freezing without the freezer controller")
+	}
+}
+
+func TestCgroupV1_BlockIO(t *testing.T) {
+	h := fakeV1Hierarchy(t, "blkio")
+	cg, err := newCgroupV1(h, "c1")
+	if err != nil {
+		t.Fatalf("newCgroupV1 failed: %v", err)
+	}
+	weight := uint16(300)
+	leaf := uint16(200)
+	err = cg.ApplyResources(&spec.LinuxResources{BlockIO: &spec.LinuxBlockIO{
+		Weight:                  &weight,
+		LeafWeight:              &leaf,
+		WeightDevice:            []spec.LinuxWeightDevice{{Major: 8, Minor: 0, Weight: &weight}},
+		ThrottleWriteBpsDevice:  []spec.LinuxThrottleDevice{{Major: 8, Minor: 0, Rate: 1024}},
+		ThrottleWriteIOPSDevice: []spec.LinuxThrottleDevice{{Major: 8, Minor: 16, Rate: 0}},
+	}})
+	if err != nil {
+		t.Fatalf("ApplyResources failed: %v", err)
+	}
+
+	dir, _ := cg.ControllerPath("blkio")
+	want := map[string]string{
+		"blkio.weight":                     "300",
+		"blkio.leaf_weight":                "200",
+		"blkio.weight_device":              "8:0 300",
+		"blkio.throttle.write_bps_device":  "8:0 1024",
+		"blkio.throttle.write_iops_device": "8:16 0",
+	}
+	for file, value := range want {
+		if got := readFakeFile(t, filepath.Join(dir, file)); got != value {
+			t.Errorf("%s = %q, want %q", file, got, value)
+		}
+	}
+
+	// blkio must be mounted for block I/O settings
+	cg, _ = newCgroupV1(fakeV1Hierarchy(t, "memory"), "c2")
+	if err := cg.ApplyResources(&spec.LinuxResources{BlockIO: &spec.LinuxBlockIO{Weight: &weight}}); err == nil {
+		t.Error("ApplyResources without blkio should fail")
+	}
+}
+
+func TestCgroupV1_ExtraKnobs(t *testing.T) {
+	h := fakeV1Hierarchy(t, "cpu,cpuacct", "hugetlb", "rdma")
+	cg, err := newCgroupV1(h, "c1")
+	if err != nil {
+		t.Fatalf("newCgroupV1 failed: %v", err)
+	}
+	burst := uint64(10000)
+	runtime := int64(950000)
+	period := uint64(1000000)
+	handles := uint32(2)
+	err = cg.ApplyResources(&spec.LinuxResources{
+		CPU:            &spec.LinuxCPU{Burst: &burst, RealtimeRuntime: &runtime, RealtimePeriod: &period},
+		HugepageLimits: []spec.LinuxHugepageLimit{{Pagesize: "1GB", Limit: 1 << 30}},
+		Rdma:           map[string]spec.LinuxRdma{"mlx4_0": {HcaHandles: &handles}},
+	})
+	if err != nil {
+		t.Fatalf("ApplyResources failed: %v", err)
+	}
+
+	cpuDir, _ := cg.ControllerPath("cpu")
+	hugeDir, _ := cg.ControllerPath("hugetlb")
+	rdmaDir, _ := cg.ControllerPath("rdma")
+	want := map[string]string{
+		filepath.Join(cpuDir, "cpu.cfs_burst_us"):            "10000",
+		filepath.Join(cpuDir, "cpu.rt_period_us"):            "1000000",
+		filepath.Join(cpuDir, "cpu.rt_runtime_us"):           "950000",
+		filepath.Join(hugeDir, "hugetlb.1GB.limit_in_bytes"): "1073741824",
+		filepath.Join(rdmaDir, "rdma.max"):                   "mlx4_0 hca_handle=2 hca_object=max",
+	}
+	for path, value := range want {
+		if got := readFakeFile(t, path); got != value {
+			t.Errorf("%s = %q, want %q", filepath.Base(path), got, value)
+		}
+	}
+
+	idle := int64(1)
+	if err := cg.ApplyResources(&spec.LinuxResources{CPU: &spec.LinuxCPU{Idle: &idle}}); err == nil {
+		t.Error("cpu.idle should be rejected on cgroup v1")
+	}
+}
+
+func TestCgroupV1_CheckBeforeUpdate(t *testing.T) {
+	h := fakeV1Hierarchy(t, "memory")
+	cg, err := newCgroupV1(h, "c1")
+	if err != nil {
+		t.Fatalf("newCgroupV1 failed: %v", err)
+	}
+	memDir, _ := cg.ControllerPath("memory")
+	if err := os.WriteFile(filepath.Join(memDir, "memory.usage_in_bytes"), []byte("5000\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	limit := int64(4096)
+	check := true
+	err = cg.ApplyResources(&spec.LinuxResources{Memory: &spec.LinuxMemory{Limit: &limit, CheckBeforeUpdate: &check}})
+	if err == nil || !strings.Contains(err.Error(), "below current usage") {
+		t.Errorf("error = %v, want usage check failure", err)
+	}
+}
+
+func TestCgroupV1_MemoryStats(t *testing.T) {
+	h := fakeV1Hierarchy(t, "memory")
+	cg, err := newCgroupV1(h, "/runc-go/c1")
+	if err != nil {
+		t.Fatalf("newCgroupV1 failed: %v", err)
+	}
+	dir, _ := cg.ControllerPath("memory")
+	os.WriteFile(filepath.Join(dir, "memory.failcnt"), []byte("12\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "memory.oom_control"), []byte("oom_kill_disable 0\nunder_oom 0\noom_kill 3\n"), 0644)
+
+	events, err := cg.GetMemoryEvents()
+	if err != nil {
+		t.Fatalf("GetMemoryEvents failed: %v", err)
+	}
+	if want := (spec.MemoryEvents{Max: 12, OOMKill: 3}); *events != want {
+		t.Errorf("events = %+v, want %+v", *events, want)
+	}
+
+	// Legacy hierarchies have no PSI
+	if _, err := cg.GetMemoryPressure(); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("GetMemoryPressure = %v, want ErrUnsupported", err)
+	}
+
+	h.Mode = CgroupModeHybrid
+	h.Unified = t.TempDir()
+	hybrid, err := newCgroupV1(h, "/runc-go/c1")
+	if err != nil {
+		t.Fatalf("newCgroupV1 failed: %v", err)
+	}
+	os.WriteFile(filepath.Join(h.Unified, "runc-go/c1/memory.pressure"), []byte("some avg10=1.00 avg60=0.00 avg300=0.00 total=5\n"), 0644)
+	pressure, err := hybrid.GetMemoryPressure()
+	if err != nil {
+		t.Fatalf("GetMemoryPressure (hybrid) failed: %v", err)
+	}
+	if pressure.Some.Total != 5 {
+		t.Errorf("pressure = %+v", *pressure)
+	}
+}
+
+func TestOpenCgroupManager_Missing(t *testing.T) {
+	if _, err := DetectCgroupHierarchy(); err != nil {
+		t.Skipf("no cgroup hierarchy: %v", err)
+	}
+	_, err := OpenCgroupManager("/runc-go-test-missing/c1")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("OpenCgroupManager = %v, want ErrNotExist", err)
+	}
+}
@@ -0,0 +1,743 @@
+// Package linux provides a minimal D-Bus client.
+// Only what the systemd cgroup driver needs is implemented: EXTERNAL
+// authentication over a unix socket, method calls and signals, and the
+// basic, array, struct and variant types of the wire format.
+// Reference: https://dbus.freedesktop.org/doc/dbus-specification.html
+package linux
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// D-Bus message types
+const (
+	dbusTypeMethodCall   = 1
+	dbusTypeMethodReturn = 2
+	dbusTypeError        = 3
+	dbusTypeSignal       = 4
+)
+
+// D-Bus header field codes
+const (
+	dbusFieldPath        = 1
+	dbusFieldInterface   = 2
+	dbusFieldMember      = 3
+	dbusFieldErrorName   = 4
+	dbusFieldReplySerial = 5
+	dbusFieldDestination = 6
+	dbusFieldSender      = 7
+	dbusFieldSignature   = 8
+)
+
+const (
+	// dbusMaxMessage is the largest message the spec allows (128 MiB).
+	dbusMaxMessage = 128 << 20
+
+	// dbusCallTimeout bounds a single method call.
+	dbusCallTimeout = 30 * time.Second
+)
+
+// dbusVariant is a value tagged with its D-Bus signature.
+type dbusVariant struct {
+	Sig   string
+	Value interface{}
+}
+
+// dbusMessage is a decoded D-Bus message.
+type dbusMessage struct {
+	Type        byte
+	Flags       byte
+	Serial      uint32
+	Path        string
+	Interface   string
+	Member      string
+	ErrorName   string
+	ReplySerial uint32
+	Destination string
+	Sender      string
+	Signature   string
+	Body        []interface{}
+}
+
+// dbusError is an error reply from a D-Bus peer.
+type dbusError struct {
+	Name    string
+	Message string
+}
+
+// Error implements the error interface.
+func (e *dbusError) Error() string {
+	if e.Message == "" {
+		return e.Name
+	}
+	return fmt.Sprintf("%s: %s", e.Name, e.Message)
+}
+
+// ============================================================================
+// Signatures
+// ============================================================================
+
+// dbusNextType splits the first complete type off a signature.
+func dbusNextType(sig string) (string, string, error) {
+	if sig == "" {
+		return "", "", fmt.Errorf("empty signature")
+	}
+	switch sig[0] {
+	case 'y', 'b', 'n', 'q', 'i', 'u', 'x', 't', 'd', 's', 'o', 'g', 'v', 'h':
+		return sig[:1], sig[1:], nil
+	case 'a':
+		elem, rest, err := dbusNextType(sig[1:])
+		if err != nil {
+			return "", "", err
+		}
+		return "a" + elem, rest, nil
+	case '(', '{':
+		closing := byte(')')
+		if sig[0] == '{' {
+			closing = '}'
+		}
+		rest := sig[1:]
+		for {
+			if rest == "" {
+				return "", "", fmt.Errorf("unterminated struct in signature %q", sig)
+			}
+			if rest[0] == closing {
+				n := len(sig) - len(rest) + 1
+				return sig[:n], sig[n:], nil
+			}
+			var err error
+			if _, rest, err = dbusNextType(rest); err != nil {
+				return "", "", err
+			}
+		}
+	default:
+		return "", "", fmt.Errorf("unsupported type %q in signature", sig[0])
+	}
+}
+
+// dbusSplitSignature splits a signature into its complete types.
+func dbusSplitSignature(sig string) ([]string, error) {
+	var types []string
+	for sig != "" {
+		t, rest, err := dbusNextType(sig)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, t)
+		sig = rest
+	}
+	return types, nil
+}
+
+// dbusAlignment returns the alignment of the first type in a signature.
+func dbusAlignment(sig string) int {
+	switch sig[0] {
+	case 'n', 'q':
+		return 2
+	case 'b', 'i', 'u', 's', 'o', 'a', 'h':
+		return 4
+	case 'x', 't', 'd', '(', '{':
+		return 8
+	default:
+		return 1
+	}
+}
+
+// ============================================================================
+// Encoding
+// ============================================================================
+
+// dbusEncoder marshals values in little-endian wire format.
+type dbusEncoder struct {
+	buf []byte
+}
+
+// align pads the buffer to a multiple of n.
+func (e *dbusEncoder) align(n int) {
+	for len(e.buf)%n != 0 {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+// uint32 appends an aligned 32-bit value.
+func (e *dbusEncoder) uint32(v uint32) {
+	e.align(4)
+	e.buf = binary.LittleEndian.AppendUint32(e.buf, v)
+}
+
+// encode marshals v as the single complete type sig.
+func (e *dbusEncoder) encode(sig string, v interface{}) error {
+	mismatch := func() error {
+		return fmt.Errorf("cannot encode %T as %q", v, sig)
+	}
+
+	switch sig[0] {
+	case 'y':
+		b, ok := v.(byte)
+		if !ok {
+			return mismatch()
+		}
+		e.buf = append(e.buf, b)
+	case 'b':
+		b, ok := v.(bool)
+		if !ok {
+			return mismatch()
+		}
+		var n uint32
+		if b {
+			n = 1
+		}
+		e.uint32(n)
+	case 'n', 'q':
+		var n uint16
+		switch x := v.(type) {
+		case int16:
+			n = uint16(x)
+		case uint16:
+			n = x
+		default:
+			return mismatch()
+		}
+		e.align(2)
+		e.buf = binary.LittleEndian.AppendUint16(e.buf, n)
+	case 'i', 'u', 'h':
+		var n uint32
+		switch x := v.(type) {
+		case int32:
+			n = uint32(x)
+		case uint32:
+			n = x
+		default:
+			return mismatch()
+		}
+		e.uint32(n)
+	case 'x', 't', 'd':
+		var n uint64
+		switch x := v.(type) {
+		case int64:
+			n = uint64(x)
+		case uint64:
+			n = x
+		case float64:
+			n = math.Float64bits(x)
+		default:
+			return mismatch()
+		}
+		e.align(8)
+		e.buf = binary.LittleEndian.AppendUint64(e.buf, n)
+	case 's', 'o':
+		s, ok := v.(string)
+		if !ok {
+			return mismatch()
+		}
+		e.uint32(uint32(len(s)))
+		e.buf = append(e.buf, s...)
+		e.buf = append(e.buf, 0)
+	case 'g':
+		s, ok := v.(string)
+		if !ok || len(s) > 255 {
+			return mismatch()
+		}
+		e.buf = append(e.buf, byte(len(s)))
+		e.buf = append(e.buf, s...)
+		e.buf = append(e.buf, 0)
+	case 'v':
+		variant, ok := v.(dbusVariant)
+		if !ok {
+			return mismatch()
+		}
+		if err := e.encode("g", variant.Sig); err != nil {
+			return err
+		}
+		return e.encode(variant.Sig, variant.Value)
+	case 'a':
+		elem := sig[1:]
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Slice {
+			return mismatch()
+		}
+		e.uint32(0)
+		lenPos := len(e.buf) - 4
+		// Padding to the first element is not counted in the length
+		e.align(dbusAlignment(elem))
+		start := len(e.buf)
+		for i := 0; i < rv.Len(); i++ {
+			if err := e.encode(elem, rv.Index(i).Interface()); err != nil {
+				return err
+			}
+		}
+		binary.LittleEndian.PutUint32(e.buf[lenPos:], uint32(len(e.buf)-start))
+	case '(', '{':
+		fields, ok := v.([]interface{})
+		if !ok {
+			return mismatch()
+		}
+		types, err := dbusSplitSignature(sig[1 : len(sig)-1])
+		if err != nil {
+			return err
+		}
+		if len(types) != len(fields) {
+			return fmt.Errorf("struct %q has %d fields, got %d", sig, len(types), len(fields))
+		}
+		e.align(8)
+		for i, t := range types {
+			if err := e.encode(t, fields[i]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported type %q", sig)
+	}
+	return nil
+}
+
+// ============================================================================
+// Decoding
+// ============================================================================
+
+// dbusDecoder unmarshals values from wire format.
+type dbusDecoder struct {
+	buf   []byte
+	pos   int
+	order binary.ByteOrder
+}
+
+// align skips padding to a multiple of n.
+func (d *dbusDecoder) align(n int) error {
+	for d.pos%n != 0 {
+		if d.pos >= len(d.buf) {
+			return io.ErrUnexpectedEOF
+		}
+		d.pos++
+	}
+	return nil
+}
+
+// take returns the next n bytes.
+func (d *dbusDecoder) take(n int) ([]byte, error) {
+	if n < 0 || d.pos+n > len(d.buf) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := d.buf[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+// fixed reads an aligned fixed-size value of 1, 2, 4 or 8 bytes.
+func (d *dbusDecoder) fixed(size int) (uint64, error) {
+	if err := d.align(size); err != nil {
+		return 0, err
+	}
+	b, err := d.take(size)
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(d.order.Uint16(b)), nil
+	case 4:
+		return uint64(d.order.Uint32(b)), nil
+	default:
+		return d.order.Uint64(b), nil
+	}
+}
+
+// decode unmarshals a single complete type.
+func (d *dbusDecoder) decode(sig string) (interface{}, error) {
+	switch sig[0] {
+	case 'y':
+		n, err := d.fixed(1)
+		return byte(n), err
+	case 'b':
+		n, err := d.fixed(4)
+		return n != 0, err
+	case 'n':
+		n, err := d.fixed(2)
+		return int16(n), err
+	case 'q':
+		n, err := d.fixed(2)
+		return uint16(n), err
+	case 'i':
+		n, err := d.fixed(4)
+		return int32(n), err
+	case 'u', 'h':
+		n, err := d.fixed(4)
+		return uint32(n), err
+	case 'x':
+		n, err := d.fixed(8)
+		return int64(n), err
+	case 't':
+		return d.fixed(8)
+	case 'd':
+		n, err := d.fixed(8)
+		return math.Float64frombits(n), err
+	case 's', 'o':
+		n, err := d.fixed(4)
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.take(int(n) + 1)
+		if err != nil {
+			return nil, err
+		}
+		return string(b[:n]), nil
+	case 'g':
+		n, err := d.fixed(1)
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.take(int(n) + 1)
+		if err != nil {
+			return nil, err
+		}
+		return string(b[:n]), nil
+	case 'v':
+		s, err := d.decode("g")
+		if err != nil {
+			return nil, err
+		}
+		sig := s.(string)
+		if _, rest, err := dbusNextType(sig); err != nil || rest != "" {
+			return nil, fmt.Errorf("invalid variant signature %q", sig)
+		}
+		value, err := d.decode(sig)
+		if err != nil {
+			return nil, err
+		}
+		return dbusVariant{Sig: sig, Value: value}, nil
+	case 'a':
+		n, err := d.fixed(4)
+		if err != nil {
+			return nil, err
+		}
+		if n > dbusMaxMessage {
+			return nil, fmt.Errorf("array too long: %d", n)
+		}
+		elem := sig[1:]
+		if err := d.align(dbusAlignment(elem)); err != nil {
+			return nil, err
+		}
+		end := d.pos + int(n)
+		if end > len(d.buf) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if elem == "y" {
+			b, _ := d.take(int(n))
+			return append([]byte(nil), b...), nil
+		}
+		items := []interface{}{}
+		for d.pos < end {
+			item, err := d.decode(elem)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case '(', '{':
+		types, err := dbusSplitSignature(sig[1 : len(sig)-1])
+		if err != nil {
+			return nil, err
+		}
+		if err := d.align(8); err != nil {
+			return nil, err
+		}
+		fields := make([]interface{}, 0, len(types))
+		for _, t := range types {
+			field, err := d.decode(t)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, field)
+		}
+		return fields, nil
+	default:
+		return nil, fmt.Errorf("unsupported type %q", sig)
+	}
+}
+
+// ============================================================================
+// Messages
+// ============================================================================
+
+// marshal encodes the message for the wire.
+func (m *dbusMessage) marshal() ([]byte, error) {
+	types, err := dbusSplitSignature(m.Signature)
+	if err != nil {
+		return nil, err
+	}
+	if len(types) != len(m.Body) {
+		return nil, fmt.Errorf("signature %q has %d types, got %d values", m.Signature, len(types), len(m.Body))
+	}
+
+	// The body starts 8-aligned, so it can be encoded on its own
+	body := &dbusEncoder{}
+	for i, t := range types {
+		if err := body.encode(t, m.Body[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	var fields []interface{}
+	addField := func(code byte, sig string, value interface{}) {
+		fields = append(fields, []interface{}{code, dbusVariant{Sig: sig, Value: value}})
+	}
+	if m.Path != "" {
+		addField(dbusFieldPath, "o", m.Path)
+	}
+	if m.Interface != "" {
+		addField(dbusFieldInterface, "s", m.Interface)
+	}
+	if m.Member != "" {
+		addField(dbusFieldMember, "s", m.Member)
+	}
+	if m.ErrorName != "" {
+		addField(dbusFieldErrorName, "s", m.ErrorName)
+	}
+	if m.ReplySerial != 0 {
+		addField(dbusFieldReplySerial, "u", m.ReplySerial)
+	}
+	if m.Destination != "" {
+		addField(dbusFieldDestination, "s", m.Destination)
+	}
+	if m.Sender != "" {
+		addField(dbusFieldSender, "s", m.Sender)
+	}
+	if m.Signature != "" {
+		addField(dbusFieldSignature, "g", m.Signature)
+	}
+
+	e := &dbusEncoder{buf: []byte{'l', m.Type, m.Flags, 1}}
+	e.uint32(uint32(len(body.buf)))
+	e.uint32(m.Serial)
+	if err := e.encode("a(yv)", fields); err != nil {
+		return nil, err
+	}
+	e.align(8)
+	return append(e.buf, body.buf...), nil
+}
+
+// readDBusMessage reads and decodes one message.
+func readDBusMessage(r io.Reader) (*dbusMessage, error) {
+	// Fixed header plus the header field array length
+	fixed := make([]byte, 16)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, err
+	}
+
+	var order binary.ByteOrder
+	switch fixed[0] {
+	case 'l':
+		order = binary.LittleEndian
+	case 'B':
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("invalid endianness %q", fixed[0])
+	}
+	if fixed[3] != 1 {
+		return nil, fmt.Errorf("unsupported protocol version %d", fixed[3])
+	}
+
+	bodyLen := int(order.Uint32(fixed[4:]))
+	fieldsLen := int(order.Uint32(fixed[12:]))
+	headerLen := 16 + fieldsLen
+	padded := (headerLen + 7) &^ 7
+	if bodyLen > dbusMaxMessage || fieldsLen > dbusMaxMessage {
+		return nil, fmt.Errorf("message too large")
+	}
+
+	buf := make([]byte, padded+bodyLen)
+	copy(buf, fixed)
+	if _, err := io.ReadFull(r, buf[16:]); err != nil {
+		return nil, err
+	}
+
+	m := &dbusMessage{
+		Type:   fixed[1],
+		Flags:  fixed[2],
+		Serial: order.Uint32(fixed[8:]),
+	}
+
+	d := &dbusDecoder{buf: buf[:headerLen], pos: 12, order: order}
+	raw, err := d.decode("a(yv)")
+	if err != nil {
+		return nil, fmt.Errorf("decode header: %w", err)
+	}
+	for _, f := range raw.([]interface{}) {
+		field := f.([]interface{})
+		value := field[1].(dbusVariant).Value
+		switch field[0].(byte) {
+		case dbusFieldPath:
+			m.Path, _ = value.(string)
+		case dbusFieldInterface:
+			m.Interface, _ = value.(string)
+		case dbusFieldMember:
+			m.Member, _ = value.(string)
+		case dbusFieldErrorName:
+			m.ErrorName, _ = value.(string)
+		case dbusFieldReplySerial:
+			m.ReplySerial, _ = value.(uint32)
+		case dbusFieldDestination:
+			m.Destination, _ = value.(string)
+		case dbusFieldSender:
+			m.Sender, _ = value.(string)
+		case dbusFieldSignature:
+			m.Signature, _ = value.(string)
+		}
+	}
+
+	types, err := dbusSplitSignature(m.Signature)
+	if err != nil {
+		return nil, err
+	}
+	// Body offsets are relative to the start of the body
+	d = &dbusDecoder{buf: buf[padded:], order: order}
+	for _, t := range types {
+		value, err := d.decode(t)
+		if err != nil {
+			return nil, fmt.Errorf("decode body: %w", err)
+		}
+		m.Body = append(m.Body, value)
+	}
+	return m, nil
+}
+
+// ============================================================================
+// Connection
+// ============================================================================
+
+// dbusConn is a synchronous D-Bus connection. Signals that arrive while
+// waiting for a method reply are queued for waitSignal.
+type dbusConn struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	serial uint32
+	queue  []*dbusMessage
+}
+
+// dialDBus connects to a D-Bus unix socket and authenticates.
+func dialDBus(path string) (*dbusConn, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := newDBusConn(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// newDBusConn authenticates over an established connection using the
+// EXTERNAL mechanism, which identifies us by our socket credentials.
+func newDBusConn(conn net.Conn) (*dbusConn, error) {
+	c := &dbusConn{conn: conn, r: bufio.NewReader(conn)}
+	conn.SetDeadline(time.Now().Add(dbusCallTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	uid := hex.EncodeToString([]byte(strconv.Itoa(os.Getuid())))
+	if _, err := fmt.Fprintf(conn, "\x00AUTH EXTERNAL %s\r\n", uid); err != nil {
+		return nil, fmt.Errorf("dbus auth: %w", err)
+	}
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("dbus auth: %w", err)
+	}
+	if !strings.HasPrefix(line, "OK ") {
+		return nil, fmt.Errorf("dbus auth rejected: %s", strings.TrimSpace(line))
+	}
+	if _, err := io.WriteString(conn, "BEGIN\r\n"); err != nil {
+		return nil, fmt.Errorf("dbus auth: %w", err)
+	}
+	return c, nil
+}
+
+// Close closes the connection.
+func (c *dbusConn) Close() error {
+	return c.conn.Close()
+}
+
+// call invokes a method and returns the reply body.
+func (c *dbusConn) call(dest, path, iface, member, sig string, args ...interface{}) ([]interface{}, error) {
+	c.serial++
+	msg := &dbusMessage{
+		Type:        dbusTypeMethodCall,
+		Serial:      c.serial,
+		Path:        path,
+		Interface:   iface,
+		Member:      member,
+		Destination: dest,
+		Signature:   sig,
+		Body:        args,
+	}
+	data, err := msg.marshal()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", member, err)
+	}
+
+	c.conn.SetDeadline(time.Now().Add(dbusCallTimeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	if _, err := c.conn.Write(data); err != nil {
+		return nil, fmt.Errorf("%s: %w", member, err)
+	}
+
+	for {
+		reply, err := readDBusMessage(c.r)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", member, err)
+		}
+		switch {
+		case reply.Type == dbusTypeSignal:
+			c.queue = append(c.queue, reply)
+		case reply.ReplySerial != msg.Serial:
+			// Not ours; nothing else is outstanding
+		case reply.Type == dbusTypeError:
+			e := &dbusError{Name: reply.ErrorName}
+			if len(reply.Body) > 0 {
+				e.Message, _ = reply.Body[0].(string)
+			}
+			return nil, e
+		default:
+			return reply.Body, nil
+		}
+	}
+}
+
+// waitSignal returns the first signal accepted by match, including any
+// queued while waiting for method replies.
+func (c *dbusConn) waitSignal(match func(*dbusMessage) bool, timeout time.Duration) (*dbusMessage, error) {
+	for i, m := range c.queue {
+		if match(m) {
+			c.queue = append(c.queue[:i], c.queue[i+1:]...)
+			return m, nil
+		}
+	}
+
+	c.conn.SetDeadline(time.Now().Add(timeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	for {
+		m, err := readDBusMessage(c.r)
+		if err != nil {
+			return nil, err
+		}
+		if m.Type == dbusTypeSignal && match(m) {
+			return m, nil
+		}
+	}
+}
@@ -0,0 +1,343 @@
+// Package linux provides the host's device policy.
+package linux
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"runc-go/spec"
+)
+
+// DevicePolicyPath is the host's device policy. Without the file, the
+// built-in policy applies.
+var DevicePolicyPath = "/etc/runc-go/devices.json"
+
+// DeviceRule matches device nodes by type, numbers and path. An empty
+// field matches anything.
+type DeviceRule struct {
+	// Type is "c" or "b"; "a" or empty matches both.
+	Type  string `json:"type,omitempty"`
+	Major *int64 `json:"major,omitempty"`
+	Minor *int64 `json:"minor,omitempty"`
+	// Path is a glob over the node's path in the container, like
+	// /dev/loop*.
+	Path string `json:"path,omitempty"`
+}
+
+// DevicePolicy decides which device nodes a container may get. A device
+// must match an allow rule and no deny rule. FIFOs are not devices and
+// are always allowed.
+type DevicePolicy struct {
+	// Defaults keeps the built-in allow rules in front of Allow; unset
+	// means true.
+	Defaults *bool        `json:"defaults,omitempty"`
+	Allow    []DeviceRule `json:"allow,omitempty"`
+	Deny     []DeviceRule `json:"deny,omitempty"`
+
+	// source is the file the policy was loaded from, empty for the
+	// built-in policy.
+	source string
+}
+
+// deviceNumber returns a rule's major or minor number.
+func deviceNumber(n int64) *int64 {
+	return &n
+}
+
+// builtinDeviceRules allow the devices a container commonly needs and
+// nothing that reaches the host's disks or memory, like /dev/sda or
+// /dev/mem.
+var builtinDeviceRules = []DeviceRule{
+	{Type: "c", Major: deviceNumber(1), Minor: deviceNumber(3)},  // /dev/null
+	{Type: "c", Major: deviceNumber(1), Minor: deviceNumber(5)},  // /dev/zero
+	{Type: "c", Major: deviceNumber(1), Minor: deviceNumber(7)},  // /dev/full
+	{Type: "c", Major: deviceNumber(1), Minor: deviceNumber(8)},  // /dev/random
+	{Type: "c", Major: deviceNumber(1), Minor: deviceNumber(9)},  // /dev/urandom
+	{Type: "c", Major: deviceNumber(1), Minor: deviceNumber(11)}, // /dev/kmsg
+	{Type: "c", Major: deviceNumber(5), Minor: deviceNumber(0)},  // /dev/tty
+	{Type: "c", Major: deviceNumber(5), Minor: deviceNumber(1)},  // /dev/console
+	{Type: "c", Major: deviceNumber(5), Minor: deviceNumber(2)},  // /dev/ptmx
+	{Type: "c", Major: deviceNumber(136)},                        // unix98 PTY slaves
+}
+
+// DefaultDevicePolicy returns the built-in policy.
+func DefaultDevicePolicy() *DevicePolicy {
+	return &DevicePolicy{Allow: append([]DeviceRule(nil), builtinDeviceRules...)}
+}
+
+// LoadDevicePolicy reads a device policy file, or returns the built-in
+// policy when there is none. The built-in allow rules come first unless
+// the file sets "defaults" to false.
+func LoadDevicePolicy(path string) (*DevicePolicy, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return DefaultDevicePolicy(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read device policy: %w", err)
+	}
+	var p DevicePolicy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("parse device policy %s: %w", path, err)
+	}
+	for _, r := range append(p.Allow, p.Deny...) {
+		if err := r.validate(); err != nil {
+			return nil, fmt.Errorf("device policy %s: %w", path, err)
+		}
+	}
+	if p.Defaults == nil || *p.Defaults {
+		p.Allow = append(append([]DeviceRule(nil), builtinDeviceRules...), p.Allow...)
+	}
+	p.source = path
+	return &p, nil
+}
+
+// encodedDevicePolicy is a loaded policy as it is passed to the init,
+// with the built-in rules already in Allow.
+type encodedDevicePolicy struct {
+	Source string       `json:"source,omitempty"`
+	Allow  []DeviceRule `json:"allow,omitempty"`
+	Deny   []DeviceRule `json:"deny,omitempty"`
+}
+
+// Encode returns the policy as a string for DecodeDevicePolicy, so the
+// init checks devices against the policy its create loaded.
+func (p *DevicePolicy) Encode() (string, error) {
+	data, err := json.Marshal(encodedDevicePolicy{Source: p.source, Allow: p.Allow, Deny: p.Deny})
+	if err != nil {
+		return "", fmt.Errorf("encode device policy: %w", err)
+	}
+	return string(data), nil
+}
+
+// DecodeDevicePolicy returns the policy Encode returned data for.
+func DecodeDevicePolicy(data string) (*DevicePolicy, error) {
+	var e encodedDevicePolicy
+	if err := json.Unmarshal([]byte(data), &e); err != nil {
+		return nil, fmt.Errorf("decode device policy: %w", err)
+	}
+	return &DevicePolicy{Allow: e.Allow, Deny: e.Deny, source: e.Source}, nil
+}
+
+// Source returns the file the policy was loaded from, or "built-in".
+func (p *DevicePolicy) Source() string {
+	if p.source == "" {
+		return "built-in"
+	}
+	return p.source
+}
+
+// validate checks a rule's type and path glob.
+func (r DeviceRule) validate() error {
+	switch r.Type {
+	case "", "a", "c", "b":
+	default:
+		return fmt.Errorf("rule %s: invalid device type %q", r, r.Type)
+	}
+	if r.Path != "" {
+		if !strings.HasPrefix(r.Path, "/dev/") {
+			return fmt.Errorf("rule %s: path must be under /dev", r)
+		}
+		if _, err := filepath.Match(r.Path, "/dev"); err != nil {
+			return fmt.Errorf("rule %s: %w", r, err)
+		}
+	}
+	return nil
+}
+
+// matches reports whether the rule covers dev.
+func (r DeviceRule) matches(dev spec.LinuxDevice) bool {
+	devType := dev.Type
+	if devType == "u" {
+		devType = "c"
+	}
+	if r.Type != "" && r.Type != "a" && r.Type != devType {
+		return false
+	}
+	if r.Major != nil && *r.Major != dev.Major {
+		return false
+	}
+	if r.Minor != nil && *r.Minor != dev.Minor {
+		return false
+	}
+	if r.Path != "" {
+		if ok, _ := filepath.Match(r.Path, filepath.Clean(dev.Path)); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// String formats the rule like a device cgroup rule, followed by its path
+// glob.
+func (r DeviceRule) String() string {
+	typ, major, minor := r.Type, "*", "*"
+	if typ == "" {
+		typ = "a"
+	}
+	if r.Major != nil {
+		major = fmt.Sprintf("%d", *r.Major)
+	}
+	if r.Minor != nil {
+		minor = fmt.Sprintf("%d", *r.Minor)
+	}
+	s := fmt.Sprintf("%s %s:%s", typ, major, minor)
+	if r.Path != "" {
+		s += " " + r.Path
+	}
+	return s
+}
+
+// Allows reports whether the policy lets a container have dev.
+func (p *DevicePolicy) Allows(dev spec.LinuxDevice) bool {
+	if dev.Type == "p" {
+		return true
+	}
+	for _, r := range p.Deny {
+		if r.matches(dev) {
+			return false
+		}
+	}
+	for _, r := range p.Allow {
+		if r.matches(dev) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check fails unless the policy lets a container have dev.
+func (p *DevicePolicy) Check(dev spec.LinuxDevice) error {
+	if !p.Allows(dev) {
+		return fmt.Errorf("device %s (%s %d:%d) is not allowed by the %s device policy",
+			dev.Path, dev.Type, dev.Major, dev.Minor, p.Source())
+	}
+	return nil
+}
+
+// RestrictRules returns device cgroup rules with each allow rule
+// narrowed to the devices the policy's allow rules cover, so no rule can
+// grant what the policy does not allow. Allow rules with a path glob have
+// no cgroup equivalent and narrow nothing down to; a device they allow is
+// only granted by its own rule from linux.devices. Deny rules stay as
+// they are.
+func (p *DevicePolicy) RestrictRules(rules []spec.LinuxDeviceCgroup) []spec.LinuxDeviceCgroup {
+	var restricted []spec.LinuxDeviceCgroup
+	for _, rule := range rules {
+		if !rule.Allow {
+			restricted = append(restricted, rule)
+			continue
+		}
+		for _, allow := range p.Allow {
+			if allow.Path != "" {
+				continue
+			}
+			restricted = append(restricted, allow.intersect(rule)...)
+		}
+	}
+	return restricted
+}
+
+// intersect returns the cgroup rules for the devices both r and rule
+// cover, with the access of rule.
+func (r DeviceRule) intersect(rule spec.LinuxDeviceCgroup) []spec.LinuxDeviceCgroup {
+	ruleType, ruleMajor, ruleMinor := rule.Type, rule.Major, rule.Minor
+	if ruleType == "" || ruleType == "a" {
+		// An "a" cgroup rule ignores the numbers
+		ruleType, ruleMajor, ruleMinor = "a", nil, nil
+	}
+	major, ok := intersectNumber(r.Major, ruleMajor)
+	if !ok {
+		return nil
+	}
+	minor, ok := intersectNumber(r.Minor, ruleMinor)
+	if !ok {
+		return nil
+	}
+	var types []string
+	switch {
+	case r.Type == "" || r.Type == "a":
+		types = []string{ruleType}
+		if ruleType == "a" && (major != nil || minor != nil) {
+			types = []string{"c", "b"}
+		}
+	case ruleType == "a" || ruleType == r.Type:
+		types = []string{r.Type}
+	}
+	var rules []spec.LinuxDeviceCgroup
+	for _, typ := range types {
+		rules = append(rules, spec.LinuxDeviceCgroup{
+			Allow:  true,
+			Type:   typ,
+			Major:  major,
+			Minor:  minor,
+			Access: rule.Access,
+		})
+	}
+	return rules
+}
+
+// intersectNumber returns the major or minor number both a and b match,
+// nil for any, and false when they match none in common.
+func intersectNumber(a, b *int64) (*int64, bool) {
+	switch {
+	case a == nil:
+		return b, true
+	case b == nil || *a == *b:
+		return a, true
+	}
+	return nil, false
+}
+
+// CgroupRules returns the deny rules as device cgroup rules, for after
+// the container's own, so its resources cannot open up what the policy
+// denies. Path globs have no cgroup equivalent; rules with one only
+// apply to node creation.
+func (p *DevicePolicy) CgroupRules() []spec.LinuxDeviceCgroup {
+	var rules []spec.LinuxDeviceCgroup
+	for _, r := range p.Deny {
+		if r.Path != "" {
+			continue
+		}
+		types := []string{r.Type}
+		if r.Type == "" || r.Type == "a" {
+			// An "a" cgroup rule ignores the numbers
+			types = []string{"a"}
+			if r.Major != nil || r.Minor != nil {
+				types = []string{"c", "b"}
+			}
+		}
+		for _, typ := range types {
+			rules = append(rules, spec.LinuxDeviceCgroup{
+				Allow:  false,
+				Type:   typ,
+				Major:  r.Major,
+				Minor:  r.Minor,
+				Access: "rwm",
+			})
+		}
+	}
+	return rules
+}
+
+// ValidateDevices checks the devices of s against the policy.
+func ValidateDevices(s *spec.Spec, p *DevicePolicy) error {
+	if s == nil || s.Linux == nil {
+		return nil
+	}
+	for _, dev := range s.Linux.Devices {
+		if err := validateDevicePath(dev.Path); err != nil {
+			return err
+		}
+		if _, err := deviceFileType(dev.Type); err != nil {
+			return fmt.Errorf("device %s: %w", dev.Path, err)
+		}
+		if err := p.Check(dev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,272 @@
+package linux
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"runc-go/spec"
+)
+
+func writeDevicePolicy(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "devices.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadDevicePolicy(t *testing.T) {
+	p, err := LoadDevicePolicy(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil {
+		t.Fatalf("LoadDevicePolicy(missing) error: %v", err)
+	}
+	if p.Source() != "built-in" || !reflect.DeepEqual(p.Allow, builtinDeviceRules) {
+		t.Errorf("LoadDevicePolicy(missing) = %+v, want the built-in policy", p)
+	}
+
+	path := writeDevicePolicy(t, `{
+		"allow": [
+			{"type": "c", "major": 10, "minor": 229},
+			{"type": "b", "major": 7, "path": "/dev/loop*"}
+		],
+		"deny": [{"type": "c", "major": 1, "minor": 11}]
+	}`)
+	p, err = LoadDevicePolicy(path)
+	if err != nil {
+		t.Fatalf("LoadDevicePolicy error: %v", err)
+	}
+	if p.Source() != path {
+		t.Errorf("Source() = %q, want %q", p.Source(), path)
+	}
+	tests := []struct {
+		dev  spec.LinuxDevice
+		want bool
+	}{
+		{spec.LinuxDevice{Path: "/dev/null", Type: "c", Major: 1, Minor: 3}, true},
+		{spec.LinuxDevice{Path: "/dev/fuse", Type: "c", Major: 10, Minor: 229}, true},
+		{spec.LinuxDevice{Path: "/dev/fuse", Type: "u", Major: 10, Minor: 229}, true},
+		{spec.LinuxDevice{Path: "/dev/loop3", Type: "b", Major: 7, Minor: 3}, true},
+		{spec.LinuxDevice{Path: "/dev/disk3", Type: "b", Major: 7, Minor: 3}, false},
+		{spec.LinuxDevice{Path: "/dev/kmsg", Type: "c", Major: 1, Minor: 11}, false},
+		{spec.LinuxDevice{Path: "/dev/fuse", Type: "b", Major: 10, Minor: 229}, false},
+		{spec.LinuxDevice{Path: "/dev/fifo", Type: "p"}, true},
+	}
+	for _, tt := range tests {
+		if got := p.Allows(tt.dev); got != tt.want {
+			t.Errorf("Allows(%s %s %d:%d) = %v, want %v", tt.dev.Path, tt.dev.Type, tt.dev.Major, tt.dev.Minor, got, tt.want)
+		}
+	}
+
+	// Without the built-in rules only the file's own apply
+	p, err = LoadDevicePolicy(writeDevicePolicy(t, `{"defaults": false, "allow": [{"type": "c", "major": 10}]}`))
+	if err != nil {
+		t.Fatalf("LoadDevicePolicy(defaults false) error: %v", err)
+	}
+	if p.Allows(spec.LinuxDevice{Path: "/dev/null", Type: "c", Major: 1, Minor: 3}) {
+		t.Error("defaults false still allows /dev/null")
+	}
+	if !p.Allows(spec.LinuxDevice{Path: "/dev/net/tun", Type: "c", Major: 10, Minor: 200}) {
+		t.Error("defaults false does not allow 10:200")
+	}
+
+	for _, content := range []string{
+		`{"allow": [{"type": "x"}]}`,
+		`{"allow": [{"path": "/etc/*"}]}`,
+		`{"deny": [{"path": "/dev/[loop"}]}`,
+		`{"allow": `,
+	} {
+		if _, err := LoadDevicePolicy(writeDevicePolicy(t, content)); err == nil {
+			t.Errorf("LoadDevicePolicy(%s) accepted it", content)
+		}
+	}
+}
+
+func TestDevicePolicy_CgroupRules(t *testing.T) {
+	p := &DevicePolicy{Deny: []DeviceRule{
+		{Type: "b"},
+		{Major: deviceNumber(1), Minor: deviceNumber(1)},
+		{Type: "c", Major: deviceNumber(10), Path: "/dev/net/*"},
+	}}
+	want := []spec.LinuxDeviceCgroup{
+		{Type: "b", Access: "rwm"},
+		{Type: "c", Major: deviceNumber(1), Minor: deviceNumber(1), Access: "rwm"},
+		{Type: "b", Major: deviceNumber(1), Minor: deviceNumber(1), Access: "rwm"},
+	}
+	if got := p.CgroupRules(); !reflect.DeepEqual(got, want) {
+		t.Errorf("CgroupRules() = %+v, want %+v", got, want)
+	}
+	if got := p.Deny[2].String(); got != "c 10:* /dev/net/*" {
+		t.Errorf("String() = %q, want %q", got, "c 10:* /dev/net/*")
+	}
+
+	// Denials hold over the defaults
+	r := DeviceResources(nil, &DevicePolicy{Deny: []DeviceRule{{Type: "b"}}})
+	wantDevices := append([]spec.LinuxDeviceCgroup{{Type: "a", Access: "rwm"}}, defaultDeviceRules...)
+	wantDevices = append(wantDevices, spec.LinuxDeviceCgroup{Type: "b", Access: "rwm"})
+	if !reflect.DeepEqual(r.Devices, wantDevices) {
+		t.Errorf("DeviceResources() devices = %+v, want %+v", r.Devices, wantDevices)
+	}
+}
+
+func TestDevicePolicy_RestrictRules(t *testing.T) {
+	p := &DevicePolicy{Allow: []DeviceRule{
+		{Type: "c", Major: deviceNumber(1), Minor: deviceNumber(3)},
+		{Major: deviceNumber(7)},
+		{Type: "c", Major: deviceNumber(10), Minor: deviceNumber(229), Path: "/dev/fuse"},
+	}}
+	deny := spec.LinuxDeviceCgroup{Allow: false, Type: "c", Major: deviceNumber(1), Access: "w"}
+	tests := []struct {
+		name string
+		rule spec.LinuxDeviceCgroup
+		want []spec.LinuxDeviceCgroup
+	}{
+		{
+			name: "allow all",
+			rule: spec.LinuxDeviceCgroup{Allow: true, Access: "rw"},
+			want: []spec.LinuxDeviceCgroup{
+				{Allow: true, Type: "c", Major: deviceNumber(1), Minor: deviceNumber(3), Access: "rw"},
+				{Allow: true, Type: "c", Major: deviceNumber(7), Access: "rw"},
+				{Allow: true, Type: "b", Major: deviceNumber(7), Access: "rw"},
+			},
+		},
+		{
+			name: "block major",
+			rule: spec.LinuxDeviceCgroup{Allow: true, Type: "b", Major: deviceNumber(7), Minor: deviceNumber(2), Access: "r"},
+			want: []spec.LinuxDeviceCgroup{
+				{Allow: true, Type: "b", Major: deviceNumber(7), Minor: deviceNumber(2), Access: "r"},
+			},
+		},
+		{
+			name: "disk",
+			rule: spec.LinuxDeviceCgroup{Allow: true, Type: "b", Major: deviceNumber(8), Access: "rwm"},
+		},
+		{
+			name: "path only",
+			rule: spec.LinuxDeviceCgroup{Allow: true, Type: "c", Major: deviceNumber(10), Minor: deviceNumber(229), Access: "rwm"},
+		},
+		{
+			name: "deny",
+			rule: deny,
+			want: []spec.LinuxDeviceCgroup{deny},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.RestrictRules([]spec.LinuxDeviceCgroup{tt.rule}); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RestrictRules() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestDeviceResources_PolicyAllow verifies rules of the spec's own cannot
+// grant a device the policy does not allow.
+func TestDeviceResources_PolicyAllow(t *testing.T) {
+	l := &spec.Linux{Resources: &spec.LinuxResources{Devices: []spec.LinuxDeviceCgroup{
+		{Allow: false, Access: "rwm"},
+		{Allow: true, Type: "a", Access: "rwm"},
+	}}}
+	prog, err := buildDeviceFilter(DeviceResources(l, DefaultDevicePolicy()).Devices)
+	if err != nil {
+		t.Fatalf("buildDeviceFilter failed: %v", err)
+	}
+	tests := []struct {
+		name                      string
+		typ, access, major, minor uint32
+		want                      uint64
+	}{
+		{"null", devC, accR | accW, 1, 3, 1},
+		{"kmsg", devC, accR, 1, 11, 1},
+		{"sda", devB, accR | accW, 8, 0, 0},
+		{"mem", devC, accR, 1, 1, 0},
+		{"tun", devC, accR | accW, 10, 200, 0},
+	}
+	for _, tt := range tests {
+		if got := runDeviceFilter(t, prog, tt.typ, tt.access, tt.major, tt.minor); got != tt.want {
+			t.Errorf("%s: verdict = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestDeviceResources_PathPolicy verifies a device the policy allows by
+// path only gets the access of the spec's rule for it, not more.
+func TestDeviceResources_PathPolicy(t *testing.T) {
+	policy, err := LoadDevicePolicy(writeDevicePolicy(t, `{"allow":[{"path":"/dev/loop*"}]}`))
+	if err != nil {
+		t.Fatalf("LoadDevicePolicy error: %v", err)
+	}
+	l := &spec.Linux{
+		Devices: []spec.LinuxDevice{
+			{Path: "/dev/loop0", Type: "b", Major: 7, Minor: 0},
+			{Path: "/dev/loop1", Type: "b", Major: 7, Minor: 1},
+		},
+		Resources: &spec.LinuxResources{Devices: []spec.LinuxDeviceCgroup{
+			{Allow: false, Access: "rwm"},
+			{Allow: true, Type: "b", Major: deviceNumber(7), Minor: deviceNumber(0), Access: "r"},
+		}},
+	}
+	prog, err := buildDeviceFilter(DeviceResources(l, policy).Devices)
+	if err != nil {
+		t.Fatalf("buildDeviceFilter failed: %v", err)
+	}
+	tests := []struct {
+		name                      string
+		typ, access, major, minor uint32
+		want                      uint64
+	}{
+		{"loop0 read", devB, accR, 7, 0, 1},
+		{"loop0 write", devB, accW, 7, 0, 0},
+		{"loop1 without a rule", devB, accR | accW, 7, 1, 1},
+		{"loop2 not a device", devB, accR, 7, 2, 0},
+	}
+	for _, tt := range tests {
+		if got := runDeviceFilter(t, prog, tt.typ, tt.access, tt.major, tt.minor); got != tt.want {
+			t.Errorf("%s: verdict = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDevicePolicy_Encode(t *testing.T) {
+	path := writeDevicePolicy(t, `{"allow":[{"type":"b","major":7,"path":"/dev/loop*"}],"deny":[{"type":"c","major":1,"minor":11}]}`)
+	p, err := LoadDevicePolicy(path)
+	if err != nil {
+		t.Fatalf("LoadDevicePolicy error: %v", err)
+	}
+	data, err := p.Encode()
+	if err != nil {
+		t.Fatalf("Encode error: %v", err)
+	}
+	got, err := DecodeDevicePolicy(data)
+	if err != nil {
+		t.Fatalf("DecodeDevicePolicy error: %v", err)
+	}
+	// The built-in rules are not added a second time
+	if !reflect.DeepEqual(got.Allow, p.Allow) || !reflect.DeepEqual(got.Deny, p.Deny) || got.Source() != path {
+		t.Errorf("DecodeDevicePolicy() = %+v, want %+v", got, p)
+	}
+	if _, err := DecodeDevicePolicy(""); err == nil {
+		t.Error("DecodeDevicePolicy accepted an empty policy")
+	}
+}
+
+func TestValidateDevices(t *testing.T) {
+	p := DefaultDevicePolicy()
+	tests := []struct {
+		dev     spec.LinuxDevice
+		wantErr bool
+	}{
+		{dev: spec.LinuxDevice{Path: "/dev/kmsg", Type: "c", Major: 1, Minor: 11}},
+		{dev: spec.LinuxDevice{Path: "/dev/sda", Type: "b", Major: 8, Minor: 0}, wantErr: true},
+		{dev: spec.LinuxDevice{Path: "/dev/null", Type: "x", Major: 1, Minor: 3}, wantErr: true},
+		{dev: spec.LinuxDevice{Path: "/etc/null", Type: "c", Major: 1, Minor: 3}, wantErr: true},
+	}
+	for _, tt := range tests {
+		s := &spec.Spec{Linux: &spec.Linux{Devices: []spec.LinuxDevice{tt.dev}}}
+		if err := ValidateDevices(s, p); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateDevices(%s) error = %v, wantErr %v", tt.dev.Path, err, tt.wantErr)
+		}
+	}
+}
@@ -8,43 +8,11 @@ import (
 	"strings"
 	"syscall"
 
+	"golang.org/x/sys/unix"
+
 	"runc-go/spec"
 )
 
-// allowedDevices is a whitelist of safe device major:minor numbers.
-// This prevents container specs from creating arbitrary devices like /dev/sda.
-var allowedDevices = map[string]bool{
-	// Standard character devices
-	"1:3":  true, // /dev/null
-	"1:5":  true, // /dev/zero
-	"1:7":  true, // /dev/full
-	"1:8":  true, // /dev/random
-	"1:9":  true, // /dev/urandom
-	"5:0":  true, // /dev/tty
-	"5:1":  true, // /dev/console
-	"5:2":  true, // /dev/ptmx
-	"1:11": true, // /dev/kmsg (read-only usually)
-	// PTY devices (136:* for pts)
-}
-
-// isPTYDevice checks if a device is a PTY (major 136 for unix98 PTYs).
-func isPTYDevice(major, minor int64) bool {
-	return major == 136 // unix98 PTY slaves
-}
-
-// isAllowedDevice checks if a device is in the whitelist.
-func isAllowedDevice(dev spec.LinuxDevice) bool {
-	key := fmt.Sprintf("%d:%d", dev.Major, dev.Minor)
-	if allowedDevices[key] {
-		return true
-	}
-	// Also allow PTY devices
-	if isPTYDevice(dev.Major, dev.Minor) {
-		return true
-	}
-	return false
-}
-
 // validateDevicePath ensures a device path is safe (within /dev).
 func validateDevicePath(path string) error {
 	// Clean the path
@@ -77,17 +45,16 @@ func DefaultDevices() []spec.LinuxDevice {
 }
 
 // CreateAllDevices creates all device nodes for the container.
-func CreateAllDevices(devices []spec.LinuxDevice, rootfs string) error {
+// Each must be allowed by the device policy.
+func CreateAllDevices(devices []spec.LinuxDevice, rootfs string, policy *DevicePolicy) error {
 	for _, dev := range devices {
 		// Validate device path format
 		if err := validateDevicePath(dev.Path); err != nil {
 			return fmt.Errorf("invalid device path: %w", err)
 		}
 
-		// Check if device is in whitelist (skip for default devices)
-		if !isAllowedDevice(dev) {
-			return fmt.Errorf("device %s (major:minor %d:%d) is not in allowed list",
-				dev.Path, dev.Major, dev.Minor)
+		if err := policy.Check(dev); err != nil {
+			return err
 		}
 
 		path := dev.Path
@@ -107,6 +74,19 @@ func CreateAllDevices(devices []spec.LinuxDevice, rootfs string) error {
 	return nil
 }
 
+// deviceFileType returns the file type bits of a device type.
+func deviceFileType(devType string) (uint32, error) {
+	switch devType {
+	case "c", "u": // Character device
+		return syscall.S_IFCHR, nil
+	case "b": // Block device
+		return syscall.S_IFBLK, nil
+	case "p": // FIFO (named pipe)
+		return syscall.S_IFIFO, nil
+	}
+	return 0, fmt.Errorf("unknown device type: %s", devType)
+}
+
 // createDeviceNode creates a single device node.
 func createDeviceNode(path string, dev spec.LinuxDevice) error {
 	// Ensure parent directory exists
@@ -115,17 +95,9 @@ func createDeviceNode(path string, dev spec.LinuxDevice) error {
 		return fmt.Errorf("mkdir %s: %w", dir, err)
 	}
 
-	// Calculate device type
-	var devType uint32
-	switch dev.Type {
-	case "c", "u": // Character device
-		devType = syscall.S_IFCHR
-	case "b": // Block device
-		devType = syscall.S_IFBLK
-	case "p": // FIFO (named pipe)
-		devType = syscall.S_IFIFO
-	default:
-		return fmt.Errorf("unknown device type: %s", dev.Type)
+	devType, err := deviceFileType(dev.Type)
+	if err != nil {
+		return err
 	}
 
 	// Calculate file mode
@@ -134,8 +106,7 @@ func createDeviceNode(path string, dev spec.LinuxDevice) error {
 		mode = devType | uint32(*dev.FileMode)
 	}
 
-	// Calculate device number (major << 8 | minor)
-	devNum := int((dev.Major << 8) | dev.Minor)
+	devNum := int(unix.Mkdev(uint32(dev.Major), uint32(dev.Minor)))
 
 	// Remove existing device if present
 	os.Remove(path)
@@ -144,6 +115,10 @@ func createDeviceNode(path string, dev spec.LinuxDevice) error {
 	if err := syscall.Mknod(path, mode, devNum); err != nil {
 		return fmt.Errorf("mknod: %w", err)
 	}
+	// mknod applies the umask
+	if err := os.Chmod(path, os.FileMode(mode&0777)); err != nil {
+		return fmt.Errorf("chmod: %w", err)
+	}
 
 	// Set ownership
 	uid := 0
@@ -162,13 +137,17 @@ func createDeviceNode(path string, dev spec.LinuxDevice) error {
 }
 
 // BindMountDevices bind-mounts devices from host instead of creating them.
-// This is useful when mknod is not available (e.g., rootless containers).
-func BindMountDevices(devices []spec.LinuxDevice, rootfs string) error {
+// This is needed when mknod is not available (e.g., in a user namespace).
+// The host node at the device's path must match its type and numbers.
+func BindMountDevices(devices []spec.LinuxDevice, rootfs string, policy *DevicePolicy) error {
 	for _, dev := range devices {
 		// Validate device path
 		if err := validateDevicePath(dev.Path); err != nil {
 			return fmt.Errorf("invalid device path: %w", err)
 		}
+		if err := policy.Check(dev); err != nil {
+			return err
+		}
 
 		hostPath := dev.Path
 		containerPath := dev.Path
@@ -181,9 +160,9 @@ func BindMountDevices(devices []spec.LinuxDevice, rootfs string) error {
 			}
 		}
 
-		// Check if host device exists
-		if _, err := os.Stat(hostPath); err != nil {
-			continue // Skip if host device doesn't exist
+		// The host device must be the requested one
+		if err := checkHostDevice(hostPath, dev); err != nil {
+			return err
 		}
 
 		// Create mount point
@@ -207,78 +186,128 @@ func BindMountDevices(devices []spec.LinuxDevice, rootfs string) error {
 	return nil
 }
 
-// SetupDevTmpfs sets up /dev as a tmpfs with necessary devices and symlinks.
-func SetupDevTmpfs(rootfs string, devices []spec.LinuxDevice) error {
-	devPath := "/dev"
-	if rootfs != "" {
-		devPath = filepath.Join(rootfs, "dev")
+// checkHostDevice fails unless the host node at path is dev.
+func checkHostDevice(path string, dev spec.LinuxDevice) error {
+	devType, err := deviceFileType(dev.Type)
+	if err != nil {
+		return err
+	}
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		return fmt.Errorf("host device %s: %w", path, err)
+	}
+	if st.Mode&syscall.S_IFMT != devType || unix.Major(st.Rdev) != uint32(dev.Major) || unix.Minor(st.Rdev) != uint32(dev.Minor) {
+		return fmt.Errorf("host device %s is not %s %d:%d", path, dev.Type, dev.Major, dev.Minor)
+	}
+	return nil
+}
+
+// devMount returns the spec's mount at /dev, if any.
+func devMount(s *spec.Spec) (spec.Mount, bool) {
+	for _, m := range s.Mounts {
+		if filepath.Clean(m.Destination) == "/dev" {
+			return m, true
+		}
+	}
+	return spec.Mount{}, false
+}
+
+// hasMount reports whether the spec mounts something at dest.
+func hasMount(s *spec.Spec, dest string) bool {
+	for _, m := range s.Mounts {
+		if filepath.Clean(m.Destination) == dest {
+			return true
+		}
 	}
+	return false
+}
 
-	// Create /dev directory
+// SetupDevTmpfs mounts a fresh tmpfs at /dev in rootfs, so the container
+// never sees the rootfs's own device nodes. It is skipped when the spec
+// mounts /dev itself.
+func SetupDevTmpfs(s *spec.Spec, rootfs string) error {
+	if _, ok := devMount(s); ok {
+		return nil
+	}
+	devPath, err := SecureJoin(rootfs, "/dev")
+	if err != nil {
+		return fmt.Errorf("invalid /dev: %w", err)
+	}
 	if err := os.MkdirAll(devPath, 0755); err != nil {
 		return fmt.Errorf("mkdir /dev: %w", err)
 	}
-
-	// Mount tmpfs on /dev
 	if err := syscall.Mount("tmpfs", devPath, "tmpfs",
 		syscall.MS_NOSUID|syscall.MS_STRICTATIME,
 		"mode=755,size=65536k"); err != nil {
 		return fmt.Errorf("mount tmpfs on /dev: %w", err)
 	}
+	return nil
+}
 
-	// Create device nodes
-	allDevices := devices
-	if len(allDevices) == 0 {
-		allDevices = DefaultDevices()
+// SetupDevices populates the container's /dev in rootfs, before
+// pivot_root: the default devices and the spec's, /dev/pts when the spec
+// has no mount for it, the standard symlinks and, with a terminal,
+// /dev/console. Device nodes are created with mknod, or bind-mounted
+// from the host in a user namespace, where mknod is not permitted. A /dev
+// bind-mounted by the spec is left as it is. Each device must be allowed
+// by the device policy.
+func SetupDevices(s *spec.Spec, rootfs string, policy *DevicePolicy) error {
+	if m, ok := devMount(s); ok && (m.Type == "bind" || hasOption(m.Options, "bind") || hasOption(m.Options, "rbind")) {
+		return nil
 	}
-
-	for _, dev := range allDevices {
-		path := filepath.Join(devPath, filepath.Base(dev.Path))
-		if err := createDeviceNode(path, dev); err != nil {
-			// Non-fatal, try to continue
-			fmt.Printf("[dev] warning: %v\n", err)
-		}
+	devPath, err := SecureJoin(rootfs, "/dev")
+	if err != nil {
+		return fmt.Errorf("invalid /dev: %w", err)
 	}
 
-	// Create /dev/pts
-	ptsPath := filepath.Join(devPath, "pts")
-	if err := os.MkdirAll(ptsPath, 0755); err != nil {
-		return fmt.Errorf("mkdir /dev/pts: %w", err)
+	// The spec's devices replace defaults at the same path
+	var devices []spec.LinuxDevice
+	var requested []spec.LinuxDevice
+	if s.Linux != nil {
+		requested = s.Linux.Devices
 	}
-
-	// Mount devpts
-	if err := syscall.Mount("devpts", ptsPath, "devpts",
-		syscall.MS_NOSUID|syscall.MS_NOEXEC,
-		"newinstance,ptmxmode=0666,mode=0620"); err != nil {
-		fmt.Printf("[dev] warning: mount devpts: %v\n", err)
+	for _, dev := range DefaultDevices() {
+		replaced := false
+		for _, r := range requested {
+			replaced = replaced || filepath.Clean(r.Path) == dev.Path
+		}
+		if !replaced {
+			devices = append(devices, dev)
+		}
 	}
+	devices = append(devices, requested...)
 
-	// Create /dev/ptmx symlink
-	ptmxPath := filepath.Join(devPath, "ptmx")
-	os.Remove(ptmxPath)
-	if err := os.Symlink("pts/ptmx", ptmxPath); err != nil {
-		// Try creating device node instead
-		mode := os.FileMode(0666)
-		dev := spec.LinuxDevice{Path: ptmxPath, Type: "c", Major: 5, Minor: 2, FileMode: &mode}
-		createDeviceNode(ptmxPath, dev)
+	// FIFOs can be created in a user namespace
+	var nodes, binds []spec.LinuxDevice
+	userns := s.Linux != nil && HasNamespace(s.Linux.Namespaces, spec.UserNamespace)
+	for _, dev := range devices {
+		// A device the spec mounts over is left to the mount
+		if hasMount(s, filepath.Clean(dev.Path)) {
+			continue
+		}
+		if userns && dev.Type != "p" {
+			binds = append(binds, dev)
+		} else {
+			nodes = append(nodes, dev)
+		}
 	}
-
-	// Create /dev/shm
-	shmPath := filepath.Join(devPath, "shm")
-	if err := os.MkdirAll(shmPath, 1777); err != nil {
-		return fmt.Errorf("mkdir /dev/shm: %w", err)
+	if err := CreateAllDevices(nodes, rootfs, policy); err != nil {
+		return err
 	}
-	if err := syscall.Mount("shm", shmPath, "tmpfs",
-		syscall.MS_NOSUID|syscall.MS_NOEXEC|syscall.MS_NODEV,
-		"mode=1777,size=65536k"); err != nil {
-		fmt.Printf("[dev] warning: mount shm: %v\n", err)
+	if err := BindMountDevices(binds, rootfs, policy); err != nil {
+		return err
 	}
 
-	// Create /dev/mqueue
-	mqPath := filepath.Join(devPath, "mqueue")
-	if err := os.MkdirAll(mqPath, 0755); err == nil {
-		syscall.Mount("mqueue", mqPath, "mqueue",
-			syscall.MS_NOSUID|syscall.MS_NOEXEC|syscall.MS_NODEV, "")
+	ptsPath := filepath.Join(devPath, "pts")
+	if !hasMount(s, "/dev/pts") {
+		if err := os.MkdirAll(ptsPath, 0755); err != nil {
+			return fmt.Errorf("mkdir /dev/pts: %w", err)
+		}
+		if err := syscall.Mount("devpts", ptsPath, "devpts",
+			syscall.MS_NOSUID|syscall.MS_NOEXEC,
+			"newinstance,ptmxmode=0666,mode=0620"); err != nil {
+			return fmt.Errorf("mount devpts: %w", err)
+		}
 	}
 
 	// Create standard symlinks
@@ -287,24 +316,138 @@ func SetupDevTmpfs(rootfs string, devices []spec.LinuxDevice) error {
 		"stdin":  "/proc/self/fd/0",
 		"stdout": "/proc/self/fd/1",
 		"stderr": "/proc/self/fd/2",
+		"ptmx":   "pts/ptmx",
 	}
-
 	for name, target := range symlinks {
 		linkPath := filepath.Join(devPath, name)
 		os.Remove(linkPath)
-		os.Symlink(target, linkPath)
+		if err := os.Symlink(target, linkPath); err != nil {
+			return fmt.Errorf("symlink /dev/%s: %w", name, err)
+		}
 	}
 
-	// Create /dev/console placeholder
-	consolePath := filepath.Join(devPath, "console")
-	f, _ := os.OpenFile(consolePath, os.O_CREATE|os.O_WRONLY, 0600)
-	if f != nil {
-		f.Close()
+	if s.Process != nil && s.Process.Terminal {
+		return setupConsole(devPath)
 	}
+	return nil
+}
 
+// setupConsole bind-mounts the init's terminal, its stdin, at
+// /dev/console. A bind works in a user namespace, unlike mknod. The
+// terminal's mount belongs to the runtime's mount namespace, so it is
+// bound by its path, which the init still sees before pivot_root.
+func setupConsole(devPath string) error {
+	var st syscall.Stat_t
+	if err := syscall.Fstat(0, &st); err != nil || st.Mode&syscall.S_IFMT != syscall.S_IFCHR {
+		return nil
+	}
+	tty, err := os.Readlink("/proc/self/fd/0")
+	if err != nil {
+		return fmt.Errorf("resolve terminal: %w", err)
+	}
+	var ttySt syscall.Stat_t
+	if err := syscall.Stat(tty, &ttySt); err != nil || ttySt.Rdev != st.Rdev {
+		return fmt.Errorf("terminal %s is not reachable from the container's mount namespace", tty)
+	}
+	console := filepath.Join(devPath, "console")
+	f, err := os.OpenFile(console, os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("create /dev/console: %w", err)
+	}
+	f.Close()
+	if err := syscall.Mount(tty, console, "", syscall.MS_BIND, ""); err != nil {
+		return fmt.Errorf("bind mount /dev/console: %w", err)
+	}
 	return nil
 }
 
+// defaultDeviceRules are the device cgroup rules every container gets,
+// as runc grants them: making any node, and using the default devices,
+// /dev/console, /dev/ptmx and the PTY slaves.
+var defaultDeviceRules = []spec.LinuxDeviceCgroup{
+	{Allow: true, Type: "c", Access: "m"},
+	{Allow: true, Type: "b", Access: "m"},
+	{Allow: true, Type: "c", Major: deviceNumber(1), Minor: deviceNumber(3), Access: "rwm"}, // /dev/null
+	{Allow: true, Type: "c", Major: deviceNumber(1), Minor: deviceNumber(5), Access: "rwm"}, // /dev/zero
+	{Allow: true, Type: "c", Major: deviceNumber(1), Minor: deviceNumber(7), Access: "rwm"}, // /dev/full
+	{Allow: true, Type: "c", Major: deviceNumber(1), Minor: deviceNumber(8), Access: "rwm"}, // /dev/random
+	{Allow: true, Type: "c", Major: deviceNumber(1), Minor: deviceNumber(9), Access: "rwm"}, // /dev/urandom
+	{Allow: true, Type: "c", Major: deviceNumber(5), Minor: deviceNumber(0), Access: "rwm"}, // /dev/tty
+	{Allow: true, Type: "c", Major: deviceNumber(5), Minor: deviceNumber(1), Access: "rwm"}, // /dev/console
+	{Allow: true, Type: "c", Major: deviceNumber(5), Minor: deviceNumber(2), Access: "rwm"}, // /dev/ptmx
+	{Allow: true, Type: "c", Major: deviceNumber(136), Access: "rwm"},                       // /dev/pts/*
+}
+
+// DeviceResources returns the resources of l with the device rules the
+// container's cgroup gets: a rule denying every device, the default
+// rules, the spec's own rules as far as the device policy allows them, a
+// rule allowing each device of l.Devices so the container can use the
+// nodes it gets, and then the device policy's cgroup rules. A device the
+// rules before already allow by its numbers keeps the access they give
+// it, and one the policy only allows by path keeps the access of the
+// spec's rule for it.
+// The result always has device rules, so a cgroup v2 container gets a
+// filter even when the spec has none.
+func DeviceResources(l *spec.Linux, policy *DevicePolicy) *spec.LinuxResources {
+	var r spec.LinuxResources
+	var rules []spec.LinuxDeviceCgroup
+	if l != nil && l.Resources != nil {
+		r = *l.Resources
+		rules = l.Resources.Devices
+	}
+	// Denying all devices again right after the first rule changes nothing,
+	// and would take back the defaults
+	for len(rules) > 0 && !rules[0].Allow && (rules[0].Type == "" || rules[0].Type == "a") {
+		rules = rules[1:]
+	}
+	r.Devices = []spec.LinuxDeviceCgroup{{Allow: false, Type: "a", Access: "rwm"}}
+	r.Devices = append(r.Devices, defaultDeviceRules...)
+	r.Devices = append(r.Devices, policy.RestrictRules(rules)...)
+	if l != nil {
+		for _, dev := range l.Devices {
+			devType := dev.Type
+			switch devType {
+			case "u":
+				devType = "c"
+			case "p":
+				// FIFOs are not device nodes
+				continue
+			}
+			major, minor := dev.Major, dev.Minor
+			// A device the rules allow by its numbers keeps their access
+			if _, ok := deviceRuleAccess(r.Devices, devType, major, minor); ok {
+				continue
+			}
+			// So does one whose rule the policy only allows by path
+			access, ok := deviceRuleAccess(rules, devType, major, minor)
+			if !ok {
+				access = "rwm"
+			}
+			r.Devices = append(r.Devices, spec.LinuxDeviceCgroup{
+				Allow:  true,
+				Type:   devType,
+				Major:  &major,
+				Minor:  &minor,
+				Access: access,
+			})
+		}
+	}
+	r.Devices = append(r.Devices, policy.CgroupRules()...)
+	return &r
+}
+
+// deviceRuleAccess returns the access of the last allow rule in rules for
+// exactly the device of type devType and major:minor.
+func deviceRuleAccess(rules []spec.LinuxDeviceCgroup, devType string, major, minor int64) (string, bool) {
+	access, found := "", false
+	for _, r := range rules {
+		if r.Allow && r.Type == devType && r.Major != nil && *r.Major == major && r.Minor != nil && *r.Minor == minor {
+			access, found = r.Access, true
+		}
+	}
+	return access, found
+}
+
 // MakeDevicesCgroupRules creates cgroup device rules from OCI config.
 func MakeDevicesCgroupRules(devices []spec.LinuxDeviceCgroup) string {
 	// Format: TYPE MAJOR:MINOR ACCESS
@@ -313,7 +456,8 @@ func MakeDevicesCgroupRules(devices []spec.LinuxDeviceCgroup) string {
 	for _, dev := range devices {
 		var devType string
 		switch dev.Type {
-		case "a":
+		case "a", "":
+			// OCI: an empty type means all devices
 			devType = "a"
 		case "c":
 			devType = "c"
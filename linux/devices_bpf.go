@@ -0,0 +1,374 @@
+// Package linux provides the cgroup v2 device controller.
+//
+// cgroup v2 has no devices.allow/devices.deny files. Device access is
+// decided by a BPF_PROG_TYPE_CGROUP_DEVICE program attached to the cgroup,
+// which the kernel runs on every open and mknod of a device node.
+package linux
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"runtime"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"runc-go/spec"
+)
+
+// Offsets into struct bpf_cgroup_dev_ctx.
+const (
+	devCtxAccessType = 0 // (access << 16) | type
+	devCtxMajor      = 4
+	devCtxMinor      = 8
+)
+
+// deviceFilterLicense is the license string passed to the kernel. The
+// program calls no GPL-only helpers.
+const deviceFilterLicense = "Apache-2.0"
+
+// ebpfInsn mirrors struct bpf_insn.
+type ebpfInsn struct {
+	Code uint8
+	Regs uint8 // dst in the low nibble, src in the high nibble
+	Off  int16
+	Imm  int32
+}
+
+// ebpf builds an instruction.
+func ebpf(code uint8, dst, src uint8, off int16, imm int32) ebpfInsn {
+	return ebpfInsn{Code: code, Regs: dst | src<<4, Off: off, Imm: imm}
+}
+
+// Registers used by the device filter. R1 holds the context on entry.
+const (
+	regRet    = 0
+	regCtx    = 1
+	regType   = 2
+	regAccess = 3
+	regMajor  = 4
+	regMinor  = 5
+	regTmp    = 1 // the context is not needed after the prologue
+)
+
+// deviceRule is a validated LinuxDeviceCgroup rule.
+type deviceRule struct {
+	allow  bool
+	typ    int32 // 0 for all device types
+	major  *int64
+	minor  *int64
+	access int32 // BPF_DEVCG_ACC_* bits
+}
+
+// parseDeviceRule validates a rule and converts it to kernel constants.
+func parseDeviceRule(d spec.LinuxDeviceCgroup) (deviceRule, error) {
+	r := deviceRule{allow: d.Allow, major: d.Major, minor: d.Minor}
+
+	switch d.Type {
+	case "a", "":
+		// "a" covers every device regardless of numbers, as in v1
+		r.major, r.minor = nil, nil
+	case "c":
+		r.typ = unix.BPF_DEVCG_DEV_CHAR
+	case "b":
+		r.typ = unix.BPF_DEVCG_DEV_BLOCK
+	default:
+		return r, fmt.Errorf("invalid device type %q", d.Type)
+	}
+
+	for _, n := range []*int64{r.major, r.minor} {
+		// Immediates are sign-extended in 64-bit comparisons
+		if n != nil && (*n < 0 || *n > math.MaxInt32) {
+			return r, fmt.Errorf("invalid device number %d", *n)
+		}
+	}
+
+	access := d.Access
+	if access == "" {
+		access = "rwm"
+	}
+	for _, ch := range access {
+		switch ch {
+		case 'r':
+			r.access |= unix.BPF_DEVCG_ACC_READ
+		case 'w':
+			r.access |= unix.BPF_DEVCG_ACC_WRITE
+		case 'm':
+			r.access |= unix.BPF_DEVCG_ACC_MKNOD
+		default:
+			return r, fmt.Errorf("invalid device access %q", d.Access)
+		}
+	}
+	return r, nil
+}
+
+// buildDeviceFilter compiles device cgroup rules to an eBPF program.
+//
+// Rules apply in order, later ones overriding earlier ones, so the program
+// checks them last to first and returns on the first match. An allow rule
+// matches when it grants every requested access; a deny rule matches when
+// it covers any of them. A request for several accesses that are only
+// granted by separate rules is therefore denied, never wrongly allowed.
+// Anything no rule matches is denied.
+func buildDeviceFilter(devices []spec.LinuxDeviceCgroup) ([]ebpfInsn, error) {
+	rules := make([]deviceRule, len(devices))
+	for i, d := range devices {
+		r, err := parseDeviceRule(d)
+		if err != nil {
+			return nil, fmt.Errorf("device rule %d: %w", i, err)
+		}
+		rules[i] = r
+	}
+
+	// Prologue: unpack the context into registers
+	prog := []ebpfInsn{
+		ebpf(unix.BPF_LDX|unix.BPF_MEM|unix.BPF_W, regType, regCtx, devCtxAccessType, 0),
+		ebpf(unix.BPF_ALU|unix.BPF_AND|unix.BPF_K, regType, 0, 0, 0xffff),
+		ebpf(unix.BPF_LDX|unix.BPF_MEM|unix.BPF_W, regAccess, regCtx, devCtxAccessType, 0),
+		ebpf(unix.BPF_ALU|unix.BPF_RSH|unix.BPF_K, regAccess, 0, 0, 16),
+		ebpf(unix.BPF_LDX|unix.BPF_MEM|unix.BPF_W, regMajor, regCtx, devCtxMajor, 0),
+		ebpf(unix.BPF_LDX|unix.BPF_MEM|unix.BPF_W, regMinor, regCtx, devCtxMinor, 0),
+	}
+
+	for i := len(rules) - 1; i >= 0; i-- {
+		block := deviceRuleBlock(rules[i])
+		prog = append(prog, block...)
+		if len(block) == 2 {
+			// A catch-all rule (e.g. "a *:* rwm") always returns; the
+			// verifier rejects anything after it as unreachable
+			return prog, nil
+		}
+	}
+
+	// Default deny
+	prog = append(prog,
+		ebpf(unix.BPF_ALU64|unix.BPF_MOV|unix.BPF_K, regRet, 0, 0, 0),
+		ebpf(unix.BPF_JMP|unix.BPF_EXIT, 0, 0, 0, 0),
+	)
+	return prog, nil
+}
+
+// deviceRuleBlock emits the checks for one rule. Each failed check jumps
+// past the end of the block to the next rule.
+func deviceRuleBlock(r deviceRule) []ebpfInsn {
+	var checks []ebpfInsn
+	if r.typ != 0 {
+		checks = append(checks, ebpf(unix.BPF_JMP|unix.BPF_JNE|unix.BPF_K, regType, 0, 0, r.typ))
+	}
+	// Every request overlaps, and is covered by, a full "rwm" rule
+	if r.access != unix.BPF_DEVCG_ACC_READ|unix.BPF_DEVCG_ACC_WRITE|unix.BPF_DEVCG_ACC_MKNOD {
+		checks = append(checks,
+			ebpf(unix.BPF_ALU|unix.BPF_MOV|unix.BPF_X, regTmp, regAccess, 0, 0),
+			ebpf(unix.BPF_ALU|unix.BPF_AND|unix.BPF_K, regTmp, 0, 0, r.access),
+		)
+		if r.allow {
+			// Every requested access must be granted
+			checks = append(checks, ebpf(unix.BPF_JMP|unix.BPF_JNE|unix.BPF_X, regTmp, regAccess, 0, 0))
+		} else {
+			// Any covered access is denied
+			checks = append(checks, ebpf(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, regTmp, 0, 0, 0))
+		}
+	}
+	if r.major != nil {
+		checks = append(checks, ebpf(unix.BPF_JMP|unix.BPF_JNE|unix.BPF_K, regMajor, 0, 0, int32(uint32(*r.major))))
+	}
+	if r.minor != nil {
+		checks = append(checks, ebpf(unix.BPF_JMP|unix.BPF_JNE|unix.BPF_K, regMinor, 0, 0, int32(uint32(*r.minor))))
+	}
+
+	verdict := int32(0)
+	if r.allow {
+		verdict = 1
+	}
+	block := append(checks,
+		ebpf(unix.BPF_ALU64|unix.BPF_MOV|unix.BPF_K, regRet, 0, 0, verdict),
+		ebpf(unix.BPF_JMP|unix.BPF_EXIT, 0, 0, 0, 0),
+	)
+
+	// Jumps are relative to the following instruction
+	for i := range checks {
+		if block[i].Code&0x07 == unix.BPF_JMP {
+			block[i].Off = int16(len(block) - i - 1)
+		}
+	}
+	return block
+}
+
+// loadDeviceFilter loads a device filter program and returns its fd.
+func loadDeviceFilter(prog []ebpfInsn) (int, error) {
+	license := []byte(deviceFilterLicense + "\x00")
+	logBuf := make([]byte, 64*1024)
+
+	// Leading fields of union bpf_attr for BPF_PROG_LOAD
+	attr := struct {
+		progType    uint32
+		insnCnt     uint32
+		insns       uint64
+		license     uint64
+		logLevel    uint32
+		logSize     uint32
+		logBuf      uint64
+		kernVersion uint32
+		progFlags   uint32
+	}{
+		progType: unix.BPF_PROG_TYPE_CGROUP_DEVICE,
+		insnCnt:  uint32(len(prog)),
+		insns:    uint64(uintptr(unsafe.Pointer(&prog[0]))),
+		license:  uint64(uintptr(unsafe.Pointer(&license[0]))),
+		logLevel: 1,
+		logSize:  uint32(len(logBuf)),
+		logBuf:   uint64(uintptr(unsafe.Pointer(&logBuf[0]))),
+	}
+
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_PROG_LOAD, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	runtime.KeepAlive(prog)
+	runtime.KeepAlive(license)
+	runtime.KeepAlive(logBuf)
+	if errno != 0 {
+		verifierLog := strings.TrimSpace(unix.ByteSliceToString(logBuf))
+		if verifierLog != "" {
+			return -1, fmt.Errorf("load device filter: %w: %s", errno, verifierLog)
+		}
+		return -1, fmt.Errorf("load device filter: %w", errno)
+	}
+	return int(fd), nil
+}
+
+// bpfCall invokes bpf(2) with an attribute struct.
+func bpfCall(cmd int, attr unsafe.Pointer, size uintptr) error {
+	_, _, errno := unix.Syscall(unix.SYS_BPF, uintptr(cmd), uintptr(attr), size)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// bpfAttachAttr holds the fields of union bpf_attr for BPF_PROG_ATTACH
+// and BPF_PROG_DETACH.
+type bpfAttachAttr struct {
+	targetFd     uint32
+	attachBpfFd  uint32
+	attachType   uint32
+	attachFlags  uint32
+	replaceBpfFd uint32
+}
+
+// queryDeviceFilters returns fds of the device programs attached to a cgroup.
+func queryDeviceFilters(cgroupFd int) ([]int, error) {
+	ids := make([]uint32, 64)
+	attr := struct {
+		targetFd    uint32
+		attachType  uint32
+		queryFlags  uint32
+		attachFlags uint32
+		progIds     uint64
+		progCnt     uint32
+		_           uint32
+	}{
+		targetFd:   uint32(cgroupFd),
+		attachType: unix.BPF_CGROUP_DEVICE,
+		progIds:    uint64(uintptr(unsafe.Pointer(&ids[0]))),
+		progCnt:    uint32(len(ids)),
+	}
+	err := bpfCall(unix.BPF_PROG_QUERY, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(ids)
+	if err != nil {
+		return nil, fmt.Errorf("query device filters: %w", err)
+	}
+
+	var fds []int
+	for _, id := range ids[:attr.progCnt] {
+		getAttr := struct {
+			progID uint32
+			nextID uint32
+			flags  uint32
+		}{progID: id}
+		fd, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_PROG_GET_FD_BY_ID, uintptr(unsafe.Pointer(&getAttr)), unsafe.Sizeof(getAttr))
+		if errno != 0 {
+			closeFds(fds)
+			return nil, fmt.Errorf("get device filter %d: %w", id, errno)
+		}
+		fds = append(fds, int(fd))
+	}
+	return fds, nil
+}
+
+// closeFds closes a list of file descriptors.
+func closeFds(fds []int) {
+	for _, fd := range fds {
+		unix.Close(fd)
+	}
+}
+
+// attachDeviceFilter attaches a device filter to a cgroup in place of any
+// filters already there. A single existing filter is swapped atomically
+// with BPF_F_REPLACE (Linux 5.6+); otherwise the new filter is attached
+// before the old ones are detached, so the cgroup is never unfiltered.
+func attachDeviceFilter(cgroupFd, progFd int) error {
+	old, err := queryDeviceFilters(cgroupFd)
+	if err != nil {
+		return err
+	}
+	defer closeFds(old)
+
+	attr := bpfAttachAttr{
+		targetFd:    uint32(cgroupFd),
+		attachBpfFd: uint32(progFd),
+		attachType:  unix.BPF_CGROUP_DEVICE,
+		attachFlags: unix.BPF_F_ALLOW_MULTI,
+	}
+
+	if len(old) == 1 {
+		replace := attr
+		replace.attachFlags |= unix.BPF_F_REPLACE
+		replace.replaceBpfFd = uint32(old[0])
+		err := bpfCall(unix.BPF_PROG_ATTACH, unsafe.Pointer(&replace), unsafe.Sizeof(replace))
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, unix.EINVAL) {
+			return fmt.Errorf("replace device filter: %w", err)
+		}
+		// Kernel without BPF_F_REPLACE
+	}
+
+	if err := bpfCall(unix.BPF_PROG_ATTACH, unsafe.Pointer(&attr), unsafe.Sizeof(attr)); err != nil {
+		return fmt.Errorf("attach device filter: %w", err)
+	}
+	for _, fd := range old {
+		detach := bpfAttachAttr{
+			targetFd:    uint32(cgroupFd),
+			attachBpfFd: uint32(fd),
+			attachType:  unix.BPF_CGROUP_DEVICE,
+		}
+		if err := bpfCall(unix.BPF_PROG_DETACH, unsafe.Pointer(&detach), unsafe.Sizeof(detach)); err != nil {
+			return fmt.Errorf("detach old device filter: %w", err)
+		}
+	}
+	return nil
+}
+
+// setDeviceFilter compiles the rules and installs them on a cgroup directory.
+func setDeviceFilter(dir string, devices []spec.LinuxDeviceCgroup) error {
+	prog, err := buildDeviceFilter(devices)
+	if err != nil {
+		return err
+	}
+
+	progFd, err := loadDeviceFilter(prog)
+	if err != nil {
+		return err
+	}
+	// The attachment holds its own reference to the program
+	defer unix.Close(progFd)
+
+	cgroupFd, err := unix.Open(dir, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("open cgroup %s: %w", dir, err)
+	}
+	defer unix.Close(cgroupFd)
+
+	return attachDeviceFilter(cgroupFd, progFd)
+}
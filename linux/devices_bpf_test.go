@@ -0,0 +1,251 @@
+package linux
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+
+	"runc-go/spec"
+)
+
+// runDeviceFilter interprets the subset of eBPF emitted by buildDeviceFilter
+// for one device access and returns the program's verdict.
+func runDeviceFilter(t *testing.T, prog []ebpfInsn, typ, access, major, minor uint32) uint64 {
+	t.Helper()
+	ctx := map[int16]uint32{
+		devCtxAccessType: access<<16 | typ,
+		devCtxMajor:      major,
+		devCtxMinor:      minor,
+	}
+
+	var regs [11]uint64
+	for pc := 0; pc < len(prog); pc++ {
+		insn := prog[pc]
+		dst, src := insn.Regs&0x0f, insn.Regs>>4
+		imm := uint64(int64(insn.Imm))
+		operand := imm
+		if insn.Code&unix.BPF_X != 0 {
+			operand = regs[src]
+		}
+
+		switch insn.Code {
+		case unix.BPF_LDX | unix.BPF_MEM | unix.BPF_W:
+			if src != regCtx {
+				t.Fatalf("pc %d: load from r%d after the context was clobbered", pc, src)
+			}
+			regs[dst] = uint64(ctx[insn.Off])
+		case unix.BPF_ALU | unix.BPF_AND | unix.BPF_K:
+			regs[dst] = uint64(uint32(regs[dst]) & uint32(imm))
+		case unix.BPF_ALU | unix.BPF_RSH | unix.BPF_K:
+			regs[dst] = uint64(uint32(regs[dst]) >> uint32(imm))
+		case unix.BPF_ALU | unix.BPF_MOV | unix.BPF_X:
+			regs[dst] = uint64(uint32(regs[src]))
+		case unix.BPF_ALU64 | unix.BPF_MOV | unix.BPF_K:
+			regs[dst] = imm
+		case unix.BPF_JMP | unix.BPF_JNE | unix.BPF_K, unix.BPF_JMP | unix.BPF_JNE | unix.BPF_X:
+			if regs[dst] != operand {
+				pc += int(insn.Off)
+			}
+		case unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K:
+			if regs[dst] == operand {
+				pc += int(insn.Off)
+			}
+		case unix.BPF_JMP | unix.BPF_EXIT:
+			return regs[regRet]
+		default:
+			t.Fatalf("pc %d: unexpected opcode %#x", pc, insn.Code)
+		}
+	}
+	t.Fatal("program fell off the end")
+	return 0
+}
+
+const (
+	accR = unix.BPF_DEVCG_ACC_READ
+	accW = unix.BPF_DEVCG_ACC_WRITE
+	accM = unix.BPF_DEVCG_ACC_MKNOD
+	devC = unix.BPF_DEVCG_DEV_CHAR
+	devB = unix.BPF_DEVCG_DEV_BLOCK
+)
+
+func TestBuildDeviceFilter_DefaultSpec(t *testing.T) {
+	prog, err := buildDeviceFilter(spec.DefaultSpec().Linux.Resources.Devices)
+	if err != nil {
+		t.Fatalf("buildDeviceFilter failed: %v", err)
+	}
+
+	tests := []struct {
+		name                      string
+		typ, access, major, minor uint32
+		want                      uint64
+	}{
+		{"null rw", devC, accR | accW, 1, 3, 1},
+		{"null mknod", devC, accM, 1, 3, 1},
+		{"urandom", devC, accR, 1, 9, 1},
+		{"pts any minor", devC, accR | accW, 136, 42, 1},
+		{"mem", devC, accR, 1, 1, 0},
+		{"kmsg", devC, accR, 1, 11, 0},
+		{"sda", devB, accR, 8, 0, 0},
+		{"null as block", devB, accR, 1, 3, 0},
+	}
+	for _, tt := range tests {
+		if got := runDeviceFilter(t, prog, tt.typ, tt.access, tt.major, tt.minor); got != tt.want {
+			t.Errorf("%s: verdict = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBuildDeviceFilter_Ordering(t *testing.T) {
+	major, minor := int64(1), int64(3)
+	disk := int64(8)
+
+	prog, err := buildDeviceFilter([]spec.LinuxDeviceCgroup{
+		{Allow: true, Access: "rwm"},
+		{Allow: false, Type: "c", Major: &major, Minor: &minor, Access: "w"},
+		{Allow: false, Type: "b", Major: &disk},
+		{Allow: true, Type: "b", Major: &disk, Minor: &minor, Access: "r"},
+	})
+	if err != nil {
+		t.Fatalf("buildDeviceFilter failed: %v", err)
+	}
+
+	tests := []struct {
+		name                      string
+		typ, access, major, minor uint32
+		want                      uint64
+	}{
+		{"other device", devC, accR | accW | accM, 4, 1, 1},
+		{"null read", devC, accR, 1, 3, 1},
+		{"null write", devC, accW, 1, 3, 0},
+		{"null read-write", devC, accR | accW, 1, 3, 0},
+		{"disk read", devB, accR, 8, 0, 0},
+		{"partition read", devB, accR, 8, 3, 1},
+		{"partition write", devB, accW, 8, 3, 0},
+	}
+	for _, tt := range tests {
+		if got := runDeviceFilter(t, prog, tt.typ, tt.access, tt.major, tt.minor); got != tt.want {
+			t.Errorf("%s: verdict = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	// Without rules everything is denied
+	prog, _ = buildDeviceFilter(nil)
+	if got := runDeviceFilter(t, prog, devC, accR, 1, 3); got != 0 {
+		t.Errorf("empty filter verdict = %d, want 0", got)
+	}
+}
+
+func TestBuildDeviceFilter_Invalid(t *testing.T) {
+	negative := int64(-1)
+	huge := int64(1 << 32)
+	tests := []spec.LinuxDeviceCgroup{
+		{Allow: true, Type: "x"},
+		{Allow: true, Type: "c", Access: "rwx"},
+		{Allow: true, Type: "c", Major: &negative},
+		{Allow: true, Type: "b", Minor: &huge},
+	}
+	for _, rule := range tests {
+		if _, err := buildDeviceFilter([]spec.LinuxDeviceCgroup{rule}); err == nil {
+			t.Errorf("buildDeviceFilter(%+v) should fail", rule)
+		}
+	}
+}
+
+// TestDeviceFilter_Kernel loads the filter into the kernel, attaches it to a
+// scratch cgroup, and checks it against real device opens, including an
+// in-place replacement.
+func TestDeviceFilter_Kernel(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("skipping device filter test: requires root")
+	}
+	h, err := DetectCgroupHierarchy()
+	if err != nil || h.Unified == "" {
+		t.Skip("skipping device filter test: no cgroup v2 mount")
+	}
+
+	dir, err := os.MkdirTemp(h.Unified, "runc-go-devtest-")
+	if err != nil {
+		t.Skipf("skipping device filter test: %v", err)
+	}
+	defer os.Remove(dir)
+
+	// The verifier accepts the default policy
+	prog, err := buildDeviceFilter(spec.DefaultSpec().Linux.Resources.Devices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fd, err := loadDeviceFilter(prog); err != nil {
+		if errors.Is(err, unix.EPERM) {
+			t.Skipf("skipping device filter test: %v", err)
+		}
+		t.Fatalf("loadDeviceFilter failed: %v", err)
+	} else {
+		unix.Close(fd)
+	}
+
+	nullMajor, nullMinor := int64(1), int64(3)
+	zeroMinor := int64(5)
+	onlyNull := []spec.LinuxDeviceCgroup{
+		{Allow: true, Type: "c", Major: &nullMajor, Minor: &nullMinor, Access: "rw"},
+	}
+	if err := setDeviceFilter(dir, onlyNull); err != nil {
+		if errors.Is(err, unix.EPERM) || errors.Is(err, unix.EINVAL) {
+			t.Skipf("skipping device filter test: %v", err)
+		}
+		t.Fatalf("setDeviceFilter failed: %v", err)
+	}
+
+	// open reads a byte from a device inside the cgroup.
+	open := func(device string) error {
+		cgroupFd, err := unix.Open(dir, unix.O_RDONLY|unix.O_DIRECTORY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer unix.Close(cgroupFd)
+		cmd := exec.Command("head", "-c", "1", device)
+		cmd.SysProcAttr = &syscall.SysProcAttr{UseCgroupFD: true, CgroupFD: cgroupFd}
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%v: %s", err, out)
+		}
+		return nil
+	}
+
+	if err := open("/dev/null"); err != nil {
+		t.Skipf("skipping device filter test: cannot run in cgroup: %v", err)
+	}
+	if err := open("/dev/zero"); err == nil {
+		t.Error("/dev/zero should be denied")
+	}
+
+	// Replace the filter and check the new policy took effect
+	swapped := []spec.LinuxDeviceCgroup{
+		{Allow: true, Type: "c", Major: &nullMajor, Minor: &zeroMinor, Access: "rw"},
+	}
+	if err := setDeviceFilter(dir, swapped); err != nil {
+		t.Fatalf("replace filter failed: %v", err)
+	}
+	cgroupFd, err := unix.Open(dir, unix.O_RDONLY|unix.O_DIRECTORY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fds, err := queryDeviceFilters(cgroupFd)
+	unix.Close(cgroupFd)
+	if err != nil {
+		t.Fatalf("queryDeviceFilters failed: %v", err)
+	}
+	closeFds(fds)
+	if len(fds) != 1 {
+		t.Errorf("%d filters attached after replace, want 1", len(fds))
+	}
+	if err := open("/dev/zero"); err != nil {
+		t.Errorf("/dev/zero should be allowed after replace: %v", err)
+	}
+	if err := open("/dev/null"); err == nil {
+		t.Error("/dev/null should be denied after replace")
+	}
+}
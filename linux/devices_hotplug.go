@@ -0,0 +1,177 @@
+// Package linux provides device hot-plug into running containers.
+package linux
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+
+	"runc-go/spec"
+)
+
+// inContainerMountNamespace runs fn on a thread that has joined the mount
+// namespace of pid, with the root of pid as its root, so paths and
+// symlinks resolve as they do in the container. The thread is never
+// unlocked, so it exits with fn.
+func inContainerMountNamespace(pid int, fn func() error) error {
+	ns, err := os.Open(fmt.Sprintf("/proc/%d/ns/mnt", pid))
+	if err != nil {
+		return fmt.Errorf("open mount namespace: %w", err)
+	}
+	defer ns.Close()
+	root, err := os.OpenFile(fmt.Sprintf("/proc/%d/root", pid), unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("open root: %w", err)
+	}
+	defer root.Close()
+
+	errc := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		// A thread sharing its root and cwd with others cannot switch
+		// mount namespaces
+		if err := unix.Unshare(unix.CLONE_FS); err != nil {
+			errc <- fmt.Errorf("unshare fs: %w", err)
+			return
+		}
+		if err := unix.Setns(int(ns.Fd()), unix.CLONE_NEWNS); err != nil {
+			errc <- fmt.Errorf("setns mount: %w", err)
+			return
+		}
+		if err := unix.Fchdir(int(root.Fd())); err != nil {
+			errc <- fmt.Errorf("chdir root: %w", err)
+			return
+		}
+		if err := unix.Chroot("."); err != nil {
+			errc <- fmt.Errorf("chroot: %w", err)
+			return
+		}
+		if err := unix.Chdir("/"); err != nil {
+			errc <- fmt.Errorf("chdir /: %w", err)
+			return
+		}
+		errc <- fn()
+	}()
+	return <-errc
+}
+
+// AddDevice gives the running container of pid the device dev. As at
+// create, the node is created with mknod, or, in a user namespace, where
+// the container's /dev does not allow device nodes, bind-mounted from the
+// host's node at the same path. Nothing may exist at the path yet.
+func AddDevice(pid int, dev spec.LinuxDevice, userns bool) error {
+	if err := validateDevicePath(dev.Path); err != nil {
+		return err
+	}
+	path := filepath.Clean(dev.Path)
+
+	var tree *os.File
+	if userns && dev.Type != "p" {
+		if !mountAPIAvailable() {
+			return fmt.Errorf("device %s: binding into a user namespace needs the mount API", path)
+		}
+		if err := checkHostDevice(path, dev); err != nil {
+			return err
+		}
+		var err error
+		if tree, err = openFileTree(path, unix.MOUNT_ATTR_NOSUID|unix.MOUNT_ATTR_NOEXEC); err != nil {
+			return err
+		}
+		defer tree.Close()
+	}
+
+	return inContainerMountNamespace(pid, func() error {
+		if _, err := os.Lstat(path); err == nil {
+			return fmt.Errorf("device %s: %w", path, os.ErrExist)
+		}
+		if userns {
+			// Files are created as the owner of the container's /dev:
+			// one of an ID the user namespace does not map cannot be
+			// created there
+			var st unix.Stat_t
+			if err := unix.Stat("/dev", &st); err != nil {
+				return fmt.Errorf("stat /dev: %w", err)
+			}
+			unix.Setfsgid(int(st.Gid))
+			unix.Setfsuid(int(st.Uid))
+			dev.UID, dev.GID = &st.Uid, &st.Gid
+		}
+		if tree != nil {
+			return AttachMount(tree, path)
+		}
+		return createDeviceNode(path, dev)
+	})
+}
+
+// RemoveDevice removes the device node at path from the running container
+// of pid, unmounting it first if it was bind-mounted.
+func RemoveDevice(pid int, path string) error {
+	if err := validateDevicePath(path); err != nil {
+		return err
+	}
+	path = filepath.Clean(path)
+	return inContainerMountNamespace(pid, func() error {
+		var st unix.Stat_t
+		if err := unix.Lstat(path, &st); err != nil {
+			return fmt.Errorf("device %s: %w", path, err)
+		}
+		switch st.Mode & unix.S_IFMT {
+		case unix.S_IFCHR, unix.S_IFBLK, unix.S_IFIFO:
+		default:
+			return fmt.Errorf("%s is not a device node", path)
+		}
+		if err := unix.Unmount(path, unix.MNT_DETACH|unix.UMOUNT_NOFOLLOW); err != nil && !errors.Is(err, unix.EINVAL) {
+			return fmt.Errorf("unmount %s: %w", path, err)
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("remove %s: %w", path, err)
+		}
+		return nil
+	})
+}
+
+// UpdateDeviceRules changes the device rules of a running container's
+// cgroup from one set to another. cgroup v2 swaps in a filter compiled
+// from all the new rules at once. v1 keeps the rules written before, and
+// starting over from a deny-all would cut the container off its devices
+// meanwhile, so only the changes are written there: a deny for each
+// allow rule dropped, then the new rules from where the two differ.
+func UpdateDeviceRules(m CgroupManager, from, to []spec.LinuxDeviceCgroup) error {
+	if s, ok := m.(*SystemdCgroup); ok {
+		m = s.fs
+	}
+	v1, ok := m.(*CgroupV1)
+	if !ok {
+		return m.ApplyResources(&spec.LinuxResources{Devices: to})
+	}
+	return v1.applyDevices(deviceRuleChanges(from, to))
+}
+
+// deviceRuleChanges returns the v1 rules that take a cgroup from one set
+// of rules to another.
+func deviceRuleChanges(from, to []spec.LinuxDeviceCgroup) []spec.LinuxDeviceCgroup {
+	i := 0
+	for i < len(from) && i < len(to) && reflect.DeepEqual(from[i], to[i]) {
+		i++
+	}
+	var changes []spec.LinuxDeviceCgroup
+	for _, r := range from[i:] {
+		if !r.Allow {
+			continue
+		}
+		kept := false
+		for _, n := range to[i:] {
+			kept = kept || reflect.DeepEqual(r, n)
+		}
+		if !kept {
+			r.Allow = false
+			changes = append(changes, r)
+		}
+	}
+	return append(changes, to[i:]...)
+}
@@ -0,0 +1,151 @@
+package linux
+
+import (
+	"os"
+	"os/exec"
+	"reflect"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+
+	"runc-go/spec"
+)
+
+func TestDeviceRuleChanges(t *testing.T) {
+	rule := func(allow bool, major, minor int64, access string) spec.LinuxDeviceCgroup {
+		return spec.LinuxDeviceCgroup{Allow: allow, Type: "c", Major: &major, Minor: &minor, Access: access}
+	}
+	denyAll := spec.LinuxDeviceCgroup{Allow: false, Access: "rwm"}
+	null := rule(true, 1, 3, "rwm")
+	tun := rule(true, 10, 200, "rw")
+	policyDeny := spec.LinuxDeviceCgroup{Type: "b", Access: "rwm"}
+
+	tests := []struct {
+		name     string
+		from, to []spec.LinuxDeviceCgroup
+		want     []spec.LinuxDeviceCgroup
+	}{
+		{
+			name: "added",
+			from: []spec.LinuxDeviceCgroup{denyAll, null, policyDeny},
+			to:   []spec.LinuxDeviceCgroup{denyAll, null, tun, policyDeny},
+			want: []spec.LinuxDeviceCgroup{tun, policyDeny},
+		},
+		{
+			name: "removed",
+			from: []spec.LinuxDeviceCgroup{denyAll, null, tun, policyDeny},
+			to:   []spec.LinuxDeviceCgroup{denyAll, null, policyDeny},
+			want: []spec.LinuxDeviceCgroup{rule(false, 10, 200, "rw"), policyDeny},
+		},
+		{
+			name: "unchanged",
+			from: []spec.LinuxDeviceCgroup{denyAll, null},
+			to:   []spec.LinuxDeviceCgroup{denyAll, null},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := deviceRuleChanges(tt.from, tt.to)
+			if len(got) != 0 || len(tt.want) != 0 {
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("deviceRuleChanges() = %+v, want %+v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// startMountNamespace starts a process in a mount namespace of its own,
+// with a tmpfs over its /dev, standing in for a container.
+func startMountNamespace(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command("sleep", "60")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWNS}
+	if err := cmd.Start(); err != nil {
+		t.Skipf("mount namespace unavailable: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	pid := cmd.Process.Pid
+	err := inContainerMountNamespace(pid, func() error {
+		if err := unix.Mount("", "/", "", unix.MS_PRIVATE|unix.MS_REC, ""); err != nil {
+			return err
+		}
+		return unix.Mount("tmpfs", "/dev", "tmpfs", unix.MS_NOSUID, "mode=755")
+	})
+	if err != nil {
+		t.Fatalf("set up mount namespace: %v", err)
+	}
+	return pid
+}
+
+func TestAddDevice(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("mknod needs root")
+	}
+	pid := startMountNamespace(t)
+	root := "/proc/" + strconv.Itoa(pid) + "/root"
+
+	dev := spec.LinuxDevice{Path: "/dev/hotplug0", Type: "c", Major: 1, Minor: 3}
+	if err := AddDevice(pid, dev, false); err != nil {
+		t.Fatalf("AddDevice error: %v", err)
+	}
+	if _, err := os.Lstat("/dev/hotplug0"); err == nil {
+		t.Fatal("AddDevice created the node in the host's /dev")
+	}
+	var st unix.Stat_t
+	if err := unix.Stat(root+"/dev/hotplug0", &st); err != nil {
+		t.Fatalf("stat added device: %v", err)
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFCHR || unix.Major(st.Rdev) != 1 || unix.Minor(st.Rdev) != 3 {
+		t.Errorf("added device is %#o %d:%d, want c 1:3", st.Mode, unix.Major(st.Rdev), unix.Minor(st.Rdev))
+	}
+	if err := AddDevice(pid, dev, false); err == nil {
+		t.Error("AddDevice replaced an existing node")
+	}
+	if err := RemoveDevice(pid, dev.Path); err != nil {
+		t.Fatalf("RemoveDevice error: %v", err)
+	}
+	if _, err := os.Lstat(root + "/dev/hotplug0"); err == nil {
+		t.Error("RemoveDevice left the node")
+	}
+}
+
+func TestAddDevice_UserNamespace(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("mounting needs root")
+	}
+	if !mountAPIAvailable() {
+		t.Skip("no mount API")
+	}
+	pid := startMountNamespace(t)
+	root := "/proc/" + strconv.Itoa(pid) + "/root"
+
+	// The host's node is bound, so it must match
+	if err := AddDevice(pid, spec.LinuxDevice{Path: "/dev/null", Type: "c", Major: 1, Minor: 5}, true); err == nil {
+		t.Fatal("AddDevice bound a host node of other numbers")
+	}
+	if err := AddDevice(pid, spec.LinuxDevice{Path: "/dev/null", Type: "c", Major: 1, Minor: 3}, true); err != nil {
+		t.Fatalf("AddDevice error: %v", err)
+	}
+	var st unix.Stat_t
+	if err := unix.Stat(root+"/dev/null", &st); err != nil {
+		t.Fatalf("stat added device: %v", err)
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFCHR || unix.Major(st.Rdev) != 1 || unix.Minor(st.Rdev) != 3 {
+		t.Errorf("added device is %#o %d:%d, want c 1:3", st.Mode, unix.Major(st.Rdev), unix.Minor(st.Rdev))
+	}
+	if err := RemoveDevice(pid, "/dev/null"); err != nil {
+		t.Fatalf("RemoveDevice error: %v", err)
+	}
+	if _, err := os.Lstat(root + "/dev/null"); err == nil {
+		t.Error("RemoveDevice left the bind mount point")
+	}
+	if _, err := os.Stat("/dev/null"); err != nil {
+		t.Errorf("host /dev/null is gone: %v", err)
+	}
+}
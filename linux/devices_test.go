@@ -3,8 +3,11 @@ package linux
 import (
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
 
+	"golang.org/x/sys/unix"
+
 	"runc-go/spec"
 )
 
@@ -90,7 +93,7 @@ func TestValidateDevicePath_SymlinkEscape(t *testing.T) {
 	}
 }
 
-// TestIsAllowedDevice tests the device whitelist.
+// TestIsAllowedDevice tests the built-in device policy.
 func TestIsAllowedDevice(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -112,12 +115,13 @@ func TestIsAllowedDevice(t *testing.T) {
 		{"dev/kmem (not allowed)", 1, 2, false},
 	}
 
+	policy := DefaultDevicePolicy()
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			dev := spec.LinuxDevice{Major: tt.major, Minor: tt.minor}
-			got := isAllowedDevice(dev)
+			dev := spec.LinuxDevice{Type: "c", Major: tt.major, Minor: tt.minor}
+			got := policy.Allows(dev)
 			if got != tt.allowed {
-				t.Errorf("isAllowedDevice(major=%d, minor=%d) = %v, want %v",
+				t.Errorf("Allows(major=%d, minor=%d) = %v, want %v",
 					tt.major, tt.minor, got, tt.allowed)
 			}
 		})
@@ -144,8 +148,8 @@ func TestDefaultDevices(t *testing.T) {
 		}
 		delete(expectedPaths, dev.Path)
 
-		// Verify it's in the whitelist
-		if !isAllowedDevice(dev) {
+		// Verify the built-in policy allows it
+		if !DefaultDevicePolicy().Allows(dev) {
 			t.Errorf("Default device %s (major=%d, minor=%d) is not in allowed list",
 				dev.Path, dev.Major, dev.Minor)
 		}
@@ -198,7 +202,7 @@ func TestCreateAllDevices_PathTraversal(t *testing.T) {
 				{Path: tt.path, Type: "c", Major: 1, Minor: 3, FileMode: &mode},
 			}
 
-			err := CreateAllDevices(devices, rootfs)
+			err := CreateAllDevices(devices, rootfs, DefaultDevicePolicy())
 			if err == nil {
 				t.Errorf("CreateAllDevices should reject path traversal: %s", tt.path)
 			}
@@ -242,7 +246,7 @@ func TestCreateDeviceNode_SymlinkSafety(t *testing.T) {
 		{Path: "/dev/escape/malicious", Type: "c", Major: 1, Minor: 3, FileMode: &mode},
 	}
 
-	err = CreateAllDevices(devices, rootfs)
+	err = CreateAllDevices(devices, rootfs, DefaultDevicePolicy())
 	// This should fail because SecureJoin should detect the symlink escape
 	if err == nil {
 		// Check if a file was created outside rootfs
@@ -294,25 +298,194 @@ func containsHelper(s, substr string) bool {
 	return false
 }
 
-// TestIsPTYDevice tests PTY device detection.
-func TestIsPTYDevice(t *testing.T) {
+// TestDeviceResources verifies the container's devices are allowed on top
+// of its device rules.
+func TestDeviceResources(t *testing.T) {
+	major, minor := int64(1), int64(11)
+	denyAll := spec.LinuxDeviceCgroup{Allow: false, Type: "a", Access: "rwm"}
+	l := &spec.Linux{
+		Devices: []spec.LinuxDevice{
+			{Path: "/dev/kmsg", Type: "c", Major: 1, Minor: 11},
+			{Path: "/dev/null", Type: "c", Major: 1, Minor: 3},
+			{Path: "/dev/fifo", Type: "p"},
+		},
+		Resources: &spec.LinuxResources{Devices: []spec.LinuxDeviceCgroup{{Allow: false, Access: "rwm"}}},
+	}
+	r := DeviceResources(l, DefaultDevicePolicy())
+	want := append([]spec.LinuxDeviceCgroup{denyAll}, defaultDeviceRules...)
+	want = append(want, spec.LinuxDeviceCgroup{Allow: true, Type: "c", Major: &major, Minor: &minor, Access: "rwm"})
+	if !reflect.DeepEqual(r.Devices, want) {
+		t.Errorf("DeviceResources() devices = %+v, want %+v", r.Devices, want)
+	}
+	if len(l.Resources.Devices) != 1 {
+		t.Error("DeviceResources() changed the spec's rules")
+	}
+}
+
+// TestDeviceResources_NoRules verifies a spec without device rules still
+// gets a filter that denies what is not a default device.
+func TestDeviceResources_NoRules(t *testing.T) {
+	for _, l := range []*spec.Linux{nil, {}, {Resources: &spec.LinuxResources{}}} {
+		r := DeviceResources(l, DefaultDevicePolicy())
+		want := append([]spec.LinuxDeviceCgroup{{Allow: false, Type: "a", Access: "rwm"}}, defaultDeviceRules...)
+		if r == nil || !reflect.DeepEqual(r.Devices, want) {
+			t.Fatalf("DeviceResources(%+v) = %+v, want deny-all and the defaults", l, r)
+		}
+		prog, err := buildDeviceFilter(r.Devices)
+		if err != nil {
+			t.Fatalf("buildDeviceFilter failed: %v", err)
+		}
+		if runDeviceFilter(t, prog, devC, accR|accW, 1, 3) != 1 {
+			t.Error("/dev/null is denied")
+		}
+		if runDeviceFilter(t, prog, devB, accR, 8, 0) != 0 {
+			t.Error("/dev/sda is allowed")
+		}
+	}
+}
+
+// TestDeviceResources_DenyAll verifies the usual deny-all rule does not
+// take back the default devices.
+func TestDeviceResources_DenyAll(t *testing.T) {
+	l := &spec.Linux{Resources: &spec.LinuxResources{Devices: []spec.LinuxDeviceCgroup{
+		{Allow: false, Access: "rwm"},
+		{Allow: false, Type: "a", Access: "rwm"},
+	}}}
+	prog, err := buildDeviceFilter(DeviceResources(l, DefaultDevicePolicy()).Devices)
+	if err != nil {
+		t.Fatalf("buildDeviceFilter failed: %v", err)
+	}
 	tests := []struct {
-		major int64
-		minor int64
-		isPTY bool
+		name                      string
+		typ, access, major, minor uint32
+		want                      uint64
 	}{
-		{136, 0, true},   // /dev/pts/0
-		{136, 10, true},  // /dev/pts/10
-		{136, 255, true}, // /dev/pts/255
-		{5, 2, false},    // /dev/ptmx (not a PTY slave)
-		{1, 3, false},    // /dev/null
-		{8, 0, false},    // /dev/sda
+		{"null", devC, accR | accW, 1, 3, 1},
+		{"ptmx", devC, accR | accW, 5, 2, 1},
+		{"pts", devC, accR | accW, 136, 4, 1},
+		{"mknod", devB, accM, 8, 0, 1},
+		{"mem", devC, accR, 1, 1, 0},
+		{"sda", devB, accR, 8, 0, 0},
+		{"tun", devC, accR | accW, 10, 200, 0},
 	}
-
 	for _, tt := range tests {
-		got := isPTYDevice(tt.major, tt.minor)
-		if got != tt.isPTY {
-			t.Errorf("isPTYDevice(%d, %d) = %v, want %v", tt.major, tt.minor, got, tt.isPTY)
+		if got := runDeviceFilter(t, prog, tt.typ, tt.access, tt.major, tt.minor); got != tt.want {
+			t.Errorf("%s: verdict = %d, want %d", tt.name, got, tt.want)
 		}
 	}
 }
+
+// TestCheckHostDevice verifies a bind-mounted device must match the host's.
+func TestCheckHostDevice(t *testing.T) {
+	if err := checkHostDevice("/dev/null", spec.LinuxDevice{Type: "c", Major: 1, Minor: 3}); err != nil {
+		t.Errorf("checkHostDevice(/dev/null) error: %v", err)
+	}
+	if err := checkHostDevice("/dev/null", spec.LinuxDevice{Type: "c", Major: 1, Minor: 5}); err == nil {
+		t.Error("checkHostDevice accepted /dev/null as 1:5")
+	}
+	if err := checkHostDevice("/dev/null", spec.LinuxDevice{Type: "b", Major: 1, Minor: 3}); err == nil {
+		t.Error("checkHostDevice accepted /dev/null as a block device")
+	}
+	if err := checkHostDevice("/dev/does-not-exist", spec.LinuxDevice{Type: "c", Major: 1, Minor: 3}); err == nil {
+		t.Error("checkHostDevice accepted a missing host device")
+	}
+}
+
+// TestSetupDevices verifies a private /dev is populated, with the spec's
+// devices replacing the defaults.
+func TestSetupDevices(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("mounting needs root")
+	}
+	rootfs := t.TempDir()
+	mode := os.FileMode(0640)
+	s := &spec.Spec{
+		Process: &spec.Process{},
+		Linux: &spec.Linux{Devices: []spec.LinuxDevice{
+			{Path: "/dev/zero", Type: "c", Major: 1, Minor: 5, FileMode: &mode},
+			{Path: "/dev/fifo0", Type: "p"},
+		}},
+	}
+	// A node left in the rootfs must not show through
+	if err := os.MkdirAll(filepath.Join(rootfs, "dev"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(rootfs, "dev", "stale"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var err error
+	var zero, fifo, null os.FileInfo
+	var ptmx string
+	var staleErr error
+	inMountNamespace(t, func() {
+		if err = SetupDevTmpfs(s, rootfs); err != nil {
+			return
+		}
+		defer unix.Unmount(filepath.Join(rootfs, "dev"), unix.MNT_DETACH)
+		if err = SetupDevices(s, rootfs, DefaultDevicePolicy()); err != nil {
+			return
+		}
+		defer unix.Unmount(filepath.Join(rootfs, "dev", "pts"), unix.MNT_DETACH)
+		zero, _ = os.Lstat(filepath.Join(rootfs, "dev", "zero"))
+		fifo, _ = os.Lstat(filepath.Join(rootfs, "dev", "fifo0"))
+		null, _ = os.Lstat(filepath.Join(rootfs, "dev", "null"))
+		ptmx, _ = os.Readlink(filepath.Join(rootfs, "dev", "ptmx"))
+		_, staleErr = os.Lstat(filepath.Join(rootfs, "dev", "stale"))
+	})
+	if err != nil {
+		t.Fatalf("SetupDevices error: %v", err)
+	}
+	if zero == nil || zero.Mode() != os.ModeDevice|os.ModeCharDevice|0640 {
+		t.Errorf("/dev/zero = %v, want the spec's mode 0640", zero)
+	}
+	if fifo == nil || fifo.Mode()&os.ModeNamedPipe == 0 {
+		t.Errorf("/dev/fifo0 = %v, want a FIFO", fifo)
+	}
+	if null == nil || null.Mode() != os.ModeDevice|os.ModeCharDevice|0666 {
+		t.Errorf("/dev/null = %v, want a default device", null)
+	}
+	if ptmx != "pts/ptmx" {
+		t.Errorf("/dev/ptmx -> %q, want pts/ptmx", ptmx)
+	}
+	if staleErr == nil {
+		t.Error("the rootfs's own /dev shows through the tmpfs")
+	}
+}
+
+// TestSetupDevices_Rejects verifies create fails for a device that cannot
+// be provided.
+func TestSetupDevices_Rejects(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("mounting needs root")
+	}
+	tests := []struct {
+		name       string
+		namespaces []spec.LinuxNamespace
+		device     spec.LinuxDevice
+	}{
+		{name: "not allowed", device: spec.LinuxDevice{Path: "/dev/sda", Type: "b", Major: 8, Minor: 0}},
+		{
+			name:       "no host node in a user namespace",
+			namespaces: []spec.LinuxNamespace{{Type: spec.UserNamespace}},
+			device:     spec.LinuxDevice{Path: "/dev/missing", Type: "c", Major: 1, Minor: 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rootfs := t.TempDir()
+			s := &spec.Spec{Linux: &spec.Linux{Namespaces: tt.namespaces, Devices: []spec.LinuxDevice{tt.device}}}
+			var err error
+			inMountNamespace(t, func() {
+				if err = SetupDevTmpfs(s, rootfs); err != nil {
+					return
+				}
+				err = SetupDevices(s, rootfs, DefaultDevicePolicy())
+				unix.Unmount(filepath.Join(rootfs, "dev"), unix.MNT_DETACH)
+			})
+			if err == nil {
+				t.Errorf("SetupDevices accepted %s", tt.device.Path)
+			}
+		})
+	}
+}
@@ -0,0 +1,313 @@
+// Package linux provides generated /etc/hosts, /etc/hostname and
+// /etc/resolv.conf files.
+package linux
+
+import (
+	"bufio"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+
+	"runc-go/spec"
+)
+
+const (
+	// EtcFilesAnnotation asks for generated /etc files, bind-mounted
+	// read-only over the rootfs's own: "true" for hosts, hostname and
+	// resolv.conf, or a comma-separated list of them.
+	EtcFilesAnnotation = "org.opencontainers.runc-go.etc.files"
+
+	// EtcDNSAnnotation lists the nameservers of the generated
+	// resolv.conf, separated by commas. Without it, the host's are used.
+	EtcDNSAnnotation = "org.opencontainers.runc-go.etc.dns"
+
+	// EtcDNSSearchAnnotation lists the search domains of the generated
+	// resolv.conf, separated by commas, in place of the host's.
+	EtcDNSSearchAnnotation = "org.opencontainers.runc-go.etc.dns-search"
+
+	// EtcDNSOptionsAnnotation lists the resolver options of the generated
+	// resolv.conf, separated by commas, in place of the host's.
+	EtcDNSOptionsAnnotation = "org.opencontainers.runc-go.etc.dns-options"
+
+	// etcFilesDir is where the files are generated, inside the
+	// container's state directory.
+	etcFilesDir = "etc"
+)
+
+// etcFileNames are the files EtcFilesAnnotation can ask for.
+var etcFileNames = []string{"hosts", "hostname", "resolv.conf"}
+
+// hostResolvConfs are the host's resolver configurations, in order of
+// preference. The second is what systemd-resolved's stub at 127.0.0.53
+// forwards to, for containers that cannot reach the host's loopback.
+var hostResolvConfs = []string{"/etc/resolv.conf", "/run/systemd/resolve/resolv.conf"}
+
+// EtcFiles describes the /etc files generated for a container.
+type EtcFiles struct {
+	// Files are the names of the files to generate, in /etc.
+	Files []string
+	// Hostname and Domainname name the container in hosts and hostname.
+	Hostname   string
+	Domainname string
+	// Nameservers, Search and Options go into resolv.conf. Nameservers
+	// empty means the host's.
+	Nameservers []string
+	Search      []string
+	Options     []string
+	// PrivateNetwork drops the host's loopback nameservers, which the
+	// container's own network namespace cannot reach.
+	PrivateNetwork bool
+}
+
+// splitList splits a comma-separated annotation value, dropping blanks.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// ParseEtcFiles returns the /etc files the annotations of s ask for, or nil
+// for none. A file the spec already mounts something over is left alone.
+func ParseEtcFiles(s *spec.Spec) (*EtcFiles, error) {
+	if s == nil {
+		return nil, nil
+	}
+	value := s.Annotations[EtcFilesAnnotation]
+	if value == "" || value == "false" {
+		return nil, nil
+	}
+	names := etcFileNames
+	if value != "true" {
+		names = splitList(value)
+	}
+
+	e := &EtcFiles{Hostname: s.Hostname, Domainname: s.Domainname}
+	for _, name := range names {
+		known := false
+		for _, n := range etcFileNames {
+			known = known || n == name
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown /etc file %q, want hosts, hostname or resolv.conf", name)
+		}
+		if name == "hostname" && s.Hostname == "" {
+			continue
+		}
+		mounted := false
+		for _, m := range s.Mounts {
+			mounted = mounted || filepath.Clean(m.Destination) == "/etc/"+name
+		}
+		if !mounted {
+			e.Files = append(e.Files, name)
+		}
+	}
+
+	for _, ns := range splitList(s.Annotations[EtcDNSAnnotation]) {
+		if _, err := netip.ParseAddr(ns); err != nil {
+			return nil, fmt.Errorf("invalid nameserver %q", ns)
+		}
+		e.Nameservers = append(e.Nameservers, ns)
+	}
+	e.Search = splitList(s.Annotations[EtcDNSSearchAnnotation])
+	e.Options = splitList(s.Annotations[EtcDNSOptionsAnnotation])
+	for _, item := range append(e.Search, e.Options...) {
+		if strings.ContainsAny(item, " \t\n") {
+			return nil, fmt.Errorf("invalid resolv.conf entry %q", item)
+		}
+	}
+	if s.Linux != nil {
+		e.PrivateNetwork = HasNamespace(s.Linux.Namespaces, spec.NetworkNamespace) &&
+			GetNamespacePath(s.Linux.Namespaces, spec.NetworkNamespace) == ""
+	}
+	return e, nil
+}
+
+// Generate writes the files into the etc directory of stateDir.
+func (e *EtcFiles) Generate(stateDir string) error {
+	dir := filepath.Join(stateDir, etcFilesDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("create %s: %w", dir, err)
+	}
+	for _, name := range e.Files {
+		var content string
+		var err error
+		switch name {
+		case "hosts":
+			content = e.hosts()
+		case "hostname":
+			content = e.Hostname + "\n"
+		case "resolv.conf":
+			content, err = e.resolvConf()
+		}
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			return fmt.Errorf("write %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// hosts returns the container's /etc/hosts. The hostname resolves to
+// 127.0.1.1, as on Debian, since the container's address is not known.
+func (e *EtcFiles) hosts() string {
+	var b strings.Builder
+	b.WriteString("127.0.0.1\tlocalhost\n")
+	b.WriteString("::1\tlocalhost ip6-localhost ip6-loopback\n")
+	if e.Hostname != "" {
+		names := e.Hostname
+		if e.Domainname != "" {
+			names = e.Hostname + "." + e.Domainname + " " + e.Hostname
+		}
+		fmt.Fprintf(&b, "127.0.1.1\t%s\n", names)
+	}
+	return b.String()
+}
+
+// resolvConf returns the container's /etc/resolv.conf, starting from the
+// host's when the annotations leave anything out.
+func (e *EtcFiles) resolvConf() (string, error) {
+	nameservers, search, options := e.Nameservers, e.Search, e.Options
+	if len(nameservers) == 0 || len(search) == 0 || len(options) == 0 {
+		var hostNS, hostSearch, hostOptions []string
+		for _, path := range hostResolvConfs {
+			fileNS, fileSearch, fileOptions, err := readResolvConf(path, e.PrivateNetwork)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return "", err
+			}
+			hostNS, hostSearch, hostOptions = fileNS, fileSearch, fileOptions
+			if len(hostNS) > 0 {
+				break
+			}
+		}
+		if len(nameservers) == 0 {
+			nameservers = hostNS
+		}
+		if len(search) == 0 {
+			search = hostSearch
+		}
+		if len(options) == 0 {
+			options = hostOptions
+		}
+	}
+	if len(nameservers) == 0 {
+		fmt.Printf("[etc] warning: resolv.conf has no nameservers, set %s\n", EtcDNSAnnotation)
+	}
+
+	var b strings.Builder
+	for _, ns := range nameservers {
+		fmt.Fprintf(&b, "nameserver %s\n", ns)
+	}
+	if len(search) > 0 {
+		fmt.Fprintf(&b, "search %s\n", strings.Join(search, " "))
+	}
+	if len(options) > 0 {
+		fmt.Fprintf(&b, "options %s\n", strings.Join(options, " "))
+	}
+	return b.String(), nil
+}
+
+// readResolvConf returns the nameservers, search domains and options of a
+// resolv.conf, without loopback nameservers when dropLoopback is set.
+func readResolvConf(path string, dropLoopback bool) (nameservers, search, options []string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], ";") {
+			continue
+		}
+		switch fields[0] {
+		case "nameserver":
+			addr, err := netip.ParseAddr(fields[1])
+			if err != nil || (dropLoopback && addr.IsLoopback()) {
+				continue
+			}
+			nameservers = append(nameservers, fields[1])
+		case "search", "domain":
+			// The last of them wins, as in the resolver
+			search = fields[1:]
+		case "options":
+			options = append(options, fields[1:]...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	return nameservers, search, options, nil
+}
+
+// etcFileMounts returns the read-only bind mounts of the generated files
+// of s. SetupRootfs performs them after the spec's mounts, so their
+// mountTrees keys follow the spec's mount indexes.
+func etcFileMounts(s *spec.Spec, stateDir string) []spec.Mount {
+	e, err := ParseEtcFiles(s)
+	if err != nil || e == nil {
+		return nil
+	}
+	var mounts []spec.Mount
+	for _, name := range e.Files {
+		mounts = append(mounts, spec.Mount{
+			Destination: "/etc/" + name,
+			Type:        "bind",
+			Source:      filepath.Join(stateDir, etcFilesDir, name),
+			Options:     []string{"bind", "ro", "nosuid", "nodev", "noexec"},
+		})
+	}
+	return mounts
+}
+
+// OpenEtcFileMounts prepares the bind mounts of the generated files of s
+// as detached read-only mount trees, keyed like the mounts of
+// etcFileMounts. A user namespaced init may not be able to reach the
+// state directory, so the runtime clones the files; without the mount
+// API the init bind mounts them by path.
+func OpenEtcFileMounts(s *spec.Spec, stateDir string) (map[int]*os.File, error) {
+	files := make(map[int]*os.File)
+	if !mountAPIAvailable() {
+		return files, nil
+	}
+	for i, m := range etcFileMounts(s, stateDir) {
+		tree, err := openFileTree(m.Source, unix.MOUNT_ATTR_RDONLY|unix.MOUNT_ATTR_NOSUID|unix.MOUNT_ATTR_NODEV|unix.MOUNT_ATTR_NOEXEC)
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, fmt.Errorf("%s: %w", m.Destination, err)
+		}
+		files[len(s.Mounts)+i] = tree
+	}
+	return files, nil
+}
+
+// openFileTree clones the mount of path, a single file, as a detached
+// tree with the given mount attributes.
+func openFileTree(path string, attrs uint64) (*os.File, error) {
+	fd, err := unix.OpenTree(unix.AT_FDCWD, path, unix.OPEN_TREE_CLONE|unix.OPEN_TREE_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("open_tree %s: %w", path, err)
+	}
+	tree := os.NewFile(uintptr(fd), path)
+	if err := setTreeAttrs(tree, nil, unix.MountAttr{Attr_set: attrs}); err != nil {
+		tree.Close()
+		return nil, err
+	}
+	return tree, nil
+}
@@ -0,0 +1,215 @@
+package linux
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/sys/unix"
+
+	"runc-go/spec"
+)
+
+func etcSpec(annotations map[string]string) *spec.Spec {
+	return &spec.Spec{
+		Hostname:    "web",
+		Domainname:  "example.org",
+		Annotations: annotations,
+		Linux:       &spec.Linux{Namespaces: []spec.LinuxNamespace{{Type: spec.NetworkNamespace}}},
+	}
+}
+
+func TestParseEtcFiles(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        *EtcFiles
+		wantErr     bool
+	}{
+		{
+			name: "none",
+		},
+		{
+			name:        "all",
+			annotations: map[string]string{EtcFilesAnnotation: "true"},
+			want: &EtcFiles{
+				Files:          []string{"hosts", "hostname", "resolv.conf"},
+				Hostname:       "web",
+				Domainname:     "example.org",
+				PrivateNetwork: true,
+			},
+		},
+		{
+			name: "resolv.conf with dns",
+			annotations: map[string]string{
+				EtcFilesAnnotation:      "resolv.conf",
+				EtcDNSAnnotation:        "10.0.0.1, fd00::1",
+				EtcDNSSearchAnnotation:  "svc.local",
+				EtcDNSOptionsAnnotation: "ndots:2,edns0",
+			},
+			want: &EtcFiles{
+				Files:          []string{"resolv.conf"},
+				Hostname:       "web",
+				Domainname:     "example.org",
+				Nameservers:    []string{"10.0.0.1", "fd00::1"},
+				Search:         []string{"svc.local"},
+				Options:        []string{"ndots:2", "edns0"},
+				PrivateNetwork: true,
+			},
+		},
+		{
+			name:        "unknown file",
+			annotations: map[string]string{EtcFilesAnnotation: "hosts,passwd"},
+			wantErr:     true,
+		},
+		{
+			name:        "bad nameserver",
+			annotations: map[string]string{EtcFilesAnnotation: "true", EtcDNSAnnotation: "dns.example.org"},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseEtcFiles(etcSpec(tt.annotations))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseEtcFiles() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseEtcFiles() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	// A file the spec mounts itself is not generated
+	s := etcSpec(map[string]string{EtcFilesAnnotation: "true"})
+	s.Hostname = ""
+	s.Mounts = []spec.Mount{{Destination: "/etc/resolv.conf", Type: "bind", Source: "/etc/resolv.conf"}}
+	e, err := ParseEtcFiles(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"hosts"}; !reflect.DeepEqual(e.Files, want) {
+		t.Errorf("files = %v, want %v", e.Files, want)
+	}
+	if mounts := etcFileMounts(s, "/run/state"); len(mounts) != 1 || mounts[0].Source != "/run/state/etc/hosts" {
+		t.Errorf("etcFileMounts() = %+v", mounts)
+	}
+}
+
+func TestEtcFiles_Generate(t *testing.T) {
+	host := filepath.Join(t.TempDir(), "resolv.conf")
+	conf := "# host\nnameserver 127.0.0.53\nnameserver 192.0.2.1\nnameserver ::1\nsearch lan\noptions edns0 trust-ad\n"
+	if err := os.WriteFile(host, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	saved := hostResolvConfs
+	hostResolvConfs = []string{host}
+	defer func() { hostResolvConfs = saved }()
+
+	tests := []struct {
+		name string
+		e    EtcFiles
+		want map[string]string
+	}{
+		{
+			name: "private network",
+			e: EtcFiles{
+				Files:          []string{"hosts", "hostname", "resolv.conf"},
+				Hostname:       "web",
+				Domainname:     "example.org",
+				PrivateNetwork: true,
+			},
+			want: map[string]string{
+				"hosts":       "127.0.0.1\tlocalhost\n::1\tlocalhost ip6-localhost ip6-loopback\n127.0.1.1\tweb.example.org web\n",
+				"hostname":    "web\n",
+				"resolv.conf": "nameserver 192.0.2.1\nsearch lan\noptions edns0 trust-ad\n",
+			},
+		},
+		{
+			name: "host network",
+			e:    EtcFiles{Files: []string{"resolv.conf"}, Search: []string{"svc.local"}},
+			want: map[string]string{
+				"resolv.conf": "nameserver 127.0.0.53\nnameserver 192.0.2.1\nnameserver ::1\nsearch svc.local\noptions edns0 trust-ad\n",
+			},
+		},
+		{
+			name: "own nameservers",
+			e:    EtcFiles{Files: []string{"hosts", "resolv.conf"}, Nameservers: []string{"10.0.0.1"}, Options: []string{"ndots:1"}},
+			want: map[string]string{
+				"hosts":       "127.0.0.1\tlocalhost\n::1\tlocalhost ip6-localhost ip6-loopback\n",
+				"resolv.conf": "nameserver 10.0.0.1\nsearch lan\noptions ndots:1\n",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stateDir := t.TempDir()
+			if err := tt.e.Generate(stateDir); err != nil {
+				t.Fatalf("Generate error: %v", err)
+			}
+			for name, want := range tt.want {
+				got, err := os.ReadFile(filepath.Join(stateDir, etcFilesDir, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenEtcFileMounts(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("mounting needs root")
+	}
+	if !mountAPIAvailable() {
+		t.Skip("no mount API")
+	}
+	stateDir := t.TempDir()
+	s := etcSpec(map[string]string{EtcFilesAnnotation: "hostname"})
+	s.Mounts = []spec.Mount{{Destination: "/proc", Type: "proc", Source: "proc"}}
+	e, err := ParseEtcFiles(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Generate(stateDir); err != nil {
+		t.Fatal(err)
+	}
+
+	trees, err := OpenEtcFileMounts(s, stateDir)
+	if err != nil {
+		t.Fatalf("OpenEtcFileMounts error: %v", err)
+	}
+	// The trees follow the spec's own mounts
+	tree, ok := trees[len(s.Mounts)]
+	if len(trees) != 1 || !ok {
+		t.Fatalf("trees = %v, want one at index %d", trees, len(s.Mounts))
+	}
+	target := filepath.Join(t.TempDir(), "hostname")
+	if err := os.WriteFile(target, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var content []byte
+	var writeErr error
+	inMountNamespace(t, func() {
+		if err = AttachMount(tree, target); err != nil {
+			return
+		}
+		content, err = os.ReadFile(target)
+		writeErr = os.WriteFile(target, []byte("x"), 0644)
+		unix.Unmount(target, unix.MNT_DETACH)
+	})
+	tree.Close()
+	if err != nil {
+		t.Fatalf("attach hostname: %v", err)
+	}
+	if string(content) != "web\n" {
+		t.Errorf("hostname = %q, want the generated one", content)
+	}
+	if writeErr == nil {
+		t.Error("generated hostname is writable")
+	}
+}
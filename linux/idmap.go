@@ -0,0 +1,223 @@
+// Package linux provides ID-mapped bind mounts.
+package linux
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+
+	"runc-go/spec"
+)
+
+// usernsHolderEnv makes a re-executed runtime hold a user namespace open
+// until its stdin is closed. It only exists to be opened by newUserNamespace.
+const usernsHolderEnv = "_RUNC_GO_USERNS_HOLDER"
+
+func init() {
+	if os.Getenv(usernsHolderEnv) == "1" {
+		io.Copy(io.Discard, os.Stdin)
+		os.Exit(0)
+	}
+}
+
+// IDMappedMount reports whether a mount asks for an ID-mapped bind mount:
+// it has the "idmap" or "ridmap" option, or mappings of its own.
+func IDMappedMount(m spec.Mount) bool {
+	return hasOption(m.Options, "idmap") || hasOption(m.Options, "ridmap") ||
+		len(m.UIDMappings) > 0 || len(m.GIDMappings) > 0
+}
+
+// idMapAttrs maps mount flags to mount_setattr attributes.
+var idMapAttrs = map[uintptr]uint64{
+	MS_RDONLY: unix.MOUNT_ATTR_RDONLY,
+	MS_NOSUID: unix.MOUNT_ATTR_NOSUID,
+	MS_NODEV:  unix.MOUNT_ATTR_NODEV,
+	MS_NOEXEC: unix.MOUNT_ATTR_NOEXEC,
+}
+
+// OpenIDMappedMounts prepares the ID-mapped mounts of a spec as detached
+// mount trees, keyed by their index in s.Mounts. This needs CAP_SYS_ADMIN
+// over the source filesystems, so it runs in the runtime before the init
+// enters its user namespace; the init attaches the trees with AttachMount.
+// Each mount's mappings default to the container's user namespace
+// mappings, so files owned by host root appear owned by container root.
+func OpenIDMappedMounts(s *spec.Spec, bundlePath string) (map[int]*os.File, error) {
+	var rootfs string
+	if s.Root != nil {
+		rootfs = s.Root.Path
+		if !filepath.IsAbs(rootfs) {
+			rootfs = filepath.Join(bundlePath, rootfs)
+		}
+	}
+
+	files := make(map[int]*os.File)
+	closeAll := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+	usernsCache := make(map[string]*os.File)
+	defer func() {
+		for _, f := range usernsCache {
+			f.Close()
+		}
+	}()
+
+	for i, m := range s.Mounts {
+		if !IDMappedMount(m) {
+			continue
+		}
+		f, err := openIDMappedMount(m, s.Linux, rootfs, usernsCache)
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("idmapped mount %s: %w", m.Destination, err)
+		}
+		files[i] = f
+	}
+	return files, nil
+}
+
+// openIDMappedMount clones the mount source and applies the ID mapping
+// and mount flags to the clone.
+func openIDMappedMount(m spec.Mount, l *spec.Linux, rootfs string, usernsCache map[string]*os.File) (*os.File, error) {
+	isBind := m.Type == "bind" || hasOption(m.Options, "bind") || hasOption(m.Options, "rbind")
+	if !isBind {
+		return nil, fmt.Errorf("only bind mounts can be ID-mapped")
+	}
+
+	uidMappings, gidMappings := m.UIDMappings, m.GIDMappings
+	if len(uidMappings) == 0 && len(gidMappings) == 0 && l != nil {
+		uidMappings, gidMappings = l.UIDMappings, l.GIDMappings
+	}
+	if len(uidMappings) == 0 || len(gidMappings) == 0 {
+		return nil, fmt.Errorf("no uid and gid mappings for the mount or the container")
+	}
+
+	source := m.Source
+	if !filepath.IsAbs(source) {
+		var err error
+		if source, err = SecureJoin(rootfs, source); err != nil {
+			return nil, fmt.Errorf("invalid bind source %q: %w", m.Source, err)
+		}
+	}
+
+	key := formatIDMap(uidMappings) + "/" + formatIDMap(gidMappings)
+	userns, ok := usernsCache[key]
+	if !ok {
+		var err error
+		if userns, err = newUserNamespace(uidMappings, gidMappings); err != nil {
+			return nil, err
+		}
+		usernsCache[key] = userns
+	}
+
+	recursive := hasOption(m.Options, "ridmap")
+	treeFlags := uint(unix.OPEN_TREE_CLONE | unix.OPEN_TREE_CLOEXEC)
+	if recursive || hasOption(m.Options, "rbind") {
+		treeFlags |= unix.AT_RECURSIVE
+	}
+	fd, err := unix.OpenTree(unix.AT_FDCWD, source, treeFlags)
+	if err != nil {
+		if errors.Is(err, unix.ENOSYS) {
+			return nil, fmt.Errorf("open_tree %s: kernel lacks the mount API (Linux 5.12+ needed): %w", source, err)
+		}
+		return nil, fmt.Errorf("open_tree %s: %w", source, err)
+	}
+	tree := os.NewFile(uintptr(fd), source)
+
+	flags, _ := parseMountOptions(m.Options)
+	attr := unix.MountAttr{
+		Attr_set:  unix.MOUNT_ATTR_IDMAP,
+		Userns_fd: uint64(userns.Fd()),
+	}
+	for flag, a := range idMapAttrs {
+		if flags&flag != 0 {
+			attr.Attr_set |= a
+		}
+	}
+	setFlags := uint(unix.AT_EMPTY_PATH)
+	if recursive {
+		setFlags |= unix.AT_RECURSIVE
+	}
+	if err := unix.MountSetattr(int(tree.Fd()), "", setFlags, &attr); err != nil {
+		tree.Close()
+		switch {
+		case errors.Is(err, unix.ENOSYS):
+			return nil, fmt.Errorf("mount_setattr: kernel lacks idmapped mounts (Linux 5.12+ needed): %w", err)
+		case errors.Is(err, unix.EINVAL):
+			return nil, fmt.Errorf("mount_setattr: filesystem of %s does not support idmapped mounts: %w", source, err)
+		}
+		return nil, fmt.Errorf("mount_setattr %s: %w", source, err)
+	}
+	if rattr, ok := recursiveMountAttr(m.Options); ok {
+		if err := setRecursiveMountAttr(int(tree.Fd()), "", unix.AT_EMPTY_PATH, &rattr); err != nil {
+			tree.Close()
+			return nil, err
+		}
+	}
+	return tree, nil
+}
+
+// newUserNamespace returns a file for a new user namespace with the given
+// mappings. A re-executed runtime holds the namespace while it is opened.
+func newUserNamespace(uidMappings, gidMappings []spec.LinuxIDMapping) (*os.File, error) {
+	cmd := exec.Command("/proc/self/exe")
+	cmd.Env = []string{usernsHolderEnv + "=1"}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:                 syscall.CLONE_NEWUSER,
+		UidMappings:                buildIDMappings(uidMappings),
+		GidMappings:                buildIDMappings(gidMappings),
+		GidMappingsEnableSetgroups: false,
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		stdin.Close()
+		return nil, fmt.Errorf("create user namespace: %w", err)
+	}
+	defer func() {
+		stdin.Close()
+		cmd.Wait()
+	}()
+
+	f, err := os.Open(fmt.Sprintf("/proc/%d/ns/user", cmd.Process.Pid))
+	if err != nil {
+		return nil, fmt.Errorf("open user namespace: %w", err)
+	}
+	return f, nil
+}
+
+// AttachMount moves a detached mount tree from OpenIDMappedMounts to dest,
+// creating the mount point like a bind mount of the same source would.
+func AttachMount(tree *os.File, dest string) error {
+	var st unix.Stat_t
+	if err := unix.Fstat(int(tree.Fd()), &st); err != nil {
+		return fmt.Errorf("stat mount tree: %w", err)
+	}
+	if st.Mode&unix.S_IFMT == unix.S_IFDIR {
+		if err := os.MkdirAll(dest, 0755); err != nil {
+			return fmt.Errorf("mkdir %s: %w", dest, err)
+		}
+	} else {
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return fmt.Errorf("mkdir parent %s: %w", filepath.Dir(dest), err)
+		}
+		f, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("create file %s: %w", dest, err)
+		}
+		f.Close()
+	}
+	if err := unix.MoveMount(int(tree.Fd()), "", unix.AT_FDCWD, dest, unix.MOVE_MOUNT_F_EMPTY_PATH); err != nil {
+		return fmt.Errorf("move_mount %s: %w", dest, err)
+	}
+	return nil
+}
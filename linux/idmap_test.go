@@ -0,0 +1,99 @@
+package linux
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+
+	"runc-go/spec"
+)
+
+func TestIDMappedMount(t *testing.T) {
+	mapping := []spec.LinuxIDMapping{{ContainerID: 0, HostID: 100000, Size: 65536}}
+	tests := []struct {
+		name  string
+		mount spec.Mount
+		want  bool
+	}{
+		{"plain bind", spec.Mount{Type: "bind", Options: []string{"rbind"}}, false},
+		{"idmap option", spec.Mount{Type: "bind", Options: []string{"bind", "idmap"}}, true},
+		{"ridmap option", spec.Mount{Type: "bind", Options: []string{"rbind", "ridmap"}}, true},
+		{"uid mappings", spec.Mount{Type: "bind", UIDMappings: mapping}, true},
+		{"gid mappings", spec.Mount{Type: "bind", GIDMappings: mapping}, true},
+	}
+	for _, tt := range tests {
+		if got := IDMappedMount(tt.mount); got != tt.want {
+			t.Errorf("%s: IDMappedMount = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOpenIDMappedMounts_Errors(t *testing.T) {
+	mapping := []spec.LinuxIDMapping{{ContainerID: 0, HostID: 100000, Size: 65536}}
+	tests := []struct {
+		name  string
+		spec  *spec.Spec
+		error string
+	}{
+		{
+			name: "not a bind mount",
+			spec: &spec.Spec{Mounts: []spec.Mount{
+				{Destination: "/t", Type: "tmpfs", Options: []string{"idmap"}, UIDMappings: mapping, GIDMappings: mapping},
+			}},
+			error: "only bind mounts",
+		},
+		{
+			name: "no mappings",
+			spec: &spec.Spec{Mounts: []spec.Mount{
+				{Destination: "/v", Type: "bind", Source: "/tmp", Options: []string{"idmap"}},
+			}},
+			error: "no uid and gid mappings",
+		},
+	}
+	for _, tt := range tests {
+		_, err := OpenIDMappedMounts(tt.spec, "/bundle")
+		if err == nil || !strings.Contains(err.Error(), tt.error) {
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.error)
+		}
+	}
+
+	// Specs without ID-mapped mounts need no privileges
+	trees, err := OpenIDMappedMounts(&spec.Spec{Mounts: []spec.Mount{{Destination: "/proc", Type: "proc"}}}, "/bundle")
+	if err != nil || len(trees) != 0 {
+		t.Errorf("OpenIDMappedMounts = %v, %v, want no trees", trees, err)
+	}
+}
+
+func TestOpenIDMappedMounts_Kernel(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("idmapped mounts need root")
+	}
+	dir := t.TempDir()
+	mapping := []spec.LinuxIDMapping{{ContainerID: 0, HostID: 100000, Size: 65536}}
+	s := &spec.Spec{
+		Linux: &spec.Linux{UIDMappings: mapping, GIDMappings: mapping},
+		Mounts: []spec.Mount{
+			{Destination: "/proc", Type: "proc", Source: "proc"},
+			{Destination: "/v", Type: "bind", Source: dir, Options: []string{"rbind", "idmap", "ro"}},
+		},
+	}
+	trees, err := OpenIDMappedMounts(s, "/bundle")
+	if err != nil {
+		t.Skipf("idmapped mounts unavailable: %v", err)
+	}
+	if len(trees) != 1 || trees[1] == nil {
+		t.Fatalf("trees = %v, want mount 1 only", trees)
+	}
+	defer trees[1].Close()
+
+	// The detached tree carries the mapping: host root shows as 100000
+	var st unix.Stat_t
+	if err := unix.Fstat(int(trees[1].Fd()), &st); err != nil {
+		t.Fatalf("fstat: %v", err)
+	}
+	if st.Uid != 100000 || st.Gid != 100000 {
+		t.Errorf("owner = %d:%d, want 100000:100000", st.Uid, st.Gid)
+	}
+}
@@ -0,0 +1,32 @@
+// Package linux provides kernel version detection.
+package linux
+
+import (
+	"fmt"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+var (
+	// kernelReleaseOnce ensures uname is only called once.
+	kernelReleaseOnce sync.Once
+	// kernelRelease holds the running kernel's release string.
+	kernelRelease string
+	// kernelReleaseErr holds the uname error, if any.
+	kernelReleaseErr error
+)
+
+// KernelRelease returns the running kernel's release string
+// (e.g. "6.1.0-18-amd64"), as reported by uname(2).
+func KernelRelease() (string, error) {
+	kernelReleaseOnce.Do(func() {
+		var uts unix.Utsname
+		if err := unix.Uname(&uts); err != nil {
+			kernelReleaseErr = fmt.Errorf("uname: %w", err)
+			return
+		}
+		kernelRelease = unix.ByteSliceToString(uts.Release[:])
+	})
+	return kernelRelease, kernelReleaseErr
+}
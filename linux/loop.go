@@ -0,0 +1,280 @@
+// Package linux provides rootfs from filesystem images on loop devices.
+package linux
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+
+	"runc-go/spec"
+)
+
+const (
+	// RootfsImageAnnotation names a squashfs, erofs or ext4 image to mount
+	// read-only as the rootfs, at root.path. Relative paths are resolved
+	// against the bundle. root.path may also be the image file itself.
+	RootfsImageAnnotation = "org.opencontainers.runc-go.rootfs.image"
+
+	// imageMountPoint is where an image given as root.path is mounted,
+	// inside the container's state directory.
+	imageMountPoint = "rootfs"
+
+	// loopDevicePrefix names loop block devices in /dev and /sys/block.
+	loopDevicePrefix = "loop"
+
+	// RootfsMountTree is the mountTrees key of a prepared image rootfs.
+	RootfsMountTree = -1
+)
+
+// imageMagics identify filesystem images by their superblock.
+var imageMagics = []struct {
+	fsType string
+	offset int64
+	magic  []byte
+}{
+	{"squashfs", 0, []byte("hsqs")},
+	{"erofs", 1024, []byte{0xe2, 0xe1, 0xf5, 0xe0}},
+	{"ext4", 1080, []byte{0x53, 0xef}},
+}
+
+// ImageRootfs is a rootfs mounted from a filesystem image.
+type ImageRootfs struct {
+	// Path is the image file.
+	Path string
+	// Type is the image's filesystem: squashfs, erofs or ext4.
+	Type string
+	// MountPoint is the directory the rootfs is assembled at.
+	MountPoint string
+}
+
+// imageRootfsPath returns the filesystem image s asks to use as its
+// rootfs, or "" for none.
+func imageRootfsPath(s *spec.Spec, bundlePath string) string {
+	resolve := func(path string) string {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(bundlePath, path)
+		}
+		return filepath.Clean(path)
+	}
+	if s == nil {
+		return ""
+	}
+	if image := s.Annotations[RootfsImageAnnotation]; image != "" {
+		return resolve(image)
+	}
+	if s.Root == nil || s.Root.Path == "" {
+		return ""
+	}
+	if fi, err := os.Stat(resolve(s.Root.Path)); err == nil && fi.Mode().IsRegular() {
+		return resolve(s.Root.Path)
+	}
+	return ""
+}
+
+// ParseImageRootfs returns the filesystem image rootfs s asks for, or nil
+// when the rootfs is a directory. An image given as root.path is mounted
+// in stateDir.
+func ParseImageRootfs(s *spec.Spec, bundlePath, stateDir string) (*ImageRootfs, error) {
+	path := imageRootfsPath(s, bundlePath)
+	if path == "" {
+		return nil, nil
+	}
+	fsType, err := detectImageType(path)
+	if err != nil {
+		return nil, err
+	}
+
+	i := &ImageRootfs{Path: path, Type: fsType}
+	if s.Annotations[RootfsImageAnnotation] != "" {
+		i.MountPoint = s.Root.Path
+		if !filepath.IsAbs(i.MountPoint) {
+			i.MountPoint = filepath.Join(bundlePath, i.MountPoint)
+		}
+	} else {
+		i.MountPoint = filepath.Join(stateDir, imageMountPoint)
+	}
+	return i, nil
+}
+
+// detectImageType returns the filesystem of an image file.
+func detectImageType(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("open image: %w", err)
+	}
+	defer f.Close()
+	for _, m := range imageMagics {
+		buf := make([]byte, len(m.magic))
+		if _, err := f.ReadAt(buf, m.offset); err == nil && bytes.Equal(buf, m.magic) {
+			return m.fsType, nil
+		}
+	}
+	return "", fmt.Errorf("%s is not a squashfs, erofs or ext4 image", path)
+}
+
+// OpenImageRootfs mounts the filesystem image rootfs of s, if any, as a
+// detached read-only mount tree. The init's device cgroup denies loop
+// devices, and squashfs, erofs and ext4 cannot be mounted in a user
+// namespace, so this runs in the runtime; the init attaches the tree.
+func OpenImageRootfs(s *spec.Spec, bundlePath string) (*os.File, error) {
+	path := imageRootfsPath(s, bundlePath)
+	if path == "" {
+		return nil, nil
+	}
+	if IsRootless() {
+		return nil, fmt.Errorf("image rootfs: loop devices need root")
+	}
+	if !mountAPIAvailable() {
+		return nil, fmt.Errorf("image rootfs: needs the mount API of Linux 5.12")
+	}
+	fsType, err := detectImageType(path)
+	if err != nil {
+		return nil, err
+	}
+
+	loop, err := attachLoopDevice(path)
+	if err != nil {
+		return nil, fmt.Errorf("image rootfs: %w", err)
+	}
+	// The mounted superblock keeps the device open; once the tree is
+	// unmounted or closed unattached, the device detaches itself
+	defer loop.Close()
+	tree, err := fsMount(fsType, loop.Name(), MS_RDONLY|MS_NODEV, "ro")
+	if err != nil {
+		return nil, fmt.Errorf("image rootfs: mount %s image: %w", fsType, err)
+	}
+	return tree, nil
+}
+
+// Mount attaches the image tree from OpenImageRootfs at the mount point.
+// With an overlay, the image becomes its bottom layer.
+func (i *ImageRootfs) Mount(tree *os.File, overlay *OverlayRootfs) error {
+	if tree == nil {
+		return fmt.Errorf("image %s was not mounted by the runtime", i.Path)
+	}
+	defer tree.Close()
+	attach := func(dir string) error {
+		return AttachMount(tree, dir)
+	}
+	if overlay != nil {
+		return overlay.mount(i.MountPoint, attach)
+	}
+	return attach(i.MountPoint)
+}
+
+// attachLoopDevice attaches the file at path read-only to a free loop
+// device and returns the opened device. The device is set to autoclear,
+// so it detaches when its last user closes or unmounts it.
+func attachLoopDevice(path string) (*os.File, error) {
+	image, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open image: %w", err)
+	}
+	defer image.Close()
+
+	ctl, err := os.OpenFile("/dev/loop-control", os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("open loop control: %w", err)
+	}
+	defer ctl.Close()
+
+	info := unix.LoopInfo64{Flags: unix.LO_FLAGS_READ_ONLY | unix.LO_FLAGS_AUTOCLEAR}
+	copy(info.File_name[:len(info.File_name)-1], path)
+
+	// Another process may take the free device first; ask again
+	for attempt := 0; attempt < 16; attempt++ {
+		n, err := unix.IoctlRetInt(int(ctl.Fd()), unix.LOOP_CTL_GET_FREE)
+		if err != nil {
+			return nil, fmt.Errorf("get free loop device: %w", err)
+		}
+		loop, err := os.OpenFile(fmt.Sprintf("/dev/%s%d", loopDevicePrefix, n), os.O_RDONLY, 0)
+		if err != nil {
+			return nil, fmt.Errorf("open loop device: %w", err)
+		}
+
+		err = unix.IoctlLoopConfigure(int(loop.Fd()), &unix.LoopConfig{Fd: uint32(image.Fd()), Info: info})
+		if errors.Is(err, unix.EINVAL) || errors.Is(err, unix.ENOTTY) {
+			// LOOP_CONFIGURE is Linux 5.8; set up the device in two steps
+			err = unix.IoctlSetInt(int(loop.Fd()), unix.LOOP_SET_FD, int(image.Fd()))
+			if err == nil {
+				if err = unix.IoctlLoopSetStatus64(int(loop.Fd()), &info); err != nil {
+					unix.IoctlSetInt(int(loop.Fd()), unix.LOOP_CLR_FD, 0)
+				}
+			}
+		}
+		if err == nil {
+			return loop, nil
+		}
+		loop.Close()
+		if !errors.Is(err, unix.EBUSY) {
+			return nil, fmt.Errorf("configure loop device: %w", err)
+		}
+	}
+	return nil, fmt.Errorf("no free loop device")
+}
+
+// TeardownImageRootfs detaches the autoclear loop devices backed by the
+// image of s. A device still mounted elsewhere, by another container of
+// the same image, detaches once that mount goes.
+func TeardownImageRootfs(s *spec.Spec, bundlePath string) error {
+	path := imageRootfsPath(s, bundlePath)
+	if path == "" {
+		return nil
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+
+	devices, err := loopDevicesBackedBy(path)
+	if err != nil {
+		return err
+	}
+	for _, dev := range devices {
+		loop, err := os.OpenFile(dev, os.O_RDONLY, 0)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("open loop device: %w", err)
+		}
+		err = unix.IoctlSetInt(int(loop.Fd()), unix.LOOP_CLR_FD, 0)
+		loop.Close()
+		// ENXIO: the device detached in the meantime
+		if err != nil && err != unix.ENXIO {
+			return fmt.Errorf("detach %s: %w", dev, err)
+		}
+	}
+	return nil
+}
+
+// loopDevicesBackedBy returns the autoclear loop devices attached to the
+// file at path.
+func loopDevicesBackedBy(path string) ([]string, error) {
+	entries, err := os.ReadDir("/sys/block")
+	if err != nil {
+		return nil, err
+	}
+	var devices []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, loopDevicePrefix) {
+			continue
+		}
+		dir := filepath.Join("/sys/block", name, "loop")
+		backing, err := os.ReadFile(filepath.Join(dir, "backing_file"))
+		if err != nil {
+			// Devices without a backing file have no loop directory
+			continue
+		}
+		autoclear, _ := os.ReadFile(filepath.Join(dir, "autoclear"))
+		if strings.TrimSpace(string(backing)) == path && strings.TrimSpace(string(autoclear)) == "1" {
+			devices = append(devices, "/dev/"+name)
+		}
+	}
+	return devices, nil
+}
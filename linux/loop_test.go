@@ -0,0 +1,202 @@
+package linux
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"runc-go/spec"
+)
+
+// writeImageHeader writes a file with magic at offset, like the start of
+// a filesystem image.
+func writeImageHeader(t *testing.T, path string, offset int, magic []byte) {
+	t.Helper()
+	data := make([]byte, 4096)
+	copy(data[offset:], magic)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDetectImageType(t *testing.T) {
+	dir := t.TempDir()
+	for _, m := range imageMagics {
+		path := filepath.Join(dir, m.fsType)
+		writeImageHeader(t, path, int(m.offset), m.magic)
+		if got, err := detectImageType(path); err != nil || got != m.fsType {
+			t.Errorf("detectImageType(%s) = %q, %v", m.fsType, got, err)
+		}
+	}
+
+	other := filepath.Join(dir, "other")
+	writeImageHeader(t, other, 0, []byte("not an image"))
+	if _, err := detectImageType(other); err == nil {
+		t.Error("detectImageType accepted a file without a known superblock")
+	}
+}
+
+func TestParseImageRootfs(t *testing.T) {
+	bundle := t.TempDir()
+	writeImageHeader(t, filepath.Join(bundle, "root.squashfs"), 0, []byte("hsqs"))
+	if err := os.Mkdir(filepath.Join(bundle, "rootfs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		root        string
+		annotations map[string]string
+		want        *ImageRootfs
+	}{
+		{
+			name: "directory",
+			root: "rootfs",
+		},
+		{
+			name:        "annotation",
+			root:        "rootfs",
+			annotations: map[string]string{RootfsImageAnnotation: "root.squashfs"},
+			want: &ImageRootfs{
+				Path:       filepath.Join(bundle, "root.squashfs"),
+				Type:       "squashfs",
+				MountPoint: filepath.Join(bundle, "rootfs"),
+			},
+		},
+		{
+			name: "root path",
+			root: "root.squashfs",
+			want: &ImageRootfs{
+				Path:       filepath.Join(bundle, "root.squashfs"),
+				Type:       "squashfs",
+				MountPoint: filepath.Join("/run/state", imageMountPoint),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &spec.Spec{Root: &spec.Root{Path: tt.root}, Annotations: tt.annotations}
+			got, err := ParseImageRootfs(s, bundle, "/run/state")
+			if err != nil {
+				t.Fatalf("ParseImageRootfs error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseImageRootfs() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	s := &spec.Spec{
+		Root:        &spec.Root{Path: "rootfs"},
+		Annotations: map[string]string{RootfsImageAnnotation: "rootfs"},
+	}
+	if _, err := ParseImageRootfs(s, bundle, "/run/state"); err == nil {
+		t.Error("ParseImageRootfs accepted a directory as the image")
+	}
+}
+
+func TestParseOverlayRootfs_Image(t *testing.T) {
+	bundle := t.TempDir()
+	writeImageHeader(t, filepath.Join(bundle, "root.img"), 0, []byte("hsqs"))
+	annotations := map[string]string{RootfsImageAnnotation: "root.img"}
+	s := &spec.Spec{Root: &spec.Root{Path: "rootfs"}, Annotations: annotations}
+
+	// A bare image is mounted read-only, without an overlay
+	if o, err := ParseOverlayRootfs(s, bundle); o != nil || err != nil {
+		t.Errorf("ParseOverlayRootfs(image) = %+v, %v, want no overlay", o, err)
+	}
+
+	annotations[RootfsUpperAnnotation] = "tmpfs"
+	o, err := ParseOverlayRootfs(s, bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (&OverlayRootfs{Ephemeral: true}); !reflect.DeepEqual(o, want) {
+		t.Errorf("ParseOverlayRootfs(image, tmpfs upper) = %+v, want %+v", o, want)
+	}
+}
+
+func TestImageRootfs_Mount(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("loop devices need root")
+	}
+	mkfs, err := exec.LookPath("mkfs.ext4")
+	if err != nil {
+		t.Skip("mkfs.ext4 not found")
+	}
+	if _, err := os.Stat("/dev/loop-control"); err != nil {
+		t.Skip("no loop devices")
+	}
+
+	bundle := t.TempDir()
+	src := filepath.Join(bundle, "src")
+	if err := os.MkdirAll(filepath.Join(src, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "etc", "release"), []byte("image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	img := filepath.Join(bundle, "root.img")
+	if out, err := exec.Command(mkfs, "-q", "-d", src, img, "8M").CombinedOutput(); err != nil {
+		t.Fatalf("mkfs.ext4: %v: %s", err, out)
+	}
+
+	s := &spec.Spec{
+		Root:        &spec.Root{Path: "rootfs"},
+		Annotations: map[string]string{RootfsImageAnnotation: "root.img"},
+	}
+	image, err := ParseImageRootfs(s, bundle, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := OpenImageRootfs(s, bundle)
+	if err != nil {
+		t.Fatalf("OpenImageRootfs error: %v", err)
+	}
+
+	var release []byte
+	var writeErr error
+	inMountNamespace(t, func() {
+		if err = image.Mount(tree, nil); err != nil {
+			return
+		}
+		release, err = os.ReadFile(filepath.Join(image.MountPoint, "etc", "release"))
+		writeErr = os.WriteFile(filepath.Join(image.MountPoint, "new"), nil, 0644)
+		// The namespace may outlive the test on the main thread
+		unix.Unmount(image.MountPoint, unix.MNT_DETACH)
+	})
+	if err != nil {
+		t.Fatalf("mount image rootfs: %v", err)
+	}
+	if string(release) != "image" {
+		t.Errorf("etc/release = %q, want the image's", release)
+	}
+	if writeErr == nil {
+		t.Error("image rootfs is writable")
+	}
+
+	// The loop device goes with the mount, or on teardown
+	resolved, _ := filepath.EvalSymlinks(img)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if err := TeardownImageRootfs(s, bundle); err != nil {
+			t.Fatalf("TeardownImageRootfs error: %v", err)
+		}
+		devices, err := loopDevicesBackedBy(resolved)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(devices) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("loop devices still attached: %v", devices)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
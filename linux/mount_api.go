@@ -0,0 +1,383 @@
+// Package linux provides race-free mounting through file descriptors.
+package linux
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+
+	"runc-go/spec"
+)
+
+// resolveInRoot makes openat2 resolve paths as if the directory fd were the
+// root, so ".." and absolute symlinks cannot leave it, and refuses
+// /proc/PID/fd style links that could point anywhere.
+const resolveInRoot = unix.RESOLVE_IN_ROOT | unix.RESOLVE_NO_MAGICLINKS
+
+// mountAPIAvailable reports whether the kernel has openat2, the fsopen
+// mount API and mount_setattr (Linux 5.12+), which the detached trees'
+// per-mount flags are set with. Without them setupMounts falls back to
+// paths checked with SecureJoin.
+var mountAPIAvailable = sync.OnceValue(func() bool {
+	fd, err := unix.Openat2(unix.AT_FDCWD, "/", &unix.OpenHow{
+		Flags:   unix.O_PATH | unix.O_CLOEXEC,
+		Resolve: resolveInRoot,
+	})
+	if err != nil {
+		return false
+	}
+	unix.Close(fd)
+
+	fsfd, err := unix.Fsopen("tmpfs", unix.FSOPEN_CLOEXEC)
+	if err != nil {
+		return false
+	}
+	unix.Close(fsfd)
+	return mountSetattrAvailable()
+})
+
+// mountSetattrAvailable reports whether the kernel has mount_setattr. An
+// invalid flag fails with EINVAL where it does, before any privilege
+// check.
+func mountSetattrAvailable() bool {
+	err := unix.MountSetattr(-1, "", unix.AT_REMOVEDIR, &unix.MountAttr{})
+	return !errors.Is(err, unix.ENOSYS)
+}
+
+// mountAttrFlags maps mount flags to fsmount attributes.
+var mountAttrFlags = map[uintptr]int{
+	MS_RDONLY:           unix.MOUNT_ATTR_RDONLY,
+	MS_NOSUID:           unix.MOUNT_ATTR_NOSUID,
+	MS_NODEV:            unix.MOUNT_ATTR_NODEV,
+	MS_NOEXEC:           unix.MOUNT_ATTR_NOEXEC,
+	MS_NOATIME:          unix.MOUNT_ATTR_NOATIME,
+	MS_STRICTATIME:      unix.MOUNT_ATTR_STRICTATIME,
+	unix.MS_NODIRATIME:  unix.MOUNT_ATTR_NODIRATIME,
+	MS_RELATIME:         unix.MOUNT_ATTR_RELATIME,
+	unix.MS_NOSYMFOLLOW: unix.MOUNT_ATTR_NOSYMFOLLOW,
+}
+
+// openInRoot opens path with every component resolved inside root.
+func openInRoot(root *os.File, path string, flags int, mode uint32) (*os.File, error) {
+	how := &unix.OpenHow{
+		Flags:   uint64(flags | unix.O_CLOEXEC),
+		Mode:    uint64(mode),
+		Resolve: resolveInRoot,
+	}
+	for {
+		fd, err := unix.Openat2(int(root.Fd()), path, how)
+		// EAGAIN means a concurrent rename raced the lookup
+		if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR) {
+			continue
+		}
+		if err != nil {
+			return nil, &os.PathError{Op: "openat2", Path: path, Err: err}
+		}
+		return os.NewFile(uintptr(fd), path), nil
+	}
+}
+
+// mkdirAllInRoot creates path and its missing parents inside root. Each
+// directory is created relative to a descriptor of its parent, and
+// symlinks are followed by hand so that a dangling one gets its target
+// created inside root too, as MkdirAll on a SecureJoin path would.
+func mkdirAllInRoot(root *os.File, path string, mode uint32) error {
+	current := "/"
+	remaining := strings.Split(path, "/")
+	links := 0
+	for len(remaining) > 0 {
+		name := remaining[0]
+		remaining = remaining[1:]
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			current = filepath.Dir(current)
+			continue
+		}
+
+		parent, err := openInRoot(root, current, unix.O_PATH|unix.O_DIRECTORY, 0)
+		if err != nil {
+			return err
+		}
+		err = unix.Mkdirat(int(parent.Fd()), name, mode)
+		if errors.Is(err, unix.EEXIST) {
+			target, linkErr := readlinkAt(parent, name)
+			if linkErr == nil {
+				parent.Close()
+				if links++; links > 40 {
+					return &os.PathError{Op: "mkdir", Path: path, Err: unix.ELOOP}
+				}
+				if filepath.IsAbs(target) {
+					current = "/"
+				}
+				remaining = append(strings.Split(target, "/"), remaining...)
+				continue
+			}
+			err = nil
+		}
+		parent.Close()
+		current = filepath.Join(current, name)
+		if err != nil {
+			return &os.PathError{Op: "mkdirat", Path: current, Err: err}
+		}
+	}
+	return nil
+}
+
+// readlinkAt reads the symlink name in dir.
+func readlinkAt(dir *os.File, name string) (string, error) {
+	buf := make([]byte, unix.PathMax)
+	n, err := unix.Readlinkat(int(dir.Fd()), name, buf)
+	if err != nil {
+		return "", err
+	}
+	return string(buf[:n]), nil
+}
+
+// openMountTarget creates the mount point dest inside root, a directory
+// or an empty file, and returns an O_PATH descriptor of it.
+func openMountTarget(root *os.File, dest string, dir bool) (*os.File, error) {
+	if dir {
+		if err := mkdirAllInRoot(root, dest, 0755); err != nil {
+			return nil, err
+		}
+		return openInRoot(root, dest, unix.O_PATH|unix.O_DIRECTORY, 0)
+	}
+
+	target, err := openInRoot(root, dest, unix.O_PATH, 0)
+	if err == nil || !errors.Is(err, unix.ENOENT) {
+		return target, err
+	}
+	parentPath := filepath.Dir(filepath.Clean("/" + dest))
+	if err := mkdirAllInRoot(root, parentPath, 0755); err != nil {
+		return nil, err
+	}
+	parent, err := openInRoot(root, parentPath, unix.O_PATH|unix.O_DIRECTORY, 0)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := unix.Openat(int(parent.Fd()), filepath.Base(dest),
+		unix.O_CREAT|unix.O_EXCL|unix.O_WRONLY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0644)
+	parent.Close()
+	if err != nil && !errors.Is(err, unix.EEXIST) {
+		return nil, &os.PathError{Op: "create", Path: dest, Err: err}
+	}
+	if err == nil {
+		unix.Close(fd)
+	}
+	return openInRoot(root, dest, unix.O_PATH, 0)
+}
+
+// fdPath returns the path the kernel reports for an open file.
+func fdPath(f *os.File) (string, error) {
+	return os.Readlink(fmt.Sprintf("/proc/self/fd/%d", f.Fd()))
+}
+
+// moveMountTo attaches a detached mount onto the target descriptor.
+func moveMountTo(mnt, target *os.File) error {
+	err := unix.MoveMount(int(mnt.Fd()), "", int(target.Fd()), "",
+		unix.MOVE_MOUNT_F_EMPTY_PATH|unix.MOVE_MOUNT_T_EMPTY_PATH)
+	if err != nil {
+		return fmt.Errorf("move_mount: %w", err)
+	}
+	return nil
+}
+
+// attachInRoot attaches a detached mount tree at dest inside root, creating
+// the mount point like a bind mount of the same source would.
+func attachInRoot(root, tree *os.File, dest string) error {
+	var st unix.Stat_t
+	if err := unix.Fstat(int(tree.Fd()), &st); err != nil {
+		return fmt.Errorf("stat mount tree: %w", err)
+	}
+	target, err := openMountTarget(root, dest, st.Mode&unix.S_IFMT == unix.S_IFDIR)
+	if err != nil {
+		return fmt.Errorf("mount target %s: %w", dest, err)
+	}
+	defer target.Close()
+	if err := moveMountTo(tree, target); err != nil {
+		return fmt.Errorf("%s: %w", dest, err)
+	}
+	return nil
+}
+
+// fsMount creates a detached mount of a new filesystem instance. Options
+// in data become fsconfig parameters and flags become mount attributes.
+func fsMount(fsType, source string, flags uintptr, data string) (*os.File, error) {
+	fsfd, err := unix.Fsopen(fsType, unix.FSOPEN_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("fsopen %s: %w", fsType, err)
+	}
+	defer unix.Close(fsfd)
+
+	if source != "" {
+		if err := unix.FsconfigSetString(fsfd, "source", source); err != nil {
+			return nil, fmt.Errorf("fsconfig source=%s: %w", source, err)
+		}
+	}
+	if data != "" {
+		for _, opt := range strings.Split(data, ",") {
+			key, value, hasValue := strings.Cut(opt, "=")
+			if hasValue {
+				err = unix.FsconfigSetString(fsfd, key, value)
+			} else {
+				err = unix.FsconfigSetFlag(fsfd, key)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("fsconfig %s: %w", opt, err)
+			}
+		}
+	}
+	if err := unix.FsconfigCreate(fsfd); err != nil {
+		return nil, fmt.Errorf("fsconfig create: %w", err)
+	}
+
+	var attrs int
+	for flag, attr := range mountAttrFlags {
+		if flags&flag != 0 {
+			attrs |= attr
+		}
+	}
+	mfd, err := unix.Fsmount(fsfd, unix.FSMOUNT_CLOEXEC, attrs)
+	if err != nil {
+		return nil, fmt.Errorf("fsmount: %w", err)
+	}
+	return os.NewFile(uintptr(mfd), fsType), nil
+}
+
+// openBindSource opens a bind mount source. Relative sources are
+// resolved inside root.
+func openBindSource(root *os.File, source string) (*os.File, error) {
+	if filepath.IsAbs(source) {
+		return os.OpenFile(source, unix.O_PATH|unix.O_CLOEXEC, 0)
+	}
+	return openInRoot(root, source, unix.O_PATH, 0)
+}
+
+// bindTree clones the mount at source as a detached tree, with its
+// submounts when recursive.
+func bindTree(source *os.File, recursive bool) (*os.File, error) {
+	flags := uint(unix.OPEN_TREE_CLONE | unix.OPEN_TREE_CLOEXEC | unix.AT_EMPTY_PATH)
+	if recursive {
+		flags |= unix.AT_RECURSIVE
+	}
+	fd, err := unix.OpenTree(int(source.Fd()), "", flags)
+	if err != nil {
+		return nil, fmt.Errorf("open_tree %s: %w", source.Name(), err)
+	}
+	return os.NewFile(uintptr(fd), source.Name()), nil
+}
+
+// setupMountsInRoot performs the mounts of setupMounts through
+// descriptors: every destination is opened with openat2 inside rootfs and
+// mounts are moved onto it, so no path inside the container is resolved
+// twice and a symlink swapped in by the container cannot redirect a mount.
+func setupMountsInRoot(mounts []spec.Mount, rootfs string, cgroupOpts cgroupMountOptions, mountTrees map[int]*os.File) error {
+	root, err := os.OpenFile(rootfs, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("open rootfs: %w", err)
+	}
+	defer root.Close()
+
+	for i, m := range mounts {
+		if err := mountInRoot(root, m, cgroupOpts, i, mountTrees); err != nil {
+			if err := mountError(m, err); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mountInRoot performs a single mount below root.
+func mountInRoot(root *os.File, m spec.Mount, cgroupOpts cgroupMountOptions, i int, mountTrees map[int]*os.File) error {
+	if tree, ok := mountTrees[i]; ok {
+		defer tree.Close()
+		return attachInRoot(root, tree, m.Destination)
+	}
+	if IDMappedMount(m) {
+		return fmt.Errorf("idmapped mount %s was not prepared", m.Destination)
+	}
+
+	flags, data := parseMountOptions(m.Options)
+	isBind := m.Type == "bind" || hasOption(m.Options, "bind") || hasOption(m.Options, "rbind")
+
+	if isBind {
+		source, err := openBindSource(root, m.Source)
+		if err != nil {
+			return fmt.Errorf("bind source: %w", err)
+		}
+		tree, err := bindTree(source, flags&MS_REC != 0)
+		source.Close()
+		if err != nil {
+			return err
+		}
+		defer tree.Close()
+		if err := setTreeAttrs(tree, m.Options, bindMountAttr(flags)); err != nil {
+			return err
+		}
+		return attachInRoot(root, tree, m.Destination)
+	}
+
+	target, err := openMountTarget(root, m.Destination, true)
+	if err != nil {
+		return fmt.Errorf("mkdir %s: %w", m.Destination, err)
+	}
+	defer target.Close()
+
+	if m.Type == "cgroup" || m.Type == "cgroup2" {
+		// The cgroup mount stacks several mounts below its destination,
+		// so it works on the path of the verified target
+		dest, err := fdPath(target)
+		if err != nil {
+			return fmt.Errorf("resolve %s: %w", m.Destination, err)
+		}
+		return mountCgroup(dest, flags, cgroupOpts)
+	}
+
+	mnt, err := fsMount(m.Type, m.Source, flags, data)
+	if err != nil {
+		return err
+	}
+	defer mnt.Close()
+	if err := setTreeAttrs(mnt, m.Options, unix.MountAttr{}); err != nil {
+		return err
+	}
+	return moveMountTo(mnt, target)
+}
+
+// bindMountAttr returns the mount_setattr request for the per-mount flags
+// of a bind mount. It only sets attributes, so whatever the source mount
+// already has, including flags locked by a user namespace, is kept.
+func bindMountAttr(flags uintptr) unix.MountAttr {
+	var attr unix.MountAttr
+	for flag, a := range mountAttrFlags {
+		if flags&flag != 0 {
+			attr.Attr_set |= uint64(a)
+		}
+	}
+	if flags&atimeFlags != 0 {
+		attr.Attr_clr = unix.MOUNT_ATTR__ATIME
+	}
+	return attr
+}
+
+// setTreeAttrs applies attr to the top mount of a detached tree, then the
+// recursive mount options to the whole tree.
+func setTreeAttrs(tree *os.File, options []string, attr unix.MountAttr) error {
+	if attr != (unix.MountAttr{}) {
+		if err := unix.MountSetattr(int(tree.Fd()), "", unix.AT_EMPTY_PATH, &attr); err != nil {
+			return fmt.Errorf("mount_setattr: %w", err)
+		}
+	}
+	if rattr, ok := recursiveMountAttr(options); ok {
+		return setRecursiveMountAttr(int(tree.Fd()), "", unix.AT_EMPTY_PATH, &rattr)
+	}
+	return nil
+}
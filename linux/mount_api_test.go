@@ -0,0 +1,257 @@
+package linux
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/sys/unix"
+
+	"runc-go/spec"
+)
+
+// openTestRoot returns a rootfs and an outside directory the container
+// must never reach, with an O_PATH descriptor of the rootfs.
+func openTestRoot(t *testing.T) (*os.File, string, string) {
+	t.Helper()
+	if !mountAPIAvailable() {
+		t.Skip("openat2 and the mount API are unavailable")
+	}
+	tmp := t.TempDir()
+	rootfs := filepath.Join(tmp, "rootfs")
+	outside := filepath.Join(tmp, "outside")
+	for _, dir := range []string{rootfs, outside} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root, err := os.OpenFile(rootfs, unix.O_PATH|unix.O_DIRECTORY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { root.Close() })
+	return root, rootfs, outside
+}
+
+// assertInRoot fails when f does not refer to a file below rootfs.
+func assertInRoot(t *testing.T, f *os.File, rootfs string) {
+	t.Helper()
+	path, err := fdPath(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isPathWithin(path, rootfs) {
+		t.Fatalf("target %s escaped the rootfs %s", path, rootfs)
+	}
+}
+
+// assertEmpty fails when the outside directory was written to.
+func assertEmpty(t *testing.T, dir string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) > 0 {
+		t.Fatalf("%s was modified through the rootfs: %v", dir, entries)
+	}
+}
+
+func TestOpenMountTarget_SymlinkEscape(t *testing.T) {
+	root, rootfs, outside := openTestRoot(t)
+
+	links := map[string]string{
+		"abs":      outside,
+		"relative": "../../" + filepath.Base(outside),
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(rootfs, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		dest string
+		dir  bool
+	}{
+		{"/abs/sub", true},
+		{"/abs/file", false},
+		{"relative/sub", true},
+		{"/../../relative/file", false},
+		{"/abs", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dest, func(t *testing.T) {
+			target, err := openMountTarget(root, tt.dest, tt.dir)
+			if err != nil {
+				t.Fatalf("openMountTarget(%q) error: %v", tt.dest, err)
+			}
+			defer target.Close()
+			assertInRoot(t, target, rootfs)
+		})
+	}
+	assertEmpty(t, outside)
+}
+
+func TestOpenMountTarget_SymlinkSwap(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping symlink swap test in short mode")
+	}
+	root, rootfs, outside := openTestRoot(t)
+
+	// Swap /swap between a directory and a symlink to the outside
+	// directory while mount targets are created below it
+	swap := filepath.Join(rootfs, "swap")
+	var stop atomic.Bool
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for !stop.Load() {
+			os.RemoveAll(swap)
+			os.Mkdir(swap, 0755)
+			os.RemoveAll(swap)
+			os.Symlink(outside, swap)
+		}
+	}()
+
+	for i := 0; i < 2000; i++ {
+		target, err := openMountTarget(root, "/swap/target", true)
+		if err != nil {
+			// Losing the race to a removal is fine, escaping is not
+			continue
+		}
+		assertInRoot(t, target, rootfs)
+		target.Close()
+	}
+	stop.Store(true)
+	wg.Wait()
+
+	// The symlink pointed at the host path, so a target created through
+	// it must have landed inside the rootfs under that path
+	assertEmpty(t, outside)
+}
+
+func TestFsMount_Options(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("fsopen needs root")
+	}
+	if !mountAPIAvailable() {
+		t.Skip("openat2 and the mount API are unavailable")
+	}
+	if _, err := fsMount("tmpfs", "tmpfs", 0, "nosuchoption=1"); err == nil {
+		t.Error("fsMount accepted an unknown option")
+	}
+	mnt, err := fsMount("tmpfs", "tmpfs", MS_RDONLY|MS_NOSUID, "mode=700,size=1m")
+	if err != nil {
+		t.Fatalf("fsMount error: %v", err)
+	}
+	defer mnt.Close()
+
+	var st unix.Stat_t
+	if err := unix.Fstat(int(mnt.Fd()), &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Mode&0777 != 0700 {
+		t.Errorf("mode = %o, want 700", st.Mode&0777)
+	}
+	var sfs unix.Statfs_t
+	if err := unix.Fstatfs(int(mnt.Fd()), &sfs); err != nil {
+		t.Fatal(err)
+	}
+	if sfs.Flags&unix.ST_RDONLY == 0 || sfs.Flags&unix.ST_NOSUID == 0 {
+		t.Errorf("statfs flags = %#x, want ro and nosuid", sfs.Flags)
+	}
+}
+
+// inMountNamespace runs fn on a thread in a private mount namespace. The
+// thread is never unlocked, so it exits with the goroutine.
+func inMountNamespace(t *testing.T, fn func()) {
+	t.Helper()
+	errc := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		if err := unix.Unshare(unix.CLONE_NEWNS); err != nil {
+			errc <- err
+			return
+		}
+		if err := unix.Mount("", "/", "", unix.MS_PRIVATE|unix.MS_REC, ""); err != nil {
+			errc <- err
+			return
+		}
+		defer close(errc)
+		fn()
+	}()
+	if err := <-errc; err != nil {
+		t.Skipf("private mount namespace unavailable: %v", err)
+	}
+}
+
+func TestSetupMountsInRoot_SymlinkEscape(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("mounting needs root")
+	}
+	root, rootfs, outside := openTestRoot(t)
+	root.Close()
+	if err := os.Symlink(outside, filepath.Join(rootfs, "evil")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	mounts := []spec.Mount{
+		{Destination: "/evil", Type: "tmpfs", Source: "tmpfs", Options: []string{"nosuid"}},
+		{Destination: "/evil/bind", Type: "bind", Source: "/proc", Options: []string{"rbind"}},
+	}
+	var mountinfo string
+	var err error
+	inMountNamespace(t, func() {
+		err = setupMountsInRoot(mounts, rootfs, cgroupMountOptions{}, nil)
+		data, _ := os.ReadFile("/proc/thread-self/mountinfo")
+		mountinfo = string(data)
+	})
+	if err != nil {
+		t.Fatalf("setupMountsInRoot error: %v", err)
+	}
+
+	inside := filepath.Join(rootfs, outside)
+	for _, want := range []string{inside + " ", filepath.Join(inside, "bind") + " "} {
+		if !strings.Contains(mountinfo, want) {
+			t.Errorf("mountinfo has no mount at %s", want)
+		}
+	}
+	if strings.Contains(mountinfo, " "+outside+" ") {
+		t.Errorf("a mount landed on the outside directory %s", outside)
+	}
+	if entries, _ := os.ReadDir(outside); len(entries) != 1 {
+		t.Errorf("outside directory changed: %v", entries)
+	}
+}
+
+// TestMountSetattrAvailable verifies mount_setattr works where the probe
+// finds it, and that the mount API is never used without it.
+func TestMountSetattrAvailable(t *testing.T) {
+	if !mountSetattrAvailable() {
+		if mountAPIAvailable() {
+			t.Error("mountAPIAvailable() without mount_setattr")
+		}
+		t.Skip("mount_setattr is unavailable")
+	}
+	if os.Getuid() != 0 {
+		t.Skip("open_tree needs root")
+	}
+	fd, err := unix.OpenTree(unix.AT_FDCWD, t.TempDir(), unix.OPEN_TREE_CLONE|unix.OPEN_TREE_CLOEXEC)
+	if err != nil {
+		t.Skipf("open_tree: %v", err)
+	}
+	defer unix.Close(fd)
+	attr := &unix.MountAttr{Attr_set: unix.MOUNT_ATTR_RDONLY}
+	if err := unix.MountSetattr(fd, "", unix.AT_EMPTY_PATH, attr); err != nil {
+		t.Errorf("mount_setattr: %v", err)
+	}
+}
@@ -82,6 +82,19 @@ func SetNamespaces(namespaces []spec.LinuxNamespace) error {
 	return nil
 }
 
+// UnshareCgroupNamespace creates a new cgroup namespace if the spec asks
+// for one. It must run after the process has been moved into the
+// container's cgroup, which becomes the namespace root.
+func UnshareCgroupNamespace(namespaces []spec.LinuxNamespace) error {
+	if !HasNamespace(namespaces, spec.CgroupNamespace) || GetNamespacePath(namespaces, spec.CgroupNamespace) != "" {
+		return nil
+	}
+	if err := syscall.Unshare(CLONE_NEWCGROUP); err != nil {
+		return fmt.Errorf("unshare cgroup namespace: %w", err)
+	}
+	return nil
+}
+
 // setns joins an existing namespace.
 func setns(path string, nsType spec.LinuxNamespaceType) error {
 	fd, err := syscall.Open(path, syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
@@ -109,7 +122,9 @@ func BuildSysProcAttr(s *spec.Spec) (*syscall.SysProcAttr, error) {
 		}, nil
 	}
 
-	flags := NamespaceFlags(s.Linux.Namespaces)
+	// A cgroup namespace is rooted at the creator's cgroup, so it is
+	// unshared by the init once it has joined the container's cgroup
+	flags := NamespaceFlags(s.Linux.Namespaces) &^ CLONE_NEWCGROUP
 	hasUserNS := HasNamespace(s.Linux.Namespaces, spec.UserNamespace)
 
 	attr := &syscall.SysProcAttr{
@@ -127,11 +142,46 @@ func BuildSysProcAttr(s *spec.Spec) (*syscall.SysProcAttr, error) {
 		attr.UidMappings = buildIDMappings(s.Linux.UIDMappings)
 		attr.GidMappings = buildIDMappings(s.Linux.GIDMappings)
 		attr.GidMappingsEnableSetgroups = false
+
+		// The child starts with the runtime's host IDs, which the
+		// mappings usually do not cover; become container root instead
+		_, uidOK := hostID(s.Linux.UIDMappings, 0)
+		_, gidOK := hostID(s.Linux.GIDMappings, 0)
+		if uidOK && gidOK {
+			attr.Credential = &syscall.Credential{Uid: 0, Gid: 0, NoSetGroups: true}
+		}
 	}
 
 	return attr, nil
 }
 
+// RootHostIDs returns the host uid and gid of container root. They are 0
+// unless the container has its own user namespace.
+func RootHostIDs(l *spec.Linux) (uid, gid int, err error) {
+	if l == nil || !HasNamespace(l.Namespaces, spec.UserNamespace) {
+		return 0, 0, nil
+	}
+	uid, ok := hostID(l.UIDMappings, 0)
+	if !ok {
+		return 0, 0, fmt.Errorf("no uid mapping for container root")
+	}
+	gid, ok = hostID(l.GIDMappings, 0)
+	if !ok {
+		return 0, 0, fmt.Errorf("no gid mapping for container root")
+	}
+	return uid, gid, nil
+}
+
+// hostID maps a container ID to the host through the ID mappings.
+func hostID(mappings []spec.LinuxIDMapping, id uint32) (int, bool) {
+	for _, m := range mappings {
+		if id >= m.ContainerID && id-m.ContainerID < m.Size {
+			return int(m.HostID + id - m.ContainerID), true
+		}
+	}
+	return 0, false
+}
+
 // buildIDMappings converts OCI ID mappings to syscall format.
 func buildIDMappings(mappings []spec.LinuxIDMapping) []syscall.SysProcIDMap {
 	result := make([]syscall.SysProcIDMap, len(mappings))
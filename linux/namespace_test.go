@@ -239,6 +239,30 @@ func TestBuildSysProcAttr(t *testing.T) {
 	}
 }
 
+func TestBuildSysProcAttrDefersCgroupNamespace(t *testing.T) {
+	s := &spec.Spec{
+		Linux: &spec.Linux{
+			Namespaces: []spec.LinuxNamespace{
+				{Type: spec.PIDNamespace},
+				{Type: spec.CgroupNamespace},
+			},
+		},
+	}
+
+	attr, err := BuildSysProcAttr(s)
+	if err != nil {
+		t.Fatalf("BuildSysProcAttr failed: %v", err)
+	}
+
+	// The init unshares it after joining the container's cgroup
+	if attr.Cloneflags&CLONE_NEWCGROUP != 0 {
+		t.Error("should not clone with CLONE_NEWCGROUP")
+	}
+	if attr.Cloneflags&CLONE_NEWPID == 0 {
+		t.Error("should have CLONE_NEWPID")
+	}
+}
+
 func TestBuildSysProcAttrNoLinux(t *testing.T) {
 	s := &spec.Spec{}
 
@@ -295,6 +319,36 @@ func TestBuildSysProcAttrWithUserNamespace(t *testing.T) {
 	if attr.GidMappingsEnableSetgroups {
 		t.Error("GidMappingsEnableSetgroups should be false")
 	}
+
+	// The child switches to container root inside the namespace
+	if attr.Credential == nil || attr.Credential.Uid != 0 || attr.Credential.Gid != 0 {
+		t.Errorf("Credential = %+v, want container root", attr.Credential)
+	}
+}
+
+func TestRootHostIDs(t *testing.T) {
+	l := &spec.Linux{
+		Namespaces: []spec.LinuxNamespace{{Type: spec.UserNamespace}},
+		UIDMappings: []spec.LinuxIDMapping{
+			{ContainerID: 1, HostID: 200001, Size: 65535},
+			{ContainerID: 0, HostID: 1000, Size: 1},
+		},
+		GIDMappings: []spec.LinuxIDMapping{{ContainerID: 0, HostID: 100000, Size: 65536}},
+	}
+	uid, gid, err := RootHostIDs(l)
+	if err != nil || uid != 1000 || gid != 100000 {
+		t.Errorf("RootHostIDs = %d, %d, %v; want 1000, 100000", uid, gid, err)
+	}
+
+	// Without a user namespace container root is host root
+	if uid, gid, err := RootHostIDs(&spec.Linux{}); err != nil || uid != 0 || gid != 0 {
+		t.Errorf("RootHostIDs without user namespace = %d, %d, %v", uid, gid, err)
+	}
+
+	l.UIDMappings = []spec.LinuxIDMapping{{ContainerID: 1, HostID: 200001, Size: 10}}
+	if _, _, err := RootHostIDs(l); err == nil {
+		t.Error("RootHostIDs should fail when root is not mapped")
+	}
 }
 
 func TestSYS_SETNS(t *testing.T) {
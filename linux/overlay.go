@@ -0,0 +1,255 @@
+// Package linux provides overlayfs rootfs assembly from layer directories.
+package linux
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+
+	"runc-go/spec"
+)
+
+const (
+	// RootfsLayersAnnotation asks for an overlay rootfs assembled from
+	// unpacked layer directories. It lists the lower layers top-most first,
+	// separated by colons, like overlayfs' lowerdir option. Relative paths
+	// are resolved against the bundle.
+	RootfsLayersAnnotation = "org.opencontainers.runc-go.rootfs.layers"
+
+	// RootfsUpperAnnotation is the overlay's writable upper directory, or
+	// "tmpfs" for an ephemeral one that is discarded with the container.
+	// It defaults to "tmpfs", or to no upper layer for a read-only root.
+	RootfsUpperAnnotation = "org.opencontainers.runc-go.rootfs.upper"
+
+	// RootfsWorkAnnotation is the overlay's work directory, which must be
+	// on the same filesystem as an upper directory.
+	RootfsWorkAnnotation = "org.opencontainers.runc-go.rootfs.work"
+
+	// RootfsVolatileAnnotation set to "true" mounts the overlay with
+	// "volatile": writes to the upper directory are never synced, and the
+	// upper directory must be discarded after a crash.
+	RootfsVolatileAnnotation = "org.opencontainers.runc-go.rootfs.volatile"
+
+	// overlayTmpfs is the RootfsUpperAnnotation value for an ephemeral
+	// upper directory.
+	overlayTmpfs = "tmpfs"
+
+	// overlayfsMagic is the statfs type of overlayfs.
+	overlayfsMagic = 0x794c7630
+)
+
+// OverlayRootfs describes a rootfs assembled by overlayfs.
+type OverlayRootfs struct {
+	// Lower are the layer directories, top-most first.
+	Lower []string
+	// Upper and Work are the writable layer and overlayfs' scratch
+	// directory. Both are empty for a read-only or ephemeral overlay.
+	Upper string
+	Work  string
+	// Ephemeral puts the upper and work directories on a tmpfs.
+	Ephemeral bool
+	// Volatile skips syncing the upper directory.
+	Volatile bool
+}
+
+// ParseOverlayRootfs returns the overlay rootfs requested by the
+// annotations of s, or nil when the rootfs is a plain directory. A
+// filesystem image rootfs is the overlay's bottom layer, and only gets an
+// overlay when it has layers or an upper directory.
+func ParseOverlayRootfs(s *spec.Spec, bundlePath string) (*OverlayRootfs, error) {
+	if s == nil {
+		return nil, nil
+	}
+	layers := s.Annotations[RootfsLayersAnnotation]
+	image := imageRootfsPath(s, bundlePath) != ""
+	if layers == "" && (!image || s.Annotations[RootfsUpperAnnotation] == "") {
+		return nil, nil
+	}
+	resolve := func(path string) string {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(bundlePath, path)
+		}
+		return filepath.Clean(path)
+	}
+
+	o := &OverlayRootfs{Volatile: s.Annotations[RootfsVolatileAnnotation] == "true"}
+	if layers != "" {
+		for _, layer := range strings.Split(layers, ":") {
+			if layer == "" {
+				return nil, fmt.Errorf("empty layer in %q", layers)
+			}
+			o.Lower = append(o.Lower, resolve(layer))
+		}
+	}
+
+	upper := s.Annotations[RootfsUpperAnnotation]
+	work := s.Annotations[RootfsWorkAnnotation]
+	readonly := s.Root != nil && s.Root.Readonly
+	switch {
+	case upper == overlayTmpfs || (upper == "" && !readonly):
+		if work != "" {
+			return nil, fmt.Errorf("a work directory needs an upper directory")
+		}
+		o.Ephemeral = true
+	case upper == "":
+		if work != "" {
+			return nil, fmt.Errorf("a work directory needs an upper directory")
+		}
+	default:
+		if work == "" {
+			return nil, fmt.Errorf("upper directory %s needs a work directory", upper)
+		}
+		o.Upper, o.Work = resolve(upper), resolve(work)
+	}
+
+	// Commas and colons separate overlayfs options and layers
+	dirs := append([]string{o.Upper, o.Work}, o.Lower...)
+	for _, dir := range dirs {
+		if strings.ContainsAny(dir, ",:") {
+			return nil, fmt.Errorf("overlay directory %q contains a comma or colon", dir)
+		}
+	}
+	return o, nil
+}
+
+// options returns the overlayfs mount options for the given lower, upper
+// and work directories. userxattr keeps overlay metadata in user.overlay.*
+// xattrs, the only ones a user namespace may write.
+func (o *OverlayRootfs) options(lower []string, upper, work string, userxattr bool) string {
+	opts := []string{"lowerdir=" + strings.Join(lower, ":")}
+	if upper != "" {
+		opts = append(opts, "upperdir="+upper, "workdir="+work)
+		if o.Volatile || o.Ephemeral {
+			opts = append(opts, "volatile")
+		}
+	}
+	if userxattr {
+		opts = append(opts, "userxattr")
+	}
+	return strings.Join(opts, ",")
+}
+
+// Mount assembles the overlay at rootfs. An ephemeral upper directory is
+// created on a tmpfs mounted at rootfs first, which the overlay then
+// hides. Without an upper directory a single layer is bind-mounted, since
+// overlayfs needs two lower layers to mount read-only.
+func (o *OverlayRootfs) Mount(rootfs string) error {
+	return o.mount(rootfs, nil)
+}
+
+// mount assembles the overlay at rootfs. bottom, if set, mounts a
+// read-only filesystem at a directory of the tmpfs, which becomes the
+// bottom-most layer.
+func (o *OverlayRootfs) mount(rootfs string, bottom func(dir string) error) error {
+	for _, layer := range o.Lower {
+		if fi, err := os.Stat(layer); err != nil {
+			return fmt.Errorf("layer: %w", err)
+		} else if !fi.IsDir() {
+			return fmt.Errorf("layer %s is not a directory", layer)
+		}
+	}
+	if err := os.MkdirAll(rootfs, 0755); err != nil {
+		return fmt.Errorf("mkdir rootfs: %w", err)
+	}
+
+	upper, work, lower := o.Upper, o.Work, o.Lower
+	if o.Ephemeral || bottom != nil {
+		if err := syscall.Mount("tmpfs", rootfs, "tmpfs", MS_NOSUID|MS_NODEV, "mode=755"); err != nil {
+			return fmt.Errorf("mount tmpfs upper: %w", err)
+		}
+		var dirs []string
+		if o.Ephemeral {
+			upper, work = filepath.Join(rootfs, "upper"), filepath.Join(rootfs, "work")
+			dirs = append(dirs, upper, work)
+		}
+		if bottom != nil {
+			image := filepath.Join(rootfs, "image")
+			lower = append(lower[:len(lower):len(lower)], image)
+			dirs = append(dirs, image)
+		}
+		for _, dir := range dirs {
+			if err := os.Mkdir(dir, 0755); err != nil {
+				return fmt.Errorf("mkdir %s: %w", dir, err)
+			}
+		}
+		if bottom != nil {
+			if err := bottom(lower[len(lower)-1]); err != nil {
+				return err
+			}
+		}
+	}
+
+	if upper == "" && len(lower) == 1 {
+		if err := syscall.Mount(lower[0], rootfs, "", MS_BIND|MS_REC, ""); err != nil {
+			return fmt.Errorf("bind mount layer: %w", err)
+		}
+		return nil
+	}
+
+	var flags uintptr
+	if upper == "" {
+		flags = MS_RDONLY
+	}
+	// In a user namespace trusted.overlay.* xattrs cannot be written
+	if err := syscall.Mount("overlay", rootfs, "overlay", flags, o.options(lower, upper, work, IsRootless())); err != nil {
+		return fmt.Errorf("mount overlay: %w", err)
+	}
+	return nil
+}
+
+// TeardownOverlayRootfs undoes what an overlay rootfs leaves behind once
+// its container is gone. The overlay itself normally disappears with the
+// container's mount namespace; without one it is unmounted here. A
+// volatile overlay marks its work directory as unusable, so the work
+// directory is emptied to let the upper directory be mounted again.
+func TeardownOverlayRootfs(s *spec.Spec, bundlePath string) error {
+	o, err := ParseOverlayRootfs(s, bundlePath)
+	if err != nil || o == nil {
+		return err
+	}
+
+	if s.Root != nil {
+		rootfs := s.Root.Path
+		if !filepath.IsAbs(rootfs) {
+			rootfs = filepath.Join(bundlePath, rootfs)
+		}
+		if err := unmountIfType(rootfs, overlayfsMagic); err != nil {
+			return fmt.Errorf("unmount overlay rootfs: %w", err)
+		}
+		if o.Ephemeral {
+			if err := unmountIfType(rootfs, unix.TMPFS_MAGIC); err != nil {
+				return fmt.Errorf("unmount tmpfs upper: %w", err)
+			}
+		}
+	}
+
+	if o.Volatile && o.Work != "" {
+		entries, err := os.ReadDir(o.Work)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		for _, entry := range entries {
+			if err := os.RemoveAll(filepath.Join(o.Work, entry.Name())); err != nil {
+				return fmt.Errorf("clean work directory: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// unmountIfType unmounts path when the filesystem there has the given
+// statfs type. Not being a mount point is not an error.
+func unmountIfType(path string, fsType int64) error {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil || st.Type != fsType {
+		return nil
+	}
+	if err := unix.Unmount(path, unix.MNT_DETACH); err != nil && err != unix.EINVAL {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+package linux
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"runc-go/spec"
+)
+
+func overlaySpec(annotations map[string]string, readonly bool) *spec.Spec {
+	return &spec.Spec{
+		Root:        &spec.Root{Path: "rootfs", Readonly: readonly},
+		Annotations: annotations,
+	}
+}
+
+func TestParseOverlayRootfs(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		readonly    bool
+		want        *OverlayRootfs
+		wantErr     bool
+	}{
+		{
+			name: "none",
+		},
+		{
+			name:        "ephemeral by default",
+			annotations: map[string]string{RootfsLayersAnnotation: "layers/top:/var/lib/base"},
+			want: &OverlayRootfs{
+				Lower:     []string{"/bundle/layers/top", "/var/lib/base"},
+				Ephemeral: true,
+			},
+		},
+		{
+			name:        "readonly without upper",
+			annotations: map[string]string{RootfsLayersAnnotation: "base"},
+			readonly:    true,
+			want:        &OverlayRootfs{Lower: []string{"/bundle/base"}},
+		},
+		{
+			name: "upper and work",
+			annotations: map[string]string{
+				RootfsLayersAnnotation:   "base",
+				RootfsUpperAnnotation:    "upper",
+				RootfsWorkAnnotation:     "/scratch/work",
+				RootfsVolatileAnnotation: "true",
+			},
+			want: &OverlayRootfs{
+				Lower:    []string{"/bundle/base"},
+				Upper:    "/bundle/upper",
+				Work:     "/scratch/work",
+				Volatile: true,
+			},
+		},
+		{
+			name: "explicit tmpfs upper",
+			annotations: map[string]string{
+				RootfsLayersAnnotation: "base",
+				RootfsUpperAnnotation:  "tmpfs",
+			},
+			readonly: true,
+			want:     &OverlayRootfs{Lower: []string{"/bundle/base"}, Ephemeral: true},
+		},
+		{
+			name: "upper without work",
+			annotations: map[string]string{
+				RootfsLayersAnnotation: "base",
+				RootfsUpperAnnotation:  "upper",
+			},
+			wantErr: true,
+		},
+		{
+			name: "work without upper",
+			annotations: map[string]string{
+				RootfsLayersAnnotation: "base",
+				RootfsWorkAnnotation:   "work",
+			},
+			wantErr: true,
+		},
+		{
+			name:        "empty layer",
+			annotations: map[string]string{RootfsLayersAnnotation: "a::b"},
+			wantErr:     true,
+		},
+		{
+			name:        "comma in layer",
+			annotations: map[string]string{RootfsLayersAnnotation: "a,upperdir=/etc"},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseOverlayRootfs(overlaySpec(tt.annotations, tt.readonly), "/bundle")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseOverlayRootfs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseOverlayRootfs() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if o, err := ParseOverlayRootfs(nil, "/bundle"); o != nil || err != nil {
+		t.Errorf("ParseOverlayRootfs(nil) = %v, %v", o, err)
+	}
+}
+
+func TestOverlayRootfs_Options(t *testing.T) {
+	o := &OverlayRootfs{Lower: []string{"/a", "/b"}}
+	tests := []struct {
+		name      string
+		volatile  bool
+		upper     string
+		userxattr bool
+		want      string
+	}{
+		{"readonly", false, "", false, "lowerdir=/a:/b"},
+		{"upper", false, "/u", false, "lowerdir=/a:/b,upperdir=/u,workdir=/w"},
+		{"volatile", true, "/u", false, "lowerdir=/a:/b,upperdir=/u,workdir=/w,volatile"},
+		{"userxattr", false, "/u", true, "lowerdir=/a:/b,upperdir=/u,workdir=/w,userxattr"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o.Volatile = tt.volatile
+			work := ""
+			if tt.upper != "" {
+				work = "/w"
+			}
+			if got := o.options(o.Lower, tt.upper, work, tt.userxattr); got != tt.want {
+				t.Errorf("options() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// overlayLayers creates two layers with a file each, the top one
+// shadowing a file of the base layer.
+func overlayLayers(t *testing.T, dir string) []string {
+	t.Helper()
+	files := map[string]map[string]string{
+		"top":  {"shared": "top", "top": "top"},
+		"base": {"shared": "base", "base": "base"},
+	}
+	var layers []string
+	for _, name := range []string{"top", "base"} {
+		layer := filepath.Join(dir, name)
+		if err := os.Mkdir(layer, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for file, content := range files[name] {
+			if err := os.WriteFile(filepath.Join(layer, file), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		layers = append(layers, layer)
+	}
+	return layers
+}
+
+func TestOverlayRootfs_Mount(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("mounting needs root")
+	}
+	tmp := t.TempDir()
+	layers := overlayLayers(t, tmp)
+	upper, work := filepath.Join(tmp, "upper"), filepath.Join(tmp, "work")
+	for _, dir := range []string{upper, work} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		o    OverlayRootfs
+	}{
+		{"ephemeral", OverlayRootfs{Lower: layers, Ephemeral: true}},
+		{"upper", OverlayRootfs{Lower: layers, Upper: upper, Work: work}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rootfs := filepath.Join(tmp, tt.name)
+			var err error
+			var shared []byte
+			inMountNamespace(t, func() {
+				if err = tt.o.Mount(rootfs); err != nil {
+					return
+				}
+				if shared, err = os.ReadFile(filepath.Join(rootfs, "shared")); err != nil {
+					return
+				}
+				if _, err = os.Stat(filepath.Join(rootfs, "base")); err != nil {
+					return
+				}
+				err = os.WriteFile(filepath.Join(rootfs, tt.name), nil, 0644)
+			})
+			if err != nil {
+				t.Fatalf("overlay rootfs error: %v", err)
+			}
+			if string(shared) != "top" {
+				t.Errorf("shared = %q, want the top layer's", shared)
+			}
+
+			// Writes land in the upper directory, never in a layer
+			_, err = os.Stat(filepath.Join(upper, tt.name))
+			if tt.o.Ephemeral != os.IsNotExist(err) {
+				t.Errorf("upper directory has %s: %v", tt.name, err)
+			}
+			for _, layer := range layers {
+				if _, err := os.Stat(filepath.Join(layer, tt.name)); !os.IsNotExist(err) {
+					t.Errorf("write reached layer %s", layer)
+				}
+			}
+		})
+	}
+}
+
+func TestOverlayRootfs_MountReadonly(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("mounting needs root")
+	}
+	tmp := t.TempDir()
+	layers := overlayLayers(t, tmp)
+
+	for _, lower := range [][]string{layers, layers[1:]} {
+		rootfs := filepath.Join(tmp, "rootfs")
+		var err, writeErr error
+		inMountNamespace(t, func() {
+			o := &OverlayRootfs{Lower: lower}
+			if err = o.Mount(rootfs); err != nil {
+				return
+			}
+			_, err = os.Stat(filepath.Join(rootfs, "base"))
+			writeErr = os.WriteFile(filepath.Join(rootfs, "new"), nil, 0644)
+		})
+		if err != nil {
+			t.Fatalf("Mount(%d layers) error: %v", len(lower), err)
+		}
+		if len(lower) > 1 && writeErr == nil {
+			t.Errorf("read-only overlay of %d layers is writable", len(lower))
+		}
+		os.Remove(filepath.Join(layers[1], "new"))
+	}
+}
+
+func TestTeardownOverlayRootfs_VolatileWork(t *testing.T) {
+	bundle := t.TempDir()
+	work := filepath.Join(bundle, "work")
+	if err := os.MkdirAll(filepath.Join(work, "work", "incompat"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	s := overlaySpec(map[string]string{
+		RootfsLayersAnnotation:   "base",
+		RootfsUpperAnnotation:    "upper",
+		RootfsWorkAnnotation:     "work",
+		RootfsVolatileAnnotation: "true",
+	}, false)
+
+	if err := TeardownOverlayRootfs(s, bundle); err != nil {
+		t.Fatalf("TeardownOverlayRootfs error: %v", err)
+	}
+	entries, err := os.ReadDir(work)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("work directory not emptied: %v", entries)
+	}
+
+	// Without an overlay there is nothing to tear down
+	if err := TeardownOverlayRootfs(overlaySpec(nil, false), bundle); err != nil {
+		t.Errorf("TeardownOverlayRootfs(no overlay) error: %v", err)
+	}
+}
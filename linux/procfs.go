@@ -0,0 +1,260 @@
+// Package linux provides verified writes to procfs.
+package linux
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+
+	"runc-go/spec"
+)
+
+// resolveBeneathProc makes openat2 stay on the /proc mount: symlinks like
+// self and thread-self are followed, but not out of /proc, not through
+// magic links and not across a mount stacked on a procfs entry.
+const resolveBeneathProc = unix.RESOLVE_BENEATH | unix.RESOLVE_NO_MAGICLINKS | unix.RESOLVE_NO_XDEV
+
+// hidepidValues are the values procfs takes for hidepid=.
+var hidepidValues = map[string]bool{
+	"0": true, "1": true, "2": true, "4": true,
+	"off": true, "noaccess": true, "invisible": true, "ptraceable": true,
+}
+
+// ipcSysctls are the sysctls outside fs.mqueue that belong to the IPC
+// namespace.
+var ipcSysctls = map[string]bool{
+	"kernel.msgmax":          true,
+	"kernel.msgmnb":          true,
+	"kernel.msgmni":          true,
+	"kernel.sem":             true,
+	"kernel.shmall":          true,
+	"kernel.shmmax":          true,
+	"kernel.shmmni":          true,
+	"kernel.shm_rmid_forced": true,
+}
+
+// ValidateProcMounts checks the options of the proc mounts of s. Besides
+// the generic mount options, procfs takes hidepid= to hide the processes
+// of other users and subset=pid to show only the process directories.
+func ValidateProcMounts(s *spec.Spec) error {
+	if s == nil {
+		return nil
+	}
+	for _, m := range s.Mounts {
+		if m.Type != "proc" {
+			continue
+		}
+		for _, opt := range m.Options {
+			key, value, _ := strings.Cut(opt, "=")
+			switch key {
+			case "hidepid":
+				if !hidepidValues[value] {
+					return fmt.Errorf("proc mount %s: invalid hidepid %q", m.Destination, value)
+				}
+			case "subset":
+				if value != "pid" {
+					return fmt.Errorf("proc mount %s: invalid subset %q, only pid is supported", m.Destination, value)
+				}
+			case "gid":
+				if _, err := strconv.ParseUint(value, 10, 32); err != nil {
+					return fmt.Errorf("proc mount %s: invalid gid %q", m.Destination, value)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateSysctls checks that every sysctl of s belongs to a namespace the
+// container gets of its own, so setting it cannot change the host.
+func ValidateSysctls(s *spec.Spec) error {
+	if s == nil || s.Linux == nil {
+		return nil
+	}
+	namespaces := s.Linux.Namespaces
+	for key := range s.Linux.Sysctl {
+		if _, err := sysctlPath(key); err != nil {
+			return err
+		}
+		var nsType spec.LinuxNamespaceType
+		switch {
+		case ipcSysctls[key] || strings.HasPrefix(key, "fs.mqueue."):
+			nsType = spec.IPCNamespace
+		case strings.HasPrefix(key, "net."):
+			nsType = spec.NetworkNamespace
+		case key == "kernel.hostname" || key == "kernel.domainname":
+			nsType = spec.UTSNamespace
+		default:
+			return fmt.Errorf("sysctl %s is not namespaced", key)
+		}
+		if !HasNamespace(namespaces, nsType) {
+			return fmt.Errorf("sysctl %s needs a %s namespace", key, nsType)
+		}
+	}
+	return nil
+}
+
+// ValidateProcessLabels checks that the LSM a process label of s is for
+// is enabled; a label the kernel cannot apply would run the process
+// unconfined.
+func ValidateProcessLabels(s *spec.Spec) error {
+	if s == nil || s.Process == nil {
+		return nil
+	}
+	if s.Process.ApparmorProfile != "" && !apparmorEnabled() {
+		return fmt.Errorf("apparmor profile %s is set, but AppArmor is not enabled", s.Process.ApparmorProfile)
+	}
+	if s.Process.SelinuxLabel != "" && !selinuxEnabled() {
+		return fmt.Errorf("selinux label %s is set, but SELinux is not enabled", s.Process.SelinuxLabel)
+	}
+	return nil
+}
+
+// apparmorEnabled reports whether AppArmor is enabled on the host.
+func apparmorEnabled() bool {
+	data, err := os.ReadFile("/sys/module/apparmor/parameters/enabled")
+	return err == nil && strings.HasPrefix(string(data), "Y")
+}
+
+// selinuxEnabled reports whether SELinux is enabled on the host.
+func selinuxEnabled() bool {
+	var st unix.Statfs_t
+	return unix.Statfs("/sys/fs/selinux", &st) == nil && st.Type == unix.SELINUX_MAGIC
+}
+
+// sysctlPath returns the /proc/sys file of a sysctl key.
+func sysctlPath(key string) (string, error) {
+	parts := strings.Split(key, ".")
+	for _, part := range parts {
+		if part == "" || part == ".." || strings.Contains(part, "/") {
+			return "", fmt.Errorf("invalid sysctl %q", key)
+		}
+	}
+	return "/proc/sys/" + strings.Join(parts, "/"), nil
+}
+
+// verifyProcfs fails unless f is on procfs. A rootfs may ship a /proc
+// directory of plain files, or mount something else there, to catch
+// the writes meant for the kernel.
+func verifyProcfs(f *os.File) error {
+	var st unix.Statfs_t
+	if err := unix.Fstatfs(int(f.Fd()), &st); err != nil {
+		return fmt.Errorf("statfs %s: %w", f.Name(), err)
+	}
+	if st.Type != unix.PROC_SUPER_MAGIC {
+		return fmt.Errorf("%s is not on procfs (filesystem type %#x)", f.Name(), st.Type)
+	}
+	return nil
+}
+
+// openProcFile opens a file below /proc, refusing one that is not on
+// the procfs mounted at /proc.
+func openProcFile(path string, flags int) (*os.File, error) {
+	rel, ok := strings.CutPrefix(path, "/proc/")
+	if !ok {
+		return nil, fmt.Errorf("%s is not below /proc", path)
+	}
+	proc, err := os.OpenFile("/proc", unix.O_PATH|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("open /proc: %w", err)
+	}
+	defer proc.Close()
+	if err := verifyProcfs(proc); err != nil {
+		return nil, err
+	}
+
+	var f *os.File
+	if mountAPIAvailable() {
+		how := &unix.OpenHow{Flags: uint64(flags | unix.O_CLOEXEC), Resolve: resolveBeneathProc}
+		for {
+			fd, err := unix.Openat2(int(proc.Fd()), rel, how)
+			if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR) {
+				continue
+			}
+			if err != nil {
+				return nil, &os.PathError{Op: "openat2", Path: path, Err: err}
+			}
+			f = os.NewFile(uintptr(fd), path)
+			break
+		}
+	} else if f, err = os.OpenFile(path, flags|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0); err != nil {
+		return nil, err
+	}
+	if err := verifyProcfs(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// writeProcFile writes data to a verified file below /proc.
+func writeProcFile(path, data string) error {
+	f, err := openProcFile(path, unix.O_WRONLY)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeSysctls sets sysctls through the container's /proc, in key order.
+func writeSysctls(sysctls map[string]string) error {
+	keys := make([]string, 0, len(sysctls))
+	for key := range sysctls {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		path, err := sysctlPath(key)
+		if err != nil {
+			return err
+		}
+		if err := writeProcFile(path, sysctls[key]); err != nil {
+			return fmt.Errorf("sysctl %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// SetOOMScoreAdj sets the OOM killer score adjustment of the calling
+// process, when the config has one.
+func SetOOMScoreAdj(adj *int) error {
+	if adj == nil {
+		return nil
+	}
+	return writeProcFile("/proc/self/oom_score_adj", strconv.Itoa(*adj))
+}
+
+// SetProcessLabel sets the AppArmor profile or SELinux label the next
+// program executed by the calling thread runs with. The caller keeps the
+// thread locked until that exec; a child forked from the thread inherits
+// the label too.
+func SetProcessLabel(p *spec.Process) error {
+	if p == nil {
+		return nil
+	}
+	if p.ApparmorProfile != "" {
+		// Linux 5.8 gives AppArmor its own attr directory
+		path := "/proc/thread-self/attr/apparmor/exec"
+		if _, err := os.Stat(path); err != nil {
+			path = "/proc/thread-self/attr/exec"
+		}
+		if err := writeProcFile(path, "exec "+p.ApparmorProfile); err != nil {
+			return fmt.Errorf("apparmor profile %s: %w", p.ApparmorProfile, err)
+		}
+	}
+	if p.SelinuxLabel != "" {
+		if err := writeProcFile("/proc/thread-self/attr/exec", p.SelinuxLabel); err != nil {
+			return fmt.Errorf("selinux label %s: %w", p.SelinuxLabel, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package linux
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+
+	"runc-go/spec"
+)
+
+func TestValidateProcMounts(t *testing.T) {
+	tests := []struct {
+		options []string
+		wantErr bool
+	}{
+		{options: nil},
+		{options: []string{"nosuid", "hidepid=invisible", "subset=pid"}},
+		{options: []string{"hidepid=2", "gid=10"}},
+		{options: []string{"hidepid=3"}, wantErr: true},
+		{options: []string{"subset=sys"}, wantErr: true},
+		{options: []string{"gid=wheel"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		s := &spec.Spec{Mounts: []spec.Mount{{Destination: "/proc", Type: "proc", Source: "proc", Options: tt.options}}}
+		if err := ValidateProcMounts(s); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateProcMounts(%v) error = %v, wantErr %v", tt.options, err, tt.wantErr)
+		}
+	}
+
+	// Other filesystems keep their own options
+	s := &spec.Spec{Mounts: []spec.Mount{{Destination: "/tmp", Type: "tmpfs", Options: []string{"subset=x"}}}}
+	if err := ValidateProcMounts(s); err != nil {
+		t.Errorf("ValidateProcMounts(tmpfs) error: %v", err)
+	}
+}
+
+func TestValidateSysctls(t *testing.T) {
+	all := []spec.LinuxNamespace{{Type: spec.IPCNamespace}, {Type: spec.NetworkNamespace}, {Type: spec.UTSNamespace}}
+	tests := []struct {
+		key        string
+		namespaces []spec.LinuxNamespace
+		wantErr    bool
+	}{
+		{key: "net.ipv4.ip_forward", namespaces: all},
+		{key: "kernel.shmmax", namespaces: all},
+		{key: "fs.mqueue.msg_max", namespaces: all},
+		{key: "kernel.domainname", namespaces: all},
+		{key: "net.ipv4.ip_forward", namespaces: all[:1], wantErr: true},
+		{key: "kernel.sem", namespaces: all[1:], wantErr: true},
+		{key: "vm.swappiness", namespaces: all, wantErr: true},
+		{key: "net.ipv4..ip_forward", namespaces: all, wantErr: true},
+		{key: "net.ipv4/../../kernel", namespaces: all, wantErr: true},
+	}
+	for _, tt := range tests {
+		s := &spec.Spec{Linux: &spec.Linux{Namespaces: tt.namespaces, Sysctl: map[string]string{tt.key: "1"}}}
+		if err := ValidateSysctls(s); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateSysctls(%s) error = %v, wantErr %v", tt.key, err, tt.wantErr)
+		}
+	}
+}
+
+func TestOpenProcFile(t *testing.T) {
+	f, err := openProcFile("/proc/self/status", os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("openProcFile error: %v", err)
+	}
+	f.Close()
+
+	if _, err := openProcFile("/etc/hostname", os.O_RDONLY); err == nil {
+		t.Error("openProcFile opened a file outside /proc")
+	}
+	if _, err := openProcFile("/proc/self/root/etc/hostname", os.O_RDONLY); err == nil {
+		t.Error("openProcFile followed a magic link out of /proc")
+	}
+}
+
+func TestSetOOMScoreAdj(t *testing.T) {
+	data, err := os.ReadFile("/proc/self/oom_score_adj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	adj, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Writing the current value back needs no privilege
+	if err := SetOOMScoreAdj(&adj); err != nil {
+		t.Errorf("SetOOMScoreAdj(%d) error: %v", adj, err)
+	}
+	if err := SetOOMScoreAdj(nil); err != nil {
+		t.Errorf("SetOOMScoreAdj(nil) error: %v", err)
+	}
+}
+
+func TestWriteProcFile_FakeProc(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("mounting needs root")
+	}
+	fake := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(fake, "self"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(fake, "self", "oom_score_adj"), []byte("0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var setupErr, fakeErr, stackedErr error
+	inMountNamespace(t, func() {
+		// A rootfs directory standing in for /proc
+		if err := unix.Mount(fake, "/proc", "", unix.MS_BIND, ""); err != nil {
+			setupErr = err
+			return
+		}
+		fakeErr = writeProcFile("/proc/self/oom_score_adj", "-1000")
+		unix.Unmount("/proc", unix.MNT_DETACH)
+
+		// A file mounted over a procfs entry
+		target := "/proc/sys/kernel/hostname"
+		if err := unix.Mount(filepath.Join(fake, "self", "oom_score_adj"), target, "", unix.MS_BIND, ""); err != nil {
+			setupErr = err
+			return
+		}
+		stackedErr = writeProcFile(target, "fake")
+		unix.Unmount(target, unix.MNT_DETACH)
+	})
+	if setupErr != nil {
+		t.Fatalf("mount: %v", setupErr)
+	}
+	if fakeErr == nil {
+		t.Error("writeProcFile wrote to a directory bound over /proc")
+	}
+	if stackedErr == nil {
+		t.Error("writeProcFile wrote to a file mounted over a procfs entry")
+	}
+	if data, _ := os.ReadFile(filepath.Join(fake, "self", "oom_score_adj")); string(data) != "0" {
+		t.Errorf("fake oom_score_adj = %q, want it untouched", data)
+	}
+}
@@ -2,12 +2,15 @@
 package linux
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 	"syscall"
 
+	"golang.org/x/sys/unix"
+
 	cerrors "runc-go/errors"
 	"runc-go/spec"
 )
@@ -196,10 +199,72 @@ var mountOptionFlags = map[string]uintptr{
 	"norelatime": 0,
 	"strictatime": MS_STRICTATIME,
 	"noatime":    MS_NOATIME,
+	"nodiratime": unix.MS_NODIRATIME,
+	"diratime":   0,
+	"nosymfollow": unix.MS_NOSYMFOLLOW,
+	"symfollow":  0,
+}
+
+// MountOptionalOption makes a mount best-effort: when it fails, a warning
+// is printed and the container starts without it. Any other failed mount
+// aborts the container's creation.
+const MountOptionalOption = "x-runc-go.optional"
+
+// MountOptionalAnnotation lists the destinations of best-effort mounts,
+// separated by commas, for specs whose mount options cannot be changed.
+const MountOptionalAnnotation = "org.opencontainers.runc-go.mounts.optional"
+
+// recursiveMountAttrs maps the recursive mount options to the
+// mount_setattr attributes they set and clear on a whole mount tree.
+var recursiveMountAttrs = map[string]unix.MountAttr{
+	"rro":          {Attr_set: unix.MOUNT_ATTR_RDONLY},
+	"rrw":          {Attr_clr: unix.MOUNT_ATTR_RDONLY},
+	"rnosuid":      {Attr_set: unix.MOUNT_ATTR_NOSUID},
+	"rsuid":        {Attr_clr: unix.MOUNT_ATTR_NOSUID},
+	"rnodev":       {Attr_set: unix.MOUNT_ATTR_NODEV},
+	"rdev":         {Attr_clr: unix.MOUNT_ATTR_NODEV},
+	"rnoexec":      {Attr_set: unix.MOUNT_ATTR_NOEXEC},
+	"rexec":        {Attr_clr: unix.MOUNT_ATTR_NOEXEC},
+	"rnodiratime":  {Attr_set: unix.MOUNT_ATTR_NODIRATIME},
+	"rdiratime":    {Attr_clr: unix.MOUNT_ATTR_NODIRATIME},
+	"rrelatime":    {Attr_set: unix.MOUNT_ATTR_RELATIME, Attr_clr: unix.MOUNT_ATTR__ATIME},
+	"rnoatime":     {Attr_set: unix.MOUNT_ATTR_NOATIME, Attr_clr: unix.MOUNT_ATTR__ATIME},
+	"rstrictatime": {Attr_set: unix.MOUNT_ATTR_STRICTATIME, Attr_clr: unix.MOUNT_ATTR__ATIME},
+	"rnosymfollow": {Attr_set: unix.MOUNT_ATTR_NOSYMFOLLOW},
+	"rsymfollow":   {Attr_clr: unix.MOUNT_ATTR_NOSYMFOLLOW},
 }
 
-// SetupRootfs sets up the container's root filesystem.
-func SetupRootfs(s *spec.Spec, bundlePath string) error {
+// bindRemountFlags are the per-mount flags that a bind mount ignores and
+// only gets from a second, remounting call.
+const bindRemountFlags = MS_RDONLY | MS_NOSUID | MS_NODEV | MS_NOEXEC |
+	MS_NOATIME | MS_STRICTATIME | MS_RELATIME | unix.MS_NODIRATIME | unix.MS_NOSYMFOLLOW
+
+// atimeFlags select one atime mode; setting any of them replaces the others.
+const atimeFlags = MS_NOATIME | MS_STRICTATIME | MS_RELATIME
+
+// stNoSymFollow is ST_NOSYMFOLLOW from statfs, missing from x/sys.
+const stNoSymFollow = 0x2000
+
+// statfsMountFlags maps statfs flags to the mount flags they report.
+var statfsMountFlags = map[int64]uintptr{
+	unix.ST_RDONLY:     MS_RDONLY,
+	unix.ST_NOSUID:     MS_NOSUID,
+	unix.ST_NODEV:      MS_NODEV,
+	unix.ST_NOEXEC:     MS_NOEXEC,
+	unix.ST_NOATIME:    MS_NOATIME,
+	unix.ST_NODIRATIME: unix.MS_NODIRATIME,
+	unix.ST_RELATIME:   MS_RELATIME,
+	stNoSymFollow:      unix.MS_NOSYMFOLLOW,
+}
+
+// SetupRootfs sets up the container's root filesystem. mountTrees holds
+// the prepared ID-mapped mounts from OpenIDMappedMounts and the generated
+// /etc files from OpenEtcFileMounts by mount index; they are attached in
+// place of a bind mount and closed. The image rootfs from OpenImageRootfs
+// is under RootfsMountTree; an image given as root.path is mounted, and
+// the /etc files are generated, in stateDir. Devices are checked against
+// policy.
+func SetupRootfs(s *spec.Spec, bundlePath, stateDir string, mountTrees map[int]*os.File, policy *DevicePolicy) error {
 	if s.Root == nil {
 		return cerrors.ErrMissingRootfs
 	}
@@ -220,16 +285,46 @@ func SetupRootfs(s *spec.Spec, bundlePath string) error {
 		fmt.Printf("[rootfs] warning: make private: %v\n", err)
 	}
 
-	// Bind mount rootfs to itself (make it a mount point for pivot_root)
-	if err := syscall.Mount(rootfs, rootfs, "", MS_BIND|MS_REC, ""); err != nil {
+	// Mount a filesystem image, assemble an overlay rootfs from its layers,
+	// or bind mount rootfs to itself; each makes it a mount point for
+	// pivot_root
+	image, err := ParseImageRootfs(s, bundlePath, stateDir)
+	if err != nil {
+		return cerrors.WrapWithDetail(err, cerrors.ErrInvalidConfig, "image rootfs", rootfs)
+	}
+	overlay, err := ParseOverlayRootfs(s, bundlePath)
+	if err != nil {
+		return cerrors.WrapWithDetail(err, cerrors.ErrInvalidConfig, "overlay rootfs", rootfs)
+	}
+	if image != nil {
+		rootfs = image.MountPoint
+		if err := image.Mount(mountTrees[RootfsMountTree], overlay); err != nil {
+			return cerrors.WrapWithDetail(err, cerrors.ErrRootfs, "image rootfs", image.Path)
+		}
+	} else if overlay != nil {
+		if err := overlay.Mount(rootfs); err != nil {
+			return cerrors.WrapWithDetail(err, cerrors.ErrRootfs, "overlay rootfs", rootfs)
+		}
+	} else if err := syscall.Mount(rootfs, rootfs, "", MS_BIND|MS_REC, ""); err != nil {
 		return fmt.Errorf("bind mount rootfs: %w", err)
 	}
 
-	// Setup mounts before pivot_root
-	if err := setupMounts(s.Mounts, rootfs); err != nil {
+	// A fresh /dev goes first, for the spec's mounts below it
+	if err := SetupDevTmpfs(s, rootfs); err != nil {
+		return cerrors.WrapWithDetail(err, cerrors.ErrRootfs, "mount", "/dev")
+	}
+
+	// Setup mounts before pivot_root, then the generated /etc files
+	mounts := append(optionalMounts(s), etcFileMounts(s, stateDir)...)
+	if err := setupMounts(mounts, rootfs, newCgroupMountOptions(s), mountTrees); err != nil {
 		return fmt.Errorf("setup mounts: %w", err)
 	}
 
+	// Devices come from the host's /dev, which is gone after pivot_root
+	if err := SetupDevices(s, rootfs, policy); err != nil {
+		return cerrors.WrapWithDetail(err, cerrors.ErrDevice, "devices", "/dev")
+	}
+
 	// Pivot root
 	if err := pivotRoot(rootfs); err != nil {
 		return fmt.Errorf("pivot_root: %w", err)
@@ -249,16 +344,24 @@ func SetupRootfs(s *spec.Spec, bundlePath string) error {
 		}
 	}
 
-	// Mask paths
+	// Sysctls go through the container's /proc before /proc/sys turns
+	// read-only
+	if s.Linux != nil && len(s.Linux.Sysctl) > 0 {
+		if err := writeSysctls(s.Linux.Sysctl); err != nil {
+			return cerrors.WrapWithDetail(err, cerrors.ErrRootfs, "sysctl", "/proc/sys")
+		}
+	}
+
+	// Mask paths. A path that stays exposed is a failure like a mount.
 	if s.Linux != nil {
 		for _, path := range s.Linux.MaskedPaths {
 			if err := maskPath(path); err != nil {
-				fmt.Printf("[rootfs] warning: mask %s: %v\n", path, err)
+				return cerrors.WrapWithDetail(err, cerrors.ErrRootfs, "mask path", path)
 			}
 		}
 		for _, path := range s.Linux.ReadonlyPaths {
 			if err := readonlyPath(path); err != nil {
-				fmt.Printf("[rootfs] warning: readonly %s: %v\n", path, err)
+				return cerrors.WrapWithDetail(err, cerrors.ErrRootfs, "readonly path", path)
 			}
 		}
 	}
@@ -271,33 +374,39 @@ func makePrivate(path string) error {
 	return syscall.Mount("", path, "", MS_REC|MS_PRIVATE, "")
 }
 
-// pivotRoot performs pivot_root to change the root filesystem.
+// pivotRoot performs pivot_root to change the root filesystem. The old
+// root is stacked on the new one with pivot_root(".", "."), which needs
+// no directory for it, so a read-only rootfs works too.
 func pivotRoot(rootfs string) error {
-	// Create directory for old root
-	oldRoot := filepath.Join(rootfs, ".old_root")
-	if err := os.MkdirAll(oldRoot, 0700); err != nil {
-		return fmt.Errorf("mkdir old_root: %w", err)
+	oldRoot, err := os.Open("/")
+	if err != nil {
+		return fmt.Errorf("open old root: %w", err)
+	}
+	defer oldRoot.Close()
+
+	if err := os.Chdir(rootfs); err != nil {
+		return fmt.Errorf("chdir rootfs: %w", err)
 	}
 
 	// Pivot root
-	if err := syscall.PivotRoot(rootfs, oldRoot); err != nil {
+	if err := syscall.PivotRoot(".", "."); err != nil {
 		// Try chroot fallback for rootless containers
 		return chrootFallback(rootfs)
 	}
 
-	// Change to new root
-	if err := os.Chdir("/"); err != nil {
-		return fmt.Errorf("chdir /: %w", err)
+	// The old root now sits on top of the new one; step into it to
+	// unmount it
+	if err := oldRoot.Chdir(); err != nil {
+		return fmt.Errorf("chdir old root: %w", err)
 	}
-
-	// Unmount old root
-	oldRoot = "/.old_root"
-	if err := syscall.Unmount(oldRoot, syscall.MNT_DETACH); err != nil {
+	if err := syscall.Unmount(".", syscall.MNT_DETACH); err != nil {
 		return fmt.Errorf("unmount old root: %w", err)
 	}
 
-	// Remove old root directory
-	os.RemoveAll(oldRoot)
+	// Change to new root
+	if err := os.Chdir("/"); err != nil {
+		return fmt.Errorf("chdir /: %w", err)
+	}
 
 	return nil
 }
@@ -313,78 +422,222 @@ func chrootFallback(rootfs string) error {
 	return nil
 }
 
-// setupMounts performs all mounts specified in the OCI config.
-func setupMounts(mounts []spec.Mount, rootfs string) error {
-	for _, m := range mounts {
-		// Use SecureJoin to prevent path traversal attacks
-		dest, err := SecureJoin(rootfs, m.Destination)
-		if err != nil {
-			return fmt.Errorf("invalid mount destination %q: %w", m.Destination, err)
+// setupMounts performs all mounts specified in the OCI config, through
+// descriptors when the kernel has the new mount API.
+func setupMounts(mounts []spec.Mount, rootfs string, cgroupOpts cgroupMountOptions, mountTrees map[int]*os.File) error {
+	if mountAPIAvailable() {
+		return setupMountsInRoot(mounts, rootfs, cgroupOpts, mountTrees)
+	}
+	return setupMountsLegacy(mounts, rootfs, cgroupOpts, mountTrees)
+}
+
+// setupMountsLegacy performs the mounts on paths checked with SecureJoin,
+// for kernels without openat2. A symlink swapped in after the check can
+// still redirect a mount.
+func setupMountsLegacy(mounts []spec.Mount, rootfs string, cgroupOpts cgroupMountOptions, mountTrees map[int]*os.File) error {
+	for i, m := range mounts {
+		if err := mountLegacy(m, rootfs, cgroupOpts, i, mountTrees); err != nil {
+			if err := mountError(m, err); err != nil {
+				return err
+			}
 		}
+	}
+	return nil
+}
 
-		// Parse mount options
-		flags, data := parseMountOptions(m.Options)
+// mountLegacy performs a single mount below rootfs.
+func mountLegacy(m spec.Mount, rootfs string, cgroupOpts cgroupMountOptions, i int, mountTrees map[int]*os.File) error {
+	// Use SecureJoin to prevent path traversal attacks
+	dest, err := SecureJoin(rootfs, m.Destination)
+	if err != nil {
+		return fmt.Errorf("invalid mount destination %q: %w", m.Destination, err)
+	}
 
-		// Handle special mount types
-		source := m.Source
-		isBind := m.Type == "bind" || hasOption(m.Options, "bind") || hasOption(m.Options, "rbind")
+	if tree, ok := mountTrees[i]; ok {
+		err := AttachMount(tree, dest)
+		tree.Close()
+		return err
+	}
+	if IDMappedMount(m) {
+		return fmt.Errorf("idmapped mount %s was not prepared", m.Destination)
+	}
 
-		if isBind {
-			// Bind mount - check if source is file or directory
-			if !filepath.IsAbs(source) {
-				// Relative source paths must also be validated
-				var err error
-				source, err = SecureJoin(rootfs, source)
-				if err != nil {
-					return fmt.Errorf("invalid bind source %q: %w", m.Source, err)
-				}
-			}
+	// Parse mount options
+	flags, data := parseMountOptions(m.Options)
 
-			// Stat the source to determine if it's a file or directory
-			srcInfo, err := os.Stat(source)
+	// Handle special mount types
+	source := m.Source
+	isBind := m.Type == "bind" || hasOption(m.Options, "bind") || hasOption(m.Options, "rbind")
+
+	if !isBind && (m.Type == "cgroup" || m.Type == "cgroup2") {
+		// Only the container's own subtree is visible
+		if err := os.MkdirAll(dest, 0755); err != nil {
+			return fmt.Errorf("mkdir %s: %w", dest, err)
+		}
+		return mountCgroup(dest, flags, cgroupOpts)
+	}
+
+	if isBind {
+		// Bind mount - check if source is file or directory
+		if !filepath.IsAbs(source) {
+			// Relative source paths must also be validated
+			var err error
+			source, err = SecureJoin(rootfs, source)
 			if err != nil {
-				// Source doesn't exist, skip this mount
-				fmt.Printf("[rootfs] warning: bind source %s not found: %v\n", source, err)
-				continue
+				return fmt.Errorf("invalid bind source %q: %w", m.Source, err)
 			}
+		}
 
-			// Create mount point based on source type
-			if srcInfo.IsDir() {
-				if err := os.MkdirAll(dest, 0755); err != nil {
-					return fmt.Errorf("mkdir %s: %w", dest, err)
-				}
-			} else {
-				// Source is a file - create parent dir and empty file
-				if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
-					return fmt.Errorf("mkdir parent %s: %w", filepath.Dir(dest), err)
-				}
-				// Create empty file if it doesn't exist
-				if _, err := os.Stat(dest); os.IsNotExist(err) {
-					f, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY, 0644)
-					if err != nil {
-						return fmt.Errorf("create file %s: %w", dest, err)
-					}
-					f.Close()
-				}
-			}
+		// Stat the source to determine if it's a file or directory
+		srcInfo, err := os.Stat(source)
+		if err != nil {
+			return fmt.Errorf("bind source: %w", err)
+		}
 
-			if err := syscall.Mount(source, dest, "", flags|MS_BIND, data); err != nil {
-				return fmt.Errorf("bind mount %s: %w", dest, err)
-			}
-		} else {
-			// Regular mount - create directory
+		// Create mount point based on source type
+		if srcInfo.IsDir() {
 			if err := os.MkdirAll(dest, 0755); err != nil {
 				return fmt.Errorf("mkdir %s: %w", dest, err)
 			}
-			if err := syscall.Mount(source, dest, m.Type, flags, data); err != nil {
-				// Non-fatal for optional mounts
-				fmt.Printf("[rootfs] warning: mount %s (%s): %v\n", dest, m.Type, err)
+		} else {
+			// Source is a file - create parent dir and empty file
+			if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+				return fmt.Errorf("mkdir parent %s: %w", filepath.Dir(dest), err)
 			}
+			// Create empty file if it doesn't exist
+			if _, err := os.Stat(dest); os.IsNotExist(err) {
+				f, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY, 0644)
+				if err != nil {
+					return fmt.Errorf("create file %s: %w", dest, err)
+				}
+				f.Close()
+			}
+		}
+
+		if err := syscall.Mount(source, dest, "", flags|MS_BIND, data); err != nil {
+			return fmt.Errorf("bind mount %s: %w", dest, err)
+		}
+		// The bind ignored the per-mount flags
+		if err := remountBind(dest, flags); err != nil {
+			syscall.Unmount(dest, syscall.MNT_DETACH)
+			return fmt.Errorf("bind mount %s: %w", dest, err)
+		}
+	} else {
+		// Regular mount - create directory
+		if err := os.MkdirAll(dest, 0755); err != nil {
+			return fmt.Errorf("mkdir %s: %w", dest, err)
+		}
+		if err := syscall.Mount(source, dest, m.Type, flags, data); err != nil {
+			return err
+		}
+	}
+
+	if attr, ok := recursiveMountAttr(m.Options); ok {
+		if err := setRecursiveMountAttr(unix.AT_FDCWD, dest, 0, &attr); err != nil {
+			// Never leave the mount without the requested flags
+			syscall.Unmount(dest, syscall.MNT_DETACH)
+			return err
 		}
 	}
 	return nil
 }
 
+// mountError turns a failed mount into a rootfs error naming the mount.
+// A mount with the optional option only gets a warning and is skipped.
+func mountError(m spec.Mount, err error) error {
+	if hasOption(m.Options, MountOptionalOption) {
+		fmt.Printf("[rootfs] warning: optional mount %s (%s): %v\n", m.Destination, m.Type, err)
+		return nil
+	}
+	return cerrors.WrapWithDetail(err, cerrors.ErrRootfs, "mount",
+		fmt.Sprintf("%s (type %s, source %s)", m.Destination, m.Type, m.Source))
+}
+
+// optionalMounts returns the mounts of s, with the optional option added to
+// those whose destination is listed in MountOptionalAnnotation.
+func optionalMounts(s *spec.Spec) []spec.Mount {
+	value := s.Annotations[MountOptionalAnnotation]
+	if value == "" {
+		return s.Mounts
+	}
+	optional := make(map[string]bool)
+	for _, dest := range strings.Split(value, ",") {
+		optional[filepath.Clean(strings.TrimSpace(dest))] = true
+	}
+	mounts := make([]spec.Mount, len(s.Mounts))
+	for i, m := range s.Mounts {
+		mounts[i] = m
+		if optional[filepath.Clean(m.Destination)] {
+			mounts[i].Options = append(append([]string(nil), m.Options...), MountOptionalOption)
+		}
+	}
+	return mounts
+}
+
+// remountBind applies the per-mount flags of the bind mount at dest, which
+// a bind ignores, with a remount. Flags the mount already has are kept: in
+// a user namespace they may be locked, and clearing one fails.
+func remountBind(dest string, flags uintptr) error {
+	flags &= bindRemountFlags
+	if flags == 0 {
+		return nil
+	}
+	var st unix.Statfs_t
+	if err := unix.Statfs(dest, &st); err != nil {
+		return fmt.Errorf("statfs: %w", err)
+	}
+	flags |= inheritedMountFlags(st.Flags, flags)
+	if err := syscall.Mount("", dest, "", MS_BIND|MS_REMOUNT|flags, ""); err != nil {
+		return fmt.Errorf("remount: %w", err)
+	}
+	return nil
+}
+
+// inheritedMountFlags returns the mount flags reported by statfs that a
+// remount with flags must repeat. The atime mode is kept unless flags
+// choose another.
+func inheritedMountFlags(statfsFlags int64, flags uintptr) uintptr {
+	var inherited uintptr
+	for st, flag := range statfsMountFlags {
+		if statfsFlags&st != 0 {
+			inherited |= flag
+		}
+	}
+	if flags&atimeFlags != 0 {
+		inherited &^= atimeFlags
+	}
+	return inherited
+}
+
+// recursiveMountAttr combines the recursive mount options, such as "rro",
+// into one mount_setattr request. Later options override earlier ones.
+func recursiveMountAttr(options []string) (unix.MountAttr, bool) {
+	var attr unix.MountAttr
+	found := false
+	for _, opt := range options {
+		a, ok := recursiveMountAttrs[opt]
+		if !ok {
+			continue
+		}
+		attr.Attr_set = attr.Attr_set&^a.Attr_clr | a.Attr_set
+		attr.Attr_clr = attr.Attr_clr&^a.Attr_set | a.Attr_clr
+		found = true
+	}
+	return attr, found
+}
+
+// setRecursiveMountAttr applies attr to the mount at path relative to
+// dirfd and to every mount below it.
+func setRecursiveMountAttr(dirfd int, path string, flags uint, attr *unix.MountAttr) error {
+	if err := unix.MountSetattr(dirfd, path, flags|unix.AT_RECURSIVE, attr); err != nil {
+		if errors.Is(err, unix.ENOSYS) {
+			return fmt.Errorf("mount_setattr: recursive mount options need Linux 5.12+: %w", err)
+		}
+		return fmt.Errorf("mount_setattr: %w", err)
+	}
+	return nil
+}
+
 // parseMountOptions parses OCI mount options into flags and data string.
 func parseMountOptions(options []string) (uintptr, string) {
 	var flags uintptr
@@ -393,6 +646,12 @@ func parseMountOptions(options []string) (uintptr, string) {
 	for _, opt := range options {
 		if flag, ok := mountOptionFlags[opt]; ok {
 			flags |= flag
+		} else if _, ok := recursiveMountAttrs[opt]; ok {
+			// Applied with mount_setattr after the mount
+			continue
+		} else if strings.HasPrefix(opt, "x-") {
+			// Options for the runtime, not the filesystem
+			continue
 		} else if strings.Contains(opt, "=") || !isKnownOption(opt) {
 			// Data options passed to filesystem
 			dataOpts = append(dataOpts, opt)
@@ -444,25 +703,29 @@ func applyPropagation(path, propagation string) error {
 	return syscall.Mount("", path, "", flag, "")
 }
 
-// maskPath masks a path by bind-mounting /dev/null over it.
+// maskPath masks a path by bind-mounting /dev/null over it. A path that
+// does not exist needs no masking.
 func maskPath(path string) error {
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		return nil
-	}
-
-	// Check if it's a file or directory
 	fi, err := os.Stat(path)
 	if err != nil {
-		return nil // Best effort
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
 	}
 
 	if fi.IsDir() {
 		// Bind mount empty tmpfs
-		return syscall.Mount("tmpfs", path, "tmpfs", MS_RDONLY, "size=0")
+		err = syscall.Mount("tmpfs", path, "tmpfs", MS_RDONLY, "size=0")
+	} else {
+		// Bind mount /dev/null
+		err = syscall.Mount("/dev/null", path, "", MS_BIND, "")
 	}
-
-	// Bind mount /dev/null
-	return syscall.Mount("/dev/null", path, "", MS_BIND, "")
+	// Some procfs entries are listed but cannot be looked up
+	if errors.Is(err, syscall.ENOENT) {
+		return nil
+	}
+	return err
 }
 
 // readonlyPath makes a path read-only by remounting it.
@@ -476,8 +739,8 @@ func readonlyPath(path string) error {
 		return err
 	}
 
-	// Remount read-only
-	return syscall.Mount(path, path, "", MS_BIND|MS_REMOUNT|MS_RDONLY|MS_REC, "")
+	// Remount read-only, keeping flags that may be locked
+	return remountBind(path, MS_RDONLY)
 }
 
 // MountProc mounts procfs at /proc.
@@ -487,116 +750,3 @@ func MountProc() error {
 	}
 	return syscall.Mount("proc", "/proc", "proc", MS_NOSUID|MS_NOEXEC|MS_NODEV, "")
 }
-
-// CreateDevices creates device nodes specified in the config.
-func CreateDevices(devices []spec.LinuxDevice) error {
-	for _, dev := range devices {
-		if err := createDevice(dev); err != nil {
-			return fmt.Errorf("create device %s: %w", dev.Path, err)
-		}
-	}
-	return nil
-}
-
-// createDevice creates a single device node.
-func createDevice(dev spec.LinuxDevice) error {
-	// Ensure parent directory exists
-	dir := filepath.Dir(dev.Path)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return err
-	}
-
-	// Calculate device type
-	var devType uint32
-	switch dev.Type {
-	case "c", "u":
-		devType = syscall.S_IFCHR
-	case "b":
-		devType = syscall.S_IFBLK
-	case "p":
-		devType = syscall.S_IFIFO
-	default:
-		return fmt.Errorf("unknown device type: %s", dev.Type)
-	}
-
-	// Calculate mode
-	mode := devType
-	if dev.FileMode != nil {
-		mode |= uint32(*dev.FileMode)
-	} else {
-		mode |= 0666
-	}
-
-	// Calculate device number
-	devNum := int((dev.Major << 8) | dev.Minor)
-
-	// Create device
-	if err := syscall.Mknod(dev.Path, mode, devNum); err != nil {
-		if !os.IsExist(err) {
-			return err
-		}
-	}
-
-	// Set ownership
-	uid := 0
-	gid := 0
-	if dev.UID != nil {
-		uid = int(*dev.UID)
-	}
-	if dev.GID != nil {
-		gid = int(*dev.GID)
-	}
-	if err := os.Chown(dev.Path, uid, gid); err != nil {
-		return err
-	}
-
-	return nil
-}
-
-// SetupDefaultDevices creates the standard container device nodes.
-func SetupDefaultDevices() error {
-	devices := []spec.LinuxDevice{
-		{Path: "/dev/null", Type: "c", Major: 1, Minor: 3},
-		{Path: "/dev/zero", Type: "c", Major: 1, Minor: 5},
-		{Path: "/dev/full", Type: "c", Major: 1, Minor: 7},
-		{Path: "/dev/random", Type: "c", Major: 1, Minor: 8},
-		{Path: "/dev/urandom", Type: "c", Major: 1, Minor: 9},
-		{Path: "/dev/tty", Type: "c", Major: 5, Minor: 0},
-	}
-
-	mode := os.FileMode(0666)
-	for i := range devices {
-		devices[i].FileMode = &mode
-	}
-
-	return CreateDevices(devices)
-}
-
-// SetupDevSymlinks creates standard /dev symlinks.
-func SetupDevSymlinks() error {
-	symlinks := map[string]string{
-		"/dev/fd":     "/proc/self/fd",
-		"/dev/stdin":  "/proc/self/fd/0",
-		"/dev/stdout": "/proc/self/fd/1",
-		"/dev/stderr": "/proc/self/fd/2",
-	}
-
-	for link, target := range symlinks {
-		os.Remove(link) // Remove if exists
-		if err := os.Symlink(target, link); err != nil {
-			fmt.Printf("[dev] warning: symlink %s: %v\n", link, err)
-		}
-	}
-
-	return nil
-}
-
-// SetupDevPts mounts devpts at /dev/pts.
-func SetupDevPts() error {
-	if err := os.MkdirAll("/dev/pts", 0755); err != nil {
-		return err
-	}
-	return syscall.Mount("devpts", "/dev/pts", "devpts",
-		MS_NOSUID|MS_NOEXEC,
-		"newinstance,ptmxmode=0666,mode=0620")
-}
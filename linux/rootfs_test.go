@@ -1,10 +1,16 @@
 package linux
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+
+	"golang.org/x/sys/unix"
+
+	cerrors "runc-go/errors"
+	"runc-go/spec"
 )
 
 func TestSecureJoin_ValidPaths(t *testing.T) {
@@ -217,6 +223,243 @@ func TestMountOptionFlags(t *testing.T) {
 	}
 }
 
+func TestParseMountOptions_Recursive(t *testing.T) {
+	flags, data := parseMountOptions([]string{"rbind", "rro", "rnosuid", "nosymfollow", "mode=755"})
+	if flags != MS_BIND|MS_REC|unix.MS_NOSYMFOLLOW {
+		t.Errorf("flags = %#x, want rbind and nosymfollow", flags)
+	}
+	if data != "mode=755" {
+		t.Errorf("data = %q, want recursive options left out", data)
+	}
+}
+
+func TestRecursiveMountAttr(t *testing.T) {
+	if _, ok := recursiveMountAttr([]string{"ro", "nosuid"}); ok {
+		t.Error("recursiveMountAttr found recursive options in per-mount ones")
+	}
+
+	attr, _ := recursiveMountAttr([]string{"rro", "rnosuid", "rnoatime"})
+	want := unix.MountAttr{
+		Attr_set: unix.MOUNT_ATTR_RDONLY | unix.MOUNT_ATTR_NOSUID | unix.MOUNT_ATTR_NOATIME,
+		Attr_clr: unix.MOUNT_ATTR__ATIME,
+	}
+	if attr != want {
+		t.Errorf("attr = %+v, want %+v", attr, want)
+	}
+
+	// A later option overrides an earlier one
+	attr, _ = recursiveMountAttr([]string{"rro", "rrw", "rnoatime", "rrelatime"})
+	want = unix.MountAttr{
+		Attr_set: unix.MOUNT_ATTR_RELATIME,
+		Attr_clr: unix.MOUNT_ATTR_RDONLY | unix.MOUNT_ATTR__ATIME,
+	}
+	if attr != want {
+		t.Errorf("attr = %+v, want %+v", attr, want)
+	}
+}
+
+func TestInheritedMountFlags(t *testing.T) {
+	tests := []struct {
+		name        string
+		statfsFlags int64
+		flags       uintptr
+		want        uintptr
+	}{
+		{"none", 0, MS_RDONLY, 0},
+		{"locked flags", unix.ST_NOSUID | unix.ST_NODEV | unix.ST_RELATIME, MS_RDONLY, MS_NOSUID | MS_NODEV | MS_RELATIME},
+		{"atime replaced", unix.ST_NOEXEC | unix.ST_NOATIME, MS_RELATIME, MS_NOEXEC},
+		{"nosymfollow", stNoSymFollow | unix.ST_RDONLY, MS_NOSUID, unix.MS_NOSYMFOLLOW | MS_RDONLY},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := inheritedMountFlags(tt.statfsFlags, tt.flags); got != tt.want {
+				t.Errorf("inheritedMountFlags(%#x, %#x) = %#x, want %#x", tt.statfsFlags, tt.flags, got, tt.want)
+			}
+		})
+	}
+}
+
+// mountinfoOptions returns the per-mount options of the mount at path
+// from mountinfo, and whether there is one.
+func mountinfoOptions(mountinfo, path string) ([]string, bool) {
+	var options []string
+	found := false
+	for _, line := range strings.Split(mountinfo, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 5 && fields[4] == path {
+			// The last mount on a path is the visible one
+			options = strings.Split(fields[5], ",")
+			found = true
+		}
+	}
+	return options, found
+}
+
+func TestSetupMounts_BindFlags(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("mounting needs root")
+	}
+	if !mountAPIAvailable() {
+		t.Skip("mount_setattr is unavailable")
+	}
+
+	setups := map[string]func([]spec.Mount, string, cgroupMountOptions, map[int]*os.File) error{
+		"mount api": setupMountsInRoot,
+		"legacy":    setupMountsLegacy,
+	}
+	for name, setup := range setups {
+		t.Run(name, func(t *testing.T) {
+			tmp := t.TempDir()
+			src := filepath.Join(tmp, "src")
+			rootfs := filepath.Join(tmp, "rootfs")
+			os.Mkdir(src, 0755)
+			os.Mkdir(rootfs, 0755)
+
+			mounts := []spec.Mount{
+				{Destination: "/ro", Type: "bind", Source: src, Options: []string{"bind", "ro", "nosuid", "noexec"}},
+				{Destination: "/rro", Type: "bind", Source: src, Options: []string{"rbind", "rro", "rnosuid"}},
+				{Destination: "/nosym", Type: "bind", Source: src, Options: []string{"bind", "nosymfollow"}},
+				{Destination: "/tmp", Type: "tmpfs", Source: "tmpfs", Options: []string{"rnoatime", "nodev"}},
+			}
+			var mountinfo string
+			var err error
+			inMountNamespace(t, func() {
+				// The source has nodev of its own and a submount
+				if err = unix.Mount("tmpfs", src, "tmpfs", unix.MS_NODEV, ""); err != nil {
+					return
+				}
+				os.Mkdir(filepath.Join(src, "sub"), 0755)
+				if err = unix.Mount("tmpfs", filepath.Join(src, "sub"), "tmpfs", 0, ""); err != nil {
+					return
+				}
+				if err = setup(mounts, rootfs, cgroupMountOptions{}, nil); err != nil {
+					return
+				}
+				data, _ := os.ReadFile("/proc/thread-self/mountinfo")
+				mountinfo = string(data)
+			})
+			if err != nil {
+				t.Fatalf("setup mounts: %v", err)
+			}
+
+			tests := []struct {
+				path string
+				want []string
+			}{
+				{"/ro", []string{"ro", "nosuid", "nodev", "noexec"}},
+				{"/rro", []string{"ro", "nosuid", "nodev"}},
+				{"/rro/sub", []string{"ro", "nosuid"}},
+				{"/nosym", []string{"rw", "nodev", "nosymfollow"}},
+				{"/tmp", []string{"nodev", "noatime"}},
+			}
+			for _, tt := range tests {
+				options, ok := mountinfoOptions(mountinfo, filepath.Join(rootfs, tt.path))
+				if !ok {
+					t.Errorf("no mount at %s", tt.path)
+					continue
+				}
+				for _, want := range tt.want {
+					if !hasOption(options, want) {
+						t.Errorf("mount %s options = %v, want %s", tt.path, options, want)
+					}
+				}
+			}
+			if _, ok := mountinfoOptions(mountinfo, filepath.Join(rootfs, "ro/sub")); ok {
+				t.Error("non-recursive bind mount copied a submount")
+			}
+		})
+	}
+}
+
+func TestParseMountOptions_RuntimeOptions(t *testing.T) {
+	_, data := parseMountOptions([]string{"rbind", MountOptionalOption, "mode=755"})
+	if data != "mode=755" {
+		t.Errorf("data = %q, want runtime options left out", data)
+	}
+}
+
+func TestMountError(t *testing.T) {
+	m := spec.Mount{Destination: "/data", Type: "bind", Source: "/srv/typo"}
+	err := mountError(m, os.ErrNotExist)
+	if !cerrors.IsKind(err, cerrors.ErrRootfs) {
+		t.Fatalf("mountError() = %v, want a rootfs error", err)
+	}
+	if !strings.Contains(err.Error(), "/data") || !strings.Contains(err.Error(), "/srv/typo") {
+		t.Errorf("error %q does not name the mount", err)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("error %q does not wrap the cause", err)
+	}
+
+	m.Options = []string{"rbind", MountOptionalOption}
+	if err := mountError(m, os.ErrNotExist); err != nil {
+		t.Errorf("mountError() for an optional mount = %v, want nil", err)
+	}
+}
+
+func TestOptionalMounts(t *testing.T) {
+	s := &spec.Spec{
+		Mounts: []spec.Mount{
+			{Destination: "/data", Options: []string{"rbind"}},
+			{Destination: "/cache/"},
+			{Destination: "/etc"},
+		},
+		Annotations: map[string]string{MountOptionalAnnotation: "/data, /cache"},
+	}
+	mounts := optionalMounts(s)
+	for i, want := range []bool{true, true, false} {
+		if got := hasOption(mounts[i].Options, MountOptionalOption); got != want {
+			t.Errorf("mount %s optional = %v, want %v", mounts[i].Destination, got, want)
+		}
+	}
+	if hasOption(s.Mounts[0].Options, MountOptionalOption) {
+		t.Error("optionalMounts modified the spec")
+	}
+}
+
+func TestSetupMounts_Strict(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("mounting needs root")
+	}
+	if !mountAPIAvailable() {
+		t.Skip("openat2 and the mount API are unavailable")
+	}
+
+	setups := map[string]func([]spec.Mount, string, cgroupMountOptions, map[int]*os.File) error{
+		"mount api": setupMountsInRoot,
+		"legacy":    setupMountsLegacy,
+	}
+	for name, setup := range setups {
+		t.Run(name, func(t *testing.T) {
+			rootfs := t.TempDir()
+			missing := filepath.Join(t.TempDir(), "missing")
+			tests := []struct {
+				name  string
+				mount spec.Mount
+			}{
+				{"missing bind source", spec.Mount{Destination: "/data", Type: "bind", Source: missing, Options: []string{"rbind"}}},
+				{"bad filesystem", spec.Mount{Destination: "/bad", Type: "nosuchfs", Source: "none"}},
+				{"bad option", spec.Mount{Destination: "/tmp", Type: "tmpfs", Source: "tmpfs", Options: []string{"nosuchoption=1"}}},
+			}
+			for _, tt := range tests {
+				var strictErr, optionalErr error
+				optional := tt.mount
+				optional.Options = append(append([]string(nil), tt.mount.Options...), MountOptionalOption)
+				inMountNamespace(t, func() {
+					strictErr = setup([]spec.Mount{tt.mount}, rootfs, cgroupMountOptions{}, nil)
+					optionalErr = setup([]spec.Mount{optional}, rootfs, cgroupMountOptions{}, nil)
+				})
+				if !cerrors.IsKind(strictErr, cerrors.ErrRootfs) || !strings.Contains(strictErr.Error(), tt.mount.Destination) {
+					t.Errorf("%s: error = %v, want a rootfs error naming %s", tt.name, strictErr, tt.mount.Destination)
+				}
+				if optionalErr != nil {
+					t.Errorf("%s: optional mount error = %v, want nil", tt.name, optionalErr)
+				}
+			}
+		})
+	}
+}
+
 func TestMaskedPaths(t *testing.T) {
 	// Default masked paths should include security-sensitive locations
 	expectedMasked := []string{
@@ -0,0 +1,154 @@
+// Package linux provides rootless detection, ID mapping helpers and
+// unprivileged cgroup placement.
+package linux
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+
+	"runc-go/spec"
+)
+
+// IsRootless reports whether the runtime lacks real root: it runs as a
+// non-root user, or as root in a user namespace that does not map the
+// whole host ID range.
+func IsRootless() bool {
+	if os.Geteuid() != 0 {
+		return true
+	}
+	data, err := os.ReadFile("/proc/self/uid_map")
+	if err != nil {
+		return false
+	}
+	return !isInitialIDMap(string(data))
+}
+
+// isInitialIDMap reports whether a uid_map is the initial user namespace's
+// identity mapping.
+func isInitialIDMap(data string) bool {
+	fields := strings.Fields(data)
+	return len(fields) == 3 && fields[0] == "0" && fields[1] == "0" && fields[2] == "4294967295"
+}
+
+// NeedIDMapHelpers reports whether the container's ID mappings must be
+// written by newuidmap and newgidmap. An unprivileged process may only map
+// its own uid and gid, so any other mapping, such as subordinate ranges
+// from /etc/subuid, needs the setuid helpers.
+func NeedIDMapHelpers(l *spec.Linux) bool {
+	if l == nil || !HasNamespace(l.Namespaces, spec.UserNamespace) || os.Geteuid() == 0 {
+		return false
+	}
+	return !isSelfMapping(l.UIDMappings, os.Geteuid()) || !isSelfMapping(l.GIDMappings, os.Getegid())
+}
+
+// isSelfMapping reports whether mappings map exactly one ID, the caller's.
+func isSelfMapping(mappings []spec.LinuxIDMapping, id int) bool {
+	return len(mappings) == 1 && mappings[0].Size == 1 && int(mappings[0].HostID) == id
+}
+
+// MapIDs writes the ID mappings of pid's user namespace with newuidmap and
+// newgidmap, which check them against /etc/subuid and /etc/subgid. Without
+// the helpers the maps are written directly, which needs CAP_SETUID and
+// CAP_SETGID over the host IDs.
+func MapIDs(pid int, uidMappings, gidMappings []spec.LinuxIDMapping) error {
+	uidHelper, uidErr := exec.LookPath("newuidmap")
+	gidHelper, gidErr := exec.LookPath("newgidmap")
+	if uidErr != nil || gidErr != nil {
+		if err := WriteIDMappings(pid, uidMappings, gidMappings); err != nil {
+			return fmt.Errorf("%w (install newuidmap and newgidmap to map subordinate IDs)", err)
+		}
+		return nil
+	}
+	if err := runIDMapHelper(uidHelper, pid, uidMappings); err != nil {
+		return err
+	}
+	return runIDMapHelper(gidHelper, pid, gidMappings)
+}
+
+// runIDMapHelper runs newuidmap or newgidmap for pid.
+func runIDMapHelper(helper string, pid int, mappings []spec.LinuxIDMapping) error {
+	if len(mappings) == 0 {
+		return nil
+	}
+	out, err := exec.Command(helper, idMapHelperArgs(pid, mappings)...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w: %s", filepath.Base(helper), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// idMapHelperArgs formats the arguments of newuidmap and newgidmap:
+// the pid followed by a "container-id host-id size" triple per mapping.
+func idMapHelperArgs(pid int, mappings []spec.LinuxIDMapping) []string {
+	args := []string{strconv.Itoa(pid)}
+	for _, m := range mappings {
+		args = append(args,
+			strconv.FormatUint(uint64(m.ContainerID), 10),
+			strconv.FormatUint(uint64(m.HostID), 10),
+			strconv.FormatUint(uint64(m.Size), 10))
+	}
+	return args
+}
+
+// RootlessCgroupPath returns a cgroup path for an unprivileged container
+// below the user's systemd manager, user@UID.service, which systemd
+// delegates to the user on cgroup v2. It fails when there is no such
+// subtree or it is not writable.
+func RootlessCgroupPath(containerID string) (string, error) {
+	root, err := UserManagerCgroup()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, "runc-go", containerID), nil
+}
+
+// UserManagerCgroup returns the cgroup of the calling user's systemd
+// manager, relative to the cgroup v2 mount.
+func UserManagerCgroup() (string, error) {
+	h, err := DetectCgroupHierarchy()
+	if err != nil {
+		return "", err
+	}
+	if h.Mode != CgroupModeUnified {
+		return "", fmt.Errorf("rootless cgroups need a cgroup v2 hierarchy")
+	}
+
+	f, err := os.Open("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	own, err := parseProcCgroup(f)
+	f.Close()
+	if err != nil {
+		return "", err
+	}
+
+	uid := os.Geteuid()
+	root, ok := findUserManager(own[""], uid)
+	if !ok {
+		return "", fmt.Errorf("cgroup %s is not below user@%d.service", own[""], uid)
+	}
+	if err := unix.Access(filepath.Join(h.Unified, root), unix.W_OK); err != nil {
+		return "", fmt.Errorf("cgroup %s is not delegated: %w", root, err)
+	}
+	return root, nil
+}
+
+// findUserManager returns the prefix of a cgroup path up to the
+// user@UID.service unit.
+func findUserManager(cgroupPath string, uid int) (string, bool) {
+	unit := fmt.Sprintf("user@%d.service", uid)
+	parts := strings.Split(cgroupPath, "/")
+	for i, part := range parts {
+		if part == unit {
+			return strings.Join(parts[:i+1], "/"), true
+		}
+	}
+	return "", false
+}
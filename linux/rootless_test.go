@@ -0,0 +1,89 @@
+package linux
+
+import (
+	"reflect"
+	"testing"
+
+	"runc-go/spec"
+)
+
+func TestIsInitialIDMap(t *testing.T) {
+	tests := []struct {
+		data string
+		want bool
+	}{
+		{"         0          0 4294967295\n", true},
+		{"         0       1000          1\n", false},
+		{"         0     100000      65536\n", false},
+		{"0 0 4294967295\n1 1 1\n", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := isInitialIDMap(tt.data); got != tt.want {
+			t.Errorf("isInitialIDMap(%q) = %v, want %v", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestIsSelfMapping(t *testing.T) {
+	self := []spec.LinuxIDMapping{{ContainerID: 0, HostID: 1000, Size: 1}}
+	if !isSelfMapping(self, 1000) {
+		t.Error("single mapping of own ID should not need helpers")
+	}
+	if isSelfMapping(self, 1001) {
+		t.Error("mapping another ID needs helpers")
+	}
+	multi := append(self, spec.LinuxIDMapping{ContainerID: 1, HostID: 100000, Size: 65536})
+	if isSelfMapping(multi, 1000) {
+		t.Error("subordinate ranges need helpers")
+	}
+	if isSelfMapping(nil, 1000) {
+		t.Error("empty mappings are not a self mapping")
+	}
+}
+
+func TestNeedIDMapHelpers_NoUserNamespace(t *testing.T) {
+	if NeedIDMapHelpers(nil) {
+		t.Error("NeedIDMapHelpers(nil) = true")
+	}
+	l := &spec.Linux{
+		UIDMappings: []spec.LinuxIDMapping{{ContainerID: 0, HostID: 100000, Size: 65536}},
+	}
+	if NeedIDMapHelpers(l) {
+		t.Error("mappings without a user namespace need no helpers")
+	}
+}
+
+func TestIDMapHelperArgs(t *testing.T) {
+	args := idMapHelperArgs(42, []spec.LinuxIDMapping{
+		{ContainerID: 0, HostID: 1000, Size: 1},
+		{ContainerID: 1, HostID: 100000, Size: 65536},
+	})
+	want := []string{"42", "0", "1000", "1", "1", "100000", "65536"}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("args = %v, want %v", args, want)
+	}
+}
+
+func TestFindUserManager(t *testing.T) {
+	tests := []struct {
+		path   string
+		uid    int
+		want   string
+		wantOK bool
+	}{
+		{"/user.slice/user-1000.slice/user@1000.service/app.slice/foo.scope", 1000,
+			"/user.slice/user-1000.slice/user@1000.service", true},
+		{"/user.slice/user-1000.slice/user@1000.service", 1000,
+			"/user.slice/user-1000.slice/user@1000.service", true},
+		{"/user.slice/user-1000.slice/session-3.scope", 1000, "", false},
+		{"/user.slice/user-1001.slice/user@1001.service/app.slice", 1000, "", false},
+		{"/", 1000, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := findUserManager(tt.path, tt.uid)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("findUserManager(%q, %d) = %q, %v, want %q, %v", tt.path, tt.uid, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
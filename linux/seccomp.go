@@ -3,6 +3,7 @@ package linux
 
 import (
 	"fmt"
+	"sort"
 	"syscall"
 	"unsafe"
 
@@ -16,31 +17,71 @@ const (
 	SECCOMP_RET_KILL_THREAD  = 0x00000000
 	SECCOMP_RET_TRAP         = 0x00030000
 	SECCOMP_RET_ERRNO        = 0x00050000
+	SECCOMP_RET_USER_NOTIF   = 0x7fc00000
 	SECCOMP_RET_TRACE        = 0x7ff00000
 	SECCOMP_RET_LOG          = 0x7ffc0000
 	SECCOMP_RET_ALLOW        = 0x7fff0000
 
+	SECCOMP_RET_ACTION_FULL = 0xffff0000
+	SECCOMP_RET_DATA        = 0x0000ffff
+
 	PR_SET_NO_NEW_PRIVS = 38
 	PR_SET_SECCOMP      = 22
 )
 
 // BPF constants
 const (
-	BPF_LD  = 0x00
-	BPF_JMP = 0x05
-	BPF_RET = 0x06
-	BPF_W   = 0x00
-	BPF_ABS = 0x20
-	BPF_JEQ = 0x10
-	BPF_JGE = 0x30
-	BPF_JGT = 0x20
-	BPF_K   = 0x00
+	BPF_LD   = 0x00
+	BPF_LDX  = 0x01
+	BPF_ST   = 0x02
+	BPF_STX  = 0x03
+	BPF_ALU  = 0x04
+	BPF_JMP  = 0x05
+	BPF_RET  = 0x06
+	BPF_MISC = 0x07
+	BPF_W    = 0x00
+	BPF_H    = 0x08
+	BPF_B    = 0x10
+	BPF_IMM  = 0x00
+	BPF_ABS  = 0x20
+	BPF_IND  = 0x40
+	BPF_MEM  = 0x60
+	BPF_LEN  = 0x80
+	BPF_ADD  = 0x00
+	BPF_SUB  = 0x10
+	BPF_MUL  = 0x20
+	BPF_DIV  = 0x30
+	BPF_OR   = 0x40
+	BPF_AND  = 0x50
+	BPF_LSH  = 0x60
+	BPF_RSH  = 0x70
+	BPF_NEG  = 0x80
+	BPF_MOD  = 0x90
+	BPF_XOR  = 0xa0
+	BPF_JA   = 0x00
+	BPF_JEQ  = 0x10
+	BPF_JGT  = 0x20
+	BPF_JGE  = 0x30
+	BPF_JSET = 0x40
+	BPF_K    = 0x00
+	BPF_X    = 0x08
+	BPF_A    = 0x10
+	BPF_TAX  = 0x00
+	BPF_TXA  = 0x80
+
+	// bpfMemWords is the size of the classic BPF scratch memory.
+	bpfMemWords = 16
 )
 
 // Seccomp data offsets
 const (
 	offsetNR   = 0
 	offsetArch = 4
+	offsetIP   = 8
+	offsetArgs = 16
+
+	// seccompDataSize is sizeof(struct seccomp_data).
+	seccompDataSize = 64
 )
 
 // Architecture audit values
@@ -49,6 +90,9 @@ const (
 	AUDIT_ARCH_I386    = 0x40000003
 	AUDIT_ARCH_AARCH64 = 0xc00000b7
 	AUDIT_ARCH_ARM     = 0x40000028
+
+	// X32_SYSCALL_BIT is set in the syscall number of x32 ABI calls.
+	X32_SYSCALL_BIT = 0x40000000
 )
 
 // sockFprog is the BPF program structure.
@@ -85,99 +129,133 @@ var archToAudit = map[spec.Arch]uint32{
 	spec.ArchARM:     AUDIT_ARCH_ARM,
 }
 
+// syscallTables holds the syscall table of each architecture a filter can
+// be compiled for. Syscall numbers differ between architectures, so rules
+// are compiled once per architecture with that architecture's numbers.
+var syscallTables = map[spec.Arch]map[string]int{
+	spec.ArchX86_64:  syscallMap,
+	spec.ArchX86:     syscallMapX86,
+	spec.ArchAARCH64: syscallMapAARCH64,
+}
+
 // syscallMap maps syscall names to numbers (x86_64).
-// This is a subset - full implementation would use a complete table.
+// It covers the full table from asm/unistd_64.h up to Linux 6.17, whose
+// last entry is file_setattr.
 var syscallMap = map[string]int{
-	"read": 0, "write": 1, "open": 2, "close": 3, "stat": 4,
-	"fstat": 5, "lstat": 6, "poll": 7, "lseek": 8, "mmap": 9,
-	"mprotect": 10, "munmap": 11, "brk": 12, "ioctl": 16,
-	"access": 21, "pipe": 22, "select": 23, "sched_yield": 24,
-	"mremap": 25, "msync": 26, "mincore": 27, "madvise": 28,
-	"dup": 32, "dup2": 33, "pause": 34, "nanosleep": 35,
-	"getpid": 39, "socket": 41, "connect": 42, "accept": 43,
-	"sendto": 44, "recvfrom": 45, "sendmsg": 46, "recvmsg": 47,
-	"shutdown": 48, "bind": 49, "listen": 50, "getsockname": 51,
-	"getpeername": 52, "socketpair": 53, "setsockopt": 54,
-	"getsockopt": 55, "clone": 56, "fork": 57, "vfork": 58,
-	"execve": 59, "exit": 60, "wait4": 61, "kill": 62,
-	"uname": 63, "fcntl": 72, "flock": 73, "fsync": 74,
-	"fdatasync": 75, "truncate": 76, "ftruncate": 77,
-	"getdents": 78, "getcwd": 79, "chdir": 80, "fchdir": 81,
-	"rename": 82, "mkdir": 83, "rmdir": 84, "creat": 85,
-	"link": 86, "unlink": 87, "symlink": 88, "readlink": 89,
-	"chmod": 90, "fchmod": 91, "chown": 92, "fchown": 93,
-	"lchown": 94, "umask": 95, "gettimeofday": 96, "getrlimit": 97,
-	"getrusage": 98, "sysinfo": 99, "times": 100,
+	"read": 0, "write": 1, "open": 2, "close": 3, "stat": 4, "fstat": 5,
+	"lstat": 6, "poll": 7, "lseek": 8, "mmap": 9, "mprotect": 10,
+	"munmap": 11, "brk": 12, "rt_sigaction": 13, "rt_sigprocmask": 14,
+	"rt_sigreturn": 15, "ioctl": 16, "pread64": 17, "pwrite64": 18,
+	"readv": 19, "writev": 20, "access": 21, "pipe": 22, "select": 23,
+	"sched_yield": 24, "mremap": 25, "msync": 26, "mincore": 27,
+	"madvise": 28, "shmget": 29, "shmat": 30, "shmctl": 31, "dup": 32,
+	"dup2": 33, "pause": 34, "nanosleep": 35, "getitimer": 36,
+	"alarm": 37, "setitimer": 38, "getpid": 39, "sendfile": 40,
+	"socket": 41, "connect": 42, "accept": 43, "sendto": 44,
+	"recvfrom": 45, "sendmsg": 46, "recvmsg": 47, "shutdown": 48,
+	"bind": 49, "listen": 50, "getsockname": 51, "getpeername": 52,
+	"socketpair": 53, "setsockopt": 54, "getsockopt": 55, "clone": 56,
+	"fork": 57, "vfork": 58, "execve": 59, "exit": 60, "wait4": 61,
+	"kill": 62, "uname": 63, "semget": 64, "semop": 65, "semctl": 66,
+	"shmdt": 67, "msgget": 68, "msgsnd": 69, "msgrcv": 70, "msgctl": 71,
+	"fcntl": 72, "flock": 73, "fsync": 74, "fdatasync": 75,
+	"truncate": 76, "ftruncate": 77, "getdents": 78, "getcwd": 79,
+	"chdir": 80, "fchdir": 81, "rename": 82, "mkdir": 83, "rmdir": 84,
+	"creat": 85, "link": 86, "unlink": 87, "symlink": 88,
+	"readlink": 89, "chmod": 90, "fchmod": 91, "chown": 92,
+	"fchown": 93, "lchown": 94, "umask": 95, "gettimeofday": 96,
+	"getrlimit": 97, "getrusage": 98, "sysinfo": 99, "times": 100,
 	"ptrace": 101, "getuid": 102, "syslog": 103, "getgid": 104,
 	"setuid": 105, "setgid": 106, "geteuid": 107, "getegid": 108,
 	"setpgid": 109, "getppid": 110, "getpgrp": 111, "setsid": 112,
-	"setreuid": 113, "setregid": 114, "getgroups": 115, "setgroups": 116,
-	"setresuid": 117, "getresuid": 118, "setresgid": 119, "getresgid": 120,
-	"getpgid": 121, "setfsuid": 122, "setfsgid": 123, "getsid": 124,
-	"capget": 125, "capset": 126, "rt_sigpending": 127,
-	"rt_sigtimedwait": 128, "rt_sigqueueinfo": 129, "rt_sigsuspend": 130,
-	"sigaltstack": 131, "utime": 132, "mknod": 133,
-	"personality": 135, "ustat": 136, "statfs": 137, "fstatfs": 138,
-	"sysfs": 139, "getpriority": 140, "setpriority": 141,
-	"sched_setparam": 142, "sched_getparam": 143,
-	"sched_setscheduler": 144, "sched_getscheduler": 145,
-	"sched_get_priority_max": 146, "sched_get_priority_min": 147,
-	"sched_rr_get_interval": 148, "mlock": 149, "munlock": 150,
-	"mlockall": 151, "munlockall": 152, "vhangup": 153,
-	"modify_ldt": 154, "pivot_root": 155, "_sysctl": 156,
-	"prctl": 157, "arch_prctl": 158, "adjtimex": 159,
+	"setreuid": 113, "setregid": 114, "getgroups": 115,
+	"setgroups": 116, "setresuid": 117, "getresuid": 118,
+	"setresgid": 119, "getresgid": 120, "getpgid": 121, "setfsuid": 122,
+	"setfsgid": 123, "getsid": 124, "capget": 125, "capset": 126,
+	"rt_sigpending": 127, "rt_sigtimedwait": 128,
+	"rt_sigqueueinfo": 129, "rt_sigsuspend": 130, "sigaltstack": 131,
+	"utime": 132, "mknod": 133, "uselib": 134, "personality": 135,
+	"ustat": 136, "statfs": 137, "fstatfs": 138, "sysfs": 139,
+	"getpriority": 140, "setpriority": 141, "sched_setparam": 142,
+	"sched_getparam": 143, "sched_setscheduler": 144,
+	"sched_getscheduler": 145, "sched_get_priority_max": 146,
+	"sched_get_priority_min": 147, "sched_rr_get_interval": 148,
+	"mlock": 149, "munlock": 150, "mlockall": 151, "munlockall": 152,
+	"vhangup": 153, "modify_ldt": 154, "pivot_root": 155,
+	"_sysctl": 156, "prctl": 157, "arch_prctl": 158, "adjtimex": 159,
 	"setrlimit": 160, "chroot": 161, "sync": 162, "acct": 163,
-	"settimeofday": 164, "mount": 165, "umount2": 166,
-	"swapon": 167, "swapoff": 168, "reboot": 169,
-	"sethostname": 170, "setdomainname": 171, "iopl": 172, "ioperm": 173,
-	"init_module": 175, "delete_module": 176,
-	"quotactl": 179, "nfsservctl": 180,
-	"gettid": 186, "readahead": 187, "setxattr": 188,
-	"getxattr": 191, "listxattr": 194, "removexattr": 197,
-	"tkill": 200, "time": 201, "futex": 202,
+	"settimeofday": 164, "mount": 165, "umount2": 166, "swapon": 167,
+	"swapoff": 168, "reboot": 169, "sethostname": 170,
+	"setdomainname": 171, "iopl": 172, "ioperm": 173,
+	"create_module": 174, "init_module": 175, "delete_module": 176,
+	"get_kernel_syms": 177, "query_module": 178, "quotactl": 179,
+	"nfsservctl": 180, "getpmsg": 181, "putpmsg": 182,
+	"afs_syscall": 183, "tuxcall": 184, "security": 185, "gettid": 186,
+	"readahead": 187, "setxattr": 188, "lsetxattr": 189,
+	"fsetxattr": 190, "getxattr": 191, "lgetxattr": 192,
+	"fgetxattr": 193, "listxattr": 194, "llistxattr": 195,
+	"flistxattr": 196, "removexattr": 197, "lremovexattr": 198,
+	"fremovexattr": 199, "tkill": 200, "time": 201, "futex": 202,
 	"sched_setaffinity": 203, "sched_getaffinity": 204,
-	"io_setup": 206, "io_destroy": 207, "io_getevents": 208,
-	"io_submit": 209, "io_cancel": 210, "lookup_dcookie": 212,
-	"epoll_create": 213, "remap_file_pages": 216,
-	"getdents64": 217, "set_tid_address": 218, "restart_syscall": 219,
-	"semtimedop": 220, "fadvise64": 221, "timer_create": 222,
-	"timer_settime": 223, "timer_gettime": 224, "timer_getoverrun": 225,
-	"timer_delete": 226, "clock_settime": 227, "clock_gettime": 228,
-	"clock_getres": 229, "clock_nanosleep": 230, "exit_group": 231,
-	"epoll_wait": 232, "epoll_ctl": 233, "tgkill": 234,
-	"utimes": 235, "mbind": 237, "set_mempolicy": 238,
-	"get_mempolicy": 239, "mq_open": 240, "mq_unlink": 241,
-	"mq_timedsend": 242, "mq_timedreceive": 243, "mq_notify": 244,
-	"mq_getsetattr": 245, "kexec_load": 246, "waitid": 247,
-	"add_key": 248, "request_key": 249, "keyctl": 250,
+	"set_thread_area": 205, "io_setup": 206, "io_destroy": 207,
+	"io_getevents": 208, "io_submit": 209, "io_cancel": 210,
+	"get_thread_area": 211, "lookup_dcookie": 212, "epoll_create": 213,
+	"epoll_ctl_old": 214, "epoll_wait_old": 215,
+	"remap_file_pages": 216, "getdents64": 217, "set_tid_address": 218,
+	"restart_syscall": 219, "semtimedop": 220, "fadvise64": 221,
+	"timer_create": 222, "timer_settime": 223, "timer_gettime": 224,
+	"timer_getoverrun": 225, "timer_delete": 226, "clock_settime": 227,
+	"clock_gettime": 228, "clock_getres": 229, "clock_nanosleep": 230,
+	"exit_group": 231, "epoll_wait": 232, "epoll_ctl": 233,
+	"tgkill": 234, "utimes": 235, "vserver": 236, "mbind": 237,
+	"set_mempolicy": 238, "get_mempolicy": 239, "mq_open": 240,
+	"mq_unlink": 241, "mq_timedsend": 242, "mq_timedreceive": 243,
+	"mq_notify": 244, "mq_getsetattr": 245, "kexec_load": 246,
+	"waitid": 247, "add_key": 248, "request_key": 249, "keyctl": 250,
 	"ioprio_set": 251, "ioprio_get": 252, "inotify_init": 253,
 	"inotify_add_watch": 254, "inotify_rm_watch": 255,
-	"migrate_pages": 256, "openat": 257, "mkdirat": 258,
-	"mknodat": 259, "fchownat": 260, "futimesat": 261,
-	"newfstatat": 262, "unlinkat": 263, "renameat": 264,
-	"linkat": 265, "symlinkat": 266, "readlinkat": 267,
-	"fchmodat": 268, "faccessat": 269, "pselect6": 270,
-	"ppoll": 271, "unshare": 272, "set_robust_list": 273,
-	"get_robust_list": 274, "splice": 275, "tee": 276,
-	"sync_file_range": 277, "vmsplice": 278, "move_pages": 279,
-	"utimensat": 280, "epoll_pwait": 281, "signalfd": 282,
-	"timerfd_create": 283, "eventfd": 284, "fallocate": 285,
-	"timerfd_settime": 286, "timerfd_gettime": 287, "accept4": 288,
-	"signalfd4": 289, "eventfd2": 290, "epoll_create1": 291,
-	"dup3": 292, "pipe2": 293, "inotify_init1": 294,
-	"preadv": 295, "pwritev": 296, "rt_tgsigqueueinfo": 297,
-	"perf_event_open": 298, "recvmmsg": 299, "fanotify_init": 300,
-	"fanotify_mark": 301, "prlimit64": 302, "name_to_handle_at": 303,
-	"open_by_handle_at": 304, "clock_adjtime": 305, "syncfs": 306,
-	"sendmmsg": 307, "setns": 308, "getcpu": 309, "process_vm_readv": 310,
-	"process_vm_writev": 311, "kcmp": 312, "finit_module": 313,
-	"sched_setattr": 314, "sched_getattr": 315, "renameat2": 316,
-	"seccomp": 317, "getrandom": 318, "memfd_create": 319,
-	"kexec_file_load": 320, "bpf": 321, "execveat": 322,
-	"userfaultfd": 323, "membarrier": 324, "mlock2": 325,
-	"copy_file_range": 326, "preadv2": 327, "pwritev2": 328,
-	"pkey_mprotect": 329, "pkey_alloc": 330, "pkey_free": 331,
-	"statx": 332, "io_pgetevents": 333, "rseq": 334,
+	"migrate_pages": 256, "openat": 257, "mkdirat": 258, "mknodat": 259,
+	"fchownat": 260, "futimesat": 261, "newfstatat": 262,
+	"unlinkat": 263, "renameat": 264, "linkat": 265, "symlinkat": 266,
+	"readlinkat": 267, "fchmodat": 268, "faccessat": 269,
+	"pselect6": 270, "ppoll": 271, "unshare": 272,
+	"set_robust_list": 273, "get_robust_list": 274, "splice": 275,
+	"tee": 276, "sync_file_range": 277, "vmsplice": 278,
+	"move_pages": 279, "utimensat": 280, "epoll_pwait": 281,
+	"signalfd": 282, "timerfd_create": 283, "eventfd": 284,
+	"fallocate": 285, "timerfd_settime": 286, "timerfd_gettime": 287,
+	"accept4": 288, "signalfd4": 289, "eventfd2": 290,
+	"epoll_create1": 291, "dup3": 292, "pipe2": 293,
+	"inotify_init1": 294, "preadv": 295, "pwritev": 296,
+	"rt_tgsigqueueinfo": 297, "perf_event_open": 298, "recvmmsg": 299,
+	"fanotify_init": 300, "fanotify_mark": 301, "prlimit64": 302,
+	"name_to_handle_at": 303, "open_by_handle_at": 304,
+	"clock_adjtime": 305, "syncfs": 306, "sendmmsg": 307, "setns": 308,
+	"getcpu": 309, "process_vm_readv": 310, "process_vm_writev": 311,
+	"kcmp": 312, "finit_module": 313, "sched_setattr": 314,
+	"sched_getattr": 315, "renameat2": 316, "seccomp": 317,
+	"getrandom": 318, "memfd_create": 319, "kexec_file_load": 320,
+	"bpf": 321, "execveat": 322, "userfaultfd": 323, "membarrier": 324,
+	"mlock2": 325, "copy_file_range": 326, "preadv2": 327,
+	"pwritev2": 328, "pkey_mprotect": 329, "pkey_alloc": 330,
+	"pkey_free": 331, "statx": 332, "io_pgetevents": 333, "rseq": 334,
+	"uretprobe": 335, "uprobe": 336, "pidfd_send_signal": 424,
+	"io_uring_setup": 425, "io_uring_enter": 426,
+	"io_uring_register": 427, "open_tree": 428, "move_mount": 429,
+	"fsopen": 430, "fsconfig": 431, "fsmount": 432, "fspick": 433,
+	"pidfd_open": 434, "clone3": 435, "close_range": 436,
+	"openat2": 437, "pidfd_getfd": 438, "faccessat2": 439,
+	"process_madvise": 440, "epoll_pwait2": 441, "mount_setattr": 442,
+	"quotactl_fd": 443, "landlock_create_ruleset": 444,
+	"landlock_add_rule": 445, "landlock_restrict_self": 446,
+	"memfd_secret": 447, "process_mrelease": 448, "futex_waitv": 449,
+	"set_mempolicy_home_node": 450, "cachestat": 451, "fchmodat2": 452,
+	"map_shadow_stack": 453, "futex_wake": 454, "futex_wait": 455,
+	"futex_requeue": 456, "statmount": 457, "listmount": 458,
+	"lsm_get_self_attr": 459, "lsm_set_self_attr": 460,
+	"lsm_list_modules": 461, "mseal": 462, "setxattrat": 463,
+	"getxattrat": 464, "listxattrat": 465, "removexattrat": 466,
+	"open_tree_attr": 467, "file_getattr": 468, "file_setattr": 469,
 }
 
 // SetupSeccomp installs a seccomp filter based on OCI configuration.
@@ -189,10 +267,11 @@ func SetupSeccomp(config *spec.LinuxSeccomp) error {
 	// Count how many syscalls we recognize vs don't recognize
 	// If too many are unrecognized, skip our filter - let Docker/containerd's
 	// native seccomp handle it instead (they use libseccomp which is complete)
+	arches := filterArches(config)
 	var recognized, unrecognized int
 	for _, rule := range config.Syscalls {
 		for _, name := range rule.Names {
-			if _, ok := syscallMap[name]; ok {
+			if syscallKnown(arches, name) {
 				recognized++
 			} else {
 				unrecognized++
@@ -250,42 +329,63 @@ func buildSeccompFilter(config *spec.LinuxSeccomp) ([]sockFilter, error) {
 	if !ok {
 		return nil, fmt.Errorf("unknown default action: %s", config.DefaultAction)
 	}
+	if config.DefaultAction == spec.ActErrno && config.DefaultErrnoRet != nil {
+		defaultRet = SECCOMP_RET_ERRNO | uint32(*config.DefaultErrnoRet)
+	}
 
-	// Step 1: Load and check architecture
-	filter = append(filter, bpfStmt(BPF_LD|BPF_W|BPF_ABS, offsetArch))
-
-	// Allow only specified architectures (default to native)
-	arches := config.Architectures
+	arches := filterArches(config)
 	if len(arches) == 0 {
-		arches = []spec.Arch{spec.ArchX86_64}
+		return nil, fmt.Errorf("no architecture with a syscall table in %v", config.Architectures)
 	}
 
-	// Build list of valid architectures with their audit values
-	// This ensures correct jump calculations (unknown arches are filtered out first)
-	type archEntry struct {
-		auditArch uint32
-	}
-	var validArches []archEntry
+	// Each architecture gets a section of its own, compiled with its own
+	// syscall numbers:
+	//
+	//	ld arch
+	//	jeq <arch>, 1, 0
+	//	ja <past the section>
+	//	<section, ending in ret default>
+	//	...
+	//	ret kill_process
+	//
+	// Calls from any other architecture, including those in the config
+	// without a syscall table, are killed.
+	filter = append(filter, bpfStmt(BPF_LD|BPF_W|BPF_ABS, offsetArch))
 	for _, arch := range arches {
-		if auditArch, ok := archToAudit[arch]; ok {
-			validArches = append(validArches, archEntry{auditArch})
+		section, err := buildArchSection(config, arch, defaultRet)
+		if err != nil {
+			return nil, err
 		}
+		filter = append(filter, bpfJump(BPF_JMP|BPF_JEQ|BPF_K, archToAudit[arch], 1, 0))
+		filter = append(filter, bpfStmt(BPF_JMP|BPF_JA, uint32(len(section))))
+		filter = append(filter, section...)
 	}
+	filter = append(filter, bpfStmt(BPF_RET|BPF_K, SECCOMP_RET_KILL_PROCESS))
 
-	// Jump over kill if arch matches any allowed
-	for i, entry := range validArches {
-		// Jump past remaining arch checks + kill instruction if match
-		// If this is the last arch check, jt=1 jumps over the kill instruction
-		jt := uint8(len(validArches) - i)
-		filter = append(filter, bpfJump(BPF_JMP|BPF_JEQ|BPF_K, entry.auditArch, jt, 0))
+	if len(filter) > bpfMaxInsns {
+		return nil, fmt.Errorf("filter too long: %d instructions for %d architectures (max %d)", len(filter), len(arches), bpfMaxInsns)
 	}
-	// Kill if no arch matched
-	filter = append(filter, bpfStmt(BPF_RET|BPF_K, SECCOMP_RET_KILL_PROCESS))
+	return filter, nil
+}
 
-	// Step 2: Load syscall number
-	filter = append(filter, bpfStmt(BPF_LD|BPF_W|BPF_ABS, offsetNR))
+// buildArchSection compiles the rules of config for calls from arch, using
+// arch's syscall numbers. The section ends in the default action.
+func buildArchSection(config *spec.LinuxSeccomp, arch spec.Arch, defaultRet uint32) ([]sockFilter, error) {
+	table := syscallTables[arch]
+
+	// Load syscall number
+	section := []sockFilter{bpfStmt(BPF_LD|BPF_W|BPF_ABS, offsetNR)}
+
+	// x32 syscalls pass the x86_64 arch check with X32_SYSCALL_BIT set in
+	// the number. There is no x32 syscall table, so they are killed like
+	// those of any other foreign architecture rather than slip past the
+	// rules.
+	if arch == spec.ArchX86_64 {
+		section = append(section, bpfJump(BPF_JMP|BPF_JGE|BPF_K, X32_SYSCALL_BIT, 0, 1))
+		section = append(section, bpfStmt(BPF_RET|BPF_K, SECCOMP_RET_KILL_PROCESS))
+	}
 
-	// Step 3: Add rules for each syscall
+	// Add rules for each syscall
 	for _, rule := range config.Syscalls {
 		action, ok := actionToRet[rule.Action]
 		if !ok {
@@ -298,27 +398,232 @@ func buildSeccompFilter(config *spec.LinuxSeccomp) ([]sockFilter, error) {
 		}
 
 		for _, name := range rule.Names {
-			nr, ok := syscallMap[name]
+			nr, ok := table[name]
 			if !ok {
-				// Unknown syscall, skip
+				// Unknown syscall on this architecture, skip
 				continue
 			}
 
 			// Simple rule: if syscall matches, return action
-			// TODO: Implement argument checking for rule.Args
 			if len(rule.Args) == 0 {
-				// Jump to action return, else continue
-				// We append the return at the end
-				filter = append(filter, bpfJump(BPF_JMP|BPF_JEQ|BPF_K, uint32(nr), 0, 1))
-				filter = append(filter, bpfStmt(BPF_RET|BPF_K, action))
+				section = append(section, bpfJump(BPF_JMP|BPF_JEQ|BPF_K, uint32(nr), 0, 1))
+				section = append(section, bpfStmt(BPF_RET|BPF_K, action))
+				continue
+			}
+
+			for _, args := range argGroups(rule.Args) {
+				block, err := buildArgRule(uint32(nr), args, action)
+				if err != nil {
+					return nil, fmt.Errorf("syscall %s: %w", name, err)
+				}
+				section = append(section, block...)
 			}
 		}
 	}
 
-	// Step 4: Default action
-	filter = append(filter, bpfStmt(BPF_RET|BPF_K, defaultRet))
+	// Default action
+	section = append(section, bpfStmt(BPF_RET|BPF_K, defaultRet))
 
-	return filter, nil
+	return section, nil
+}
+
+// filterArches returns the architectures of config a filter is compiled
+// for: those with a syscall table, or x86_64 when none are listed.
+func filterArches(config *spec.LinuxSeccomp) []spec.Arch {
+	if len(config.Architectures) == 0 {
+		return []spec.Arch{spec.ArchX86_64}
+	}
+	var arches []spec.Arch
+	for _, arch := range config.Architectures {
+		if _, ok := syscallTables[arch]; ok && !hasArch(arches, arch) {
+			arches = append(arches, arch)
+		}
+	}
+	return arches
+}
+
+// syscallKnown reports whether name is in the syscall table of any of
+// arches.
+func syscallKnown(arches []spec.Arch, name string) bool {
+	for _, arch := range arches {
+		if _, ok := syscallTables[arch][name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// hasArch checks if an architecture is in the list.
+func hasArch(arches []spec.Arch, arch spec.Arch) bool {
+	for _, a := range arches {
+		if a == arch {
+			return true
+		}
+	}
+	return false
+}
+
+// argGroups splits a rule's argument conditions into groups that are each
+// compiled as a separate rule. Conditions are ANDed, except that when an
+// argument index appears more than once every condition stands alone (ORed),
+// matching how runc hands such rules to libseccomp.
+func argGroups(args []spec.LinuxSeccompArg) [][]spec.LinuxSeccompArg {
+	seen := make(map[uint]bool)
+	for _, arg := range args {
+		if seen[arg.Index] {
+			groups := make([][]spec.LinuxSeccompArg, len(args))
+			for i := range args {
+				groups[i] = args[i : i+1]
+			}
+			return groups
+		}
+		seen[arg.Index] = true
+	}
+	return [][]spec.LinuxSeccompArg{args}
+}
+
+// buildArgRule compiles a syscall rule with argument conditions:
+//
+//	jeq nr, 0, fail
+//	<check per condition, jumping to fail on mismatch>
+//	ret action
+//	fail: ld nr
+//
+// The argument loads clobber the accumulator, so the block ends by
+// reloading the syscall number for the rules that follow.
+func buildArgRule(nr uint32, args []spec.LinuxSeccompArg, action uint32) ([]sockFilter, error) {
+	checks := make([][]sockFilter, len(args))
+
+	// Build back to front so each check knows how far away the failure label is
+	skip := 1 // the return instruction
+	for i := len(args) - 1; i >= 0; i-- {
+		check, err := buildArgCheck(args[i], skip)
+		if err != nil {
+			return nil, err
+		}
+		checks[i] = check
+		skip += len(check)
+	}
+	if skip > 255 {
+		return nil, fmt.Errorf("too many argument conditions")
+	}
+
+	block := []sockFilter{bpfJump(BPF_JMP|BPF_JEQ|BPF_K, nr, 0, uint8(skip))}
+	for _, check := range checks {
+		block = append(block, check...)
+	}
+	block = append(block, bpfStmt(BPF_RET|BPF_K, action))
+	block = append(block, bpfStmt(BPF_LD|BPF_W|BPF_ABS, offsetNR))
+	return block, nil
+}
+
+// jumpTarget is a symbolic branch target inside an argument check.
+type jumpTarget int
+
+const (
+	jumpNext jumpTarget = iota // the following instruction
+	jumpPass                   // end of the check: the condition holds
+	jumpFail                   // the rule's failure label
+)
+
+// argInsn is an argument check instruction with unresolved branch targets.
+type argInsn struct {
+	ins    sockFilter
+	jt, jf jumpTarget
+}
+
+// buildArgCheck compiles a single argument condition. Arguments are 64-bit
+// but classic BPF is 32-bit, so the high word is compared first and the low
+// word only decides when the high words are equal. Control falls through
+// when the condition holds and jumps failSkip instructions past the end of
+// the check otherwise.
+func buildArgCheck(arg spec.LinuxSeccompArg, failSkip int) ([]sockFilter, error) {
+	if arg.Index > 5 {
+		return nil, fmt.Errorf("argument index %d out of range", arg.Index)
+	}
+
+	// seccomp_data.args is little-endian on every supported architecture
+	lo := uint32(offsetArgs + 8*arg.Index)
+	hi := lo + 4
+	vlo, vhi := uint32(arg.Value), uint32(arg.Value>>32)
+
+	ld := func(off uint32) argInsn {
+		return argInsn{ins: bpfStmt(BPF_LD|BPF_W|BPF_ABS, off)}
+	}
+	jmp := func(op uint16, k uint32, jt, jf jumpTarget) argInsn {
+		return argInsn{ins: bpfJump(BPF_JMP|op|BPF_K, k, 0, 0), jt: jt, jf: jf}
+	}
+
+	var prog []argInsn
+	switch arg.Op {
+	case spec.OpEqualTo:
+		prog = []argInsn{
+			ld(hi), jmp(BPF_JEQ, vhi, jumpNext, jumpFail),
+			ld(lo), jmp(BPF_JEQ, vlo, jumpPass, jumpFail),
+		}
+	case spec.OpNotEqual:
+		prog = []argInsn{
+			ld(hi), jmp(BPF_JEQ, vhi, jumpNext, jumpPass),
+			ld(lo), jmp(BPF_JEQ, vlo, jumpFail, jumpPass),
+		}
+	case spec.OpMaskedEqual:
+		and := func(k uint32) argInsn {
+			return argInsn{ins: bpfStmt(BPF_ALU|BPF_AND|BPF_K, k)}
+		}
+		prog = []argInsn{
+			ld(hi), and(vhi), jmp(BPF_JEQ, uint32(arg.ValueTwo>>32), jumpNext, jumpFail),
+			ld(lo), and(vlo), jmp(BPF_JEQ, uint32(arg.ValueTwo), jumpPass, jumpFail),
+		}
+	case spec.OpGreaterThan, spec.OpGreaterEqual:
+		last := uint16(BPF_JGT)
+		if arg.Op == spec.OpGreaterEqual {
+			last = BPF_JGE
+		}
+		prog = []argInsn{
+			ld(hi), jmp(BPF_JGT, vhi, jumpPass, jumpNext), jmp(BPF_JEQ, vhi, jumpNext, jumpFail),
+			ld(lo), jmp(last, vlo, jumpPass, jumpFail),
+		}
+	case spec.OpLessThan, spec.OpLessEqual:
+		// a < v is !(a >= v) and a <= v is !(a > v)
+		last := uint16(BPF_JGE)
+		if arg.Op == spec.OpLessEqual {
+			last = BPF_JGT
+		}
+		prog = []argInsn{
+			ld(hi), jmp(BPF_JGT, vhi, jumpFail, jumpNext), jmp(BPF_JEQ, vhi, jumpNext, jumpPass),
+			ld(lo), jmp(last, vlo, jumpFail, jumpPass),
+		}
+	default:
+		return nil, fmt.Errorf("unknown argument operator: %s", arg.Op)
+	}
+
+	// Resolve branch targets to relative offsets
+	check := make([]sockFilter, len(prog))
+	for i, p := range prog {
+		resolve := func(t jumpTarget) (uint8, error) {
+			var off int
+			switch t {
+			case jumpPass:
+				off = len(prog) - 1 - i
+			case jumpFail:
+				off = len(prog) - 1 - i + failSkip
+			}
+			if off > 255 {
+				return 0, fmt.Errorf("jump offset %d out of range", off)
+			}
+			return uint8(off), nil
+		}
+		ins := p.ins
+		var err error
+		if ins.Jt, err = resolve(p.jt); err != nil {
+			return nil, err
+		}
+		if ins.Jf, err = resolve(p.jf); err != nil {
+			return nil, err
+		}
+		check[i] = ins
+	}
+	return check, nil
 }
 
 // bpfStmt creates a BPF statement.
@@ -336,3 +641,96 @@ func SyscallNumber(name string) (int, bool) {
 	nr, ok := syscallMap[name]
 	return nr, ok
 }
+
+// SyscallNumberArch returns the syscall number for a name on arch.
+func SyscallNumberArch(arch spec.Arch, name string) (int, bool) {
+	nr, ok := syscallTables[arch][name]
+	return nr, ok
+}
+
+// SyscallArches returns the architectures that have a syscall table, in
+// a stable order.
+func SyscallArches() []spec.Arch {
+	arches := make([]spec.Arch, 0, len(syscallTables))
+	for arch := range syscallTables {
+		arches = append(arches, arch)
+	}
+	sort.Slice(arches, func(i, j int) bool { return arches[i] < arches[j] })
+	return arches
+}
+
+// SyscallName returns the name for a syscall number.
+func SyscallName(nr int) (string, bool) {
+	return syscallNameIn(syscallMap, nr)
+}
+
+// syscallNameIn returns the name for a syscall number in table.
+func syscallNameIn(table map[string]int, nr int) (string, bool) {
+	for name, n := range table {
+		if n == nr {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// AuditArch returns the AUDIT_ARCH_* value for an OCI architecture.
+func AuditArch(arch spec.Arch) (uint32, bool) {
+	audit, ok := archToAudit[arch]
+	return audit, ok
+}
+
+// UnrecognizedSyscalls returns the syscall names in config that have no
+// entry in the syscall table of any of its architectures and are therefore
+// left out of the filter.
+func UnrecognizedSyscalls(config *spec.LinuxSeccomp) []string {
+	if config == nil {
+		return nil
+	}
+	arches := filterArches(config)
+	var names []string
+	for _, rule := range config.Syscalls {
+		for _, name := range rule.Names {
+			if !syscallKnown(arches, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// UnsupportedArchitectures returns the architectures in config that have
+// no syscall table. The filter kills calls made from them.
+func UnsupportedArchitectures(config *spec.LinuxSeccomp) []spec.Arch {
+	if config == nil {
+		return nil
+	}
+	var arches []spec.Arch
+	for _, arch := range config.Architectures {
+		if _, ok := syscallTables[arch]; !ok {
+			arches = append(arches, arch)
+		}
+	}
+	return arches
+}
+
+// DisassembleSeccomp compiles an OCI seccomp config and returns a readable
+// listing of the resulting BPF program.
+func DisassembleSeccomp(config *spec.LinuxSeccomp) (string, error) {
+	filter, err := buildSeccompFilter(config)
+	if err != nil {
+		return "", fmt.Errorf("build filter: %w", err)
+	}
+	return disassembleBPF(filter), nil
+}
+
+// SimulateSeccomp compiles an OCI seccomp config and runs the program
+// against data in a userspace BPF interpreter, returning the raw
+// SECCOMP_RET_* value the kernel would act on.
+func SimulateSeccomp(config *spec.LinuxSeccomp, data SeccompData) (uint32, error) {
+	filter, err := buildSeccompFilter(config)
+	if err != nil {
+		return 0, fmt.Errorf("build filter: %w", err)
+	}
+	return runBPF(filter, data.bytes())
+}
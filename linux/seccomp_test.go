@@ -145,6 +145,38 @@ func TestSyscallMap_NoNegativeNumbers(t *testing.T) {
 	}
 }
 
+// TestSyscallNumberArch tests that names resolve through each
+// architecture's own table.
+func TestSyscallNumberArch(t *testing.T) {
+	tests := []struct {
+		arch spec.Arch
+		name string
+		want int
+		ok   bool
+	}{
+		{spec.ArchX86_64, "mount", 165, true},
+		{spec.ArchX86, "mount", 21, true},
+		{spec.ArchX86, "socketcall", 102, true},
+		{spec.ArchAARCH64, "mount", 40, true},
+		{spec.ArchAARCH64, "open", 0, false},
+		{spec.ArchX86_64, "file_setattr", 469, true},
+		{spec.ArchX86_64, "listns", 0, false},
+		{spec.ArchX32, "mount", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := SyscallNumberArch(tt.arch, tt.name)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("SyscallNumberArch(%s, %s) = %d, %v, want %d, %v", tt.arch, tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	for _, arch := range SyscallArches() {
+		if _, ok := AuditArch(arch); !ok {
+			t.Errorf("architecture %s has a syscall table but no audit value", arch)
+		}
+	}
+}
+
 // ============================================================================
 // BPF FILTER BUILD TESTS
 // ============================================================================
@@ -380,20 +412,26 @@ func TestArchJumpCalculation_SingleArch(t *testing.T) {
 		t.Fatalf("buildSeccompFilter failed: %v", err)
 	}
 
-	// Find the arch check instruction (should be after the load arch instruction)
 	// Instruction 0: load arch
-	// Instruction 1: arch check (should jump to instruction 2 on match = jt=1)
-	// Instruction 2: kill
+	// Instruction 1: arch check (jt=1: jump over the section skip)
+	// Instruction 2: ja past the section
 	// Instruction 3: load nr
 	// ...
-	if len(filter) < 4 {
+	// Last: kill
+	if len(filter) < 5 {
 		t.Fatalf("filter too short: %d", len(filter))
 	}
 
 	archCheckInst := filter[1]
-	// For single arch, jt should be 1 (jump over kill instruction)
-	if archCheckInst.Jt != 1 {
-		t.Errorf("single arch jt = %d, want 1", archCheckInst.Jt)
+	if archCheckInst.K != AUDIT_ARCH_X86_64 || archCheckInst.Jt != 1 || archCheckInst.Jf != 0 {
+		t.Errorf("arch check = %+v, want jeq x86_64 jt=1 jf=0", archCheckInst)
+	}
+	skip := filter[2]
+	if skip.Code != BPF_JMP|BPF_JA || int(skip.K) != len(filter)-4 {
+		t.Errorf("section skip = %+v, want ja %d", skip, len(filter)-4)
+	}
+	if last := filter[len(filter)-1]; last.K != SECCOMP_RET_KILL_PROCESS {
+		t.Errorf("last instruction = %+v, want ret kill_process", last)
 	}
 }
 
@@ -408,26 +446,32 @@ func TestArchJumpCalculation_TwoArches(t *testing.T) {
 	if err != nil {
 		t.Fatalf("buildSeccompFilter failed: %v", err)
 	}
+	checkArchSections(t, filter, AUDIT_ARCH_X86_64, AUDIT_ARCH_I386)
+}
 
-	// Instruction 0: load arch
-	// Instruction 1: arch check x86_64 (jt=2: jump over next arch check + kill)
-	// Instruction 2: arch check x86 (jt=1: jump over kill)
-	// Instruction 3: kill
-	// Instruction 4: load nr
-	if len(filter) < 5 {
-		t.Fatalf("filter too short: %d", len(filter))
-	}
-
-	firstArchCheck := filter[1]
-	secondArchCheck := filter[2]
-
-	// First arch should jump 2 instructions (over second arch check + kill)
-	if firstArchCheck.Jt != 2 {
-		t.Errorf("first arch jt = %d, want 2", firstArchCheck.Jt)
+// checkArchSections checks that filter holds one section per audit arch,
+// each skipped by the arch checks of the others, followed by a kill.
+func checkArchSections(t *testing.T, filter []sockFilter, audits ...uint32) {
+	t.Helper()
+	pc := 1 // past the arch load
+	for _, audit := range audits {
+		if pc+1 >= len(filter) {
+			t.Fatalf("filter too short: %d", len(filter))
+		}
+		check, skip := filter[pc], filter[pc+1]
+		if check.Code != BPF_JMP|BPF_JEQ|BPF_K || check.K != audit || check.Jt != 1 || check.Jf != 0 {
+			t.Fatalf("instruction %d = %+v, want jeq 0x%x jt=1 jf=0", pc, check, audit)
+		}
+		if skip.Code != BPF_JMP|BPF_JA {
+			t.Fatalf("instruction %d = %+v, want ja", pc+1, skip)
+		}
+		pc += 2 + int(skip.K)
+		if end := filter[pc-1]; end.Code != BPF_RET|BPF_K {
+			t.Errorf("section of 0x%x ends in %+v, want ret", audit, end)
+		}
 	}
-	// Second arch should jump 1 instruction (over kill)
-	if secondArchCheck.Jt != 1 {
-		t.Errorf("second arch jt = %d, want 1", secondArchCheck.Jt)
+	if pc != len(filter)-1 || filter[pc].K != SECCOMP_RET_KILL_PROCESS {
+		t.Errorf("filter does not end in a single kill after %d sections", len(audits))
 	}
 }
 
@@ -447,26 +491,8 @@ func TestArchJumpCalculation_WithUnknownArch(t *testing.T) {
 		t.Fatalf("buildSeccompFilter failed: %v", err)
 	}
 
-	// Unknown arch should be filtered out, so we should have 2 arch checks
-	// Instruction 0: load arch
-	// Instruction 1: arch check x86_64 (jt=2)
-	// Instruction 2: arch check x86 (jt=1)
-	// Instruction 3: kill
-	if len(filter) < 5 {
-		t.Fatalf("filter too short: %d", len(filter))
-	}
-
-	firstArchCheck := filter[1]
-	secondArchCheck := filter[2]
-
-	// First arch should jump 2 (over second arch check + kill)
-	if firstArchCheck.Jt != 2 {
-		t.Errorf("first arch jt = %d, want 2 (unknown arch should be filtered)", firstArchCheck.Jt)
-	}
-	// Second arch should jump 1 (over kill)
-	if secondArchCheck.Jt != 1 {
-		t.Errorf("second arch jt = %d, want 1", secondArchCheck.Jt)
-	}
+	// Unknown arch should be filtered out, so there are two sections
+	checkArchSections(t, filter, AUDIT_ARCH_X86_64, AUDIT_ARCH_I386)
 }
 
 // ============================================================================
@@ -517,3 +543,23 @@ func TestSetupSeccomp_EmptySyscalls(t *testing.T) {
 		t.Errorf("empty syscalls should not error: %v", err)
 	}
 }
+
+// TestBuildSeccompFilter_DefaultErrnoRet tests that defaultErrnoRet is honored.
+func TestBuildSeccompFilter_DefaultErrnoRet(t *testing.T) {
+	errnoVal := uint(1)
+	config := &spec.LinuxSeccomp{
+		DefaultAction:   spec.ActErrno,
+		DefaultErrnoRet: &errnoVal,
+	}
+
+	filter, err := buildSeccompFilter(config)
+	if err != nil {
+		t.Fatalf("buildSeccompFilter failed: %v", err)
+	}
+
+	// The section's default return precedes the final kill
+	last := filter[len(filter)-2]
+	if last.K != SECCOMP_RET_ERRNO|1 {
+		t.Errorf("default return = 0x%x, want 0x%x", last.K, SECCOMP_RET_ERRNO|1)
+	}
+}
@@ -0,0 +1,194 @@
+// Package spec provides the built-in default seccomp profile.
+package spec
+
+import "fmt"
+
+// Errno values returned by the default profile.
+const (
+	errnoEPERM  = 1
+	errnoENOSYS = 38
+)
+
+// Clone flags inspected by the default profile.
+const (
+	cloneNewUser = 0x10000000
+)
+
+// defaultSeccompProfile is the curated profile used by DefaultSpec. It is
+// modeled on Docker's default profile, but written as a deny list on top of
+// SCMP_ACT_ALLOW: the seccomp compiler's syscall tables end at a fixed
+// kernel release, and an allow list would break binaries using newer
+// syscalls. Denied syscalls fail with EPERM unless the container holds the
+// capability that would make the call meaningful. The architectures are
+// those the compiler has syscall tables for; a native x86_64 process may
+// not make i386 calls.
+var defaultSeccompProfile = DockerSeccompProfile{
+	DefaultAction: ActAllow,
+	ArchMap: []DockerSeccompArchMap{
+		{Arch: ArchX86_64},
+		{Arch: ArchAARCH64},
+		{Arch: ArchX86},
+	},
+	Syscalls: []DockerSeccompSyscall{
+		{
+			Names:    []string{"add_key", "keyctl", "request_key"},
+			Action:   ActErrno,
+			ErrnoRet: uintPtr(errnoEPERM),
+			Comment:  "the kernel keyring is not namespaced",
+		},
+		{
+			Names:    []string{"io_uring_setup", "io_uring_enter", "io_uring_register"},
+			Action:   ActErrno,
+			ErrnoRet: uintPtr(errnoEPERM),
+			Comment:  "io_uring bypasses syscall filtering",
+		},
+		{
+			Names:    []string{"nfsservctl"},
+			Action:   ActErrno,
+			ErrnoRet: uintPtr(errnoEPERM),
+			Comment:  "obsolete or unimplemented",
+		},
+		{
+			Names: []string{
+				"_sysctl", "afs_syscall", "create_module", "get_kernel_syms",
+				"getpmsg", "putpmsg", "query_module", "sysfs", "uselib",
+				"ustat", "vserver",
+			},
+			Action:   ActErrno,
+			ErrnoRet: uintPtr(errnoEPERM),
+			Includes: &DockerSeccompFilter{Arches: []string{"amd64", "386"}},
+			Comment:  "obsolete or unimplemented, and only in the x86 tables",
+		},
+		{
+			Names:    []string{"security", "tuxcall"},
+			Action:   ActErrno,
+			ErrnoRet: uintPtr(errnoEPERM),
+			Includes: &DockerSeccompFilter{Arches: []string{"amd64"}},
+			Comment:  "unimplemented, and only in the x86_64 table",
+		},
+		{
+			Names:    []string{"init_module", "finit_module", "delete_module"},
+			Action:   ActErrno,
+			ErrnoRet: uintPtr(errnoEPERM),
+			Excludes: &DockerSeccompFilter{Caps: []string{"CAP_SYS_MODULE"}},
+		},
+		{
+			Names:    []string{"kexec_load", "kexec_file_load", "reboot"},
+			Action:   ActErrno,
+			ErrnoRet: uintPtr(errnoEPERM),
+			Excludes: &DockerSeccompFilter{Caps: []string{"CAP_SYS_BOOT"}},
+		},
+		{
+			Names:    []string{"settimeofday", "clock_settime", "clock_adjtime"},
+			Action:   ActErrno,
+			ErrnoRet: uintPtr(errnoEPERM),
+			Excludes: &DockerSeccompFilter{Caps: []string{"CAP_SYS_TIME"}},
+			Comment:  "the clock is not namespaced",
+		},
+		{
+			Names:    []string{"acct"},
+			Action:   ActErrno,
+			ErrnoRet: uintPtr(errnoEPERM),
+			Excludes: &DockerSeccompFilter{Caps: []string{"CAP_SYS_PACCT"}},
+		},
+		{
+			Names:    []string{"iopl", "ioperm"},
+			Action:   ActErrno,
+			ErrnoRet: uintPtr(errnoEPERM),
+			Includes: &DockerSeccompFilter{Arches: []string{"amd64", "386"}},
+			Excludes: &DockerSeccompFilter{Caps: []string{"CAP_SYS_RAWIO"}},
+		},
+		{
+			Names:    []string{"syslog"},
+			Action:   ActErrno,
+			ErrnoRet: uintPtr(errnoEPERM),
+			Excludes: &DockerSeccompFilter{Caps: []string{"CAP_SYSLOG"}},
+		},
+		{
+			Names:    []string{"vhangup"},
+			Action:   ActErrno,
+			ErrnoRet: uintPtr(errnoEPERM),
+			Excludes: &DockerSeccompFilter{Caps: []string{"CAP_SYS_TTY_CONFIG"}},
+		},
+		{
+			Names:    []string{"open_by_handle_at"},
+			Action:   ActErrno,
+			ErrnoRet: uintPtr(errnoEPERM),
+			Excludes: &DockerSeccompFilter{Caps: []string{"CAP_DAC_READ_SEARCH"}},
+			Comment:  "file handles can escape bind mounts",
+		},
+		{
+			Names:    []string{"userfaultfd"},
+			Action:   ActErrno,
+			ErrnoRet: uintPtr(errnoEPERM),
+			Excludes: &DockerSeccompFilter{Caps: []string{"CAP_SYS_PTRACE"}},
+		},
+		{
+			Names:    []string{"bpf"},
+			Action:   ActErrno,
+			ErrnoRet: uintPtr(errnoEPERM),
+			Excludes: &DockerSeccompFilter{Caps: []string{"CAP_SYS_ADMIN", "CAP_BPF"}},
+		},
+		{
+			Names:    []string{"perf_event_open"},
+			Action:   ActErrno,
+			ErrnoRet: uintPtr(errnoEPERM),
+			Excludes: &DockerSeccompFilter{Caps: []string{"CAP_SYS_ADMIN", "CAP_PERFMON"}},
+		},
+		{
+			Names: []string{
+				"mount", "umount2", "pivot_root", "setns", "swapon", "swapoff",
+				"fanotify_init", "lookup_dcookie", "name_to_handle_at",
+				"quotactl", "quotactl_fd", "open_tree", "move_mount", "fsopen",
+				"fsconfig", "fsmount", "fspick", "mount_setattr",
+			},
+			Action:   ActErrno,
+			ErrnoRet: uintPtr(errnoEPERM),
+			Excludes: &DockerSeccompFilter{Caps: []string{"CAP_SYS_ADMIN"}},
+		},
+		{
+			Names:    []string{"unshare", "clone"},
+			Action:   ActErrno,
+			ErrnoRet: uintPtr(errnoEPERM),
+			Args: []LinuxSeccompArg{
+				{Index: 0, Value: cloneNewUser, ValueTwo: cloneNewUser, Op: OpMaskedEqual},
+			},
+			Excludes: &DockerSeccompFilter{Caps: []string{"CAP_SYS_ADMIN"}},
+			Comment:  "user namespaces grant a full capability set over new namespaces",
+		},
+		{
+			Names:    []string{"clone3"},
+			Action:   ActErrno,
+			ErrnoRet: uintPtr(errnoENOSYS),
+			Excludes: &DockerSeccompFilter{Caps: []string{"CAP_SYS_ADMIN"}},
+			Comment:  "flags are behind a pointer; ENOSYS makes libc fall back to clone",
+		},
+	},
+}
+
+// DefaultSeccompProfile returns a copy of the built-in default profile in
+// Docker format, with its conditional rules unresolved.
+func DefaultSeccompProfile() *DockerSeccompProfile {
+	profile := defaultSeccompProfile
+	profile.Syscalls = append([]DockerSeccompSyscall(nil), defaultSeccompProfile.Syscalls...)
+	return &profile
+}
+
+// DefaultSeccomp resolves the built-in default profile for a Go-style
+// architecture and bounding capability set. It fails on architectures the
+// profile does not cover, rather than leave a container unconfined.
+func DefaultSeccomp(arch string, capabilities []string) (*LinuxSeccomp, error) {
+	config, err := DefaultSeccompProfile().ToOCI(DockerSeccompEnv{
+		Arch:         arch,
+		Capabilities: capabilities,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("no default seccomp profile for architecture %s: %w", arch, err)
+	}
+	return config, nil
+}
+
+// uintPtr returns a pointer to a uint.
+func uintPtr(u uint) *uint {
+	return &u
+}
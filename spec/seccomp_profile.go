@@ -0,0 +1,294 @@
+// Package spec provides conversion of Docker/Podman seccomp profiles.
+package spec
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DockerSeccompProfile is a seccomp profile in the format used by Docker,
+// Podman and containers/common. Unlike LinuxSeccomp, rules may be
+// conditional on capabilities, architecture and kernel version, and the
+// architecture list is expressed as a map from native to sub-architectures.
+// Reference: https://github.com/moby/profiles/tree/main/seccomp
+type DockerSeccompProfile struct {
+	// DefaultAction is the default action when no rules match.
+	DefaultAction LinuxSeccompAction `json:"defaultAction"`
+
+	// DefaultErrnoRet is the errno returned by the default action.
+	DefaultErrnoRet *uint `json:"defaultErrnoRet,omitempty"`
+
+	// Architectures is the legacy flat architecture list.
+	Architectures []Arch `json:"architectures,omitempty"`
+
+	// ArchMap maps a native architecture to the sub-architectures it can run.
+	ArchMap []DockerSeccompArchMap `json:"archMap,omitempty"`
+
+	// Flags are seccomp flags passed through unchanged.
+	Flags []LinuxSeccompFlag `json:"flags,omitempty"`
+
+	// ListenerPath is a path to a socket to receive seccomp notifications.
+	ListenerPath string `json:"listenerPath,omitempty"`
+
+	// ListenerMetadata is opaque data to pass to the seccomp agent.
+	ListenerMetadata string `json:"listenerMetadata,omitempty"`
+
+	// Syscalls are the (possibly conditional) syscall rules.
+	Syscalls []DockerSeccompSyscall `json:"syscalls,omitempty"`
+}
+
+// DockerSeccompArchMap lists the sub-architectures of a native architecture.
+type DockerSeccompArchMap struct {
+	// Arch is the native architecture.
+	Arch Arch `json:"architecture"`
+
+	// SubArches are additional architectures the native one can execute.
+	SubArches []Arch `json:"subArchitectures,omitempty"`
+}
+
+// DockerSeccompSyscall is a syscall rule with optional conditions.
+type DockerSeccompSyscall struct {
+	// Name is the legacy single-name form.
+	Name string `json:"name,omitempty"`
+
+	// Names specifies the names of the syscalls.
+	Names []string `json:"names,omitempty"`
+
+	// Action is the action to take when the syscall is matched.
+	Action LinuxSeccompAction `json:"action"`
+
+	// ErrnoRet is the errno return value when action is SCMP_ACT_ERRNO.
+	ErrnoRet *uint `json:"errnoRet,omitempty"`
+
+	// Args specifies conditions on syscall arguments.
+	Args []LinuxSeccompArg `json:"args,omitempty"`
+
+	// Comment is free-form documentation and is dropped on conversion.
+	Comment string `json:"comment,omitempty"`
+
+	// Includes lists conditions that must all hold for the rule to apply.
+	Includes *DockerSeccompFilter `json:"includes,omitempty"`
+
+	// Excludes lists conditions any of which disables the rule.
+	Excludes *DockerSeccompFilter `json:"excludes,omitempty"`
+}
+
+// DockerSeccompFilter is a set of conditions on the runtime environment.
+type DockerSeccompFilter struct {
+	// Caps are capability names checked against the bounding set.
+	Caps []string `json:"caps,omitempty"`
+
+	// Arches are Go-style architecture names (amd64, arm64, s390x, ...).
+	Arches []string `json:"arches,omitempty"`
+
+	// MinKernel is a kernel version such as "4.8".
+	MinKernel string `json:"minKernel,omitempty"`
+}
+
+// DockerSeccompEnv describes the environment conditional rules are resolved against.
+type DockerSeccompEnv struct {
+	// Arch is the Go-style native architecture (e.g. runtime.GOARCH).
+	Arch string
+
+	// Capabilities is the container's bounding capability set.
+	Capabilities []string
+
+	// KernelRelease is the running kernel's release string (e.g. "6.1.0-18-amd64").
+	KernelRelease string
+}
+
+// goArchToSeccomp maps Go architecture names to seccomp architectures.
+var goArchToSeccomp = map[string]Arch{
+	"386":      ArchX86,
+	"amd64":    ArchX86_64,
+	"arm":      ArchARM,
+	"arm64":    ArchAARCH64,
+	"mips":     ArchMIPS,
+	"mips64":   ArchMIPS64,
+	"mips64le": ArchMIPSEL64,
+	"mipsle":   ArchMIPSEL,
+	"ppc":      ArchPPC,
+	"ppc64":    ArchPPC64,
+	"ppc64le":  ArchPPC64LE,
+	"riscv64":  ArchRISCV64,
+	"s390":     ArchS390,
+	"s390x":    ArchS390X,
+}
+
+// LoadDockerSeccompProfile loads a Docker-format seccomp profile from a file.
+func LoadDockerSeccompProfile(path string) (*DockerSeccompProfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var profile DockerSeccompProfile
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// ToOCI resolves the profile's conditional rules against env and returns
+// the equivalent flattened OCI LinuxSeccomp. It follows the semantics of
+// Docker's own loader: a rule is dropped if any exclude condition matches
+// or any include condition does not.
+func (p *DockerSeccompProfile) ToOCI(env DockerSeccompEnv) (*LinuxSeccomp, error) {
+	if len(p.Architectures) > 0 && len(p.ArchMap) > 0 {
+		return nil, fmt.Errorf("profile sets both architectures and archMap")
+	}
+
+	config := &LinuxSeccomp{
+		DefaultAction:    p.DefaultAction,
+		DefaultErrnoRet:  p.DefaultErrnoRet,
+		Architectures:    p.Architectures,
+		Flags:            p.Flags,
+		ListenerPath:     p.ListenerPath,
+		ListenerMetadata: p.ListenerMetadata,
+	}
+
+	// Without an entry for the native architecture the filter would list
+	// none and the kernel would kill the container on its first syscall
+	if len(p.ArchMap) > 0 {
+		native, known := goArchToSeccomp[env.Arch]
+		for _, m := range p.ArchMap {
+			if known && m.Arch == native {
+				config.Architectures = append([]Arch{m.Arch}, m.SubArches...)
+				break
+			}
+		}
+		if len(config.Architectures) == 0 {
+			return nil, fmt.Errorf("profile has no archMap entry for %s", env.Arch)
+		}
+	}
+
+	for i, call := range p.Syscalls {
+		names := call.Names
+		if call.Name != "" {
+			if len(names) > 0 {
+				return nil, fmt.Errorf("syscall rule %d sets both name and names", i)
+			}
+			names = []string{call.Name}
+		}
+		if len(names) == 0 {
+			return nil, fmt.Errorf("syscall rule %d has no names", i)
+		}
+
+		excluded, err := call.Excludes.matchesAny(env)
+		if err != nil {
+			return nil, fmt.Errorf("syscall rule %d (%s): %w", i, names[0], err)
+		}
+		included, err := call.Includes.matchesAll(env)
+		if err != nil {
+			return nil, fmt.Errorf("syscall rule %d (%s): %w", i, names[0], err)
+		}
+		if excluded || !included {
+			continue
+		}
+
+		config.Syscalls = append(config.Syscalls, LinuxSyscall{
+			Names:    names,
+			Action:   call.Action,
+			ErrnoRet: call.ErrnoRet,
+			Args:     call.Args,
+		})
+	}
+
+	return config, nil
+}
+
+// matchesAll reports whether every condition in f holds. A nil filter matches.
+func (f *DockerSeccompFilter) matchesAll(env DockerSeccompEnv) (bool, error) {
+	if f == nil {
+		return true, nil
+	}
+	if len(f.Arches) > 0 && !containsString(f.Arches, env.Arch) {
+		return false, nil
+	}
+	for _, c := range f.Caps {
+		if !containsString(env.Capabilities, c) {
+			return false, nil
+		}
+	}
+	if f.MinKernel != "" {
+		ok, err := kernelAtLeast(env.KernelRelease, f.MinKernel)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// matchesAny reports whether any condition in f holds. A nil filter does not match.
+func (f *DockerSeccompFilter) matchesAny(env DockerSeccompEnv) (bool, error) {
+	if f == nil {
+		return false, nil
+	}
+	if containsString(f.Arches, env.Arch) {
+		return true, nil
+	}
+	for _, c := range f.Caps {
+		if containsString(env.Capabilities, c) {
+			return true, nil
+		}
+	}
+	if f.MinKernel != "" {
+		return kernelAtLeast(env.KernelRelease, f.MinKernel)
+	}
+	return false, nil
+}
+
+// kernelAtLeast reports whether release is at least the minimum version.
+// Only the numeric major.minor[.patch] prefix of each string is compared.
+func kernelAtLeast(release, minimum string) (bool, error) {
+	want, err := parseKernelVersion(minimum)
+	if err != nil {
+		return false, fmt.Errorf("invalid minKernel %q: %w", minimum, err)
+	}
+	have, err := parseKernelVersion(release)
+	if err != nil {
+		return false, fmt.Errorf("invalid kernel release %q: %w", release, err)
+	}
+	for i := range want {
+		if have[i] != want[i] {
+			return have[i] > want[i], nil
+		}
+	}
+	return true, nil
+}
+
+// parseKernelVersion parses the leading "major.minor[.patch]" of a version.
+func parseKernelVersion(v string) ([3]int, error) {
+	var parts [3]int
+	// Cut at the first character that isn't part of the dotted version.
+	end := strings.IndexFunc(v, func(r rune) bool {
+		return r != '.' && (r < '0' || r > '9')
+	})
+	if end >= 0 {
+		v = v[:end]
+	}
+	fields := strings.Split(v, ".")
+	if len(fields) < 2 || len(fields) > 3 {
+		return parts, fmt.Errorf("expected major.minor[.patch]")
+	}
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil {
+			return parts, err
+		}
+		parts[i] = n
+	}
+	return parts, nil
+}
+
+// containsString checks if a string is in the list.
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
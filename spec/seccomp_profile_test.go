@@ -0,0 +1,256 @@
+package spec
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testDockerProfile = `{
+	"defaultAction": "SCMP_ACT_ERRNO",
+	"defaultErrnoRet": 1,
+	"archMap": [
+		{"architecture": "SCMP_ARCH_X86_64", "subArchitectures": ["SCMP_ARCH_X86", "SCMP_ARCH_X32"]},
+		{"architecture": "SCMP_ARCH_AARCH64", "subArchitectures": ["SCMP_ARCH_ARM"]}
+	],
+	"syscalls": [
+		{"names": ["read", "write"], "action": "SCMP_ACT_ALLOW"},
+		{"name": "uname", "action": "SCMP_ACT_ALLOW"},
+		{"names": ["arch_prctl"], "action": "SCMP_ACT_ALLOW", "includes": {"arches": ["amd64", "x32"]}},
+		{"names": ["bpf"], "action": "SCMP_ACT_ALLOW", "includes": {"caps": ["CAP_SYS_ADMIN"]}},
+		{"names": ["clone"], "action": "SCMP_ACT_ALLOW",
+		 "args": [{"index": 0, "value": 2114060288, "op": "SCMP_CMP_MASKED_EQ"}],
+		 "excludes": {"caps": ["CAP_SYS_ADMIN"]}},
+		{"names": ["io_uring_setup"], "action": "SCMP_ACT_ALLOW", "includes": {"minKernel": "5.1"}},
+		{"names": ["socket"], "action": "SCMP_ACT_ERRNO", "errnoRet": 38, "excludes": {"minKernel": "4.8"}}
+	]
+}`
+
+// writeTestProfile writes the test profile to a temp file.
+func writeTestProfile(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "docker.json")
+	if err := os.WriteFile(path, []byte(testDockerProfile), 0644); err != nil {
+		t.Fatalf("write profile: %v", err)
+	}
+	return path
+}
+
+// ruleNames returns the set of syscall names in a converted config.
+func ruleNames(config *LinuxSeccomp) map[string]LinuxSyscall {
+	names := make(map[string]LinuxSyscall)
+	for _, rule := range config.Syscalls {
+		for _, name := range rule.Names {
+			names[name] = rule
+		}
+	}
+	return names
+}
+
+func TestDockerProfileToOCI_Unprivileged(t *testing.T) {
+	profile, err := LoadDockerSeccompProfile(writeTestProfile(t))
+	if err != nil {
+		t.Fatalf("LoadDockerSeccompProfile failed: %v", err)
+	}
+
+	config, err := profile.ToOCI(DockerSeccompEnv{
+		Arch:          "amd64",
+		Capabilities:  []string{"CAP_CHOWN"},
+		KernelRelease: "4.19.0-21-amd64",
+	})
+	if err != nil {
+		t.Fatalf("ToOCI failed: %v", err)
+	}
+
+	if config.DefaultAction != ActErrno {
+		t.Errorf("DefaultAction = %s, want %s", config.DefaultAction, ActErrno)
+	}
+	if config.DefaultErrnoRet == nil || *config.DefaultErrnoRet != 1 {
+		t.Errorf("DefaultErrnoRet = %v, want 1", config.DefaultErrnoRet)
+	}
+
+	wantArches := []Arch{ArchX86_64, ArchX86, ArchX32}
+	if len(config.Architectures) != len(wantArches) {
+		t.Fatalf("Architectures = %v, want %v", config.Architectures, wantArches)
+	}
+	for i, a := range wantArches {
+		if config.Architectures[i] != a {
+			t.Errorf("Architectures[%d] = %s, want %s", i, config.Architectures[i], a)
+		}
+	}
+
+	names := ruleNames(config)
+	for _, want := range []string{"read", "write", "uname", "arch_prctl", "clone"} {
+		if _, ok := names[want]; !ok {
+			t.Errorf("expected rule for %s", want)
+		}
+	}
+	for _, unwanted := range []string{"bpf", "io_uring_setup", "socket"} {
+		if _, ok := names[unwanted]; ok {
+			t.Errorf("rule for %s should have been dropped", unwanted)
+		}
+	}
+
+	if len(names["clone"].Args) != 1 {
+		t.Errorf("clone args not preserved: %+v", names["clone"].Args)
+	}
+}
+
+func TestDockerProfileToOCI_Privileged(t *testing.T) {
+	profile, err := LoadDockerSeccompProfile(writeTestProfile(t))
+	if err != nil {
+		t.Fatalf("LoadDockerSeccompProfile failed: %v", err)
+	}
+
+	config, err := profile.ToOCI(DockerSeccompEnv{
+		Arch:          "arm64",
+		Capabilities:  []string{"CAP_SYS_ADMIN"},
+		KernelRelease: "3.10.0-1160.el7.x86_64",
+	})
+	if err != nil {
+		t.Fatalf("ToOCI failed: %v", err)
+	}
+
+	if len(config.Architectures) != 2 || config.Architectures[0] != ArchAARCH64 {
+		t.Errorf("Architectures = %v, want [%s %s]", config.Architectures, ArchAARCH64, ArchARM)
+	}
+
+	names := ruleNames(config)
+	for _, want := range []string{"bpf", "socket"} {
+		if _, ok := names[want]; !ok {
+			t.Errorf("expected rule for %s", want)
+		}
+	}
+	for _, unwanted := range []string{"arch_prctl", "clone", "io_uring_setup"} {
+		if _, ok := names[unwanted]; ok {
+			t.Errorf("rule for %s should have been dropped", unwanted)
+		}
+	}
+	if r := names["socket"]; r.ErrnoRet == nil || *r.ErrnoRet != 38 {
+		t.Errorf("socket errnoRet = %v, want 38", r.ErrnoRet)
+	}
+}
+
+func TestDockerProfileToOCI_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile DockerSeccompProfile
+	}{
+		{"architectures and archMap", DockerSeccompProfile{
+			DefaultAction: ActAllow,
+			Architectures: []Arch{ArchX86_64},
+			ArchMap:       []DockerSeccompArchMap{{Arch: ArchX86_64}},
+		}},
+		{"archMap without native entry", DockerSeccompProfile{
+			DefaultAction: ActAllow,
+			ArchMap:       []DockerSeccompArchMap{{Arch: ArchAARCH64, SubArches: []Arch{ArchARM}}},
+		}},
+		{"name and names", DockerSeccompProfile{
+			DefaultAction: ActAllow,
+			Syscalls:      []DockerSeccompSyscall{{Name: "read", Names: []string{"write"}, Action: ActAllow}},
+		}},
+		{"no names", DockerSeccompProfile{
+			DefaultAction: ActAllow,
+			Syscalls:      []DockerSeccompSyscall{{Action: ActAllow}},
+		}},
+		{"bad minKernel", DockerSeccompProfile{
+			DefaultAction: ActAllow,
+			Syscalls: []DockerSeccompSyscall{{
+				Names: []string{"read"}, Action: ActAllow,
+				Includes: &DockerSeccompFilter{MinKernel: "four"},
+			}},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := DockerSeccompEnv{Arch: "amd64", KernelRelease: "6.1.0"}
+			if _, err := tt.profile.ToOCI(env); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestKernelAtLeast(t *testing.T) {
+	tests := []struct {
+		release string
+		minimum string
+		want    bool
+	}{
+		{"5.15.0-91-generic", "5.15", true},
+		{"5.15.0-91-generic", "5.16", false},
+		{"6.1.0", "5.10", true},
+		{"4.19.0-21-amd64", "4.19.1", false},
+		{"4.19.2", "4.19.1", true},
+		{"3.10.0-1160.el7.x86_64", "4.8", false},
+	}
+
+	for _, tt := range tests {
+		got, err := kernelAtLeast(tt.release, tt.minimum)
+		if err != nil {
+			t.Errorf("kernelAtLeast(%q, %q) error: %v", tt.release, tt.minimum, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("kernelAtLeast(%q, %q) = %v, want %v", tt.release, tt.minimum, got, tt.want)
+		}
+	}
+}
+
+func TestDefaultSeccomp(t *testing.T) {
+	config, err := DefaultSeccomp("amd64", defaultCapabilities())
+	if err != nil {
+		t.Fatalf("DefaultSeccomp failed on amd64: %v", err)
+	}
+	if config.DefaultAction != ActAllow {
+		t.Errorf("DefaultAction = %s, want %s", config.DefaultAction, ActAllow)
+	}
+	if len(config.Architectures) != 1 || config.Architectures[0] != ArchX86_64 {
+		t.Errorf("Architectures = %v, want [%s]", config.Architectures, ArchX86_64)
+	}
+
+	names := ruleNames(config)
+	for _, want := range []string{"kexec_load", "init_module", "bpf", "keyctl", "unshare", "mount"} {
+		if _, ok := names[want]; !ok {
+			t.Errorf("expected rule for %s", want)
+		}
+	}
+
+	config, err = DefaultSeccomp("amd64", []string{"CAP_SYS_ADMIN", "CAP_SYS_MODULE"})
+	if err != nil {
+		t.Fatalf("DefaultSeccomp failed: %v", err)
+	}
+	privileged := ruleNames(config)
+	for _, unwanted := range []string{"bpf", "mount", "unshare", "init_module"} {
+		if _, ok := privileged[unwanted]; ok {
+			t.Errorf("rule for %s should be dropped with its capability", unwanted)
+		}
+	}
+	if _, ok := privileged["keyctl"]; !ok {
+		t.Error("keyctl should be denied regardless of capabilities")
+	}
+
+	arm64, err := DefaultSeccomp("arm64", defaultCapabilities())
+	if err != nil {
+		t.Fatalf("DefaultSeccomp failed on arm64: %v", err)
+	}
+	if len(arm64.Architectures) != 1 || arm64.Architectures[0] != ArchAARCH64 {
+		t.Errorf("arm64 Architectures = %v, want [%s]", arm64.Architectures, ArchAARCH64)
+	}
+	if _, ok := ruleNames(arm64)["iopl"]; ok {
+		t.Error("x86-only rule for iopl kept on arm64")
+	}
+
+	if config, err := DefaultSeccomp("riscv64", nil); err == nil {
+		t.Errorf("expected an error on an architecture without a syscall table, got %+v", config)
+	}
+}
+
+func TestDefaultSeccompProfile_Copy(t *testing.T) {
+	profile := DefaultSeccompProfile()
+	profile.Syscalls[0].Names = nil
+	if len(DefaultSeccompProfile().Syscalls[0].Names) == 0 {
+		t.Error("modifying the returned profile changed the built-in one")
+	}
+}
@@ -6,6 +6,7 @@ package spec
 import (
 	"encoding/json"
 	"os"
+	"runtime"
 )
 
 // Version is the OCI Runtime Specification version this implementation targets.
@@ -517,6 +518,9 @@ type LinuxSeccomp struct {
 	// DefaultAction is the default action when no rules match.
 	DefaultAction LinuxSeccompAction `json:"defaultAction"`
 
+	// DefaultErrnoRet is the errno returned when DefaultAction is SCMP_ACT_ERRNO.
+	DefaultErrnoRet *uint `json:"defaultErrnoRet,omitempty"`
+
 	// Architectures specifies the architectures this configuration applies to.
 	Architectures []Arch `json:"architectures,omitempty"`
 
@@ -689,8 +693,10 @@ func (s *Spec) Save(path string) error {
 }
 
 // DefaultSpec returns a minimal default OCI spec suitable for most containers.
+// It carries the built-in seccomp profile, except on architectures without
+// one, where Seccomp is nil and DefaultSeccomp reports why.
 func DefaultSpec() *Spec {
-	return &Spec{
+	s := &Spec{
 		Version: Version,
 		Root: &Root{
 			Path:     "rootfs",
@@ -806,6 +812,8 @@ func DefaultSpec() *Spec {
 			},
 		},
 	}
+	s.Linux.Seccomp, _ = DefaultSeccomp(runtime.GOARCH, defaultCapabilities())
+	return s
 }
 
 // defaultCapabilities returns the default capability set.
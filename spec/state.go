@@ -47,6 +47,48 @@ type State struct {
 
 	// Annotations are key-value pairs associated with the container.
 	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// OOMKilled reports that the kernel OOM killer killed a process in the
+	// container's cgroup. This is a runtime extension to the OCI state.
+	OOMKilled bool `json:"oomKilled,omitempty"`
+
+	// OOMKillCount is the number of processes the OOM killer killed.
+	OOMKillCount uint64 `json:"oomKillCount,omitempty"`
+}
+
+// MemoryEvents are the cgroup memory event counters, as in memory.events
+// on cgroup v2. Counters a hierarchy does not provide stay zero.
+type MemoryEvents struct {
+	// Low counts reclaims below memory.low.
+	Low uint64 `json:"low"`
+
+	// High counts throttling above memory.high.
+	High uint64 `json:"high"`
+
+	// Max counts allocations that hit memory.max.
+	Max uint64 `json:"max"`
+
+	// OOM counts OOM conditions in the cgroup.
+	OOM uint64 `json:"oom"`
+
+	// OOMKill counts processes killed by the OOM killer.
+	OOMKill uint64 `json:"oomKill"`
+}
+
+// PSIData holds the pressure stall averages (percent) and total stall time
+// (microseconds) from one line of a PSI file.
+type PSIData struct {
+	Avg10  float64 `json:"avg10"`
+	Avg60  float64 `json:"avg60"`
+	Avg300 float64 `json:"avg300"`
+	Total  uint64  `json:"total"`
+}
+
+// PSIStats is a pressure stall information reading. Some is time at least
+// one task stalled; Full is time all tasks stalled.
+type PSIStats struct {
+	Some PSIData `json:"some"`
+	Full PSIData `json:"full"`
 }
 
 // ContainerState extends State with additional internal runtime information.
@@ -64,7 +106,26 @@ type ContainerState struct {
 	// Owner is the user who created the container.
 	Owner string `json:"owner,omitempty"`
 
-	// Config holds the original spec (optional, for debugging/introspection).
+	// CgroupPath is the container's cgroup: a path under the cgroup mount,
+	// or a "slice:prefix:name" triple when SystemdCgroup is set.
+	CgroupPath string `json:"cgroupPath,omitempty"`
+
+	// SystemdCgroup reports whether the cgroup is a systemd scope unit.
+	SystemdCgroup bool `json:"systemdCgroup,omitempty"`
+
+	// Rootless reports that the container was created without real root.
+	// A rootless container with an empty CgroupPath has no cgroup.
+	Rootless bool `json:"rootless,omitempty"`
+
+	// MemoryEvents are the cgroup memory event counters last read by
+	// state or list.
+	MemoryEvents *MemoryEvents `json:"memoryEvents,omitempty"`
+
+	// MemoryPressure is the last memory.pressure reading.
+	MemoryPressure *PSIStats `json:"memoryPressure,omitempty"`
+
+	// Config holds the container's spec once it differs from the bundle's
+	// config.json, after device add or rm.
 	Config *Spec `json:"config,omitempty"`
 }
 